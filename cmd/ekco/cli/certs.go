@@ -0,0 +1,103 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/replicatedhq/ekco/pkg/certinventory"
+	"github.com/replicatedhq/ekco/pkg/cluster"
+	"github.com/replicatedhq/ekco/pkg/hosttask"
+	"github.com/replicatedhq/ekco/pkg/logger"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func CertsCmd(v *viper.Viper) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "certs",
+		Short: "Manage certificates",
+		Long:  `Inspect the certificates managed by ekco`,
+	}
+
+	cmd.AddCommand(CertsListCmd(v))
+
+	return cmd
+}
+
+func CertsListCmd(v *viper.Viper) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List certificates",
+		Long:  `List the certificates on each node and in secrets with their expiration and whether ekco will rotate them`,
+		Args:  cobra.ExactArgs(0),
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return v.BindPFlags(cmd.Flags())
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			output := v.GetString("output")
+			if output != "table" && output != "json" {
+				return fmt.Errorf("unknown output format %q", output)
+			}
+
+			var certs []certinventory.Certificate
+
+			if v.GetBool("host") {
+				hostname := v.GetString("hostname")
+				hostCerts, err := certinventory.ListHostCertificates(cluster.DefaultEtcKubernetesDir, hostname)
+				if err != nil {
+					return errors.Wrap(err, "failed to list host certificates")
+				}
+				kubeletCerts, err := certinventory.ListKubeletCertificates(v.GetString("kubelet-config"), v.GetString("kubelet-pki-dir"), hostname)
+				if err != nil {
+					return errors.Wrap(err, "failed to list kubelet certificates")
+				}
+				certs = append(hostCerts, kubeletCerts...)
+			} else {
+				config, err := initEKCOConfig(v)
+				if err != nil {
+					return errors.Wrap(err, "failed to initialize config")
+				}
+
+				log, err := logger.FromViper(v)
+				if err != nil {
+					return errors.Wrap(err, "failed to initialize logger")
+				}
+
+				clusterController, err := initClusterController(config, log)
+				if err != nil {
+					return errors.Wrap(err, "failed to initialize cluster controller")
+				}
+
+				certs, err = clusterController.CertificateInventory(context.Background())
+				if err != nil {
+					return errors.Wrap(err, "failed to list certificates")
+				}
+			}
+
+			if output == "json" && v.GetBool("host") {
+				// the operator reads the certificates from between the output markers, apart from
+				// anything else logged by the pod
+				var buf bytes.Buffer
+				if err := certinventory.PrintJSON(&buf, certs); err != nil {
+					return err
+				}
+				return hosttask.PrintOutput(os.Stdout, buf.Bytes())
+			}
+			if output == "json" {
+				return certinventory.PrintJSON(os.Stdout, certs)
+			}
+			return certinventory.Print(os.Stdout, certs)
+		},
+	}
+
+	cmd.Flags().Bool("host", false, "List only the certificates on this host")
+	cmd.Flags().String("hostname", "", "Hostname where this pod is running")
+	cmd.Flags().String("kubelet-config", cluster.DefaultKubeletConfigPath, "Kubelet configuration file used with --host")
+	cmd.Flags().String("kubelet-pki-dir", cluster.DefaultKubeletPKIDir, "Kubelet PKI directory used with --host")
+	cmd.Flags().StringP("output", "o", "table", "Output format, table or json")
+
+	return cmd
+}
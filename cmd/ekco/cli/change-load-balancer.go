@@ -4,10 +4,14 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net/url"
 
+	"github.com/replicatedhq/ekco/pkg/kubeconfig"
 	"github.com/replicatedhq/ekco/pkg/logger"
+	"github.com/replicatedhq/ekco/pkg/rotate"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -15,6 +19,8 @@ func ChangeLoadBalancerCmd(v *viper.Viper) *cobra.Command {
 	var internal bool
 	var exclude string
 	var server string
+	var regenCert bool
+	var dropAltNames string
 
 	cmd := &cobra.Command{
 		Use:   "change-load-balancer",
@@ -27,6 +33,10 @@ func ChangeLoadBalancerCmd(v *viper.Viper) *cobra.Command {
 		Run: func(cmd *cobra.Command, args []string) {
 			ctx := context.Background()
 
+			if err := kubeconfig.ValidateServer(server); err != nil {
+				log.Fatalf("Result: Invalid server: %v", err)
+			}
+
 			config, err := initEKCOConfig(v)
 			if err != nil {
 				log.Fatalf("Result: Failed to initialize config: %v", err)
@@ -54,16 +64,27 @@ func ChangeLoadBalancerCmd(v *viper.Viper) *cobra.Command {
 				}
 			}
 
-			for _, node := range nodeList.Items {
-				if node.Name == exclude {
-					continue
-				}
-				err := clusterController.SetKubeconfigServer(ctx, node, server)
+			if regenCert {
+				serverURL, _ := url.Parse(server)
+				// the api server certificates must be valid for the new address before kubeconfigs use it
+				err = clusterController.RegenCerts(ctx, []string{rotate.RegenCertAPIServer}, []string{serverURL.Hostname()}, splitList(dropAltNames))
 				if err != nil {
-					log.Fatalf("Result: Failed to update kubeconfigs: %v", err)
+					log.Fatalf("Result: Failed to regenerate api server certificates: %v", err)
 				}
 			}
 
+			var nodes []corev1.Node
+			for _, node := range nodeList.Items {
+				if node.Name != exclude {
+					nodes = append(nodes, node)
+				}
+			}
+			// every node checks the new server before any kubeconfig is changed, and nodes are
+			// rolled back if one does not become ready through it
+			if err := clusterController.ChangeKubeconfigServer(ctx, nodes, server); err != nil {
+				log.Fatalf("Result: Failed to update kubeconfigs: %v", err)
+			}
+
 			fmt.Println("Result: success") // Scripts rely on this exact string to indicate success
 		},
 	}
@@ -71,6 +92,8 @@ func ChangeLoadBalancerCmd(v *viper.Viper) *cobra.Command {
 	cmd.Flags().StringVar(&server, "server", "", "New load balancer address, including protocol")
 	cmd.Flags().BoolVar(&internal, "internal", false, "Enable the internal load balancer")
 	cmd.Flags().StringVar(&exclude, "exclude", "", "Node to exclude")
+	cmd.Flags().BoolVar(&regenCert, "regen-cert", true, "Add the new load balancer address to the api server certificates on all primaries")
+	cmd.Flags().StringVar(&dropAltNames, "drop-alt-names", "", "Comma-separated list of subject alternative names, such as the old load balancer address, to drop from the api server certificates")
 
 	_ = cmd.MarkFlagRequired("server")
 
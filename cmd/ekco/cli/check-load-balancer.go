@@ -0,0 +1,76 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/replicatedhq/ekco/pkg/cluster"
+	"github.com/replicatedhq/ekco/pkg/hosttask"
+	"github.com/replicatedhq/ekco/pkg/kubeconfig"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func CheckLoadBalancerCmd(v *viper.Viper) *cobra.Command {
+	var hostEtcDir string
+	var server string
+	var resultFile string
+
+	cmd := &cobra.Command{
+		Use:    "check-load-balancer",
+		Short:  "Check that the node can reach the Kubernetes API server through a new load balancer address",
+		Hidden: true,
+		Args:   cobra.ExactArgs(0),
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return v.BindPFlags(cmd.Flags())
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			result := hosttask.NewResult(cluster.CheckLoadBalancerValue, "")
+			err := result.Error(checkLoadBalancer(context.Background(), result, hostEtcDir, server))
+			if err := result.Write(resultFile); err != nil {
+				fmt.Printf("Error: %v\n", err)
+			}
+			return err
+		},
+	}
+
+	cmd.Flags().StringVar(&server, "server", "", "Address of Kubernetes API server including protocol, e.g. https://localhost:6444")
+	cmd.Flags().StringVar(&hostEtcDir, "host-etc-dir", "/etc", "Etc directory where kubeconfigs reside")
+	cmd.Flags().StringVar(&resultFile, "result-file", "", "Write the JSON result of the task to this file")
+
+	return cmd
+}
+
+// checkLoadBalancer verifies the new server with the certificate authority of kubelet.conf and
+// reports the server kubelet.conf currently uses so that the operator can roll back to it
+func checkLoadBalancer(ctx context.Context, result *hosttask.Result, hostEtcDir string, server string) error {
+	if err := kubeconfig.ValidateServer(server); err != nil {
+		return err
+	}
+
+	current, ca, err := kubeconfig.GetCluster(filepath.Join(hostEtcDir, "kubernetes/kubelet.conf"), hostEtcDir)
+	if err != nil {
+		return fmt.Errorf("read kubelet.conf: %v", err)
+	}
+	result.SetOutput("server", current)
+
+	addrs, err := kubeconfig.ResolveServer(ctx, server)
+	if err != nil {
+		return err
+	}
+	result.Stepf("dns", "Resolved %s to %s", server, strings.Join(addrs, ", "))
+
+	if err := kubeconfig.CheckServerCertificate(ctx, server, ca); err != nil {
+		return err
+	}
+	result.Stepf("tls", "Verified the certificate of %s", server)
+
+	if err := kubeconfig.CheckServerReadyz(ctx, server, ca); err != nil {
+		return fmt.Errorf("check readyz through %s: %v", server, err)
+	}
+	result.Stepf("readyz", "Kubernetes API server is ready through %s", server)
+
+	return nil
+}
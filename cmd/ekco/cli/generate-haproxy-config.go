@@ -10,6 +10,7 @@ import (
 
 func GenerateHAProxyConfigCmd(v *viper.Viper) *cobra.Command {
 	primaries := &[]string{}
+	options := internallb.Options{}
 
 	cmd := &cobra.Command{
 		Use:   "generate-haproxy-config",
@@ -19,8 +20,11 @@ func GenerateHAProxyConfigCmd(v *viper.Viper) *cobra.Command {
 			return v.BindPFlags(cmd.Flags())
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := options.Validate(internallb.ModeHAProxy); err != nil {
+				return err
+			}
 
-			out, err := internallb.GenerateHAProxyConfig(*primaries...)
+			out, err := internallb.GenerateHAProxyConfig(options, *primaries...)
 			if err != nil {
 				return err
 			}
@@ -32,6 +36,7 @@ func GenerateHAProxyConfigCmd(v *viper.Viper) *cobra.Command {
 	}
 
 	cmd.Flags().StringSliceVar(primaries, "primary-host", []string{}, "Kubernetes API server IP or hostname")
+	addInternalLBOptionsFlags(cmd, &options)
 
 	return cmd
 }
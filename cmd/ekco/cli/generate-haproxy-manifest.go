@@ -1,6 +1,12 @@
 package cli
 
 import (
+	"fmt"
+	"os"
+
+	"github.com/replicatedhq/ekco/pkg/cluster"
+	"github.com/replicatedhq/ekco/pkg/hostfile"
+	"github.com/replicatedhq/ekco/pkg/hosttask"
 	"github.com/replicatedhq/ekco/pkg/internallb"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -10,6 +16,13 @@ func GenerateHAProxyManifestCmd(v *viper.Viper) *cobra.Command {
 	primaries := &[]string{}
 	var filename string
 	var image string
+	var removeFile string
+	var resultFile string
+	var hostRootDir string
+	var backupDir string
+	var configFile string
+	var newConfigFile string
+	options := internallb.Options{}
 
 	cmd := &cobra.Command{
 		Use:   "generate-haproxy-manifest",
@@ -19,17 +32,38 @@ func GenerateHAProxyManifestCmd(v *viper.Viper) *cobra.Command {
 			return v.BindPFlags(cmd.Flags())
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
-			_, err := internallb.GenerateHAProxyManifest(filename, image, internallb.DefaultFileversion)
-			if err != nil {
-				return err
+			result := hosttask.NewResult(cluster.UpdateInternalLBValue, "")
+			backups := hostfile.Backups{Dir: backupDir, HostRoot: hostRootDir, Task: cluster.UpdateInternalLBValue}
+			err := installHAProxyConfig(backups, newConfigFile, configFile, result)
+			if err == nil {
+				err = removeManifest(backups, removeFile, result)
 			}
-
-			return nil
+			if err == nil {
+				var changed bool
+				changed, err = internallb.GenerateHAProxyManifest(backups, filename, image, internallb.DefaultFileversion, options)
+				if err == nil && changed {
+					result.Stepf("update", "Updated haproxy manifest %s", filename)
+					result.ChangedFile(filename)
+					result.RestartedComponent("haproxy")
+				}
+			}
+			err = result.Error(err)
+			if err := result.Write(resultFile); err != nil {
+				fmt.Printf("Error: %v\n", err)
+			}
+			return err
 		},
 	}
 
 	cmd.Flags().StringVar(&filename, "file", "/etc/kubernetes/manifests/haproxy", "Filename for the haproxy static pod manifest")
 	cmd.Flags().StringVar(&image, "image", internallb.HAProxyImage, "Container image for the haproxy static pod manifest")
+	cmd.Flags().StringVar(&removeFile, "remove-file", "", "Remove the ekco proxy static pod manifest at this path")
+	cmd.Flags().StringVar(&resultFile, "result-file", "", "Write the JSON result of the task to this file")
+	cmd.Flags().StringVar(&hostRootDir, "host-root-dir", "/", "Directory the host root filesystem is mounted at")
+	cmd.Flags().StringVar(&backupDir, "backup-dir", hostfile.DefaultBackupDir, "Directory to keep the original manifests in, empty to not keep backups")
+	cmd.Flags().StringVar(&configFile, "config-file", "/etc/haproxy/haproxy.cfg", "Path of the haproxy config mounted in the haproxy static pod")
+	cmd.Flags().StringVar(&newConfigFile, "new-config-file", "", "Replace the haproxy config with this file, which is removed")
+	addInternalLBOptionsFlags(cmd, &options)
 
 	cmd.Flags().StringSliceVar(primaries, "primary-host", []string{}, "Kubernetes API server IP or hostname")
 	_ = cmd.Flags().MarkDeprecated("primary-host", "this flag is no longer used")
@@ -37,3 +71,25 @@ func GenerateHAProxyManifestCmd(v *viper.Viper) *cobra.Command {
 
 	return cmd
 }
+
+// installHAProxyConfig copies the new config over the current config and removes the new config.
+// The current config is overwritten in place to keep the inode of the file mounted in the haproxy
+// pod.
+func installHAProxyConfig(backups hostfile.Backups, newConfigFile, configFile string, result *hosttask.Result) error {
+	if newConfigFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(newConfigFile)
+	if err != nil {
+		return err
+	}
+	changed, err := backups.WriteFileInPlace(configFile, data, 0644)
+	if err != nil {
+		return fmt.Errorf("write %s: %v", configFile, err)
+	}
+	if changed {
+		result.Stepf("update", "Updated haproxy config %s", configFile)
+		result.ChangedFile(configFile)
+	}
+	return os.Remove(newConfigFile)
+}
@@ -0,0 +1,32 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/replicatedhq/ekco/pkg/internallb"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func GenerateInternalLBBackendsCmd(v *viper.Viper) *cobra.Command {
+	primaries := &[]string{}
+	options := internallb.Options{}
+
+	cmd := &cobra.Command{
+		Use:   "generate-internal-lb-backends",
+		Short: "Generate the backends file for the internal load balancer proxy",
+		Args:  cobra.ExactArgs(0),
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return v.BindPFlags(cmd.Flags())
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			fmt.Print(string(internallb.GenerateProxyBackends(options.UpstreamPort, *primaries...)))
+			return nil
+		},
+	}
+
+	cmd.Flags().StringSliceVar(primaries, "primary-host", []string{}, "Kubernetes API server IP or hostname")
+	addInternalLBOptionsFlags(cmd, &options)
+
+	return cmd
+}
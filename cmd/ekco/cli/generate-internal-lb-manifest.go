@@ -0,0 +1,78 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/replicatedhq/ekco/pkg/cluster"
+	"github.com/replicatedhq/ekco/pkg/hostfile"
+	"github.com/replicatedhq/ekco/pkg/hosttask"
+	"github.com/replicatedhq/ekco/pkg/internallb"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func GenerateInternalLBManifestCmd(v *viper.Viper) *cobra.Command {
+	var filename string
+	var image string
+	var removeFile string
+	var resultFile string
+	var hostRootDir string
+	var backupDir string
+	options := internallb.Options{}
+
+	cmd := &cobra.Command{
+		Use:   "generate-internal-lb-manifest",
+		Short: "Generate the ekco proxy manifest file for the internal load balancer",
+		Args:  cobra.ExactArgs(0),
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return v.BindPFlags(cmd.Flags())
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			result := hosttask.NewResult(cluster.UpdateInternalLBValue, "")
+			backups := hostfile.Backups{Dir: backupDir, HostRoot: hostRootDir, Task: cluster.UpdateInternalLBValue}
+			err := removeManifest(backups, removeFile, result)
+			if err == nil {
+				var changed bool
+				changed, err = internallb.GenerateProxyManifest(backups, filename, image, internallb.DefaultFileversion, options)
+				if err == nil && changed {
+					result.Stepf("update", "Updated internal load balancer manifest %s", filename)
+					result.ChangedFile(filename)
+					result.RestartedComponent("ekco-internal-lb")
+				}
+			}
+			err = result.Error(err)
+			if err := result.Write(resultFile); err != nil {
+				fmt.Printf("Error: %v\n", err)
+			}
+			return err
+		},
+	}
+
+	cmd.Flags().StringVar(&filename, "file", "/etc/kubernetes/manifests/ekco-internal-lb.yaml", "Filename for the proxy static pod manifest")
+	cmd.Flags().StringVar(&image, "image", "", "Ekco container image for the proxy static pod manifest")
+	cmd.Flags().StringVar(&removeFile, "remove-file", "", "Remove the haproxy static pod manifest at this path")
+	cmd.Flags().StringVar(&resultFile, "result-file", "", "Write the JSON result of the task to this file")
+	cmd.Flags().StringVar(&hostRootDir, "host-root-dir", "/", "Directory the host root filesystem is mounted at")
+	cmd.Flags().StringVar(&backupDir, "backup-dir", hostfile.DefaultBackupDir, "Directory to keep the original manifests in, empty to not keep backups")
+	_ = cmd.MarkFlagRequired("image")
+	addInternalLBOptionsFlags(cmd, &options)
+
+	return cmd
+}
+
+// removeManifest removes the static pod manifest of the other internal load balancer mode so that
+// only one listens on the load balancer port
+func removeManifest(backups hostfile.Backups, filename string, result *hosttask.Result) error {
+	if filename == "" {
+		return nil
+	}
+	removed, err := internallb.RemoveManifest(backups, filename)
+	if err != nil {
+		return err
+	}
+	if removed {
+		result.Stepf("remove", "Removed static pod manifest %s", filename)
+		result.ChangedFile(filename)
+	}
+	return nil
+}
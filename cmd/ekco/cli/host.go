@@ -0,0 +1,79 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/replicatedhq/ekco/pkg/hostfile"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func HostCmd(v *viper.Viper) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "host",
+		Short: "Manage host files",
+		Long:  `Manage the files ekco host tasks change on this host`,
+	}
+
+	cmd.AddCommand(HostRestoreCmd(v))
+
+	return cmd
+}
+
+func HostRestoreCmd(v *viper.Viper) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "restore",
+		Short: "Restore host files",
+		Long:  `Roll back the last change ekco made to the files on this host, or the backup given by --name`,
+		Args:  cobra.ExactArgs(0),
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return v.BindPFlags(cmd.Flags())
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			backupDir := v.GetString("backup-dir")
+
+			names, err := hostfile.List(backupDir)
+			if err != nil {
+				return errors.Wrap(err, "failed to list backups")
+			}
+
+			if v.GetBool("list") {
+				for _, name := range names {
+					fmt.Println(name)
+				}
+				return nil
+			}
+
+			name := v.GetString("name")
+			if name == "" {
+				if len(names) == 0 {
+					return fmt.Errorf("no backups in %s", backupDir)
+				}
+				name = names[0]
+			}
+
+			files, err := hostfile.Restore(backupDir, name, v.GetString("host-root-dir"))
+			if err != nil {
+				return errors.Wrapf(err, "failed to restore backup %s", name)
+			}
+			for _, file := range files {
+				if file.Existed {
+					fmt.Printf("Restored %s\n", file.Path)
+				} else {
+					fmt.Printf("Removed %s\n", file.Path)
+				}
+			}
+			fmt.Printf("Restored backup %s. Restart the components that read these files.\n", name)
+
+			return nil
+		},
+	}
+
+	cmd.Flags().String("backup-dir", hostfile.DefaultBackupDir, "Directory of the backups")
+	cmd.Flags().String("host-root-dir", "/", "Directory the host root filesystem is mounted at")
+	cmd.Flags().String("name", "", "Name of the backup to restore instead of the latest")
+	cmd.Flags().Bool("list", false, "List the backups that can be restored, latest first")
+
+	return cmd
+}
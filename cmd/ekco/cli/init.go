@@ -1,10 +1,15 @@
 package cli
 
 import (
+	"context"
+
 	"github.com/pkg/errors"
 	"github.com/replicatedhq/ekco/pkg/cluster"
 	"github.com/replicatedhq/ekco/pkg/cluster/types"
 	"github.com/replicatedhq/ekco/pkg/ekcoops"
+	"github.com/replicatedhq/ekco/pkg/internallb"
+	"github.com/replicatedhq/ekco/pkg/util"
+	cephv1api "github.com/rook/rook/pkg/apis/ceph.rook.io/v1"
 	cephv1 "github.com/rook/rook/pkg/client/clientset/versioned/typed/ceph.rook.io/v1"
 	"github.com/spf13/viper"
 	velerov1 "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
@@ -20,6 +25,8 @@ import (
 
 func init() {
 	utilruntime.Must(velerov1.AddToScheme(scheme.Scheme))
+	// events are recorded for the CephCluster
+	utilruntime.Must(cephv1api.AddToScheme(scheme.Scheme))
 }
 
 func initEKCOConfig(v *viper.Viper) (*ekcoops.Config, error) {
@@ -32,6 +39,28 @@ func initEKCOConfig(v *viper.Viper) (*ekcoops.Config, error) {
 		return config, errors.New("min_ready_master_nodes must be at least 1")
 	}
 
+	if err := config.CertPolicies.Validate(config.RotateCertsTTL); err != nil {
+		return config, errors.Wrap(err, "invalid cert_policies")
+	}
+
+	switch config.InternalLoadBalancerMode {
+	case "":
+		config.InternalLoadBalancerMode = internallb.ModeHAProxy
+	case internallb.ModeHAProxy, internallb.ModeEKCO:
+	default:
+		return config, errors.Errorf("internal_load_balancer_mode must be %s or %s", internallb.ModeHAProxy, internallb.ModeEKCO)
+	}
+	config.InternalLoadBalancer.Port = config.InternalLoadBalancerPort
+	if err := config.InternalLoadBalancer.Validate(config.InternalLoadBalancerMode); err != nil {
+		return config, errors.Wrap(err, "invalid internal_load_balancer")
+	}
+
+	switch config.PreferredIPFamily {
+	case "", util.IPFamilyIPv4, util.IPFamilyIPv6:
+	default:
+		return config, errors.Errorf("preferred_ip_family must be %s or %s", util.IPFamilyIPv4, util.IPFamilyIPv6)
+	}
+
 	if !v.IsSet("contour_namespace") && v.IsSet("contour_cert_namespace") {
 		config.ContourNamespace = config.ContourCertNamespace
 	}
@@ -76,7 +105,7 @@ func initClusterController(config *ekcoops.Config, log *zap.SugaredLogger) (*clu
 		Resource: "alertmanagers",
 	})
 
-	return cluster.NewController(types.ControllerConfig{
+	controller := cluster.NewController(types.ControllerConfig{
 		ClientConfig:                          clientConfig,
 		Client:                                kclient,
 		CtrlClient:                            ctrlClient,
@@ -99,13 +128,27 @@ func initClusterController(config *ekcoops.Config, log *zap.SugaredLogger) (*clu
 		ContourNamespace:                      config.ContourNamespace,
 		ContourCertSecret:                     config.ContourCertSecret,
 		EnvoyCertSecret:                       config.EnvoyCertSecret,
+		CertIssuers:                           config.CertIssuers,
+		CertPolicies:                          config.CertPolicies,
+		DynamicClient:                         dynamicClient,
 		RestartFailedEnvoyPods:                config.RestartFailedEnvoyPods,
 		EnvoyPodsNotReadyDuration:             config.EnvoyPodsNotReadyDuration,
 		EnableInternalLoadBalancer:            config.EnableInternalLoadBalancer,
 		InternalLoadBalancerHAProxyImage:      config.InternalLoadBalancerHAProxyImage,
+		InternalLoadBalancerMode:              config.InternalLoadBalancerMode,
+		InternalLoadBalancer:                  config.InternalLoadBalancer,
+		PreferredIPFamily:                     config.PreferredIPFamily,
 		HostTaskImage:                         config.HostTaskImage,
 		HostTaskNamespace:                     config.HostTaskNamespace,
+		KubeconfigGlobs:                       config.KubeconfigGlobs,
 		AutoApproveKubeletCertSigningRequests: config.AutoApproveKubeletCertSigningRequests,
 		RookCephImage:                         config.RookCephImage,
-	}, log), nil
+		CephObjectStoreECDataChunks:           config.CephObjectStoreECDataChunks,
+		CephObjectStoreECCodingChunks:         config.CephObjectStoreECCodingChunks,
+		CephCSIResourceProfile:                config.CephCSIResourceProfile,
+		CephCSICustomResources:                config.CephCSICustomResources,
+	}, log)
+	controller.SetDefaultHostTaskImages(context.Background())
+
+	return controller, nil
 }
@@ -0,0 +1,51 @@
+package cli
+
+import (
+	"strings"
+
+	"github.com/replicatedhq/ekco/pkg/internallb"
+	"github.com/spf13/cobra"
+)
+
+// addInternalLBOptionsFlags registers the flags returned by internallb.Options.Args
+func addInternalLBOptionsFlags(cmd *cobra.Command, o *internallb.Options) {
+	d := internallb.DefaultOptions()
+	cmd.Flags().StringVar(&o.BindAddress, "bind-address", d.BindAddress, "Address haproxy binds the frontends to")
+	cmd.Flags().IntVar(&o.Port, "port", d.Port, "Port to load balance the Kubernetes API servers on")
+	cmd.Flags().IntVar(&o.UpstreamPort, "upstream-port", d.UpstreamPort, "Port of the Kubernetes API servers")
+	cmd.Flags().StringVar(&o.Balance, "balance", d.Balance, "Balance algorithm: "+strings.Join(internallb.BalanceAlgorithms, ", "))
+	cmd.Flags().DurationVar(&o.HealthCheckInterval, "health-check-interval", d.HealthCheckInterval, "Interval between health checks of each backend")
+	cmd.Flags().IntVar(&o.HealthCheckRise, "health-check-rise", d.HealthCheckRise, "Consecutive passing checks to mark a backend up")
+	cmd.Flags().IntVar(&o.HealthCheckFall, "health-check-fall", d.HealthCheckFall, "Consecutive failing checks to mark a backend down")
+	cmd.Flags().DurationVar(&o.ConnectTimeout, "connect-timeout", d.ConnectTimeout, "Timeout connecting to a backend")
+	cmd.Flags().DurationVar(&o.IdleTimeout, "idle-timeout", d.IdleTimeout, "Timeout of idle connections")
+	cmd.Flags().Var(&frontendsValue{frontends: &o.Frontends}, "frontend", "Additional frontend as name:port:upstream-port. May be repeated.")
+}
+
+type frontendsValue struct {
+	frontends *[]internallb.Frontend
+}
+
+func (v *frontendsValue) String() string {
+	if v.frontends == nil {
+		return ""
+	}
+	var s []string
+	for _, f := range *v.frontends {
+		s = append(s, f.String())
+	}
+	return strings.Join(s, ",")
+}
+
+func (v *frontendsValue) Set(s string) error {
+	f, err := internallb.ParseFrontend(s)
+	if err != nil {
+		return err
+	}
+	*v.frontends = append(*v.frontends, f)
+	return nil
+}
+
+func (v *frontendsValue) Type() string {
+	return "frontend"
+}
@@ -0,0 +1,93 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"github.com/replicatedhq/ekco/pkg/internallb"
+	"github.com/replicatedhq/ekco/pkg/logger"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func InternalLBProxyCmd(v *viper.Viper) *cobra.Command {
+	var statsAddress string
+	var backendsFile string
+	options := internallb.Options{}
+
+	cmd := &cobra.Command{
+		Use:   "internal-lb-proxy",
+		Short: "Run the TCP proxy for the internal load balancer",
+		Long:  `Balance connections across the Kubernetes API servers listed in the backends file, reloading it when it changes`,
+		Args:  cobra.ExactArgs(0),
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return v.BindPFlags(cmd.Flags())
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := options.Validate(internallb.ModeEKCO); err != nil {
+				return err
+			}
+
+			log, err := logger.FromViper(v)
+			if err != nil {
+				return errors.Wrap(err, "failed to initialize logger")
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			proxy := internallb.NewProxy(options, statsAddress, backendsFile, log)
+
+			signals := make(chan os.Signal, 1)
+			signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+			go func() {
+				for sig := range signals {
+					if sig != syscall.SIGHUP {
+						cancel()
+						return
+					}
+					if err := proxy.Reload(); err != nil {
+						log.Warnf("Failed to reload backends: %v", err)
+					}
+				}
+			}()
+
+			return proxy.Run(ctx)
+		},
+	}
+
+	cmd.Flags().StringVar(&statsAddress, "stats-address", internallb.StatsAddress, "Address to serve stats and /healthz on")
+	cmd.Flags().StringVar(&backendsFile, "backends-file", internallb.BackendsFile, "File listing the API server addresses, one per line")
+	addInternalLBOptionsFlags(cmd, &options)
+
+	return cmd
+}
+
+func InternalLBStatsCmd(v *viper.Viper) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:    "internal-lb-stats",
+		Short:  "Print the CSV stats of the internal load balancer on this host",
+		Args:   cobra.ExactArgs(0),
+		Hidden: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			resp, err := http.Get(internallb.StatsCSVURL)
+			if err != nil {
+				return errors.Wrap(err, "get stats")
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return fmt.Errorf("get stats: unexpected status %s", resp.Status)
+			}
+			_, err = io.Copy(os.Stdout, resp.Body)
+			return err
+		},
+	}
+
+	return cmd
+}
@@ -115,14 +115,21 @@ func OperatorCmd(v *viper.Viper) *cobra.Command {
 	cmd.Flags().String("ceph_object_store", "replicated", "Name of CephObjectStore to manage if maintain_rook_storage_nodes is enabled")
 	cmd.Flags().Bool("reconcile_rook_mds_placement", true, "Reconcile CephFilesystem MDS placement when the cluster is scaled beyond one node")
 	cmd.Flags().Bool("reconcile_ceph_csi_resources", true, "Set Ceph CSI provisioner and plugin resources to their recommendations once the cluster is scaled to three nodes")
+	cmd.Flags().String("ceph_csi_resource_profile", "auto", "Ceph CSI provisioner and plugin resource profile: auto, small, medium, large or custom")
+	cmd.Flags().String("ceph_csi_custom_resources", "", "Ceph CSI sidecar, plugin, registrar and liveness resource requirements YAML for the custom profile")
 	cmd.Flags().String("rook_version", "1.4.3", "Version of Rook to manage")
 	cmd.Flags().String("rook_priority_class", "node-critical", "Priority class to add to Rook 1.0 Deployments and DaemonSets. Will be created if not found")
 	cmd.Flags().Int("min_ceph_pool_replication", 1, "Minimum replication factor of ceph_block_pool and ceph_filesystem pools")
 	cmd.Flags().Int("max_ceph_pool_replication", 3, "Maximum replication factor of ceph_block_pool and ceph_filesystem pools")
+	cmd.Flags().Int("ceph_object_store_ec_data_chunks", 0, "Number of data chunks for an erasure coded object store data pool. Requires ceph_object_store_ec_coding_chunks")
+	cmd.Flags().Int("ceph_object_store_ec_coding_chunks", 0, "Number of coding chunks for an erasure coded object store data pool. Requires ceph_object_store_ec_data_chunks")
+	cmd.Flags().Bool("replace_failed_osds", false, "Replace Ceph OSDs that have been down for longer than osd_down_toleration on ready nodes")
+	cmd.Flags().Duration("osd_down_toleration", time.Hour, "Minimum OSD down time on a ready node until it is replaced")
+	cmd.Flags().String("ceph_upgrade_image", "", "Upgrade the CephCluster to this Ceph image")
 	cmd.Flags().String("certificates_dir", "/etc/kubernetes/pki", "Kubernetes certificates directory")
 	cmd.Flags().Duration("reconcile_interval", time.Minute, "Frequency to run the operator's control loop")
 	cmd.Flags().String("rotate_certs_namespace", "kurl", "Namespace where certificate rotation pods will run")
-	cmd.Flags().String("rotate_certs_image", "replicated/ekco:latest", "Image to use in certificate rotation pods")
+	cmd.Flags().String("rotate_certs_image", "", "Image to use in certificate rotation pods, the image of the ekco pod by digest if empty")
 	cmd.Flags().Duration("rotate_certs_check_interval", time.Hour*24, "How often to check for certs that need to be rotated")
 	cmd.Flags().Duration("rotate_certs_ttl", time.Hour*24*30, "Rotate any certificates expiring within this timeframe")
 	cmd.Flags().Bool("rotate_certs", true, "Enable automatic certificate rotation")
@@ -141,9 +148,13 @@ func OperatorCmd(v *viper.Viper) *cobra.Command {
 	cmd.Flags().Bool("restart_failed_envoy_pods", true, "Restarts envoy pods that have been in ready state 1/2 (envoy container not ready) for at least \"envoy_pods_not_ready_duration\"")
 	cmd.Flags().Duration("envoy_pods_not_ready_duration", cluster.DefaultEnvoyPodsNotReadyDuration, "Duration which to wait to restart failed envoy pods (see \"restart_failed_envoy_pods\")")
 	cmd.Flags().String("host_task_namespace", "kurl", "Namespace where pods performing host tasks will run")
-	cmd.Flags().String("host_task_image", "replicated/ekco:latest", "Image to use in host task pods")
+	cmd.Flags().String("host_task_image", "", "Image to use in host task pods, the image of the ekco pod by digest if empty")
+	cmd.Flags().StringSlice("kubeconfig_globs", nil, "Absolute glob patterns of kubeconfigs on the nodes to update with the known kubeconfigs when the load balancer changes")
 	cmd.Flags().Bool("enable_internal_load_balancer", false, "Run haproxy on localhost forwarding to all in-cluster Kubernetes API servers")
 	cmd.Flags().String("internal_load_balancer_haproxy_image", internallb.HAProxyImage, "HAProxy container image to use for internal load balancer")
+	cmd.Flags().Int("internal_load_balancer_port", internallb.DefaultPort, "Port of the internal load balancer on each node")
+	cmd.Flags().String("internal_load_balancer_mode", internallb.ModeHAProxy, "Internal load balancer to run on each node: haproxy, or ekco to run the ekco TCP proxy with the host task image")
+	cmd.Flags().String("preferred_ip_family", "", "IP family of the node addresses used for the internal load balancer and etcd on dual-stack nodes: ipv4 or ipv6. Defaults to the first InternalIP of each node.")
 	cmd.Flags().StringSlice("pod_image_overrides", nil, "Image to override in pods")
 	cmd.Flags().Bool("auto_approve_kubelet_csrs", false, "Enable auto approval of kubelet Certificate Signing Requests")
 
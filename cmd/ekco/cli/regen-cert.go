@@ -1,142 +1,111 @@
 package cli
 
 import (
+	"context"
 	"fmt"
-	"net"
+	"os"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
-	"github.com/replicatedhq/ekco/pkg/util"
+	"github.com/replicatedhq/ekco/pkg/cluster"
+	"github.com/replicatedhq/ekco/pkg/hostfile"
+	"github.com/replicatedhq/ekco/pkg/hosttask"
+	"github.com/replicatedhq/ekco/pkg/logger"
+	"github.com/replicatedhq/ekco/pkg/rotate"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
-	certutil "k8s.io/client-go/util/cert"
-	certsphase "k8s.io/kubernetes/cmd/kubeadm/app/phases/certs"
-	"k8s.io/kubernetes/cmd/kubeadm/app/phases/certs/renewal"
-	"k8s.io/kubernetes/cmd/kubeadm/app/util/pkiutil"
 )
 
 func RegenCertCmd(v *viper.Viper) *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "regen-cert",
-		Short: "Regenerate API server certificate",
-		Long:  `Regenerate an API server certificate with different subject alternative names`,
-		Args:  cobra.ExactArgs(0),
+		Short: "Regenerate serving certificates",
+		Long: `Regenerate the API server or etcd serving certificates with different subject alternative names.
+
+By default the certificates on this host are regenerated. With --cluster the certificates are
+regenerated on every primary, one at a time, and the API server and etcd are restarted.`,
+		Args: cobra.ExactArgs(0),
 		PreRunE: func(cmd *cobra.Command, args []string) error {
 			return v.BindPFlags(cmd.Flags())
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
-			addAltNames := v.GetString("add-alt-names")
-			dropAltNames := v.GetString("drop-alt-names")
-			certPath := "/etc/kubernetes/pki/apiserver.crt"
-
-			certs, err := certutil.CertsFromFile(certPath)
-			if err != nil {
-				return errors.Wrapf(err, "parse cert from %s", certPath)
-			}
-			if len(certs) != 1 {
-				return fmt.Errorf("Expected exactly 1 cert in %s, got %d", certPath, len(certs))
-			}
-			cert := certs[0]
-
-			// check if encryption algorithm is supported
-			encryptionAlgorithm, err := util.GetEncryptionAlgorithmType(cert)
-			if err != nil {
-				return errors.Wrapf(err, "get encryption algorithm type from %s", certPath)
-			}
-
-			cfg := &pkiutil.CertConfig{
-				Config: certutil.Config{
-					CommonName:   cert.Subject.CommonName,
-					Organization: cert.Subject.Organization,
-					AltNames:     certutil.AltNames{},
-					Usages:       cert.ExtKeyUsage,
-				},
-				NotAfter:            cert.NotAfter,
-				EncryptionAlgorithm: encryptionAlgorithm,
-			}
-
-			newCertIPs := map[string]net.IP{}
-			newCertDNSNames := map[string]string{}
-
-			dropIPs := map[string]net.IP{}
-			dropDNSNames := map[string]string{}
-
-			for _, dropAltName := range strings.Split(dropAltNames, ",") {
-				ip := net.ParseIP(dropAltName)
-				if ip != nil {
-					dropIPs[ip.String()] = ip
-					continue
+			certs := splitList(v.GetString("certs"))
+			addAltNames := splitList(v.GetString("add-alt-names"))
+			dropAltNames := splitList(v.GetString("drop-alt-names"))
+
+			if v.GetBool("cluster") {
+				config, err := initEKCOConfig(v)
+				if err != nil {
+					return errors.Wrap(err, "failed to initialize config")
 				}
-				dropDNSNames[dropAltName] = dropAltName
-			}
-
-			for _, oldCertIP := range cert.IPAddresses {
-				if _, drop := dropIPs[oldCertIP.String()]; drop {
-					fmt.Printf("Drop IP from old cert: %s\n", oldCertIP)
-					continue
-				}
-				fmt.Printf("Include IP from old cert: %s\n", oldCertIP)
-				newCertIPs[oldCertIP.String()] = oldCertIP
-			}
-			for _, oldCertDNSName := range cert.DNSNames {
-				if oldCertDNSName == "" {
-					continue
+				log, err := logger.FromViper(v)
+				if err != nil {
+					return errors.Wrap(err, "failed to initialize logger")
 				}
-				if _, drop := dropDNSNames[oldCertDNSName]; drop {
-					fmt.Printf("Drop DNS name from old cert: %s\n", oldCertDNSName)
-					continue
+				clusterController, err := initClusterController(config, log)
+				if err != nil {
+					return errors.Wrap(err, "failed to initialize cluster controller")
 				}
-				fmt.Printf("Include DNS name from old cert: %s\n", oldCertDNSName)
-				newCertDNSNames[oldCertDNSName] = oldCertDNSName
-			}
 
-			for _, addAltName := range strings.Split(addAltNames, ",") {
-				if addAltName == "" {
-					continue
-				}
-				ip := net.ParseIP(addAltName)
-				if ip != nil {
-					if _, ok := newCertIPs[ip.String()]; ok {
-						continue
-					}
-					fmt.Printf("Add new IP: %s\n", ip)
-					newCertIPs[ip.String()] = ip
-					continue
-				}
-				if _, ok := newCertDNSNames[ip.String()]; ok {
-					continue
-				}
-				fmt.Printf("Add new DNS name: %s\n", addAltName)
-				newCertDNSNames[addAltName] = addAltName
-			}
+				ctx, cancel := context.WithTimeout(context.Background(), v.GetDuration("timeout"))
+				defer cancel()
 
-			for _, ip := range newCertIPs {
-				cfg.AltNames.IPs = append(cfg.AltNames.IPs, ip)
-			}
-			for _, dnsName := range newCertDNSNames {
-				cfg.AltNames.DNSNames = append(cfg.AltNames.DNSNames, dnsName)
+				return clusterController.RegenCerts(ctx, certs, addAltNames, dropAltNames)
 			}
 
-			caCert, caKey, err := certsphase.LoadCertificateAuthority("/etc/kubernetes/pki", "ca")
-			if err != nil {
-				return errors.Wrap(err, "parse CA from /etc/kubernets/pki")
+			hostname := v.GetString("hostname")
+			opts := rotate.RegenCertsOptions{
+				PKIDir:       v.GetString("pki-dir"),
+				Certs:        certs,
+				AddAltNames:  addAltNames,
+				DropAltNames: dropAltNames,
+				Restart:      v.GetBool("restart"),
+				Hostname:     hostname,
+				BackupDir:    v.GetString("backup-dir"),
 			}
 
-			newCert, newKey, err := renewal.NewFileRenewer(caCert, caKey).Renew(cfg)
-			if err != nil {
-				return errors.Wrapf(err, "regenerate %s", certPath)
+			result := hosttask.NewResult(cluster.RegenCertValue, hostname)
+			err := result.Error(rotate.RegenCerts(opts, result))
+			resultFile := v.GetString("result-file")
+			if resultFile == "" {
+				for _, step := range result.Steps {
+					fmt.Println(step.Message)
+				}
+			} else if err := result.Write(resultFile); err != nil {
+				fmt.Printf("Error: %v\n", err)
 			}
-
-			if err := pkiutil.WriteCertAndKey("/etc/kubernetes/pki", "apiserver", newCert, newKey); err != nil {
-				return errors.Wrap(err, "write new cert to /etc/kubernetes/pki/apiserver.crt")
+			if err != nil {
+				if resultFile != "" {
+					os.Exit(1)
+				}
+				return err
 			}
-
 			return nil
 		},
 	}
 
+	cmd.Flags().String("certs", rotate.RegenCertAPIServer, fmt.Sprintf("Comma-separated list of certificates to regenerate: %s, %s or %s", rotate.RegenCertAPIServer, rotate.RegenCertEtcdServer, rotate.RegenCertEtcdPeer))
 	cmd.Flags().String("add-alt-names", "", "Comma-separated list of subject alternative names to add to the new certificate")
 	cmd.Flags().String("drop-alt-names", "", "Comma-separated list of subject alternative names to drop from the new certificate")
+	cmd.Flags().Bool("cluster", false, "Regenerate the certificates on all primaries")
+	cmd.Flags().Bool("restart", false, "Restart the components serving the regenerated certificates on this host")
+	cmd.Flags().String("pki-dir", "/etc/kubernetes/pki", "Kubernetes PKI directory")
+	cmd.Flags().Duration("timeout", time.Hour, "Maximum time to wait for all primaries with --cluster")
+	cmd.Flags().String("hostname", "", "Hostname where this pod is running")
+	cmd.Flags().String("result-file", "", "Write the JSON result of the task to this file")
+	cmd.Flags().String("backup-dir", hostfile.DefaultBackupDir, "Directory to keep the original certificates in, empty to not keep backups")
 
 	return cmd
 }
+
+// splitList splits a comma-separated list, dropping empty entries
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
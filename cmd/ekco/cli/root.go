@@ -32,12 +32,23 @@ func RootCmd(v *viper.Viper) *cobra.Command {
 	cmd.AddCommand(OperatorCmd(v))
 	cmd.AddCommand(PurgeNodeCmd(v))
 	cmd.AddCommand(RotateCertsCmd(v))
+	cmd.AddCommand(RotateKubeletCertsCmd(v))
 	cmd.AddCommand(RegenCertCmd(v))
 	cmd.AddCommand(RotateKotsadmCertsCmd(v))
 	cmd.AddCommand(GenerateHAProxyConfigCmd(v))
 	cmd.AddCommand(GenerateHAProxyManifestCmd(v))
+	cmd.AddCommand(GenerateInternalLBBackendsCmd(v))
+	cmd.AddCommand(GenerateInternalLBManifestCmd(v))
+	cmd.AddCommand(InternalLBProxyCmd(v))
+	cmd.AddCommand(InternalLBStatsCmd(v))
 	cmd.AddCommand(ChangeLoadBalancerCmd(v))
 	cmd.AddCommand(SetKubeconfigServerCmd(v))
+	cmd.AddCommand(CheckLoadBalancerCmd(v))
+	cmd.AddCommand(UpgradeCephCmd(v))
+	cmd.AddCommand(CertsCmd(v))
+	cmd.AddCommand(RotateCACmd(v))
+	cmd.AddCommand(RotateCAHostCmd(v))
+	cmd.AddCommand(HostCmd(v))
 
 	cobra.OnInitialize(initConfig(v, cfgFile))
 	v.AutomaticEnv()
@@ -0,0 +1,138 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/replicatedhq/ekco/pkg/cluster"
+	"github.com/replicatedhq/ekco/pkg/hostfile"
+	"github.com/replicatedhq/ekco/pkg/hosttask"
+	"github.com/replicatedhq/ekco/pkg/logger"
+	"github.com/replicatedhq/ekco/pkg/rotate"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func RotateCACmd(v *viper.Viper) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "rotate-ca",
+		Short: "Rotate the Kubernetes CAs",
+		Long: `Rotate the cluster, front-proxy and etcd CAs in a kURL cluster.
+
+Rotation runs in phases: generate, trust, restart-workloads, reissue, verify and finalize.
+Each run of this command completes the next phase and saves a checkpoint, so an interrupted
+phase can be resumed by running the command again. Use --all to run all remaining phases.`,
+		Args: cobra.ExactArgs(0),
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return v.BindPFlags(cmd.Flags())
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config, err := initEKCOConfig(v)
+			if err != nil {
+				return errors.Wrap(err, "failed to initialize config")
+			}
+
+			log, err := logger.FromViper(v)
+			if err != nil {
+				return errors.Wrap(err, "failed to initialize logger")
+			}
+
+			clusterController, err := initClusterController(config, log)
+			if err != nil {
+				return errors.Wrap(err, "failed to initialize cluster controller")
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), v.GetDuration("timeout"))
+			defer cancel()
+
+			if v.GetBool("status") {
+				status, err := clusterController.GetCARotationStatus(ctx)
+				if err != nil {
+					return errors.Wrap(err, "failed to get CA rotation status")
+				}
+				switch {
+				case status == nil:
+					fmt.Println("CAs have not been rotated")
+				case status.InProgress():
+					fmt.Printf("CA rotation started %s completed phase %q, next phase %q\n", status.Started.Format(time.RFC3339), status.Phase, status.NextPhase())
+				default:
+					fmt.Printf("CA rotation started %s is complete\n", status.Started.Format(time.RFC3339))
+				}
+				return nil
+			}
+
+			for {
+				phase, done, err := clusterController.RotateCANextPhase(ctx, v.GetBool("restart-workloads"))
+				if err != nil {
+					return errors.Wrap(err, "failed to rotate CAs")
+				}
+				if done {
+					log.Infof("CA rotation complete")
+					return nil
+				}
+				if !v.GetBool("all") {
+					log.Infof("Completed CA rotation phase %s. Run this command again to continue.", phase)
+					return nil
+				}
+			}
+		},
+	}
+
+	cmd.Flags().Bool("all", false, "Run all remaining phases")
+	cmd.Flags().Bool("status", false, "Print the status of the CA rotation and exit")
+	cmd.Flags().Bool("restart-workloads", true, "Restart deployments, daemonsets and statefulsets one at a time so that pods trust the new CA before leaf certificates are reissued. Ceph daemons and ekco are not restarted.")
+	cmd.Flags().Duration("timeout", time.Hour, "Maximum time to wait for the phases to complete")
+
+	return cmd
+}
+
+func RotateCAHostCmd(v *viper.Viper) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:    "rotate-ca-host",
+		Short:  "Run a phase of CA rotation on this host",
+		Long:   `Run a phase of CA rotation on this host. This is run by the rotate-ca pods.`,
+		Hidden: true,
+		Args:   cobra.ExactArgs(0),
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return v.BindPFlags(cmd.Flags())
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			hostname := v.GetString("hostname")
+			opts := rotate.RotateCAOptions{
+				Phase:         v.GetString("phase"),
+				ConfDir:       v.GetString("conf-dir"),
+				KubeletPKIDir: v.GetString("kubelet-pki-dir"),
+				NewCADir:      v.GetString("new-ca-dir"),
+				Hostname:      hostname,
+				Primary:       v.GetBool("primary"),
+				BackupDir:     v.GetString("backup-dir"),
+			}
+
+			result := hosttask.NewResult(cluster.RotateCAValue, hostname)
+			err := result.Error(rotate.RotateCA(context.Background(), opts, result))
+			if err := result.Write(v.GetString("result-file")); err != nil {
+				fmt.Printf("Error: %v\n", err)
+			}
+			if err != nil {
+				os.Exit(1)
+			}
+		},
+	}
+
+	cmd.Flags().String("phase", "", "CA rotation phase to run")
+	cmd.Flags().Bool("primary", false, "This host is a primary")
+	cmd.Flags().String("new-ca-dir", "", "Directory with the new CA certs and keys")
+	cmd.Flags().String("conf-dir", cluster.DefaultEtcKubernetesDir, "Kubernetes configuration directory")
+	cmd.Flags().String("kubelet-pki-dir", cluster.DefaultKubeletPKIDir, "Kubelet PKI directory")
+	cmd.Flags().String("hostname", "", "Hostname where this pod is running")
+	cmd.Flags().String("result-file", "", "Write the JSON result of the task to this file")
+	cmd.Flags().String("backup-dir", hostfile.DefaultBackupDir, "Directory to keep the original certificates and kubeconfigs in, empty to not keep backups")
+
+	_ = cmd.MarkFlagRequired("phase")
+	_ = cmd.MarkFlagRequired("new-ca-dir")
+
+	return cmd
+}
@@ -5,6 +5,10 @@ import (
 	"os"
 	"time"
 
+	"github.com/replicatedhq/ekco/pkg/certpolicy"
+	"github.com/replicatedhq/ekco/pkg/cluster"
+	"github.com/replicatedhq/ekco/pkg/hostfile"
+	"github.com/replicatedhq/ekco/pkg/hosttask"
 	"github.com/replicatedhq/ekco/pkg/rotate"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -20,19 +24,35 @@ func RotateCertsCmd(v *viper.Viper) *cobra.Command {
 			return v.BindPFlags(cmd.Flags())
 		},
 		Run: func(cmd *cobra.Command, args []string) {
-			ttl := v.GetDuration("ttl")
+			policy := certpolicy.Policy{
+				RenewBefore:  v.GetDuration("ttl"),
+				Validity:     v.GetDuration("validity"),
+				KeyAlgorithm: v.GetString("key-algorithm"),
+			}
 			hostname := v.GetString("hostname")
 
-			if err := rotate.RotateCerts(ttl, hostname); err != nil {
-				// warning - this output is parsed by the ekco operator
+			result := hosttask.NewResult(cluster.RotateCertsValue, hostname)
+			err := policy.Validate()
+			if err == nil {
+				err = rotate.RotateCerts(policy, hostname, v.GetString("etcd-address"), v.GetString("backup-dir"), result)
+			}
+			err = result.Error(err)
+			if err := result.Write(v.GetString("result-file")); err != nil {
 				fmt.Printf("Error: %v\n", err)
+			}
+			if err != nil {
 				os.Exit(1)
 			}
 		},
 	}
 
 	cmd.Flags().Duration("ttl", time.Hour*24*180, "Rotate any certificates expiring within this timeframe")
+	cmd.Flags().Duration("validity", 0, "Lifetime of rotated certificates. Defaults to one year.")
+	cmd.Flags().String("key-algorithm", "", "Key algorithm of rotated certificates. Defaults to the algorithm of the cluster CA.")
 	cmd.Flags().String("hostname", "", "Hostname where this pod is running")
+	cmd.Flags().String("etcd-address", "", "Address of the local etcd member to verify serving certificates after rotation")
+	cmd.Flags().String("result-file", "", "Write the JSON result of the task to this file")
+	cmd.Flags().String("backup-dir", hostfile.DefaultBackupDir, "Directory to keep the original certificates and kubeconfigs in, empty to not keep backups")
 
 	return cmd
 }
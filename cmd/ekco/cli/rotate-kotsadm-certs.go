@@ -2,9 +2,9 @@ package cli
 
 import (
 	"context"
-	"time"
 
 	"github.com/pkg/errors"
+	"github.com/replicatedhq/ekco/pkg/certpolicy"
 	"github.com/replicatedhq/ekco/pkg/logger"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -24,7 +24,17 @@ func RotateKotsadmCertsCmd(v *viper.Viper) *cobra.Command {
 			if err != nil {
 				return errors.Wrap(err, "failed to initialize config")
 			}
-			config.RotateCertsTTL = v.GetDuration("ttl")
+			if cmd.Flags().Changed("ttl") {
+				policy := config.CertPolicies[certpolicy.ClassKurlProxy]
+				policy.RenewBefore = v.GetDuration("ttl")
+				if config.CertPolicies == nil {
+					config.CertPolicies = certpolicy.Policies{}
+				}
+				config.CertPolicies[certpolicy.ClassKurlProxy] = policy
+				if err := config.CertPolicies.Validate(config.RotateCertsTTL); err != nil {
+					return errors.Wrap(err, "invalid --ttl")
+				}
+			}
 
 			log, err := logger.FromViper(v)
 			if err != nil {
@@ -40,7 +50,7 @@ func RotateKotsadmCertsCmd(v *viper.Viper) *cobra.Command {
 		},
 	}
 
-	cmd.Flags().Duration("ttl", time.Hour*24*180, "Rotate any certificates expiring within this timeframe")
+	cmd.Flags().Duration("ttl", 0, "Rotate any certificates expiring within this timeframe. Defaults to the renew_before of the kurl-proxy cert policy.")
 
 	return cmd
 }
@@ -0,0 +1,56 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/replicatedhq/ekco/pkg/cluster"
+	"github.com/replicatedhq/ekco/pkg/hostfile"
+	"github.com/replicatedhq/ekco/pkg/hosttask"
+	"github.com/replicatedhq/ekco/pkg/rotate"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func RotateKubeletCertsCmd(v *viper.Viper) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "rotate-kubelet-certs",
+		Short: "Rotate kubelet certs",
+		Long:  `Install a new kubelet client certificate and regenerate the self-signed kubelet serving certificate on this host`,
+		Args:  cobra.ExactArgs(0),
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return v.BindPFlags(cmd.Flags())
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			hostname := v.GetString("hostname")
+			opts := rotate.RotateKubeletCertsOptions{
+				ConfigPath:    v.GetString("kubelet-config"),
+				PKIDir:        v.GetString("kubelet-pki-dir"),
+				ClientCertDir: v.GetString("client-cert-dir"),
+				Serving:       v.GetBool("serving"),
+				Hostname:      hostname,
+				BackupDir:     v.GetString("backup-dir"),
+			}
+
+			result := hosttask.NewResult(cluster.RotateKubeletCertsValue, hostname)
+			err := result.Error(rotate.RotateKubeletCerts(context.Background(), opts, result))
+			if err := result.Write(v.GetString("result-file")); err != nil {
+				fmt.Printf("Error: %v\n", err)
+			}
+			if err != nil {
+				os.Exit(1)
+			}
+		},
+	}
+
+	cmd.Flags().String("client-cert-dir", "", "Directory with tls.crt and tls.key of a new kubelet client certificate")
+	cmd.Flags().Bool("serving", false, "Regenerate the self-signed kubelet serving certificate")
+	cmd.Flags().String("kubelet-config", cluster.DefaultKubeletConfigPath, "Kubelet configuration file")
+	cmd.Flags().String("kubelet-pki-dir", cluster.DefaultKubeletPKIDir, "Kubelet PKI directory")
+	cmd.Flags().String("hostname", "", "Hostname where this pod is running")
+	cmd.Flags().String("result-file", "", "Write the JSON result of the task to this file")
+	cmd.Flags().String("backup-dir", hostfile.DefaultBackupDir, "Directory to keep the original certificates in, empty to not keep backups")
+
+	return cmd
+}
@@ -3,8 +3,15 @@ package cli
 import (
 	"context"
 	"fmt"
+	"os"
 	"path/filepath"
+	"slices"
+	"time"
 
+	"github.com/pkg/errors"
+	"github.com/replicatedhq/ekco/pkg/cluster"
+	"github.com/replicatedhq/ekco/pkg/hostfile"
+	"github.com/replicatedhq/ekco/pkg/hosttask"
 	"github.com/replicatedhq/ekco/pkg/kubeconfig"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -12,8 +19,13 @@ import (
 
 func SetKubeconfigServerCmd(v *viper.Viper) *cobra.Command {
 	var hostEtcDir string
+	var hostRootDir string
 	var server string
-	var admin bool
+	var globs []string
+	var resultFile string
+	var backupDir string
+	var nodeName string
+	var checkTimeout time.Duration
 
 	cmd := &cobra.Command{
 		Use:   "set-kubeconfig-server",
@@ -23,31 +35,212 @@ func SetKubeconfigServerCmd(v *viper.Viper) *cobra.Command {
 			return v.BindPFlags(cmd.Flags())
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
-			ctx := context.Background()
-
-			kubeletConf := filepath.Join(hostEtcDir, "kubernetes/kubelet.conf")
-			if err := kubeconfig.SetServer(kubeletConf, server); err != nil {
-				return fmt.Errorf("update kubelet.conf: %v", err)
-			}
-
-			if admin {
-				adminConf := filepath.Join(hostEtcDir, "kubernetes/admin.conf")
-				if err := kubeconfig.SetServer(adminConf, server); err != nil {
-					return fmt.Errorf("update admin.conf: %v", err)
-				}
+			// the host root was previously mounted only at the etc dir
+			if hostRootDir == "" {
+				hostRootDir = filepath.Dir(hostEtcDir)
 			}
-
-			if err := kubeconfig.RestartKubelet(ctx); err != nil {
-				return fmt.Errorf("restart kubelet: %v", err)
+			result := hosttask.NewResult(cluster.SetKubeconfigServerValue, "")
+			check := kubeletCheck{nodeName: nodeName, timeout: checkTimeout}
+			err := result.Error(setKubeconfigServer(context.Background(), result, hostRootDir, backupDir, server, globs, check))
+			if err := result.Write(resultFile); err != nil {
+				fmt.Printf("Error: %v\n", err)
 			}
-
-			return nil
+			return err
 		},
 	}
 
 	cmd.Flags().StringVar(&server, "server", "", "Address of Kubernetes API server including protocol, e.g. https://localhost:6444")
+	cmd.Flags().StringVar(&hostRootDir, "host-root-dir", "", "Directory the host root filesystem is mounted at")
 	cmd.Flags().StringVar(&hostEtcDir, "host-etc-dir", "/etc", "Etc directory where kubeconfigs reside")
-	cmd.Flags().BoolVar(&admin, "admin", false, "Update /etc/kubernetes/admin.conf")
+	cmd.Flags().StringSliceVar(&globs, "kubeconfig-glob", nil, "Additional kubeconfigs to update, as absolute glob patterns on the host")
+	cmd.Flags().Bool("admin", false, "Update /etc/kubernetes/admin.conf")
+	cmd.Flags().StringVar(&backupDir, "backup-dir", hostfile.DefaultBackupDir, "Directory to keep the original kubeconfigs in, empty to not keep backups")
+	cmd.Flags().StringVar(&resultFile, "result-file", "", "Write the JSON result of the task to this file")
+	cmd.Flags().StringVar(&nodeName, "node-name", "", "Name of the node. After kubelet is restarted its lease must be renewed through the new server, or the kubeconfigs are restored from the backup.")
+	cmd.Flags().DurationVar(&checkTimeout, "check-timeout", 3*time.Minute, "Time allowed for kubelet to renew the lease of the node through the new server")
+
+	_ = cmd.Flags().MarkDeprecated("host-etc-dir", "use --host-root-dir")
+	_ = cmd.Flags().MarkDeprecated("admin", "kubeconfigs are discovered")
 
 	return cmd
 }
+
+// kubeletCheck waits for kubelet to renew the lease of the node after it is restarted
+type kubeletCheck struct {
+	// name of the node, empty to not check
+	nodeName string
+	timeout  time.Duration
+}
+
+// time between checks of the lease of the node
+const kubeletCheckInterval = 5 * time.Second
+
+// pendingMarker is created in the backup directory before the kubeconfigs are changed and removed
+// once kubelet has been restarted with them, so that a retry after a failure restarts kubelet even
+// if the kubeconfigs already use the new server
+const pendingMarker = cluster.SetKubeconfigServerValue + ".pending"
+
+// setKubeconfigServer updates kubelet.conf and the other kubeconfigs on the host that use the same
+// server as kubelet.conf, then restarts the components that read the changed kubeconfigs. If kubelet
+// does not renew the lease of the node through the new server, the original kubeconfigs are restored
+// from the backup.
+func setKubeconfigServer(ctx context.Context, result *hosttask.Result, hostRootDir, backupDir string, server string, globs []string, check kubeletCheck) error {
+	if err := kubeconfig.ValidateServer(server); err != nil {
+		return err
+	}
+
+	hostEtcDir := filepath.Join(hostRootDir, "etc")
+	kubeletConf := filepath.Join(hostEtcDir, "kubernetes/kubelet.conf")
+	previous, _, err := kubeconfig.GetCluster(kubeletConf, hostEtcDir)
+	if err != nil {
+		return fmt.Errorf("read kubelet.conf: %v", err)
+	}
+	result.SetOutput("previousServer", previous)
+
+	files, err := kubeconfig.Discover(hostRootDir, globs)
+	if err != nil {
+		return err
+	}
+
+	// kubelet.conf is updated last so that a retry after a failure finds the previous server in it
+	slices.SortStableFunc(files, func(a, b kubeconfig.File) int {
+		if a.AllClusters == b.AllClusters {
+			return 0
+		}
+		if a.AllClusters {
+			return 1
+		}
+		return -1
+	})
+
+	backups := hostfile.Backups{Dir: backupDir, HostRoot: hostRootDir, Task: cluster.SetKubeconfigServerValue}
+	restart := map[string]bool{}
+	if backupDir != "" {
+		marker := filepath.Join(backupDir, pendingMarker)
+		if _, err := os.Stat(marker); err == nil {
+			// a previous attempt failed before kubelet was restarted with the new server
+			result.Stepf("pending", "A previous attempt did not finish")
+			restart[kubeconfig.RestartKubeletComponent] = true
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("stat %s: %v", marker, err)
+		}
+		if err := os.MkdirAll(backupDir, 0700); err != nil {
+			return fmt.Errorf("create backup directory: %v", err)
+		}
+		if err := os.WriteFile(marker, []byte(server), 0600); err != nil {
+			return fmt.Errorf("write %s: %v", marker, err)
+		}
+	}
+	for _, file := range files {
+		match := previous
+		if file.AllClusters {
+			match = ""
+		}
+		changed, err := kubeconfig.UpdateServer(backups, filepath.Join(hostRootDir, file.Path), match, server)
+		if err != nil {
+			return fmt.Errorf("update %s: %v", file.Path, err)
+		}
+		if !changed {
+			result.Stepf("skip", "No change to %s", file.Path)
+			continue
+		}
+		result.Stepf("update", "Set server %s in %s", server, file.Path)
+		result.ChangedFile(file.Path)
+		if file.Restart != "" {
+			restart[file.Restart] = true
+		}
+	}
+
+	if len(result.ChangedFiles) > 0 && backupDir != "" {
+		result.Stepf("backup", "Kept the original kubeconfigs in backup %s", backups.Name())
+	}
+
+	start := time.Now()
+	if err := restartComponents(ctx, result, backups, hostEtcDir, restart); err != nil {
+		return err
+	}
+	if check.nodeName == "" || !restart[kubeconfig.RestartKubeletComponent] {
+		return removePendingMarker(backupDir)
+	}
+
+	if err := check.wait(ctx, kubeletConf, hostRootDir, start); err != nil {
+		if len(result.ChangedFiles) == 0 || backupDir == "" {
+			return fmt.Errorf("kubelet not connected through %s: %v", server, err)
+		}
+		if _, restoreErr := hostfile.Restore(backupDir, backups.Name(), hostRootDir); restoreErr != nil {
+			return fmt.Errorf("kubelet not connected through %s: %v; restore backup %s: %v", server, err, backups.Name(), restoreErr)
+		}
+		result.Stepf("restore", "Restored the original kubeconfigs from backup %s", backups.Name())
+		// the backup has been restored, so the components are restarted without backing up again
+		if restartErr := restartComponents(ctx, result, hostfile.Backups{}, hostEtcDir, restart); restartErr != nil {
+			return fmt.Errorf("kubelet not connected through %s: %v; restart after restore: %v", server, err, restartErr)
+		}
+		if removeErr := removePendingMarker(backupDir); removeErr != nil {
+			return fmt.Errorf("kubelet not connected through %s, restored backup %s: %v; %v", server, backups.Name(), err, removeErr)
+		}
+		return fmt.Errorf("kubelet not connected through %s, restored backup %s: %v", server, backups.Name(), err)
+	}
+	result.Stepf("check", "Kubelet renewed the lease of node %s through %s", check.nodeName, server)
+
+	return removePendingMarker(backupDir)
+}
+
+func removePendingMarker(backupDir string) error {
+	if backupDir == "" {
+		return nil
+	}
+	marker := filepath.Join(backupDir, pendingMarker)
+	if err := os.Remove(marker); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("remove %s: %v", marker, err)
+	}
+	return nil
+}
+
+// restartComponents restarts the static pods and kubelet in restart
+func restartComponents(ctx context.Context, result *hosttask.Result, backups hostfile.Backups, hostEtcDir string, restart map[string]bool) error {
+	// static pods first, as restarting kubelet with a server it cannot reach stops it from
+	// recreating them
+	for _, file := range kubeconfig.KnownFiles {
+		component := file.Restart
+		if component == "" || component == kubeconfig.RestartKubeletComponent || !restart[component] {
+			continue
+		}
+		if err := backups.RestartStaticPod(filepath.Join(hostEtcDir, "kubernetes/manifests", component+".yaml")); err != nil {
+			return fmt.Errorf("restart %s: %v", component, err)
+		}
+		result.Stepf("restart", "Restarted static pod %s", component)
+		result.RestartedComponent(component)
+	}
+
+	if restart[kubeconfig.RestartKubeletComponent] {
+		if err := kubeconfig.RestartKubelet(ctx); err != nil {
+			return fmt.Errorf("restart kubelet: %v", err)
+		}
+		result.Stepf("restart", "Restarted kubelet")
+		result.RestartedComponent(kubeconfig.RestartKubeletComponent)
+	}
+
+	return nil
+}
+
+// wait checks the lease of the node with the credentials in kubelet.conf until it has been renewed
+// after since
+func (k kubeletCheck) wait(ctx context.Context, kubeletConf, hostRootDir string, since time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, k.timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(kubeletCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		err := kubeconfig.CheckNodeLease(ctx, kubeletConf, hostRootDir, k.nodeName, since)
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package cli
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/replicatedhq/ekco/pkg/logger"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func UpgradeCephCmd(v *viper.Viper) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "upgrade-ceph",
+		Short: "Upgrade Ceph",
+		Long:  `Upgrade the Rook CephCluster to a new Ceph image and wait for all daemons to be updated`,
+		Args:  cobra.ExactArgs(0),
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return v.BindPFlags(cmd.Flags())
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config, err := initEKCOConfig(v)
+			if err != nil {
+				return errors.Wrap(err, "failed to initialize config")
+			}
+
+			log, err := logger.FromViper(v)
+			if err != nil {
+				return errors.Wrap(err, "failed to initialize logger")
+			}
+
+			clusterController, err := initClusterController(config, log)
+			if err != nil {
+				return errors.Wrap(err, "failed to initialize cluster controller")
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), v.GetDuration("timeout"))
+			defer cancel()
+
+			rookVersion, err := clusterController.GetRookVersion(ctx)
+			if err != nil {
+				return errors.Wrap(err, "failed to get Rook version")
+			}
+
+			image := v.GetString("to")
+			for {
+				done, err := clusterController.ReconcileCephUpgrade(ctx, *rookVersion, image)
+				if err != nil {
+					return errors.Wrapf(err, "failed to upgrade Ceph to %s", image)
+				}
+				if done {
+					log.Infof("Ceph upgraded to %s", image)
+					return nil
+				}
+				select {
+				case <-ctx.Done():
+					return errors.Wrapf(ctx.Err(), "timed out upgrading Ceph to %s", image)
+				case <-time.After(10 * time.Second):
+				}
+			}
+		},
+	}
+
+	cmd.Flags().String("to", "", "Ceph image to upgrade to")
+	cmd.Flags().Duration("timeout", 2*time.Hour, "Maximum time to wait for the upgrade to complete")
+
+	_ = cmd.MarkFlagRequired("to")
+
+	return cmd
+}
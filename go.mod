@@ -12,6 +12,7 @@ require (
 	github.com/minio/minio-go/v7 v7.0.98
 	github.com/pkg/errors v0.9.1
 	github.com/projectcontour/contour v1.33.4
+	github.com/prometheus/client_golang v1.23.2
 	github.com/replicatedhq/pvmigrate v0.12.3
 	github.com/rook/rook v1.19.3
 	github.com/rook/rook/pkg/apis v0.0.0-20260506120302-d7751125ce56
@@ -21,6 +22,8 @@ require (
 	github.com/vmware-tanzu/velero v1.18.0
 	go.etcd.io/etcd/client/v3 v3.6.11
 	go.uber.org/zap v1.27.1
+	golang.org/x/crypto v0.49.0
+	golang.org/x/sync v0.20.0
 	gopkg.in/yaml.v2 v2.4.0
 	helm.sh/helm/v3 v3.20.2
 	k8s.io/api v0.35.4
@@ -162,7 +165,6 @@ require (
 	github.com/pmezard/go-difflib v1.0.1-0.20181226105442-5d4384ee4fb2 // indirect
 	github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring v0.87.0 // indirect
 	github.com/prometheus-operator/prometheus-operator/pkg/client v0.87.0 // indirect
-	github.com/prometheus/client_golang v1.23.2 // indirect
 	github.com/prometheus/client_model v0.6.2 // indirect
 	github.com/prometheus/common v0.67.4 // indirect
 	github.com/prometheus/procfs v0.16.1 // indirect
@@ -194,10 +196,8 @@ require (
 	go.yaml.in/yaml/v2 v2.4.4 // indirect
 	go.yaml.in/yaml/v3 v3.0.4 // indirect
 	golang.org/x/arch v0.22.0 // indirect
-	golang.org/x/crypto v0.49.0 // indirect
 	golang.org/x/net v0.52.0 // indirect
 	golang.org/x/oauth2 v0.36.0 // indirect
-	golang.org/x/sync v0.20.0 // indirect
 	golang.org/x/sys v0.42.0 // indirect
 	golang.org/x/term v0.41.0 // indirect
 	golang.org/x/text v0.36.0 // indirect
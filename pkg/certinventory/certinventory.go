@@ -0,0 +1,209 @@
+package certinventory
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"k8s.io/client-go/tools/clientcmd"
+	certutil "k8s.io/client-go/util/cert"
+	kubeadmapi "k8s.io/kubernetes/cmd/kubeadm/app/apis/kubeadm"
+	"k8s.io/kubernetes/cmd/kubeadm/app/phases/certs/renewal"
+)
+
+const (
+	SourceFile   = "file"
+	SourceSecret = "secret"
+)
+
+// Certificate describes a certificate found on a host or in a secret
+type Certificate struct {
+	// name of the kubeadm certificate or secret
+	Name        string    `json:"name"`
+	Subject     string    `json:"subject,omitempty"`
+	Issuer      string    `json:"issuer,omitempty"`
+	DNSNames    []string  `json:"dnsNames,omitempty"`
+	IPAddresses []string  `json:"ipAddresses,omitempty"`
+	NotBefore   time.Time `json:"notBefore,omitempty"`
+	NotAfter    time.Time `json:"notAfter,omitempty"`
+	// file or secret
+	Source string `json:"source"`
+	// file path or namespace/name/key of the secret
+	Location string `json:"location"`
+	// node the file was found on
+	Node string `json:"node,omitempty"`
+	// whether ekco will rotate the certificate before it expires
+	Rotate bool `json:"rotate"`
+	// set if the certificate could not be read
+	Error string `json:"error,omitempty"`
+}
+
+// New returns the inventory entry for a parsed certificate
+func New(name, source, location string, cert *x509.Certificate) Certificate {
+	c := Certificate{
+		Name:      name,
+		Subject:   cert.Subject.String(),
+		Issuer:    cert.Issuer.String(),
+		DNSNames:  cert.DNSNames,
+		NotBefore: cert.NotBefore,
+		NotAfter:  cert.NotAfter,
+		Source:    source,
+		Location:  location,
+	}
+	for _, ip := range cert.IPAddresses {
+		c.IPAddresses = append(c.IPAddresses, ip.String())
+	}
+	return c
+}
+
+// ListHostCertificates reads the certificates in the kubeadm pki directory and the client
+// certificates of the kubeconfigs in confDir. Certificates that are renewed by kubeadm, and so by
+// ekco's rotate-certs task, are marked for rotation. Certificates that cannot be read are included
+// with an error.
+func ListHostCertificates(confDir string, hostname string) ([]Certificate, error) {
+	pkiDir := filepath.Join(confDir, "pki")
+
+	rm, err := renewal.NewManager(&kubeadmapi.ClusterConfiguration{CertificatesDir: pkiDir}, confDir)
+	if err != nil {
+		return nil, errors.Wrap(err, "new renewal manager")
+	}
+	rotated := map[string]bool{}
+	for _, handler := range rm.Certificates() {
+		if strings.HasSuffix(handler.FileName, ".conf") {
+			rotated[filepath.Join(confDir, handler.FileName)] = true
+		} else {
+			rotated[filepath.Join(pkiDir, handler.FileName+".crt")] = true
+		}
+	}
+
+	certs := []Certificate{}
+
+	for _, dir := range []string{pkiDir, filepath.Join(pkiDir, "etcd")} {
+		paths, err := filepath.Glob(filepath.Join(dir, "*.crt"))
+		if err != nil {
+			return nil, errors.Wrapf(err, "list certificates in %s", dir)
+		}
+		sort.Strings(paths)
+		for _, path := range paths {
+			name := strings.TrimSuffix(strings.TrimPrefix(path, pkiDir+string(filepath.Separator)), ".crt")
+			cert := readCertFile(name, path)
+			cert.Rotate = rotated[path]
+			certs = append(certs, cert)
+		}
+	}
+
+	paths, err := filepath.Glob(filepath.Join(confDir, "*.conf"))
+	if err != nil {
+		return nil, errors.Wrapf(err, "list kubeconfigs in %s", confDir)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		cert, ok := readKubeconfigClientCert(filepath.Base(path), path)
+		if !ok {
+			continue
+		}
+		cert.Rotate = rotated[path]
+		certs = append(certs, cert)
+	}
+
+	for i := range certs {
+		certs[i].Node = hostname
+	}
+
+	return certs, nil
+}
+
+func readCertFile(name, path string) Certificate {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return errorCertificate(name, path, err)
+	}
+	return parseCert(name, path, data)
+}
+
+func parseCert(name, location string, data []byte) Certificate {
+	certs, err := certutil.ParseCertsPEM(data)
+	if err != nil {
+		return errorCertificate(name, location, err)
+	}
+	return New(name, SourceFile, location, certs[0])
+}
+
+// readKubeconfigClientCert returns the client certificate of the current context user. It returns
+// false if the kubeconfig does not use a client certificate.
+func readKubeconfigClientCert(name, path string) (Certificate, bool) {
+	config, err := clientcmd.LoadFromFile(path)
+	if err != nil {
+		return errorCertificate(name, path, err), true
+	}
+	kubeContext, ok := config.Contexts[config.CurrentContext]
+	if !ok {
+		return Certificate{}, false
+	}
+	authInfo, ok := config.AuthInfos[kubeContext.AuthInfo]
+	if !ok {
+		return Certificate{}, false
+	}
+	if len(authInfo.ClientCertificateData) > 0 {
+		return parseCert(name, path, authInfo.ClientCertificateData), true
+	}
+	if authInfo.ClientCertificate != "" {
+		certPath := authInfo.ClientCertificate
+		if !filepath.IsAbs(certPath) {
+			certPath = filepath.Join(filepath.Dir(path), certPath)
+		}
+		return readCertFile(name, certPath), true
+	}
+	return Certificate{}, false
+}
+
+func errorCertificate(name, location string, err error) Certificate {
+	return Certificate{
+		Name:     name,
+		Source:   SourceFile,
+		Location: location,
+		Error:    err.Error(),
+	}
+}
+
+// Print writes certificates as a table sorted by expiration
+func Print(w io.Writer, certs []Certificate) error {
+	sorted := append([]Certificate{}, certs...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].NotAfter.Before(sorted[j].NotAfter)
+	})
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tNODE\tSOURCE\tLOCATION\tSUBJECT\tISSUER\tSANS\tEXPIRES\tROTATE")
+	for _, cert := range sorted {
+		expires := cert.NotAfter.Format(time.RFC3339)
+		if cert.Error != "" {
+			expires = fmt.Sprintf("error: %s", cert.Error)
+		}
+		node := cert.Node
+		if node == "" {
+			node = "-"
+		}
+		sans := strings.Join(append(append([]string{}, cert.DNSNames...), cert.IPAddresses...), ",")
+		if sans == "" {
+			sans = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%t\n", cert.Name, node, cert.Source, cert.Location, cert.Subject, cert.Issuer, sans, expires, cert.Rotate)
+	}
+	return tw.Flush()
+}
+
+// PrintJSON writes certificates as a JSON array
+func PrintJSON(w io.Writer, certs []Certificate) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(certs)
+}
@@ -0,0 +1,114 @@
+package certinventory
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	certutil "k8s.io/client-go/util/cert"
+)
+
+func TestListHostCertificates(t *testing.T) {
+	confDir := t.TempDir()
+	pkiDir := filepath.Join(confDir, "pki")
+	if err := os.MkdirAll(filepath.Join(pkiDir, "etcd"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	apiserverCrt, _, err := certutil.GenerateSelfSignedCertKey("kube-apiserver", []net.IP{net.ParseIP("10.96.0.1")}, []string{"kubernetes.default"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	etcdCrt, _, err := certutil.GenerateSelfSignedCertKey("etcd-server", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adminCrt, _, err := certutil.GenerateSelfSignedCertKey("kubernetes-admin", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string][]byte{
+		filepath.Join(pkiDir, "apiserver.crt"):   apiserverCrt,
+		filepath.Join(pkiDir, "ca.crt"):          apiserverCrt,
+		filepath.Join(pkiDir, "etcd/server.crt"): etcdCrt,
+		filepath.Join(pkiDir, "sa.crt"):          []byte("not a cert"),
+	}
+	for path, data := range files {
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	kubeconfigs := map[string]*clientcmdapi.AuthInfo{
+		"admin.conf":   {ClientCertificateData: adminCrt},
+		"kubelet.conf": {ClientCertificate: filepath.Join(confDir, "missing.pem")},
+		"token.conf":   {Token: "abc"},
+	}
+	for name, authInfo := range kubeconfigs {
+		config := clientcmdapi.NewConfig()
+		config.AuthInfos["user"] = authInfo
+		config.Contexts["default"] = &clientcmdapi.Context{AuthInfo: "user"}
+		config.CurrentContext = "default"
+		if err := clientcmd.WriteToFile(*config, filepath.Join(confDir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := ListHostCertificates(confDir, "node1")
+	if err != nil {
+		t.Fatalf("ListHostCertificates() error = %v", err)
+	}
+
+	type want struct {
+		subject string
+		rotate  bool
+		err     bool
+	}
+	wants := map[string]want{
+		"apiserver":    {subject: "CN=kube-apiserver", rotate: true},
+		"ca":           {subject: "CN=kube-apiserver"},
+		"etcd/server":  {subject: "CN=etcd-server", rotate: true},
+		"sa":           {err: true},
+		"admin.conf":   {subject: "CN=kubernetes-admin", rotate: true},
+		"kubelet.conf": {err: true},
+	}
+	if len(got) != len(wants) {
+		t.Fatalf("ListHostCertificates() returned %d certs, want %d: %+v", len(got), len(wants), got)
+	}
+	for _, cert := range got {
+		w, ok := wants[cert.Name]
+		if !ok {
+			t.Errorf("unexpected cert %s", cert.Name)
+			continue
+		}
+		if cert.Node != "node1" {
+			t.Errorf("%s node = %q, want node1", cert.Name, cert.Node)
+		}
+		if cert.Source != SourceFile {
+			t.Errorf("%s source = %q, want %s", cert.Name, cert.Source, SourceFile)
+		}
+		if (cert.Error != "") != w.err {
+			t.Errorf("%s error = %q, wantErr %v", cert.Name, cert.Error, w.err)
+		}
+		// self-signed test certs have a timestamp suffix
+		if !strings.HasPrefix(cert.Subject, w.subject) || (w.subject == "" && cert.Subject != "") {
+			t.Errorf("%s subject = %q, want %q", cert.Name, cert.Subject, w.subject)
+		}
+		if cert.Rotate != w.rotate {
+			t.Errorf("%s rotate = %v, want %v", cert.Name, cert.Rotate, w.rotate)
+		}
+	}
+
+	for _, cert := range got {
+		if cert.Name == "apiserver" {
+			if len(cert.IPAddresses) == 0 || cert.IPAddresses[0] != "10.96.0.1" {
+				t.Errorf("apiserver IP addresses = %v, want 10.96.0.1", cert.IPAddresses)
+			}
+		}
+	}
+}
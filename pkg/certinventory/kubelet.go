@@ -0,0 +1,71 @@
+package certinventory
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	KubeletClientName  = "kubelet-client"
+	KubeletServingName = "kubelet-serving"
+)
+
+// KubeletConfig is the subset of the kubelet configuration file that determines how the kubelet
+// certificates are managed
+type KubeletConfig struct {
+	// the kubelet requests a new client certificate from the API server before it expires
+	RotateCertificates bool `json:"rotateCertificates"`
+	// the kubelet requests its serving certificate from the API server and renews it before it
+	// expires. Otherwise it uses a self-signed certificate generated at startup.
+	ServerTLSBootstrap bool   `json:"serverTLSBootstrap"`
+	TLSCertFile        string `json:"tlsCertFile"`
+}
+
+// ReadKubeletConfig reads the kubelet configuration file. Missing fields default to false.
+func ReadKubeletConfig(path string) (*KubeletConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read %s", path)
+	}
+	config := &KubeletConfig{}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, errors.Wrapf(err, "unmarshal %s", path)
+	}
+	return config, nil
+}
+
+// KubeletServingCertPath returns the path of the serving certificate the kubelet is using
+func (c *KubeletConfig) KubeletServingCertPath(pkiDir string) string {
+	switch {
+	case c.TLSCertFile != "":
+		return c.TLSCertFile
+	case c.ServerTLSBootstrap:
+		return filepath.Join(pkiDir, "kubelet-server-current.pem")
+	}
+	return filepath.Join(pkiDir, "kubelet.crt")
+}
+
+// ListKubeletCertificates reads the kubelet client and serving certificates. Certificates the
+// kubelet does not renew itself are marked for rotation by ekco, except for a serving certificate
+// configured with tlsCertFile, which is managed outside the cluster.
+func ListKubeletCertificates(configPath, pkiDir, hostname string) ([]Certificate, error) {
+	config, err := ReadKubeletConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	client := readCertFile(KubeletClientName, filepath.Join(pkiDir, "kubelet-client-current.pem"))
+	client.Rotate = !config.RotateCertificates
+
+	serving := readCertFile(KubeletServingName, config.KubeletServingCertPath(pkiDir))
+	serving.Rotate = !config.ServerTLSBootstrap && config.TLSCertFile == ""
+
+	certs := []Certificate{client, serving}
+	for i := range certs {
+		certs[i].Node = hostname
+	}
+	return certs, nil
+}
@@ -0,0 +1,94 @@
+package certinventory
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	certutil "k8s.io/client-go/util/cert"
+)
+
+func TestListKubeletCertificates(t *testing.T) {
+	clientCrt, _, err := certutil.GenerateSelfSignedCertKey("system:node:node1", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	servingCrt, _, err := certutil.GenerateSelfSignedCertKey("node1", nil, []string{"node1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		config      string
+		files       map[string][]byte
+		wantRotate  map[string]bool
+		wantErrors  map[string]bool
+		wantServing string
+	}{
+		{
+			name:   "auto rotation",
+			config: "rotateCertificates: true\nserverTLSBootstrap: true\n",
+			files: map[string][]byte{
+				"kubelet-client-current.pem": clientCrt,
+				"kubelet-server-current.pem": servingCrt,
+			},
+			wantRotate:  map[string]bool{KubeletClientName: false, KubeletServingName: false},
+			wantServing: "kubelet-server-current.pem",
+		},
+		{
+			name:   "no auto rotation",
+			config: "kind: KubeletConfiguration\n",
+			files: map[string][]byte{
+				"kubelet-client-current.pem": clientCrt,
+				"kubelet.crt":                servingCrt,
+			},
+			wantRotate:  map[string]bool{KubeletClientName: true, KubeletServingName: true},
+			wantServing: "kubelet.crt",
+		},
+		{
+			name:        "missing serving cert",
+			config:      "rotateCertificates: true\n",
+			files:       map[string][]byte{"kubelet-client-current.pem": clientCrt},
+			wantRotate:  map[string]bool{KubeletClientName: false, KubeletServingName: true},
+			wantErrors:  map[string]bool{KubeletServingName: true},
+			wantServing: "kubelet.crt",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			configPath := filepath.Join(dir, "config.yaml")
+			if err := os.WriteFile(configPath, []byte(tt.config), 0644); err != nil {
+				t.Fatal(err)
+			}
+			for name, data := range tt.files {
+				if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got, err := ListKubeletCertificates(configPath, dir, "node1")
+			if err != nil {
+				t.Fatalf("ListKubeletCertificates() error = %v", err)
+			}
+			if len(got) != 2 {
+				t.Fatalf("ListKubeletCertificates() got %d certificates, want 2", len(got))
+			}
+			for _, cert := range got {
+				if cert.Node != "node1" {
+					t.Errorf("%s node = %q, want node1", cert.Name, cert.Node)
+				}
+				if cert.Rotate != tt.wantRotate[cert.Name] {
+					t.Errorf("%s rotate = %t, want %t", cert.Name, cert.Rotate, tt.wantRotate[cert.Name])
+				}
+				if (cert.Error != "") != tt.wantErrors[cert.Name] {
+					t.Errorf("%s error = %q", cert.Name, cert.Error)
+				}
+				if cert.Name == KubeletServingName && cert.Location != filepath.Join(dir, tt.wantServing) {
+					t.Errorf("%s location = %q, want %q", cert.Name, cert.Location, tt.wantServing)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+// Package certpolicy decides when the certificates ekco manages are renewed, how long the new
+// certificates are valid and what keys they use. Policies are keyed by certificate class.
+package certpolicy
+
+import (
+	"crypto/x509"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/replicatedhq/ekco/pkg/util"
+	kubeadmapi "k8s.io/kubernetes/cmd/kubeadm/app/apis/kubeadm"
+)
+
+// certificate classes
+const (
+	// ClassKubeadm is the kubeadm certificates and kubeconfigs on each primary
+	ClassKubeadm = "kubeadm"
+	// ClassKubelet is the kubelet client and serving certificates on nodes where the kubelet does
+	// not renew them. The kubelet generates its own serving certificate, so only renew before
+	// applies to it.
+	ClassKubelet = "kubelet"
+	// ClassRegistry is the registry serving certificate
+	ClassRegistry = "registry"
+	// ClassKurlProxy is the kurl proxy serving certificate
+	ClassKurlProxy = "kurl-proxy"
+	// ClassContour is the contour and envoy certificates
+	ClassContour = "contour"
+)
+
+var classes = []string{ClassKubeadm, ClassKubelet, ClassRegistry, ClassKurlProxy, ClassContour}
+
+var algorithms = []kubeadmapi.EncryptionAlgorithmType{
+	kubeadmapi.EncryptionAlgorithmRSA2048,
+	kubeadmapi.EncryptionAlgorithmRSA3072,
+	kubeadmapi.EncryptionAlgorithmRSA4096,
+	kubeadmapi.EncryptionAlgorithmECDSAP256,
+	kubeadmapi.EncryptionAlgorithmECDSAP384,
+}
+
+// Policy is the rotation policy for a class of certificates. Zero fields keep the defaults.
+type Policy struct {
+	// renew certificates expiring within this duration. Defaults to rotate_certs_ttl.
+	RenewBefore time.Duration `mapstructure:"renew_before"`
+	// lifetime of renewed certificates. Defaults to the lifetime chosen by the issuer, one year
+	// for certificates signed by a kubernetes CA.
+	Validity time.Duration `mapstructure:"validity"`
+	// one of RSA-2048, RSA-3072, RSA-4096, ECDSA-P256 or ECDSA-P384. Defaults to the algorithm of
+	// the certificate being replaced.
+	KeyAlgorithm string `mapstructure:"key_algorithm"`
+}
+
+// Policies are keyed by class
+type Policies map[string]Policy
+
+// Get returns the policy for class with renewBefore as the default renew before duration
+func (p Policies) Get(class string, renewBefore time.Duration) Policy {
+	policy := p[class]
+	if policy.RenewBefore == 0 {
+		policy.RenewBefore = renewBefore
+	}
+	return policy
+}
+
+// Validate returns an error for unknown classes and invalid policies, with renewBefore as the
+// default renew before duration
+func (p Policies) Validate(renewBefore time.Duration) error {
+	names := make([]string, 0, len(p))
+	for class := range p {
+		names = append(names, class)
+	}
+	slices.Sort(names)
+
+	for _, class := range names {
+		if !slices.Contains(classes, class) {
+			return fmt.Errorf("unknown certificate class %q", class)
+		}
+		if err := p.Get(class, renewBefore).Validate(); err != nil {
+			return errors.Wrapf(err, "%s policy", class)
+		}
+	}
+	return nil
+}
+
+// Validate returns an error if the durations are negative or the key algorithm is unknown
+func (p Policy) Validate() error {
+	if p.RenewBefore < 0 {
+		return errors.New("renew_before must not be negative")
+	}
+	if p.Validity < 0 {
+		return errors.New("validity must not be negative")
+	}
+	if p.Validity != 0 && p.RenewBefore >= p.Validity {
+		return fmt.Errorf("renew_before %s must be less than validity %s", p.RenewBefore, p.Validity)
+	}
+	if p.KeyAlgorithm != "" && !slices.Contains(algorithms, kubeadmapi.EncryptionAlgorithmType(p.KeyAlgorithm)) {
+		return fmt.Errorf("unknown key_algorithm %q", p.KeyAlgorithm)
+	}
+	return nil
+}
+
+// Due returns true if a certificate expiring at notAfter should be renewed
+func (p Policy) Due(notAfter time.Time) bool {
+	return time.Until(notAfter) <= p.RenewBefore
+}
+
+// Algorithm returns the configured key algorithm, or the algorithm of cert if none is configured
+func (p Policy) Algorithm(cert *x509.Certificate) (kubeadmapi.EncryptionAlgorithmType, error) {
+	if p.KeyAlgorithm != "" {
+		return kubeadmapi.EncryptionAlgorithmType(p.KeyAlgorithm), nil
+	}
+	return util.GetEncryptionAlgorithmType(cert)
+}
+
+// ValidityOr returns the configured validity, or def if none is configured
+func (p Policy) ValidityOr(def time.Duration) time.Duration {
+	if p.Validity == 0 {
+		return def
+	}
+	return p.Validity
+}
@@ -0,0 +1,95 @@
+package certpolicy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	certutil "k8s.io/client-go/util/cert"
+	kubeadmapi "k8s.io/kubernetes/cmd/kubeadm/app/apis/kubeadm"
+	"k8s.io/kubernetes/cmd/kubeadm/app/util/pkiutil"
+)
+
+const day = 24 * time.Hour
+
+func TestPoliciesGet(t *testing.T) {
+	policies := Policies{
+		ClassKurlProxy: {RenewBefore: 180 * day},
+		ClassRegistry:  {Validity: 90 * day},
+	}
+
+	require.Equal(t, Policy{RenewBefore: 180 * day}, policies.Get(ClassKurlProxy, 30*day))
+	require.Equal(t, Policy{RenewBefore: 30 * day, Validity: 90 * day}, policies.Get(ClassRegistry, 30*day))
+	require.Equal(t, Policy{RenewBefore: 30 * day}, policies.Get(ClassContour, 30*day))
+	require.Equal(t, Policy{RenewBefore: 30 * day}, Policies(nil).Get(ClassKubeadm, 30*day))
+}
+
+func TestPoliciesValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		policies Policies
+		wantErr  string
+	}{
+		{
+			name: "valid",
+			policies: Policies{
+				ClassKubeadm: {RenewBefore: 60 * day, Validity: 365 * day, KeyAlgorithm: "ECDSA-P256"},
+				ClassContour: {Validity: 90 * day},
+			},
+		},
+		{
+			name:     "unknown class",
+			policies: Policies{"etcd": {}},
+			wantErr:  `unknown certificate class "etcd"`,
+		},
+		{
+			name:     "unknown key algorithm",
+			policies: Policies{ClassRegistry: {KeyAlgorithm: "RSA-1024"}},
+			wantErr:  `registry policy: unknown key_algorithm "RSA-1024"`,
+		},
+		{
+			name:     "negative validity",
+			policies: Policies{ClassKubelet: {Validity: -day}},
+			wantErr:  "kubelet policy: validity must not be negative",
+		},
+		{
+			name:     "default renew before exceeds validity",
+			policies: Policies{ClassKurlProxy: {Validity: 7 * day}},
+			wantErr:  "kurl-proxy policy: renew_before 720h0m0s must be less than validity 168h0m0s",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policies.Validate(30 * day)
+			if tt.wantErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPolicyDue(t *testing.T) {
+	policy := Policy{RenewBefore: 30 * day}
+
+	require.True(t, policy.Due(time.Now().Add(29*day)))
+	require.False(t, policy.Due(time.Now().Add(31*day)))
+	require.True(t, policy.Due(time.Now().Add(-day)))
+}
+
+func TestPolicyAlgorithm(t *testing.T) {
+	cert, _, err := pkiutil.NewCertificateAuthority(&pkiutil.CertConfig{
+		Config:              certutil.Config{CommonName: "ca"},
+		EncryptionAlgorithm: kubeadmapi.EncryptionAlgorithmECDSAP384,
+	})
+	require.NoError(t, err)
+
+	algorithm, err := Policy{}.Algorithm(cert)
+	require.NoError(t, err)
+	require.Equal(t, kubeadmapi.EncryptionAlgorithmECDSAP384, algorithm)
+
+	algorithm, err = Policy{KeyAlgorithm: "RSA-4096"}.Algorithm(cert)
+	require.NoError(t, err)
+	require.Equal(t, kubeadmapi.EncryptionAlgorithmRSA4096, algorithm)
+}
@@ -0,0 +1,624 @@
+package cluster
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/replicatedhq/ekco/pkg/hosttask"
+	"github.com/replicatedhq/ekco/pkg/issuer"
+	"github.com/replicatedhq/ekco/pkg/rotate"
+	"github.com/replicatedhq/ekco/pkg/util"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	certutil "k8s.io/client-go/util/cert"
+	"k8s.io/client-go/util/keyutil"
+	kubeadmapi "k8s.io/kubernetes/cmd/kubeadm/app/apis/kubeadm"
+	"k8s.io/kubernetes/cmd/kubeadm/app/util/pkiutil"
+)
+
+const (
+	// CARotationName is the name of the checkpoint ConfigMap and the Secret holding the new CAs in
+	// the rotate certs namespace
+	CARotationName = "rotate-ca"
+
+	CAPhaseGenerate         = "generate"
+	CAPhaseRestartWorkloads = "restart-workloads"
+
+	caRotationPhaseKey     = "phase"
+	caRotationNodesKey     = "nodes"
+	caRotationWorkloadsKey = "workloads"
+	caRotationStartedKey   = "started"
+
+	// mount path of the CA rotation secret in host task pods
+	caRotationMountPath = "/etc/ekco/rotate-ca"
+
+	caRotationWaitTimeout = 5 * time.Minute
+)
+
+// CARotationPhases are run in this order. A checkpoint is saved after each phase and after each
+// node within a phase.
+var CARotationPhases = []string{
+	CAPhaseGenerate,
+	rotate.CAPhaseTrust,
+	CAPhaseRestartWorkloads,
+	rotate.CAPhaseReissue,
+	rotate.CAPhaseVerify,
+	rotate.CAPhaseFinalize,
+}
+
+// CARotationStatus is the checkpoint of a CA rotation
+type CARotationStatus struct {
+	// the last completed phase
+	Phase string
+	// nodes that have completed the current phase
+	Nodes []string
+	// workloads restarted in the restart-workloads phase, as kind/namespace/name
+	Workloads []string
+	Started   time.Time
+}
+
+// InProgress returns true if a rotation has been started and not finalized
+func (s *CARotationStatus) InProgress() bool {
+	return s != nil && s.Phase != rotate.CAPhaseFinalize
+}
+
+// NextPhase returns the phase to run next or an empty string if the rotation is complete
+func (s *CARotationStatus) NextPhase() string {
+	for i, phase := range CARotationPhases {
+		if phase == s.Phase && i+1 < len(CARotationPhases) {
+			return CARotationPhases[i+1]
+		}
+	}
+	if s.Phase == "" {
+		return CARotationPhases[0]
+	}
+	return ""
+}
+
+// GetCARotationStatus returns the checkpoint of the current or last CA rotation or nil if the CAs
+// have never been rotated
+func (c *Controller) GetCARotationStatus(ctx context.Context) (*CARotationStatus, error) {
+	cm, err := c.Config.Client.CoreV1().ConfigMaps(c.Config.RotateCertsNamespace).Get(ctx, CARotationName, metav1.GetOptions{})
+	if err != nil {
+		if util.IsNotFoundErr(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "get configmap %s/%s", c.Config.RotateCertsNamespace, CARotationName)
+	}
+	status := &CARotationStatus{
+		Phase: cm.Data[caRotationPhaseKey],
+	}
+	if nodes := cm.Data[caRotationNodesKey]; nodes != "" {
+		status.Nodes = strings.Split(nodes, ",")
+	}
+	if workloads := cm.Data[caRotationWorkloadsKey]; workloads != "" {
+		status.Workloads = strings.Split(workloads, ",")
+	}
+	if started := cm.Data[caRotationStartedKey]; started != "" {
+		status.Started, err = time.Parse(time.RFC3339, started)
+		if err != nil {
+			return nil, errors.Wrap(err, "parse CA rotation start time")
+		}
+	}
+	return status, nil
+}
+
+// CARotationInProgress returns true if a CA rotation has been started and not finalized
+func (c *Controller) CARotationInProgress(ctx context.Context) (bool, error) {
+	status, err := c.GetCARotationStatus(ctx)
+	if err != nil {
+		return false, err
+	}
+	return status.InProgress(), nil
+}
+
+func (c *Controller) saveCARotationStatus(ctx context.Context, status *CARotationStatus) error {
+	client := c.Config.Client.CoreV1().ConfigMaps(c.Config.RotateCertsNamespace)
+	data := map[string]string{
+		caRotationPhaseKey:     status.Phase,
+		caRotationNodesKey:     strings.Join(status.Nodes, ","),
+		caRotationWorkloadsKey: strings.Join(status.Workloads, ","),
+		caRotationStartedKey:   status.Started.Format(time.RFC3339),
+	}
+
+	cm, err := client.Get(ctx, CARotationName, metav1.GetOptions{})
+	if err != nil {
+		if !util.IsNotFoundErr(err) {
+			return errors.Wrapf(err, "get configmap %s/%s", c.Config.RotateCertsNamespace, CARotationName)
+		}
+		cm = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      CARotationName,
+				Namespace: c.Config.RotateCertsNamespace,
+			},
+			Data: data,
+		}
+		if _, err := client.Create(ctx, cm, metav1.CreateOptions{}); err != nil {
+			return errors.Wrapf(err, "create configmap %s/%s", c.Config.RotateCertsNamespace, CARotationName)
+		}
+		return nil
+	}
+	cm.Data = data
+	if _, err := client.Update(ctx, cm, metav1.UpdateOptions{}); err != nil {
+		return errors.Wrapf(err, "update configmap %s/%s", c.Config.RotateCertsNamespace, CARotationName)
+	}
+	return nil
+}
+
+// RotateCANextPhase runs the next phase of the cluster, front-proxy and etcd CA rotation and saves
+// a checkpoint. A new rotation is started if none is in progress. It returns the phase that was
+// run and true once the rotation is complete.
+func (c *Controller) RotateCANextPhase(ctx context.Context, restartWorkloads bool) (string, bool, error) {
+	status, err := c.GetCARotationStatus(ctx)
+	if err != nil {
+		return "", false, err
+	}
+	if !status.InProgress() {
+		status = &CARotationStatus{Started: time.Now()}
+	}
+
+	phase := status.NextPhase()
+	c.Log.Infof("Running CA rotation phase %s", phase)
+
+	switch phase {
+	case CAPhaseGenerate:
+		err = c.generateCARotationSecret(ctx)
+	case CAPhaseRestartWorkloads:
+		if restartWorkloads {
+			err = c.restartWorkloadsForCARotation(ctx, status)
+		} else {
+			c.Log.Warnf("Skipping restart of workloads. Pods that have loaded the cluster CA must be restarted before the reissue phase.")
+		}
+	default:
+		err = c.runCARotationPhaseOnNodes(ctx, phase, status)
+	}
+	if err != nil {
+		return phase, false, errors.Wrapf(err, "CA rotation phase %s", phase)
+	}
+
+	switch phase {
+	case rotate.CAPhaseReissue:
+		c.reissueSecretsForCARotation(ctx)
+	case rotate.CAPhaseFinalize:
+		err := c.Config.Client.CoreV1().Secrets(c.Config.RotateCertsNamespace).Delete(ctx, CARotationName, metav1.DeleteOptions{})
+		if err != nil && !util.IsNotFoundErr(err) {
+			c.Log.Warnf("Failed to delete CA rotation secret: %v", err)
+		}
+	}
+
+	status.Phase = phase
+	status.Nodes = nil
+	status.Workloads = nil
+	if err := c.saveCARotationStatus(ctx, status); err != nil {
+		return phase, false, errors.Wrap(err, "save checkpoint")
+	}
+	c.Log.Infof("Completed CA rotation phase %s", phase)
+
+	return phase, phase == rotate.CAPhaseFinalize, nil
+}
+
+// generateCARotationSecret generates the new CAs and stores them in a secret that is mounted in the
+// host task pods. An existing secret is kept so that all nodes receive the same CAs.
+func (c *Controller) generateCARotationSecret(ctx context.Context) error {
+	client := c.Config.Client.CoreV1().Secrets(c.Config.RotateCertsNamespace)
+	_, err := client.Get(ctx, CARotationName, metav1.GetOptions{})
+	if err == nil {
+		c.Log.Infof("Using existing CA rotation secret %s/%s", c.Config.RotateCertsNamespace, CARotationName)
+		return nil
+	}
+	if !util.IsNotFoundErr(err) {
+		return errors.Wrapf(err, "get secret %s/%s", c.Config.RotateCertsNamespace, CARotationName)
+	}
+
+	algorithm := kubeadmapi.EncryptionAlgorithmRSA2048
+	if currentCA, err := pkiutil.TryLoadCertFromDisk(DefaultEtcKubernetesDir+"/pki", "ca"); err == nil {
+		if algorithm, err = util.GetEncryptionAlgorithmType(currentCA); err != nil {
+			return errors.Wrap(err, "get encryption algorithm of current CA")
+		}
+	}
+
+	data := map[string][]byte{}
+	for _, ca := range rotate.CertificateAuthorities {
+		cert, key, err := pkiutil.NewCertificateAuthority(&pkiutil.CertConfig{
+			Config: certutil.Config{
+				CommonName: ca.CommonName,
+			},
+			EncryptionAlgorithm: algorithm,
+		})
+		if err != nil {
+			return errors.Wrapf(err, "generate %s", ca.BaseName)
+		}
+		keyData, err := keyutil.MarshalPrivateKeyToPEM(key)
+		if err != nil {
+			return errors.Wrapf(err, "encode %s key", ca.BaseName)
+		}
+		data[ca.SecretName+".crt"] = pkiutil.EncodeCertPEM(cert)
+		data[ca.SecretName+".key"] = keyData
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      CARotationName,
+			Namespace: c.Config.RotateCertsNamespace,
+		},
+		Data: data,
+	}
+	if _, err := client.Create(ctx, secret, metav1.CreateOptions{}); err != nil {
+		return errors.Wrapf(err, "create secret %s/%s", c.Config.RotateCertsNamespace, CARotationName)
+	}
+	return nil
+}
+
+// runCARotationPhaseOnNodes runs the phase on each node that has not yet completed it, primaries
+// first. Control plane pods must be ready again before the next node is started.
+func (c *Controller) runCARotationPhaseOnNodes(ctx context.Context, phase string, status *CARotationStatus) error {
+	nodes, err := c.Config.Client.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return errors.Wrap(err, "list nodes")
+	}
+	sort.SliceStable(nodes.Items, func(i, j int) bool {
+		return util.NodeIsMaster(nodes.Items[i]) && !util.NodeIsMaster(nodes.Items[j])
+	})
+
+	done := map[string]bool{}
+	for _, name := range status.Nodes {
+		done[name] = true
+	}
+
+	for _, node := range nodes.Items {
+		if done[node.Name] {
+			c.Log.Debugf("Node %s has completed CA rotation phase %s", node.Name, phase)
+			continue
+		}
+		primary := util.NodeIsMaster(node)
+
+		c.Log.Infof("Running CA rotation phase %s on node %s", phase, node.Name)
+		start := time.Now()
+		task := c.rotateCATask(node.Name, phase, primary)
+		result, err := c.runHostTask(ctx, task)
+		if err != nil {
+			return errors.Wrapf(err, "rotate CA task for node %s", node.Name)
+		}
+
+		if primary && result != nil {
+			if err := c.waitForControlPlanePodsReady(ctx, node.Name, result.RestartedComponents, start); err != nil {
+				return errors.Wrapf(err, "wait for control plane on node %s", node.Name)
+			}
+		}
+
+		status.Nodes = append(status.Nodes, node.Name)
+		if err := c.saveCARotationStatus(ctx, status); err != nil {
+			return errors.Wrap(err, "save checkpoint")
+		}
+	}
+
+	return nil
+}
+
+// restartWorkloadsForCARotation waits for the controller manager to publish the CA bundle to the
+// kube-root-ca.crt ConfigMap in every namespace and then restarts deployments, daemonsets and
+// statefulsets so that pods trust the new CA before leaf certificates are reissued. Workloads are
+// restarted one at a time, waiting for each rollout, and a checkpoint is saved after each. Ceph
+// daemons, which do not use the Kubernetes API, and ekco itself are not restarted.
+func (c *Controller) restartWorkloadsForCARotation(ctx context.Context, status *CARotationStatus) error {
+	secret, err := c.Config.Client.CoreV1().Secrets(c.Config.RotateCertsNamespace).Get(ctx, CARotationName, metav1.GetOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "get secret %s/%s", c.Config.RotateCertsNamespace, CARotationName)
+	}
+	newCA := strings.TrimSpace(string(secret.Data["ca.crt"]))
+
+	waitCtx, cancel := context.WithTimeout(ctx, caRotationWaitTimeout)
+	defer cancel()
+	for {
+		missing, err := c.namespacesMissingRootCA(waitCtx, newCA)
+		if err == nil && len(missing) == 0 {
+			break
+		}
+		select {
+		case <-waitCtx.Done():
+			return errors.Errorf("new CA not published to kube-root-ca.crt in namespaces %s", strings.Join(missing, ", "))
+		case <-time.After(time.Second * 5):
+		}
+	}
+
+	workloads, err := c.listWorkloadsForCARotation(ctx)
+	if err != nil {
+		return err
+	}
+
+	done := map[string]bool{}
+	for _, workload := range status.Workloads {
+		done[workload] = true
+	}
+
+	restartedAt := time.Now().Format(time.RFC3339)
+	patch := []byte(fmt.Sprintf(`{"spec":{"template":{"metadata":{"annotations":{"kubectl.kubernetes.io/restartedAt":"%s"}}}}}`, restartedAt))
+
+	for _, workload := range workloads {
+		if done[workload.String()] {
+			continue
+		}
+		if workload.skip != "" {
+			c.Log.Infof("Not restarting %s: %s", workload, workload.skip)
+			continue
+		}
+
+		c.Log.Infof("Restarting %s", workload)
+		if err := c.restartWorkload(ctx, workload, patch); err != nil {
+			return errors.Wrapf(err, "restart %s", workload)
+		}
+		if workload.onDelete {
+			c.Log.Warnf("Pods of %s must be deleted to restart them as its update strategy is OnDelete", workload)
+		} else if err := c.waitForWorkloadRollout(ctx, workload); err != nil {
+			return errors.Wrapf(err, "wait for %s rollout", workload)
+		}
+
+		status.Workloads = append(status.Workloads, workload.String())
+		if err := c.saveCARotationStatus(ctx, status); err != nil {
+			return errors.Wrap(err, "save checkpoint")
+		}
+	}
+
+	return nil
+}
+
+var cephDaemonApps = map[string]bool{
+	"rook-ceph-mon":            true,
+	"rook-ceph-mgr":            true,
+	"rook-ceph-osd":            true,
+	"rook-ceph-mds":            true,
+	"rook-ceph-rgw":            true,
+	"rook-ceph-crashcollector": true,
+}
+
+// caRotationWorkload is a deployment, daemonset or statefulset restarted in the restart-workloads
+// phase of CA rotation
+type caRotationWorkload struct {
+	kind      string
+	namespace string
+	name      string
+	// the reason the workload is not restarted
+	skip string
+	// pods are only replaced when they are deleted
+	onDelete bool
+}
+
+func (w caRotationWorkload) String() string {
+	return fmt.Sprintf("%s/%s/%s", w.kind, w.namespace, w.name)
+}
+
+func (c *Controller) listWorkloadsForCARotation(ctx context.Context) ([]caRotationWorkload, error) {
+	// restarting the Ceph daemons Rook runs for a CephCluster all at once would lose mon quorum
+	skipReason := func(obj metav1.ObjectMeta, template corev1.PodTemplateSpec) string {
+		_, rookCluster := template.Labels["rook_cluster"]
+		if obj.Namespace == RookCephNS && (rookCluster || cephDaemonApps[template.Labels["app"]]) {
+			return "Ceph daemons do not use the Kubernetes API"
+		}
+		if template.Labels["app"] == "ekc-operator" {
+			return "restart ekco once the CA rotation is complete"
+		}
+		return ""
+	}
+
+	var workloads []caRotationWorkload
+
+	deployments, err := c.Config.Client.AppsV1().Deployments("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "list deployments")
+	}
+	for _, deploy := range deployments.Items {
+		workloads = append(workloads, caRotationWorkload{
+			kind:      "deployment",
+			namespace: deploy.Namespace,
+			name:      deploy.Name,
+			skip:      skipReason(deploy.ObjectMeta, deploy.Spec.Template),
+		})
+	}
+
+	daemonsets, err := c.Config.Client.AppsV1().DaemonSets("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "list daemonsets")
+	}
+	for _, ds := range daemonsets.Items {
+		workloads = append(workloads, caRotationWorkload{
+			kind:      "daemonset",
+			namespace: ds.Namespace,
+			name:      ds.Name,
+			skip:      skipReason(ds.ObjectMeta, ds.Spec.Template),
+			onDelete:  ds.Spec.UpdateStrategy.Type == appsv1.OnDeleteDaemonSetStrategyType,
+		})
+	}
+
+	statefulsets, err := c.Config.Client.AppsV1().StatefulSets("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "list statefulsets")
+	}
+	for _, sts := range statefulsets.Items {
+		workloads = append(workloads, caRotationWorkload{
+			kind:      "statefulset",
+			namespace: sts.Namespace,
+			name:      sts.Name,
+			skip:      skipReason(sts.ObjectMeta, sts.Spec.Template),
+			onDelete:  sts.Spec.UpdateStrategy.Type == appsv1.OnDeleteStatefulSetStrategyType,
+		})
+	}
+
+	return workloads, nil
+}
+
+func (c *Controller) restartWorkload(ctx context.Context, workload caRotationWorkload, patch []byte) error {
+	var err error
+	switch workload.kind {
+	case "deployment":
+		_, err = c.Config.Client.AppsV1().Deployments(workload.namespace).Patch(ctx, workload.name, k8stypes.StrategicMergePatchType, patch, metav1.PatchOptions{})
+	case "daemonset":
+		_, err = c.Config.Client.AppsV1().DaemonSets(workload.namespace).Patch(ctx, workload.name, k8stypes.StrategicMergePatchType, patch, metav1.PatchOptions{})
+	case "statefulset":
+		_, err = c.Config.Client.AppsV1().StatefulSets(workload.namespace).Patch(ctx, workload.name, k8stypes.StrategicMergePatchType, patch, metav1.PatchOptions{})
+	}
+	return err
+}
+
+// waitForWorkloadRollout waits for the controller of the workload to observe the restart and for
+// all its pods to be updated and available
+func (c *Controller) waitForWorkloadRollout(ctx context.Context, workload caRotationWorkload) error {
+	ctx, cancel := context.WithTimeout(ctx, caRotationWaitTimeout)
+	defer cancel()
+
+	for {
+		done, err := c.workloadRolledOut(ctx, workload)
+		if err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second * 5):
+		}
+	}
+}
+
+func (c *Controller) workloadRolledOut(ctx context.Context, workload caRotationWorkload) (bool, error) {
+	switch workload.kind {
+	case "deployment":
+		deploy, err := c.Config.Client.AppsV1().Deployments(workload.namespace).Get(ctx, workload.name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		replicas := int32(1)
+		if deploy.Spec.Replicas != nil {
+			replicas = *deploy.Spec.Replicas
+		}
+		status := deploy.Status
+		return status.ObservedGeneration >= deploy.Generation &&
+			status.UpdatedReplicas == replicas &&
+			status.Replicas == replicas &&
+			status.AvailableReplicas == replicas, nil
+	case "daemonset":
+		ds, err := c.Config.Client.AppsV1().DaemonSets(workload.namespace).Get(ctx, workload.name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		status := ds.Status
+		return status.ObservedGeneration >= ds.Generation &&
+			status.UpdatedNumberScheduled == status.DesiredNumberScheduled &&
+			status.NumberAvailable == status.DesiredNumberScheduled, nil
+	case "statefulset":
+		sts, err := c.Config.Client.AppsV1().StatefulSets(workload.namespace).Get(ctx, workload.name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		replicas := int32(1)
+		if sts.Spec.Replicas != nil {
+			replicas = *sts.Spec.Replicas
+		}
+		status := sts.Status
+		return status.ObservedGeneration >= sts.Generation &&
+			status.UpdatedReplicas == replicas &&
+			status.ReadyReplicas == replicas &&
+			status.CurrentRevision == status.UpdateRevision, nil
+	}
+	return false, errors.Errorf("unknown workload kind %s", workload.kind)
+}
+
+func (c *Controller) namespacesMissingRootCA(ctx context.Context, newCA string) ([]string, error) {
+	configMaps, err := c.Config.Client.CoreV1().ConfigMaps("").List(ctx, metav1.ListOptions{FieldSelector: "metadata.name=kube-root-ca.crt"})
+	if err != nil {
+		return nil, errors.Wrap(err, "list kube-root-ca.crt configmaps")
+	}
+	var missing []string
+	for _, cm := range configMaps.Items {
+		if cm.Name != "kube-root-ca.crt" {
+			continue
+		}
+		if !strings.Contains(cm.Data["ca.crt"], newCA) {
+			missing = append(missing, cm.Namespace)
+		}
+	}
+	return missing, nil
+}
+
+// reissueSecretsForCARotation reissues the certificates in secrets that are signed by the cluster
+// CA. Failures are logged since the host certificates have already been reissued.
+func (c *Controller) reissueSecretsForCARotation(ctx context.Context) {
+	secret, err := c.Config.Client.CoreV1().Secrets(c.Config.RotateCertsNamespace).Get(ctx, CARotationName, metav1.GetOptions{})
+	if err != nil {
+		c.Log.Warnf("Failed to get CA rotation secret: %v", err)
+		return
+	}
+	caCerts, err := certutil.ParseCertsPEM(secret.Data["ca.crt"])
+	if err != nil {
+		c.Log.Warnf("Failed to parse new CA: %v", err)
+		return
+	}
+	caKey, err := keyutil.ParsePrivateKeyPEM(secret.Data["ca.key"])
+	if err != nil {
+		c.Log.Warnf("Failed to parse new CA key: %v", err)
+		return
+	}
+	caSigner, ok := caKey.(crypto.Signer)
+	if !ok {
+		c.Log.Warnf("New CA key is not a signer")
+		return
+	}
+
+	if err := c.reissueRegistryCert(ctx, caCerts[0], caSigner); err != nil {
+		c.Log.Warnf("Failed to reissue registry cert with new CA: %v", err)
+	}
+	if err := c.UpdateKubeletClientCertSecret(ctx); err != nil {
+		c.Log.Warnf("Failed to update kotsadm kubelet client cert secret: %v", err)
+	}
+}
+
+func (c *Controller) reissueRegistryCert(ctx context.Context, caCert *x509.Certificate, caKey crypto.Signer) error {
+	ns := c.Config.RegistryCertNamespace
+	name := c.Config.RegistryCertSecret
+	if ns == "" || name == "" {
+		return nil
+	}
+	cert, err := c.readRegistryCert(ctx, ns, name)
+	if err != nil {
+		return errors.Wrap(err, "load existing certificate")
+	}
+	if cert == nil || cert.CheckSignatureFrom(caCert) == nil {
+		return nil
+	}
+	return c.renewRegistryCert(ctx, ns, name, cert, &issuer.CAIssuer{Cert: caCert, Key: caKey}, issuer.Config{Type: issuer.TypeClusterCA})
+}
+
+func (c *Controller) rotateCATask(nodeName, phase string, primary bool) HostTask {
+	task := c.rotateCertsTask(nodeName)
+	task.Name = RotateCAValue
+	task.Command = []string{
+		"ekco",
+		"rotate-ca-host",
+		fmt.Sprintf("--phase=%s", phase),
+		fmt.Sprintf("--primary=%t", primary),
+		fmt.Sprintf("--new-ca-dir=%s", caRotationMountPath),
+		fmt.Sprintf("--result-file=%s", hosttask.TerminationMessagePath),
+	}
+	task.Privileged = true
+	task.TolerateAll = true
+	task.Mounts = append(task.Mounts,
+		kubeletPKIMount(false),
+		dbusMount(),
+		HostTaskMount{
+			Name:      "new-ca",
+			Secret:    CARotationName,
+			MountPath: caRotationMountPath,
+			ReadOnly:  true,
+		},
+	)
+	return task
+}
@@ -0,0 +1,225 @@
+package cluster
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/replicatedhq/ekco/pkg/cluster/types"
+	"github.com/replicatedhq/ekco/pkg/logger"
+	"github.com/replicatedhq/ekco/pkg/rotate"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	certutil "k8s.io/client-go/util/cert"
+)
+
+func TestCARotationStatus_NextPhase(t *testing.T) {
+	tests := []struct {
+		name   string
+		status *CARotationStatus
+		want   string
+	}{
+		{
+			name:   "new",
+			status: &CARotationStatus{},
+			want:   CAPhaseGenerate,
+		},
+		{
+			name:   "after trust",
+			status: &CARotationStatus{Phase: rotate.CAPhaseTrust},
+			want:   CAPhaseRestartWorkloads,
+		},
+		{
+			name:   "after verify",
+			status: &CARotationStatus{Phase: rotate.CAPhaseVerify},
+			want:   rotate.CAPhaseFinalize,
+		},
+		{
+			name:   "complete",
+			status: &CARotationStatus{Phase: rotate.CAPhaseFinalize},
+			want:   "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, tt.status.NextPhase())
+		})
+	}
+}
+
+func TestController_RotateCANextPhase(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+
+	c := &Controller{
+		Config: types.ControllerConfig{
+			Client:               fake.NewSimpleClientset(),
+			RotateCertsNamespace: "kurl",
+		},
+		Log: logger.NewDiscardLogger(),
+	}
+
+	inProgress, err := c.CARotationInProgress(ctx)
+	req.NoError(err)
+	req.False(inProgress)
+
+	phase, done, err := c.RotateCANextPhase(ctx, false)
+	req.NoError(err)
+	req.Equal(CAPhaseGenerate, phase)
+	req.False(done)
+
+	secret, err := c.Config.Client.CoreV1().Secrets("kurl").Get(ctx, CARotationName, metav1.GetOptions{})
+	req.NoError(err)
+	for _, ca := range rotate.CertificateAuthorities {
+		certs, err := certutil.ParseCertsPEM(secret.Data[ca.SecretName+".crt"])
+		req.NoError(err, ca.SecretName)
+		req.True(certs[0].IsCA, ca.SecretName)
+		req.Equal(ca.CommonName, certs[0].Subject.CommonName)
+		req.NotEmpty(secret.Data[ca.SecretName+".key"], ca.SecretName)
+	}
+
+	inProgress, err = c.CARotationInProgress(ctx)
+	req.NoError(err)
+	req.True(inProgress)
+
+	// the secret is reused if generate runs again
+	req.NoError(c.Config.Client.CoreV1().ConfigMaps("kurl").Delete(ctx, CARotationName, metav1.DeleteOptions{}))
+	_, _, err = c.RotateCANextPhase(ctx, false)
+	req.NoError(err)
+	regenerated, err := c.Config.Client.CoreV1().Secrets("kurl").Get(ctx, CARotationName, metav1.GetOptions{})
+	req.NoError(err)
+	req.Equal(secret.Data, regenerated.Data)
+
+	// no nodes
+	phase, _, err = c.RotateCANextPhase(ctx, false)
+	req.NoError(err)
+	req.Equal(rotate.CAPhaseTrust, phase)
+
+	phase, _, err = c.RotateCANextPhase(ctx, false)
+	req.NoError(err)
+	req.Equal(CAPhaseRestartWorkloads, phase)
+
+	status, err := c.GetCARotationStatus(ctx)
+	req.NoError(err)
+	req.Equal(CAPhaseRestartWorkloads, status.Phase)
+	req.Equal(rotate.CAPhaseReissue, status.NextPhase())
+}
+
+func Test_podReadySince(t *testing.T) {
+	since := time.Now()
+
+	tests := []struct {
+		name      string
+		ready     corev1.ConditionStatus
+		startedAt time.Time
+		want      bool
+	}{
+		{
+			name:      "restarted and ready",
+			ready:     corev1.ConditionTrue,
+			startedAt: since.Add(time.Second),
+			want:      true,
+		},
+		{
+			name:      "restarted not ready",
+			ready:     corev1.ConditionFalse,
+			startedAt: since.Add(time.Second),
+			want:      false,
+		},
+		{
+			name:      "not restarted",
+			ready:     corev1.ConditionTrue,
+			startedAt: since.Add(-time.Minute),
+			want:      false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := corev1.Pod{
+				Status: corev1.PodStatus{
+					Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: tt.ready}},
+					ContainerStatuses: []corev1.ContainerStatus{{
+						State: corev1.ContainerState{
+							Running: &corev1.ContainerStateRunning{StartedAt: metav1.NewTime(tt.startedAt)},
+						},
+					}},
+				},
+			}
+			require.Equal(t, tt.want, podReadySince(pod, since))
+		})
+	}
+}
+
+func TestController_restartWorkloadsForCARotation(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+
+	replicas := int32(1)
+	deployment := func(namespace, name string, labels map[string]string) *appsv1.Deployment {
+		return &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: labels},
+			Spec: appsv1.DeploymentSpec{
+				Replicas: &replicas,
+				Template: corev1.PodTemplateSpec{ObjectMeta: metav1.ObjectMeta{Labels: labels}},
+			},
+			Status: appsv1.DeploymentStatus{Replicas: 1, UpdatedReplicas: 1, AvailableReplicas: 1},
+		}
+	}
+	clientset := fake.NewSimpleClientset(
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: CARotationName, Namespace: "kurl"},
+			Data:       map[string][]byte{"ca.crt": []byte("new CA")},
+		},
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "kube-root-ca.crt", Namespace: "default"},
+			Data:       map[string]string{"ca.crt": "old CA\nnew CA"},
+		},
+		deployment("default", "kotsadm", map[string]string{"app": "kotsadm"}),
+		deployment("kurl", "ekc-operator", map[string]string{"app": "ekc-operator"}),
+		deployment("rook-ceph", "rook-ceph-operator", map[string]string{"app": "rook-ceph-operator"}),
+		deployment("rook-ceph", "rook-ceph-mon-a", map[string]string{"app": "rook-ceph-mon", "rook_cluster": "rook-ceph"}),
+		&appsv1.DaemonSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "envoy", Namespace: "projectcontour"},
+			Status:     appsv1.DaemonSetStatus{DesiredNumberScheduled: 1, UpdatedNumberScheduled: 1, NumberAvailable: 1},
+		},
+	)
+	c := &Controller{
+		Config: types.ControllerConfig{
+			Client:               clientset,
+			RotateCertsNamespace: "kurl",
+		},
+		Log: logger.NewDiscardLogger(),
+	}
+
+	status := &CARotationStatus{Phase: rotate.CAPhaseTrust, Started: time.Now()}
+	req.NoError(c.restartWorkloadsForCARotation(ctx, status))
+	req.Equal([]string{
+		"deployment/default/kotsadm",
+		"deployment/rook-ceph/rook-ceph-operator",
+		"daemonset/projectcontour/envoy",
+	}, status.Workloads)
+
+	restarted := func(namespace, name string) bool {
+		deploy, err := clientset.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+		req.NoError(err)
+		return deploy.Spec.Template.Annotations["kubectl.kubernetes.io/restartedAt"] != ""
+	}
+	req.True(restarted("default", "kotsadm"))
+	req.False(restarted("kurl", "ekc-operator"))
+	req.False(restarted("rook-ceph", "rook-ceph-mon-a"))
+
+	// workloads restarted before an interruption are not restarted again
+	saved, err := c.GetCARotationStatus(ctx)
+	req.NoError(err)
+	req.Equal(status.Workloads, saved.Workloads)
+	deploy, err := clientset.AppsV1().Deployments("default").Get(ctx, "kotsadm", metav1.GetOptions{})
+	req.NoError(err)
+	deploy.Spec.Template.Annotations = nil
+	_, err = clientset.AppsV1().Deployments("default").Update(ctx, deploy, metav1.UpdateOptions{})
+	req.NoError(err)
+	req.NoError(c.restartWorkloadsForCARotation(ctx, saved))
+	req.False(restarted("default", "kotsadm"))
+}
@@ -0,0 +1,262 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/blang/semver"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	CephCSIResourceProfileAuto   = "auto"
+	CephCSIResourceProfileSmall  = "small"
+	CephCSIResourceProfileMedium = "medium"
+	CephCSIResourceProfileLarge  = "large"
+	CephCSIResourceProfileCustom = "custom"
+)
+
+// CephCSIResourceProfile holds the resources for each kind of container in the Ceph CSI
+// provisioner and plugin pods.
+type CephCSIResourceProfile struct {
+	// csi-provisioner, csi-resizer, csi-attacher and csi-snapshotter
+	Sidecar corev1.ResourceRequirements `json:"sidecar"`
+	// csi-rbdplugin, csi-cephfsplugin, csi-nfsplugin and csi-omap-generator
+	Plugin corev1.ResourceRequirements `json:"plugin"`
+	// driver-registrar
+	Registrar corev1.ResourceRequirements `json:"registrar"`
+	// liveness-prometheus
+	Liveness corev1.ResourceRequirements `json:"liveness"`
+}
+
+// cephCSIContainerResource is the format of the CSI_*_RESOURCE keys in rook-ceph-operator-config
+type cephCSIContainerResource struct {
+	Name     string                      `json:"name"`
+	Resource corev1.ResourceRequirements `json:"resource"`
+}
+
+func resourceRequirements(requestCPU, requestMemory, limitCPU, limitMemory string) corev1.ResourceRequirements {
+	return corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse(requestCPU),
+			corev1.ResourceMemory: resource.MustParse(requestMemory),
+		},
+		Limits: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse(limitCPU),
+			corev1.ResourceMemory: resource.MustParse(limitMemory),
+		},
+	}
+}
+
+var cephCSIResourceProfiles = map[string]CephCSIResourceProfile{
+	CephCSIResourceProfileSmall: {
+		Sidecar:   resourceRequirements("50m", "64Mi", "100m", "128Mi"),
+		Plugin:    resourceRequirements("100m", "256Mi", "250m", "512Mi"),
+		Registrar: resourceRequirements("25m", "64Mi", "50m", "128Mi"),
+		Liveness:  resourceRequirements("25m", "64Mi", "50m", "128Mi"),
+	},
+	CephCSIResourceProfileMedium: {
+		Sidecar:   resourceRequirements("100m", "128Mi", "200m", "256Mi"),
+		Plugin:    resourceRequirements("250m", "512Mi", "500m", "1Gi"),
+		Registrar: resourceRequirements("50m", "128Mi", "100m", "256Mi"),
+		Liveness:  resourceRequirements("50m", "128Mi", "100m", "256Mi"),
+	},
+	CephCSIResourceProfileLarge: {
+		Sidecar:   resourceRequirements("200m", "256Mi", "400m", "512Mi"),
+		Plugin:    resourceRequirements("500m", "1Gi", "1", "2Gi"),
+		Registrar: resourceRequirements("50m", "128Mi", "100m", "256Mi"),
+		Liveness:  resourceRequirements("50m", "128Mi", "100m", "256Mi"),
+	},
+}
+
+// nodes with less allocatable than this use the small profile
+var cephCSISmallNodeCPU = resource.MustParse("4")
+var cephCSISmallNodeMemory = resource.MustParse("8Gi")
+
+// nodes with at least this much allocatable use the large profile
+var cephCSILargeNodeCPU = resource.MustParse("16")
+var cephCSILargeNodeMemory = resource.MustParse("32Gi")
+
+// SetCephCSIResources will set CSI provisioner and plugin resources from the configured profile.
+// With the auto profile resources are only set once the cluster has enough capacity at 3 nodes and
+// the profile is chosen from the smallest node allocatable.
+func (c *Controller) SetCephCSIResources(ctx context.Context, rookVersion semver.Version, nodes []corev1.Node) (bool, error) {
+	if rookVersion.LT(Rookv19) {
+		return false, nil
+	}
+
+	profileName := c.Config.CephCSIResourceProfile
+	if profileName == "" || profileName == CephCSIResourceProfileAuto {
+		if len(nodes) < 3 {
+			return false, nil
+		}
+		profileName = cephCSIAutoResourceProfile(nodes)
+	}
+	profile, err := c.getCephCSIResourceProfile(profileName)
+	if err != nil {
+		return false, err
+	}
+
+	configMap, err := c.Config.Client.CoreV1().ConfigMaps(RookCephNS).Get(ctx, "rook-ceph-operator-config", metav1.GetOptions{})
+	if err != nil {
+		return false, errors.Wrap(err, "get rook-ceph-operator-config configmap")
+	}
+
+	desired := cephCSIResources(profile)
+	data := map[string]string{}
+	for key, containers := range desired {
+		if cephCSIResourcesEqual(configMap.Data[key], containers) {
+			continue
+		}
+		b, err := yaml.Marshal(containers)
+		if err != nil {
+			return false, errors.Wrapf(err, "marshal %s", key)
+		}
+		data[key] = string(b)
+	}
+	if len(data) == 0 {
+		return false, nil
+	}
+
+	c.Log.Infof("Setting Ceph CSI plugin and provisioner resources to %s profile", profileName)
+
+	patch, err := json.Marshal(map[string]interface{}{"data": data})
+	if err != nil {
+		return false, errors.Wrap(err, "marshal patch")
+	}
+	_, err = c.Config.Client.CoreV1().ConfigMaps(RookCephNS).Patch(ctx, "rook-ceph-operator-config", apitypes.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return false, errors.Wrap(err, "patch rook-ceph-operator-config configmap")
+	}
+	return true, nil
+}
+
+func (c *Controller) getCephCSIResourceProfile(name string) (CephCSIResourceProfile, error) {
+	if name == CephCSIResourceProfileCustom {
+		profile := CephCSIResourceProfile{}
+		if err := yaml.UnmarshalStrict([]byte(c.Config.CephCSICustomResources), &profile); err != nil {
+			return profile, errors.Wrap(err, "parse custom Ceph CSI resources")
+		}
+		return profile, nil
+	}
+	profile, ok := cephCSIResourceProfiles[name]
+	if !ok {
+		return profile, fmt.Errorf("unknown Ceph CSI resource profile %q", name)
+	}
+	return profile, nil
+}
+
+// cephCSIAutoResourceProfile chooses a profile from the node with the least allocatable cpu and
+// memory
+func cephCSIAutoResourceProfile(nodes []corev1.Node) string {
+	var minCPU, minMemory *resource.Quantity
+	for _, node := range nodes {
+		cpu, memory := node.Status.Allocatable.Cpu(), node.Status.Allocatable.Memory()
+		if cpu.IsZero() || memory.IsZero() {
+			// allocatable has not been reported
+			continue
+		}
+		if minCPU == nil || cpu.Cmp(*minCPU) < 0 {
+			minCPU = cpu
+		}
+		if minMemory == nil || memory.Cmp(*minMemory) < 0 {
+			minMemory = memory
+		}
+	}
+	if minCPU == nil {
+		return CephCSIResourceProfileMedium
+	}
+	if minCPU.Cmp(cephCSISmallNodeCPU) < 0 || minMemory.Cmp(cephCSISmallNodeMemory) < 0 {
+		return CephCSIResourceProfileSmall
+	}
+	if minCPU.Cmp(cephCSILargeNodeCPU) >= 0 && minMemory.Cmp(cephCSILargeNodeMemory) >= 0 {
+		return CephCSIResourceProfileLarge
+	}
+	return CephCSIResourceProfileMedium
+}
+
+// cephCSIResources returns the containers for each rook-ceph-operator-config key
+func cephCSIResources(profile CephCSIResourceProfile) map[string][]cephCSIContainerResource {
+	return map[string][]cephCSIContainerResource{
+		"CSI_RBD_PROVISIONER_RESOURCE": {
+			{Name: "csi-provisioner", Resource: profile.Sidecar},
+			{Name: "csi-resizer", Resource: profile.Sidecar},
+			{Name: "csi-attacher", Resource: profile.Sidecar},
+			{Name: "csi-snapshotter", Resource: profile.Sidecar},
+			{Name: "csi-rbdplugin", Resource: profile.Plugin},
+			{Name: "csi-omap-generator", Resource: profile.Plugin},
+			{Name: "liveness-prometheus", Resource: profile.Liveness},
+		},
+		"CSI_RBD_PLUGIN_RESOURCE": {
+			{Name: "driver-registrar", Resource: profile.Registrar},
+			{Name: "csi-rbdplugin", Resource: profile.Plugin},
+			{Name: "liveness-prometheus", Resource: profile.Liveness},
+		},
+		"CSI_CEPHFS_PROVISIONER_RESOURCE": {
+			{Name: "csi-provisioner", Resource: profile.Sidecar},
+			{Name: "csi-resizer", Resource: profile.Sidecar},
+			{Name: "csi-attacher", Resource: profile.Sidecar},
+			{Name: "csi-snapshotter", Resource: profile.Sidecar},
+			{Name: "csi-cephfsplugin", Resource: profile.Plugin},
+			{Name: "liveness-prometheus", Resource: profile.Liveness},
+		},
+		"CSI_CEPHFS_PLUGIN_RESOURCE": {
+			{Name: "driver-registrar", Resource: profile.Registrar},
+			{Name: "csi-cephfsplugin", Resource: profile.Plugin},
+			{Name: "liveness-prometheus", Resource: profile.Liveness},
+		},
+		"CSI_NFS_PROVISIONER_RESOURCE": {
+			{Name: "csi-provisioner", Resource: profile.Sidecar},
+			{Name: "csi-nfsplugin", Resource: profile.Plugin},
+		},
+		"CSI_NFS_PLUGIN_RESOURCE": {
+			{Name: "driver-registrar", Resource: profile.Registrar},
+			{Name: "csi-nfsplugin", Resource: profile.Plugin},
+		},
+	}
+}
+
+// cephCSIResourcesEqual parses the current value of a rook-ceph-operator-config key and compares
+// the resource quantities so that formatting differences do not cause an update
+func cephCSIResourcesEqual(current string, desired []cephCSIContainerResource) bool {
+	containers := []cephCSIContainerResource{}
+	if err := yaml.Unmarshal([]byte(current), &containers); err != nil {
+		return false
+	}
+	if len(containers) != len(desired) {
+		return false
+	}
+	byName := map[string]corev1.ResourceRequirements{}
+	for _, container := range containers {
+		byName[container.Name] = container.Resource
+	}
+	for _, container := range desired {
+		resources, ok := byName[container.Name]
+		if !ok {
+			return false
+		}
+		if !resourceListsEqual(resources.Requests, container.Resource.Requests) || !resourceListsEqual(resources.Limits, container.Resource.Limits) {
+			return false
+		}
+	}
+	return true
+}
+
+func resourceListsEqual(a, b corev1.ResourceList) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, quantity := range a {
+		other, ok := b[name]
+		if !ok || quantity.Cmp(other) != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,368 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/blang/semver"
+	"github.com/pkg/errors"
+	"github.com/replicatedhq/ekco/pkg/k8s"
+	"github.com/replicatedhq/ekco/pkg/rook"
+	cephv1 "github.com/rook/rook/pkg/apis/ceph.rook.io/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	apitypes "k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// CephUpgradeImageAnnotation is set on the CephCluster while EKCO is upgrading Ceph to the image
+	CephUpgradeImageAnnotation = "kurl.sh/ceph-upgrade-image"
+	// CephUpgradePausedAnnotation is set on the CephCluster with the reason an upgrade was stopped.
+	// The upgrade will not continue until the annotation is removed.
+	CephUpgradePausedAnnotation = "kurl.sh/ceph-upgrade-paused"
+	// CephUpgradeProgressAnnotation is set on the CephCluster with the daemon counts seen by the
+	// last check of an upgrade. The upgrade is paused if any of them decrease.
+	CephUpgradeProgressAnnotation = "kurl.sh/ceph-upgrade-progress"
+)
+
+var cephVersionRX = regexp.MustCompile(`(\d+)\.(\d+)\.(\d+)`)
+
+// minimum Rook version required to run each Ceph major version
+var cephMajorMinRookVersion = map[uint64]semver.Version{
+	16: semver.MustParse("1.6.0"),
+	17: Rookv19,
+	18: semver.MustParse("1.12.0"),
+	19: semver.MustParse("1.15.0"),
+}
+
+// the order Rook updates daemons in
+var cephUpgradeDaemonOrder = []string{"mon", "mgr", "osd", "mds", "rgw", "rbd-mirror", "cephfs-mirror"}
+
+// cephImageVersion returns the Ceph version from the tag of an image such as
+// quay.io/ceph/ceph:v17.2.6-20230410.
+func cephImageVersion(image string) (semver.Version, error) {
+	image = strings.SplitN(image, "@", 2)[0]
+	tag := image[strings.LastIndex(image, "/")+1:]
+	i := strings.LastIndex(tag, ":")
+	if i == -1 {
+		return semver.Version{}, fmt.Errorf("image %s has no tag", image)
+	}
+	return parseCephVersion(tag[i+1:])
+}
+
+// parseCephVersion parses the first x.y.z version found in s, such as the output of `ceph version`
+func parseCephVersion(s string) (semver.Version, error) {
+	matches := cephVersionRX.FindStringSubmatch(s)
+	if matches == nil {
+		return semver.Version{}, fmt.Errorf("no ceph version found in %q", s)
+	}
+	return semver.Parse(matches[0])
+}
+
+// CheckCephUpgradePath returns an error if upgrading Ceph from current to target is not supported
+// with the installed version of Rook.
+func CheckCephUpgradePath(rookVersion, current, target semver.Version) error {
+	current = semver.Version{Major: current.Major, Minor: current.Minor, Patch: current.Patch}
+	if target.LT(current) {
+		return fmt.Errorf("downgrading Ceph from %s to %s is not supported", current, target)
+	}
+	// Ceph supports upgrades from up to two major releases back
+	if target.Major > current.Major+2 {
+		return fmt.Errorf("upgrading Ceph from %s to %s is more than two major releases", current, target)
+	}
+	if minRook, ok := cephMajorMinRookVersion[target.Major]; ok && rookVersion.LT(minRook) {
+		return fmt.Errorf("ceph %s requires Rook %s or later, installed Rook version is %s", target, minRook, rookVersion)
+	}
+	return nil
+}
+
+// cephDaemonsUpgraded checks the daemon versions reported in the CephCluster status in the order
+// they are updated by Rook and returns the first daemon type not yet running the target version.
+func cephDaemonsUpgraded(versions *cephv1.CephDaemonsVersions, target semver.Version) (string, bool) {
+	if versions == nil {
+		return "", false
+	}
+	daemons := cephDaemonVersions(versions)
+	for _, daemon := range cephUpgradeDaemonOrder {
+		for version, count := range daemons[daemon] {
+			if count == 0 {
+				continue
+			}
+			v, err := parseCephVersion(version)
+			if err != nil || !v.Equals(target) {
+				return daemon, false
+			}
+		}
+	}
+	return "", true
+}
+
+// cephDaemonVersions returns the count of each version of each daemon type
+func cephDaemonVersions(versions *cephv1.CephDaemonsVersions) map[string]map[string]int {
+	return map[string]map[string]int{
+		"mon":           versions.Mon,
+		"mgr":           versions.Mgr,
+		"osd":           versions.Osd,
+		"mds":           versions.Mds,
+		"rgw":           versions.Rgw,
+		"rbd-mirror":    versions.RbdMirror,
+		"cephfs-mirror": versions.CephFSMirror,
+	}
+}
+
+// cephUpgradeProgress is the number of daemons of each type, the number of those running the
+// target version and the number of mons in quorum and OSDs up and in.
+type cephUpgradeProgress struct {
+	Daemons      map[string]int `json:"daemons,omitempty"`
+	Upgraded     map[string]int `json:"upgraded,omitempty"`
+	MonsInQuorum int            `json:"monsInQuorum"`
+	OSDsUp       int            `json:"osdsUp"`
+	OSDsIn       int            `json:"osdsIn"`
+}
+
+func newCephUpgradeProgress(status cephStatus, versions *cephv1.CephDaemonsVersions, target semver.Version) cephUpgradeProgress {
+	progress := cephUpgradeProgress{
+		Daemons:      map[string]int{},
+		Upgraded:     map[string]int{},
+		MonsInQuorum: len(status.QuorumNames),
+		OSDsUp:       status.OSDMap.NumUpOSDs,
+		OSDsIn:       status.OSDMap.NumInOSDs,
+	}
+	if versions == nil {
+		return progress
+	}
+	for daemon, counts := range cephDaemonVersions(versions) {
+		for version, count := range counts {
+			if count == 0 {
+				continue
+			}
+			progress.Daemons[daemon] += count
+			if v, err := parseCephVersion(version); err == nil && v.Equals(target) {
+				progress.Upgraded[daemon] += count
+			}
+		}
+	}
+	return progress
+}
+
+// cephUpgradeRegression returns a description of the first count that is lower in cur than in
+// prev.
+func cephUpgradeRegression(prev, cur cephUpgradeProgress) (string, bool) {
+	for _, daemon := range cephUpgradeDaemonOrder {
+		if cur.Daemons[daemon] < prev.Daemons[daemon] {
+			return fmt.Sprintf("%s daemons decreased from %d to %d", daemon, prev.Daemons[daemon], cur.Daemons[daemon]), true
+		}
+		if cur.Upgraded[daemon] < prev.Upgraded[daemon] {
+			return fmt.Sprintf("%s daemons running the target version decreased from %d to %d", daemon, prev.Upgraded[daemon], cur.Upgraded[daemon]), true
+		}
+	}
+	if cur.MonsInQuorum < prev.MonsInQuorum {
+		return fmt.Sprintf("mons in quorum decreased from %d to %d", prev.MonsInQuorum, cur.MonsInQuorum), true
+	}
+	if cur.OSDsUp < prev.OSDsUp {
+		return fmt.Sprintf("OSDs up decreased from %d to %d", prev.OSDsUp, cur.OSDsUp), true
+	}
+	if cur.OSDsIn < prev.OSDsIn {
+		return fmt.Sprintf("OSDs in decreased from %d to %d", prev.OSDsIn, cur.OSDsIn), true
+	}
+	return "", false
+}
+
+type cephStatus struct {
+	Health      cephHealthStatus `json:"health"`
+	QuorumNames []string         `json:"quorum_names"`
+	OSDMap      struct {
+		NumUpOSDs int `json:"num_up_osds"`
+		NumInOSDs int `json:"num_in_osds"`
+	} `json:"osdmap"`
+}
+
+func (c *Controller) cephStatus(ctx context.Context, rookVersion semver.Version) (cephStatus, error) {
+	status := cephStatus{}
+	stdout, err := c.rookCephExecOutput(ctx, rookVersion, "ceph", "status", "--format", "json")
+	if err != nil {
+		return status, errors.Wrap(err, "ceph status")
+	}
+	if err := json.Unmarshal([]byte(stdout), &status); err != nil {
+		return status, errors.Wrap(err, "unmarshal ceph status")
+	}
+	return status, nil
+}
+
+type cephHealthStatus struct {
+	Status string `json:"status"`
+}
+
+func (c *Controller) cephHealth(ctx context.Context, rookVersion semver.Version) (string, error) {
+	stdout, err := c.rookCephExecOutput(ctx, rookVersion, "ceph", "health", "--format", "json")
+	if err != nil {
+		return "", errors.Wrap(err, "ceph health")
+	}
+	health := cephHealthStatus{}
+	if err := json.Unmarshal([]byte(stdout), &health); err != nil {
+		return "", errors.Wrap(err, "unmarshal ceph health")
+	}
+	return health.Status, nil
+}
+
+// ReconcileCephUpgrade upgrades the CephCluster to the Ceph image. The upgrade is started once the
+// cluster is healthy and the upgrade path is supported, then Rook rolls the daemons. Each call
+// checks progress and returns true once all daemons are running the target version. If Ceph health
+// becomes HEALTH_ERR during the upgrade, or the number of daemons, daemons running the target
+// version, mons in quorum or OSDs up or in is lower than on the previous check, the Rook operator
+// is scaled down to stop the rollout and the CephCluster is annotated with the reason.
+func (c *Controller) ReconcileCephUpgrade(ctx context.Context, rookVersion semver.Version, image string) (bool, error) {
+	cluster, err := c.GetCephCluster(ctx)
+	if err != nil {
+		return false, errors.Wrap(err, "get CephCluster")
+	}
+	if reason, ok := cluster.Annotations[CephUpgradePausedAnnotation]; ok {
+		return false, fmt.Errorf("ceph upgrade paused: %s", reason)
+	}
+
+	target, err := cephImageVersion(image)
+	if err != nil {
+		return false, err
+	}
+
+	if cluster.Spec.CephVersion.Image != image {
+		return false, c.startCephUpgrade(ctx, rookVersion, cluster, image, target)
+	}
+	if cluster.Annotations[CephUpgradeImageAnnotation] != image {
+		// not an upgrade started by EKCO
+		return true, nil
+	}
+
+	status, err := c.cephStatus(ctx, rookVersion)
+	if err != nil {
+		return false, err
+	}
+	if status.Health.Status == "HEALTH_ERR" {
+		reason := fmt.Sprintf("Ceph health is %s during upgrade to %s", status.Health.Status, image)
+		if err := c.pauseCephUpgrade(ctx, cluster, reason); err != nil {
+			return false, err
+		}
+		return false, errors.New(reason)
+	}
+
+	var versions *cephv1.CephDaemonsVersions
+	if cluster.Status.CephStatus != nil {
+		versions = cluster.Status.CephStatus.Versions
+	}
+	progress := newCephUpgradeProgress(status, versions, target)
+	if last, ok := cluster.Annotations[CephUpgradeProgressAnnotation]; ok {
+		prev := cephUpgradeProgress{}
+		if err := json.Unmarshal([]byte(last), &prev); err != nil {
+			c.Log.Warnf("Failed to parse %s annotation: %v", CephUpgradeProgressAnnotation, err)
+		} else if regression, ok := cephUpgradeRegression(prev, progress); ok {
+			reason := fmt.Sprintf("%s with Ceph health %s during upgrade to %s", regression, status.Health.Status, image)
+			if err := c.pauseCephUpgrade(ctx, cluster, reason); err != nil {
+				return false, err
+			}
+			return false, errors.New(reason)
+		}
+	}
+
+	if daemon, ok := cephDaemonsUpgraded(versions, target); !ok {
+		b, err := json.Marshal(progress)
+		if err != nil {
+			return false, errors.Wrap(err, "marshal upgrade progress")
+		}
+		if cluster.Annotations[CephUpgradeProgressAnnotation] != string(b) {
+			if _, err := c.mergePatchCephClusterAnnotations(ctx, map[string]interface{}{CephUpgradeProgressAnnotation: string(b)}); err != nil {
+				return false, err
+			}
+		}
+		c.Log.Infof("Waiting for Ceph %s daemons to be upgraded to %s", daemon, target)
+		return false, nil
+	}
+
+	if err := c.rookCephExec(ctx, rookVersion, "ceph", "osd", "unset", "noout"); err != nil {
+		return false, errors.Wrap(err, "unset noout")
+	}
+	if _, err := c.mergePatchCephClusterAnnotations(ctx, map[string]interface{}{CephUpgradeImageAnnotation: nil, CephUpgradeProgressAnnotation: nil}); err != nil {
+		return false, err
+	}
+	c.Log.Infof("Ceph upgrade to %s complete", target)
+	c.recordEventf(cluster, corev1.EventTypeNormal, "CephUpgradeComplete", "Upgraded Ceph to %s", target)
+
+	return true, nil
+}
+
+func (c *Controller) startCephUpgrade(ctx context.Context, rookVersion semver.Version, cluster *cephv1.CephCluster, image string, target semver.Version) error {
+	current, err := rook.GetCephVersion(*cluster)
+	if err != nil {
+		return errors.Wrap(err, "get current Ceph version")
+	}
+	if err := CheckCephUpgradePath(rookVersion, current, target); err != nil {
+		return err
+	}
+
+	health, err := c.cephHealth(ctx, rookVersion)
+	if err != nil {
+		return err
+	}
+	// noout will already be set if a previous attempt to start this upgrade failed
+	retry := cluster.Annotations[CephUpgradeImageAnnotation] == image && health == "HEALTH_WARN"
+	if health != "HEALTH_OK" && !retry {
+		return fmt.Errorf("ceph health is %s, upgrade requires HEALTH_OK", health)
+	}
+
+	if err := c.rookCephExec(ctx, rookVersion, "ceph", "osd", "set", "noout"); err != nil {
+		return errors.Wrap(err, "set noout")
+	}
+	if _, err := c.mergePatchCephClusterAnnotations(ctx, map[string]interface{}{CephUpgradeImageAnnotation: image}); err != nil {
+		return err
+	}
+	patches := []k8s.JSONPatchOperation{{
+		Op:    k8s.JSONPatchOpReplace,
+		Path:  "/spec/cephVersion/image",
+		Value: image,
+	}}
+	if _, err := c.JSONPatchCephCluster(ctx, patches); err != nil {
+		return errors.Wrap(err, "patch CephCluster image")
+	}
+	c.Log.Infof("Started Ceph upgrade from %s to %s", current, target)
+	c.recordEventf(cluster, corev1.EventTypeNormal, "CephUpgradeStarted", "Upgrading Ceph from %s to %s", current, target)
+
+	return nil
+}
+
+// pauseCephUpgrade stops Rook from updating any more daemons by scaling down the operator. The
+// recorded progress is removed so that counts are checked from the state the upgrade is continued
+// in.
+func (c *Controller) pauseCephUpgrade(ctx context.Context, cluster *cephv1.CephCluster, reason string) error {
+	patch := []byte(`{"spec":{"replicas":0}}`)
+	_, err := c.Config.Client.AppsV1().Deployments(RookCephNS).Patch(ctx, "rook-ceph-operator", apitypes.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return errors.Wrap(err, "scale down rook-ceph-operator")
+	}
+	if _, err := c.mergePatchCephClusterAnnotations(ctx, map[string]interface{}{CephUpgradePausedAnnotation: reason, CephUpgradeProgressAnnotation: nil}); err != nil {
+		return err
+	}
+	c.Log.Errorf("Paused Ceph upgrade: %s. Scale up the rook-ceph-operator deployment and remove the %s annotation from the CephCluster to continue.", reason, CephUpgradePausedAnnotation)
+	c.recordEventf(cluster, corev1.EventTypeWarning, "CephUpgradePaused", "Paused Ceph upgrade: %s", reason)
+
+	return nil
+}
+
+// mergePatchCephClusterAnnotations sets annotations on the CephCluster. A nil value removes the
+// annotation.
+func (c *Controller) mergePatchCephClusterAnnotations(ctx context.Context, annotations map[string]interface{}) (*cephv1.CephCluster, error) {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": annotations,
+		},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal patch")
+	}
+	cluster, err := c.Config.CephV1.CephClusters(RookCephNS).Patch(ctx, CephClusterName, apitypes.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "patch CephCluster annotations")
+	}
+	return cluster, nil
+}
@@ -0,0 +1,443 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/blang/semver"
+	"github.com/golang/mock/gomock"
+	"github.com/replicatedhq/ekco/pkg/cluster/types"
+	mock_k8s "github.com/replicatedhq/ekco/pkg/k8s/mock"
+	"github.com/replicatedhq/ekco/pkg/logger"
+	cephv1 "github.com/rook/rook/pkg/apis/ceph.rook.io/v1"
+	rookfake "github.com/rook/rook/pkg/client/clientset/versioned/fake"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func Test_cephImageVersion(t *testing.T) {
+	tests := []struct {
+		image   string
+		want    string
+		wantErr bool
+	}{
+		{image: "quay.io/ceph/ceph:v17.2.6", want: "17.2.6"},
+		{image: "quay.io/ceph/ceph:v17.2.6-20230410", want: "17.2.6"},
+		{image: "registry.local:5000/ceph/ceph:v18.2.1@sha256:0123456789abcdef", want: "18.2.1"},
+		{image: "ceph/ceph:v16.2.11", want: "16.2.11"},
+		{image: "registry.local:5000/ceph/ceph", wantErr: true},
+		{image: "ceph/ceph:latest", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.image, func(t *testing.T) {
+			got, err := cephImageVersion(tt.image)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("cephImageVersion() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && got.String() != tt.want {
+				t.Errorf("cephImageVersion() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckCephUpgradePath(t *testing.T) {
+	tests := []struct {
+		name        string
+		rookVersion string
+		current     string
+		target      string
+		wantErr     bool
+	}{
+		{
+			name:        "patch upgrade",
+			rookVersion: "1.9.12",
+			current:     "17.2.5-0",
+			target:      "17.2.6",
+		},
+		{
+			name:        "major upgrade",
+			rookVersion: "1.12.0",
+			current:     "17.2.6",
+			target:      "18.2.1",
+		},
+		{
+			name:        "two major releases",
+			rookVersion: "1.12.0",
+			current:     "16.2.11",
+			target:      "18.2.1",
+		},
+		{
+			name:        "three major releases",
+			rookVersion: "1.15.0",
+			current:     "16.2.11",
+			target:      "19.2.0",
+			wantErr:     true,
+		},
+		{
+			name:        "downgrade",
+			rookVersion: "1.9.12",
+			current:     "17.2.6",
+			target:      "17.2.5",
+			wantErr:     true,
+		},
+		{
+			name:        "rook too old",
+			rookVersion: "1.9.12",
+			current:     "17.2.6",
+			target:      "18.2.1",
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckCephUpgradePath(semver.MustParse(tt.rookVersion), semver.MustParse(tt.current), semver.MustParse(tt.target))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CheckCephUpgradePath() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_cephDaemonsUpgraded(t *testing.T) {
+	quincy5 := "ceph version 17.2.5 (98318ae89f1a893a6ded3a640405cdbb33e08757) quincy (stable)"
+	quincy6 := "ceph version 17.2.6 (d7ff0d10654d2280e08f1ab989c7cdf3064446a5) quincy (stable)"
+	target := semver.MustParse("17.2.6")
+
+	tests := []struct {
+		name       string
+		versions   *cephv1.CephDaemonsVersions
+		wantDaemon string
+		want       bool
+	}{
+		{
+			name:     "no status",
+			versions: nil,
+			want:     false,
+		},
+		{
+			name: "mons rolling",
+			versions: &cephv1.CephDaemonsVersions{
+				Mon: map[string]int{quincy5: 2, quincy6: 1},
+				Mgr: map[string]int{quincy5: 1},
+				Osd: map[string]int{quincy5: 3},
+			},
+			wantDaemon: "mon",
+			want:       false,
+		},
+		{
+			name: "osds rolling",
+			versions: &cephv1.CephDaemonsVersions{
+				Mon: map[string]int{quincy6: 3},
+				Mgr: map[string]int{quincy6: 1},
+				Osd: map[string]int{quincy5: 1, quincy6: 2},
+				Rgw: map[string]int{quincy5: 1},
+			},
+			wantDaemon: "osd",
+			want:       false,
+		},
+		{
+			name: "complete",
+			versions: &cephv1.CephDaemonsVersions{
+				Mon:     map[string]int{quincy6: 3},
+				Mgr:     map[string]int{quincy6: 1},
+				Osd:     map[string]int{quincy6: 3},
+				Mds:     map[string]int{quincy6: 2},
+				Rgw:     map[string]int{quincy6: 1},
+				Overall: map[string]int{quincy6: 10},
+			},
+			want: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotDaemon, got := cephDaemonsUpgraded(tt.versions, target)
+			if got != tt.want {
+				t.Errorf("cephDaemonsUpgraded() = %v, want %v", got, tt.want)
+			}
+			if gotDaemon != tt.wantDaemon {
+				t.Errorf("cephDaemonsUpgraded() daemon = %q, want %q", gotDaemon, tt.wantDaemon)
+			}
+		})
+	}
+}
+
+func TestController_ReconcileCephUpgrade(t *testing.T) {
+	quincy5 := "ceph version 17.2.5 (98318ae89f1a893a6ded3a640405cdbb33e08757) quincy (stable)"
+	quincy6 := "ceph version 17.2.6 (d7ff0d10654d2280e08f1ab989c7cdf3064446a5) quincy (stable)"
+	image := "quay.io/ceph/ceph:v17.2.6"
+
+	cephClusterVersions := func(specImage string, annotations map[string]string, mon, osd map[string]int) *cephv1.CephCluster {
+		return &cephv1.CephCluster{
+			TypeMeta: metav1.TypeMeta{Kind: "CephCluster", APIVersion: "ceph.rook.io/v1"},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "rook-ceph",
+				Namespace:   "rook-ceph",
+				Annotations: annotations,
+			},
+			Spec: cephv1.ClusterSpec{
+				CephVersion: cephv1.CephVersionSpec{
+					Image: specImage,
+				},
+			},
+			Status: cephv1.ClusterStatus{
+				CephVersion: &cephv1.ClusterVersion{
+					Image:   "quay.io/ceph/ceph:v17.2.5",
+					Version: "17.2.5-0",
+				},
+				CephStatus: &cephv1.CephStatus{
+					Versions: &cephv1.CephDaemonsVersions{
+						Mon: mon,
+						Osd: osd,
+					},
+				},
+			},
+		}
+	}
+	cephCluster := func(specImage string, annotations map[string]string, daemonVersion string) *cephv1.CephCluster {
+		return cephClusterVersions(specImage, annotations, map[string]int{daemonVersion: 3}, map[string]int{daemonVersion: 3})
+	}
+	toolsPod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "rook-ceph-tools-5b8b8b8b8b-5b8b8",
+			Namespace: "rook-ceph",
+			Labels: map[string]string{
+				"app": "rook-ceph-tools",
+			},
+		},
+	}
+	one := int32(1)
+	operator := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "rook-ceph-operator",
+			Namespace: "rook-ceph",
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &one,
+		},
+	}
+	expectHealth := func(m *mock_k8s.MockSyncExecutorInterface, status string) {
+		m.EXPECT().ExecContainer(gomock.Any(), "rook-ceph", "rook-ceph-tools-5b8b8b8b8b-5b8b8", "rook-ceph-tools", "ceph", "health", "--format", "json").
+			Return(0, `{"status":"`+status+`","checks":{},"mutes":[]}`, "", nil)
+	}
+	expectStatus := func(m *mock_k8s.MockSyncExecutorInterface, health string, quorum string, osdsUp, osdsIn int) {
+		m.EXPECT().ExecContainer(gomock.Any(), "rook-ceph", "rook-ceph-tools-5b8b8b8b8b-5b8b8", "rook-ceph-tools", "ceph", "status", "--format", "json").
+			Return(0, fmt.Sprintf(`{"health":{"status":"%s","checks":{},"mutes":[]},"quorum_names":[%s],"osdmap":{"epoch":42,"num_osds":3,"num_up_osds":%d,"num_in_osds":%d}}`, health, quorum, osdsUp, osdsIn), "", nil)
+	}
+	allMons := `"a","b","c"`
+	rolling := `{"daemons":{"mon":3,"osd":3},"monsInQuorum":3,"osdsUp":3,"osdsIn":3}`
+	monsUpgraded := `{"daemons":{"mon":3,"osd":3},"upgraded":{"mon":3},"monsInQuorum":3,"osdsUp":3,"osdsIn":3}`
+
+	tests := []struct {
+		name                    string
+		cephCluster             *cephv1.CephCluster
+		mockSyncExecutorExpects func(*mock_k8s.MockSyncExecutorInterface)
+		want                    bool
+		wantErr                 bool
+		wantImage               string
+		wantAnnotations         map[string]string
+		wantOperatorReplicas    int32
+	}{
+		{
+			name:        "start upgrade",
+			cephCluster: cephCluster("quay.io/ceph/ceph:v17.2.5", nil, quincy5),
+			mockSyncExecutorExpects: func(m *mock_k8s.MockSyncExecutorInterface) {
+				expectHealth(m, "HEALTH_OK")
+				m.EXPECT().ExecContainer(gomock.Any(), "rook-ceph", "rook-ceph-tools-5b8b8b8b8b-5b8b8", "rook-ceph-tools", "ceph", "osd", "set", "noout").
+					Return(0, "", "noout is set", nil)
+			},
+			want:                 false,
+			wantImage:            image,
+			wantAnnotations:      map[string]string{CephUpgradeImageAnnotation: image},
+			wantOperatorReplicas: 1,
+		},
+		{
+			name:        "unhealthy cluster is not upgraded",
+			cephCluster: cephCluster("quay.io/ceph/ceph:v17.2.5", nil, quincy5),
+			mockSyncExecutorExpects: func(m *mock_k8s.MockSyncExecutorInterface) {
+				expectHealth(m, "HEALTH_WARN")
+			},
+			want:                 false,
+			wantErr:              true,
+			wantImage:            "quay.io/ceph/ceph:v17.2.5",
+			wantOperatorReplicas: 1,
+		},
+		{
+			name:        "daemons rolling",
+			cephCluster: cephCluster(image, map[string]string{CephUpgradeImageAnnotation: image}, quincy5),
+			mockSyncExecutorExpects: func(m *mock_k8s.MockSyncExecutorInterface) {
+				expectStatus(m, "HEALTH_WARN", allMons, 3, 3)
+			},
+			want:      false,
+			wantImage: image,
+			wantAnnotations: map[string]string{
+				CephUpgradeImageAnnotation:    image,
+				CephUpgradeProgressAnnotation: rolling,
+			},
+			wantOperatorReplicas: 1,
+		},
+		{
+			name: "daemons upgraded since last check",
+			cephCluster: cephClusterVersions(image, map[string]string{
+				CephUpgradeImageAnnotation:    image,
+				CephUpgradeProgressAnnotation: rolling,
+			}, map[string]int{quincy6: 3}, map[string]int{quincy5: 3}),
+			mockSyncExecutorExpects: func(m *mock_k8s.MockSyncExecutorInterface) {
+				expectStatus(m, "HEALTH_WARN", allMons, 3, 3)
+			},
+			want:      false,
+			wantImage: image,
+			wantAnnotations: map[string]string{
+				CephUpgradeImageAnnotation:    image,
+				CephUpgradeProgressAnnotation: monsUpgraded,
+			},
+			wantOperatorReplicas: 1,
+		},
+		{
+			name: "fewer daemons on target version pauses upgrade",
+			cephCluster: cephClusterVersions(image, map[string]string{
+				CephUpgradeImageAnnotation:    image,
+				CephUpgradeProgressAnnotation: monsUpgraded,
+			}, map[string]int{quincy5: 1, quincy6: 2}, map[string]int{quincy5: 3}),
+			mockSyncExecutorExpects: func(m *mock_k8s.MockSyncExecutorInterface) {
+				expectStatus(m, "HEALTH_WARN", allMons, 3, 3)
+			},
+			want:      false,
+			wantErr:   true,
+			wantImage: image,
+			wantAnnotations: map[string]string{
+				CephUpgradeImageAnnotation:  image,
+				CephUpgradePausedAnnotation: "mon daemons running the target version decreased from 3 to 2 with Ceph health HEALTH_WARN during upgrade to " + image,
+			},
+			wantOperatorReplicas: 0,
+		},
+		{
+			name: "mon out of quorum pauses upgrade",
+			cephCluster: cephCluster(image, map[string]string{
+				CephUpgradeImageAnnotation:    image,
+				CephUpgradeProgressAnnotation: rolling,
+			}, quincy5),
+			mockSyncExecutorExpects: func(m *mock_k8s.MockSyncExecutorInterface) {
+				expectStatus(m, "HEALTH_WARN", `"a","b"`, 3, 3)
+			},
+			want:      false,
+			wantErr:   true,
+			wantImage: image,
+			wantAnnotations: map[string]string{
+				CephUpgradeImageAnnotation:  image,
+				CephUpgradePausedAnnotation: "mons in quorum decreased from 3 to 2 with Ceph health HEALTH_WARN during upgrade to " + image,
+			},
+			wantOperatorReplicas: 0,
+		},
+		{
+			name: "osd out pauses upgrade",
+			cephCluster: cephCluster(image, map[string]string{
+				CephUpgradeImageAnnotation:    image,
+				CephUpgradeProgressAnnotation: rolling,
+			}, quincy5),
+			mockSyncExecutorExpects: func(m *mock_k8s.MockSyncExecutorInterface) {
+				expectStatus(m, "HEALTH_WARN", allMons, 3, 2)
+			},
+			want:      false,
+			wantErr:   true,
+			wantImage: image,
+			wantAnnotations: map[string]string{
+				CephUpgradeImageAnnotation:  image,
+				CephUpgradePausedAnnotation: "OSDs in decreased from 3 to 2 with Ceph health HEALTH_WARN during upgrade to " + image,
+			},
+			wantOperatorReplicas: 0,
+		},
+		{
+			name: "regression pauses upgrade",
+			cephCluster: cephCluster(image, map[string]string{
+				CephUpgradeImageAnnotation:    image,
+				CephUpgradeProgressAnnotation: rolling,
+			}, quincy5),
+			mockSyncExecutorExpects: func(m *mock_k8s.MockSyncExecutorInterface) {
+				expectStatus(m, "HEALTH_ERR", allMons, 3, 3)
+			},
+			want:      false,
+			wantErr:   true,
+			wantImage: image,
+			wantAnnotations: map[string]string{
+				CephUpgradeImageAnnotation:  image,
+				CephUpgradePausedAnnotation: "Ceph health is HEALTH_ERR during upgrade to " + image,
+			},
+			wantOperatorReplicas: 0,
+		},
+		{
+			name: "complete",
+			cephCluster: cephCluster(image, map[string]string{
+				CephUpgradeImageAnnotation:    image,
+				CephUpgradeProgressAnnotation: monsUpgraded,
+			}, quincy6),
+			mockSyncExecutorExpects: func(m *mock_k8s.MockSyncExecutorInterface) {
+				expectStatus(m, "HEALTH_WARN", allMons, 3, 3)
+				m.EXPECT().ExecContainer(gomock.Any(), "rook-ceph", "rook-ceph-tools-5b8b8b8b8b-5b8b8", "rook-ceph-tools", "ceph", "osd", "unset", "noout").
+					Return(0, "", "noout is unset", nil)
+			},
+			want:                 true,
+			wantImage:            image,
+			wantOperatorReplicas: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			m := mock_k8s.NewMockSyncExecutorInterface(ctrl)
+			if tt.mockSyncExecutorExpects != nil {
+				tt.mockSyncExecutorExpects(m)
+			}
+
+			clientset := fake.NewSimpleClientset(toolsPod, operator.DeepCopy())
+			rookClientset := rookfake.NewSimpleClientset(tt.cephCluster)
+			c := &Controller{
+				Config: types.ControllerConfig{
+					Client: clientset,
+					CephV1: rookClientset.CephV1(),
+				},
+				SyncExecutor: m,
+				Log:          logger.NewDiscardLogger(),
+			}
+
+			got, err := c.ReconcileCephUpgrade(context.Background(), semver.MustParse("1.9.12"), image)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Controller.ReconcileCephUpgrade() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("Controller.ReconcileCephUpgrade() = %v, want %v", got, tt.want)
+			}
+
+			cluster, err := rookClientset.CephV1().CephClusters("rook-ceph").Get(context.Background(), "rook-ceph", metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("CephClusters.Get(\"rook-ceph\") error = %v", err)
+			}
+			if cluster.Spec.CephVersion.Image != tt.wantImage {
+				t.Errorf("CephCluster.Spec.CephVersion.Image = %s, want %s", cluster.Spec.CephVersion.Image, tt.wantImage)
+			}
+			if len(cluster.Annotations) != len(tt.wantAnnotations) {
+				t.Errorf("CephCluster annotations = %v, want %v", cluster.Annotations, tt.wantAnnotations)
+			}
+			for key, value := range tt.wantAnnotations {
+				if cluster.Annotations[key] != value {
+					t.Errorf("CephCluster annotation %s = %q, want %q", key, cluster.Annotations[key], value)
+				}
+			}
+
+			deploy, err := clientset.AppsV1().Deployments("rook-ceph").Get(context.Background(), "rook-ceph-operator", metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("Deployments.Get(\"rook-ceph-operator\") error = %v", err)
+			}
+			if *deploy.Spec.Replicas != tt.wantOperatorReplicas {
+				t.Errorf("rook-ceph-operator replicas = %d, want %d", *deploy.Spec.Replicas, tt.wantOperatorReplicas)
+			}
+		})
+	}
+}
@@ -0,0 +1,241 @@
+package cluster
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/replicatedhq/ekco/pkg/certinventory"
+	"github.com/replicatedhq/ekco/pkg/util"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	certutil "k8s.io/client-go/util/cert"
+)
+
+const (
+	DefaultKubeletPKIDir     = "/var/lib/kubelet/pki"
+	DefaultKubeletConfigPath = "/var/lib/kubelet/config.yaml"
+)
+
+// time allowed for the list certs task on each node to complete
+const listCertsTimeout = 2 * time.Minute
+
+// list certs tasks running at a time
+const listCertsParallelism = 5
+
+// how long an inventory is served before the certificates are listed again
+const certInventoryMaxAge = 5 * time.Minute
+
+type certInventoryCache struct {
+	certs []certinventory.Certificate
+	time  time.Time
+}
+
+// CertificateInventory returns the certificates in the secrets ekco manages, the kubeadm
+// certificates on each primary and the kubelet certificates on every node. Host certificates are
+// read by a host task with read-only mounts of the host directories. Failures to read a node or
+// secret are reported in the Error field of an entry rather than failing the inventory.
+// Inventories are cached for certInventoryMaxAge. Callers that ask while the inventory is being
+// refreshed wait for that refresh, which is not cancelled if they stop waiting.
+func (c *Controller) CertificateInventory(ctx context.Context) ([]certinventory.Certificate, error) {
+	c.certInventoryMtx.Lock()
+	cached := c.certInventoryCache
+	c.certInventoryMtx.Unlock()
+	if cached != nil && time.Since(cached.time) < certInventoryMaxAge {
+		return cached.certs, nil
+	}
+
+	ch := c.certInventoryGroup.DoChan("inventory", func() (interface{}, error) {
+		certs, err := c.certificateInventory(context.WithoutCancel(ctx))
+		if err != nil {
+			return nil, err
+		}
+		c.certInventoryMtx.Lock()
+		c.certInventoryCache = &certInventoryCache{certs: certs, time: time.Now()}
+		c.certInventoryMtx.Unlock()
+		return certs, nil
+	})
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res := <-ch:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return res.Val.([]certinventory.Certificate), nil
+	}
+}
+
+func (c *Controller) certificateInventory(ctx context.Context) ([]certinventory.Certificate, error) {
+	certs, err := c.secretCertificates(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes, err := c.Config.Client.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "list nodes")
+	}
+
+	hostCerts, errs := c.listHostCertificates(ctx, nodes.Items)
+	for _, node := range nodes.Items {
+		if err := errs[node.Name]; err != nil {
+			certs = append(certs, certinventory.Certificate{
+				Name:     "host",
+				Source:   certinventory.SourceFile,
+				Location: DefaultEtcKubernetesDir,
+				Node:     node.Name,
+				Error:    err.Error(),
+			})
+			continue
+		}
+		for _, cert := range hostCerts[node.Name] {
+			cert.Rotate = cert.Rotate && c.Config.RotateCerts
+			certs = append(certs, cert)
+		}
+	}
+
+	return certs, nil
+}
+
+// listHostCertificates runs the list certs task on the nodes with at most listCertsParallelism
+// running at a time. It returns the certificates and the error of each node by node name.
+func (c *Controller) listHostCertificates(ctx context.Context, nodes []corev1.Node) (map[string][]certinventory.Certificate, map[string]error) {
+	tasks := make([]HostTask, 0, len(nodes))
+	for _, node := range nodes {
+		tasks = append(tasks, c.listCertsTask(node.Name))
+	}
+
+	certs := map[string][]certinventory.Certificate{}
+	var mtx sync.Mutex
+	errs := c.runHostTasksOutput(ctx, tasks, listCertsParallelism, func(ctx context.Context, task HostTask, output []byte) error {
+		nodeCerts := []certinventory.Certificate{}
+		if err := json.Unmarshal(output, &nodeCerts); err != nil {
+			return errors.Wrap(err, "decode list certs output")
+		}
+		mtx.Lock()
+		certs[task.Node] = nodeCerts
+		mtx.Unlock()
+		return nil
+	})
+	for node, err := range errs {
+		if err != nil {
+			errs[node] = errors.Wrapf(err, "list certs task for node %s", node)
+		}
+	}
+	return certs, errs
+}
+
+// listCertsTask returns the list-certs host task. It prints the certificates to its logs, as they
+// may be longer than a termination message.
+func (c *Controller) listCertsTask(nodeName string) HostTask {
+	task := c.rotateCertsTask(nodeName)
+	task.Name = ListCertsValue
+	task.Command = []string{
+		"ekco",
+		"certs",
+		"list",
+		"--host",
+		"--output=json",
+	}
+	task.TolerateAll = true
+	task.Timeout = listCertsTimeout
+	task.Mounts = []HostTaskMount{
+		{
+			Name:     "etc-kubernetes",
+			HostPath: DefaultEtcKubernetesDir,
+			ReadOnly: true,
+		},
+		kubeletPKIMount(true),
+		kubeletConfigMount(),
+	}
+	return task
+}
+
+func kubeletPKIMount(readOnly bool) HostTaskMount {
+	return HostTaskMount{
+		Name:     "kubelet-pki",
+		HostPath: DefaultKubeletPKIDir,
+		ReadOnly: readOnly,
+	}
+}
+
+func kubeletConfigMount() HostTaskMount {
+	return HostTaskMount{
+		Name:         "kubelet-config",
+		HostPath:     DefaultKubeletConfigPath,
+		HostPathType: corev1.HostPathFile,
+		ReadOnly:     true,
+	}
+}
+
+// secretCertificates returns the certificates stored in secrets that ekco rotates
+func (c *Controller) secretCertificates(ctx context.Context) ([]certinventory.Certificate, error) {
+	type secretCert struct {
+		name      string
+		namespace string
+		secret    string
+		key       string
+		rotate    func(*x509.Certificate) bool
+	}
+	always := func(*x509.Certificate) bool { return true }
+
+	sources := []secretCert{
+		{"registry", c.Config.RegistryCertNamespace, c.Config.RegistryCertSecret, "registry.crt", always},
+		{"kurl-proxy", c.Config.KurlProxyCertNamespace, c.Config.KurlProxyCertSecret, corev1.TLSCertKey, func(cert *x509.Certificate) bool {
+			return !isCustomKurlProxyCert(cert)
+		}},
+		{"contour-ca", c.Config.ContourNamespace, c.Config.ContourCertSecret, "ca.crt", always},
+		{"contour", c.Config.ContourNamespace, c.Config.ContourCertSecret, corev1.TLSCertKey, always},
+		{"envoy", c.Config.ContourNamespace, c.Config.EnvoyCertSecret, corev1.TLSCertKey, always},
+		{"kotsadm-kubelet-client", c.Config.KotsadmKubeletCertNamespace, c.Config.KotsadmKubeletCertSecret, "client.crt", always},
+	}
+
+	certs := []certinventory.Certificate{}
+	for _, source := range sources {
+		if source.namespace == "" || source.secret == "" {
+			continue
+		}
+		location := fmt.Sprintf("%s/%s/%s", source.namespace, source.secret, source.key)
+
+		secret, err := c.Config.Client.CoreV1().Secrets(source.namespace).Get(ctx, source.secret, metav1.GetOptions{})
+		if err != nil {
+			if util.IsNotFoundErr(err) {
+				continue
+			}
+			return nil, errors.Wrapf(err, "get secret %s/%s", source.namespace, source.secret)
+		}
+
+		parsed, err := certutil.ParseCertsPEM(secret.Data[source.key])
+		if err != nil {
+			certs = append(certs, certinventory.Certificate{
+				Name:     source.name,
+				Source:   certinventory.SourceSecret,
+				Location: location,
+				Error:    errors.Wrapf(err, "parse %s", source.key).Error(),
+			})
+			continue
+		}
+		cert := certinventory.New(source.name, certinventory.SourceSecret, location, parsed[0])
+		cert.Rotate = c.Config.RotateCerts && source.rotate(parsed[0])
+		certs = append(certs, cert)
+	}
+
+	return certs, nil
+}
+
+// If generated by kurl installer Subject will be "kotsadm.default.svc.cluster.local" and Issuer
+// will be empty. If already rotated by ekco then Subject will be like
+// "kotsadm.default.svc.cluster.local@1604697213" and Issuer like
+// "kotsadm.default.svc.cluster.local-ca@1604697213". Anything else is a custom uploaded cert.
+func isCustomKurlProxyCert(cert *x509.Certificate) bool {
+	if cert.Issuer.CommonName != "" && !strings.HasPrefix(cert.Issuer.CommonName, "kotsadm.default.svc.cluster.local") {
+		return true
+	}
+	return !strings.HasPrefix(cert.Subject.CommonName, "kotsadm.default.svc.cluster.local")
+}
@@ -0,0 +1,188 @@
+package cluster
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/replicatedhq/ekco/pkg/certinventory"
+	"github.com/replicatedhq/ekco/pkg/cluster/types"
+	"github.com/replicatedhq/ekco/pkg/logger"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	certutil "k8s.io/client-go/util/cert"
+)
+
+func TestController_secretCertificates(t *testing.T) {
+	registryCrt, _, err := certutil.GenerateSelfSignedCertKey("registry.kurl.svc.cluster.local", nil, []string{"registry.kurl.svc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kurlProxyCrt, _, err := certutil.GenerateSelfSignedCertKey("kotsadm.default.svc.cluster.local", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	customCrt, _, err := certutil.GenerateSelfSignedCertKey("app.example.com", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret := func(namespace, name string, data map[string][]byte) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+			Data:       data,
+		}
+	}
+
+	tests := []struct {
+		name        string
+		rotateCerts bool
+		secrets     []*corev1.Secret
+		want        map[string]certinventory.Certificate
+	}{
+		{
+			name:        "rotation enabled",
+			rotateCerts: true,
+			secrets: []*corev1.Secret{
+				secret("kurl", "registry-pki", map[string][]byte{"registry.crt": registryCrt}),
+				secret("default", "kotsadm-tls", map[string][]byte{"tls.crt": kurlProxyCrt}),
+				secret("default", "kubelet-client-cert", map[string][]byte{"client.crt": []byte("garbage")}),
+			},
+			want: map[string]certinventory.Certificate{
+				"registry":               {Location: "kurl/registry-pki/registry.crt", Rotate: true},
+				"kurl-proxy":             {Location: "default/kotsadm-tls/tls.crt", Rotate: true},
+				"kotsadm-kubelet-client": {Location: "default/kubelet-client-cert/client.crt", Error: "parse client.crt"},
+			},
+		},
+		{
+			name:        "custom kurl proxy cert",
+			rotateCerts: true,
+			secrets: []*corev1.Secret{
+				secret("default", "kotsadm-tls", map[string][]byte{"tls.crt": customCrt}),
+			},
+			want: map[string]certinventory.Certificate{
+				"kurl-proxy": {Location: "default/kotsadm-tls/tls.crt", Rotate: false},
+			},
+		},
+		{
+			name:        "rotation disabled",
+			rotateCerts: false,
+			secrets: []*corev1.Secret{
+				secret("kurl", "registry-pki", map[string][]byte{"registry.crt": registryCrt}),
+			},
+			want: map[string]certinventory.Certificate{
+				"registry": {Location: "kurl/registry-pki/registry.crt", Rotate: false},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset()
+			for _, s := range tt.secrets {
+				if _, err := clientset.CoreV1().Secrets(s.Namespace).Create(context.Background(), s, metav1.CreateOptions{}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			c := &Controller{
+				Config: types.ControllerConfig{
+					Client:                      clientset,
+					RotateCerts:                 tt.rotateCerts,
+					RegistryCertNamespace:       "kurl",
+					RegistryCertSecret:          "registry-pki",
+					KurlProxyCertNamespace:      "default",
+					KurlProxyCertSecret:         "kotsadm-tls",
+					ContourNamespace:            "projectcontour",
+					ContourCertSecret:           "contourcert",
+					EnvoyCertSecret:             "envoycert",
+					KotsadmKubeletCertNamespace: "default",
+					KotsadmKubeletCertSecret:    "kubelet-client-cert",
+				},
+				Log: logger.NewDiscardLogger(),
+			}
+
+			got, err := c.secretCertificates(context.Background())
+			if err != nil {
+				t.Fatalf("Controller.secretCertificates() error = %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Controller.secretCertificates() returned %d certs, want %d: %+v", len(got), len(tt.want), got)
+			}
+			for _, cert := range got {
+				want, ok := tt.want[cert.Name]
+				if !ok {
+					t.Errorf("unexpected cert %s", cert.Name)
+					continue
+				}
+				if cert.Source != certinventory.SourceSecret {
+					t.Errorf("%s source = %q, want %s", cert.Name, cert.Source, certinventory.SourceSecret)
+				}
+				if cert.Location != want.Location {
+					t.Errorf("%s location = %q, want %q", cert.Name, cert.Location, want.Location)
+				}
+				if cert.Rotate != want.Rotate {
+					t.Errorf("%s rotate = %v, want %v", cert.Name, cert.Rotate, want.Rotate)
+				}
+				if want.Error == "" && cert.Error != "" {
+					t.Errorf("%s error = %q, want none", cert.Name, cert.Error)
+				}
+				if want.Error != "" && cert.Error == "" {
+					t.Errorf("%s error = none, want %q", cert.Name, want.Error)
+				}
+				if want.Error == "" && cert.NotAfter.IsZero() {
+					t.Errorf("%s has no expiration", cert.Name)
+				}
+			}
+		})
+	}
+}
+
+func TestController_CertificateInventory_cache(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}},
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node2"}},
+	)
+	fakeHostTaskJobs(clientset, func(pod *corev1.Pod) {})
+	c := &Controller{
+		Config: types.ControllerConfig{
+			Client:               clientset,
+			RotateCertsNamespace: "kurl",
+		},
+		Log: logger.NewDiscardLogger(),
+	}
+	countJobs := func() int {
+		jobs, err := clientset.BatchV1().Jobs("kurl").List(context.Background(), metav1.ListOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(jobs.Items)
+	}
+
+	// the fake clientset returns logs without output markers so the jobs are kept
+	certs, err := c.CertificateInventory(context.Background())
+	if err != nil {
+		t.Fatalf("Controller.CertificateInventory() error = %v", err)
+	}
+	if len(certs) != 2 || certs[0].Error == "" || certs[1].Error == "" {
+		t.Errorf("Controller.CertificateInventory() = %v, want an error entry for each node", certs)
+	}
+	if got := countJobs(); got != 2 {
+		t.Errorf("got %d jobs, want 2", got)
+	}
+
+	// the cached inventory is served without running the tasks again
+	if _, err := c.CertificateInventory(context.Background()); err != nil {
+		t.Fatalf("Controller.CertificateInventory() error = %v", err)
+	}
+	if got := countJobs(); got != 2 {
+		t.Errorf("got %d jobs after cached inventory, want 2", got)
+	}
+
+	c.certInventoryCache.time = time.Now().Add(-certInventoryMaxAge)
+	if _, err := c.CertificateInventory(context.Background()); err != nil {
+		t.Fatalf("Controller.CertificateInventory() error = %v", err)
+	}
+	if got := countJobs(); got != 4 {
+		t.Errorf("got %d jobs after the cache expired, want 4", got)
+	}
+}
@@ -1,21 +1,20 @@
 package cluster
 
 import (
-	"bufio"
 	"context"
 	"fmt"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/pkg/errors"
+	"github.com/replicatedhq/ekco/pkg/certpolicy"
+	"github.com/replicatedhq/ekco/pkg/hosttask"
 	"github.com/replicatedhq/ekco/pkg/util"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/labels"
 )
 
-var errPodFailed error = errors.New("pod failed")
-
 // Any time this returns true it updates the last attempted timestamp
 func (c *Controller) CheckRotateCertsDue(ctx context.Context, reset bool) (bool, error) {
 	client := c.Config.Client.CoreV1().ConfigMaps(c.Config.RotateCertsNamespace)
@@ -60,178 +59,108 @@ func (c *Controller) CheckRotateCertsDue(ctx context.Context, reset bool) (bool,
 	return true, nil
 }
 
-// This launches a pod on each primary to mount /etc/kubernetes and rotate the certs.
-// It leaves the pods up if any fail.
+// This runs a host task on each primary to mount /etc/kubernetes and rotate the certs.
+// The jobs of failed tasks are left until their TTL.
 func (c *Controller) RotateAllCerts(ctx context.Context) error {
-	if err := c.deletePods(ctx, c.Config.RotateCertsNamespace, RotateCertsSelector); err != nil {
-		c.Log.Warnf("Failed to delete rotate pods: %v", err)
-	}
-	opts := metav1.ListOptions{
-		LabelSelector: "node-role.kubernetes.io/master=",
-	}
-	node, err := c.Config.Client.CoreV1().Nodes().List(ctx, opts)
+	nodes, err := c.listPrimaryNodes(ctx)
 	if err != nil {
-		return errors.Wrap(err, "list primary nodes")
-	}
-	if len(node.Items) == 0 {
-		opts = metav1.ListOptions{
-			LabelSelector: "node-role.kubernetes.io/control-plane=",
-		}
-		node, err = c.Config.Client.CoreV1().Nodes().List(ctx, opts)
-		if err != nil {
-			return errors.Wrap(err, "list primary nodes")
-		}
+		return err
 	}
-	for i, node := range node.Items {
+	for _, node := range nodes {
 		c.Log.Debugf("Running certificate rotation task on node %s", node.Name)
-		if i != 0 {
-			// Sine the api server on the previous node may have restarted, give load balancers a
-			// chance to detect it is healthy again before possibly restarting the api server on
-			// this node
-			time.Sleep(time.Second * 5)
+		start := time.Now()
+		task := c.rotateCertsTask(node.Name)
+		if ip := c.nodeInternalIP(node); ip != "" {
+			task.Command = append(task.Command, fmt.Sprintf("--etcd-address=%s", ip))
 		}
-		pod := c.getRotateCertsPodConfig(node.Name)
-		pod, err := c.Config.Client.CoreV1().Pods(c.Config.RotateCertsNamespace).Create(ctx, pod, metav1.CreateOptions{})
+		result, err := c.runHostTask(ctx, task)
 		if err != nil {
-			return errors.Wrapf(err, "create rotate pod for node %s", node.Name)
+			return errors.Wrapf(err, "rotate certs task for node %s", node.Name)
 		}
-		err = c.pollForPodCompleted(ctx, c.Config.RotateCertsNamespace, pod.Name)
-		if err != nil {
-			if err == errPodFailed {
-				c.logPodResults(ctx, c.Config.RotateCertsNamespace, pod.Name)
+		if result == nil {
+			// the task did not report which components it restarted
+			if err := c.waitForAPIServerReady(ctx, node); err != nil {
+				return errors.Wrapf(err, "control plane on node %s not healthy after certificate rotation", node.Name)
 			}
-			return errors.Wrapf(err, "rotate certs pod for node %s", node.Name)
+			continue
+		}
+		if len(result.RestartedComponents) == 0 {
+			continue
 		}
-		c.logPodResults(ctx, c.Config.RotateCertsNamespace, pod.Name)
-	}
 
-	if err := c.deletePods(ctx, c.Config.RotateCertsNamespace, RotateCertsSelector); err != nil {
-		c.Log.Warnf("Failed to delete rotate pods: %v", err)
+		// the next primary is not restarted until this one is serving again, so that at most one
+		// api server is out of the load balancer at a time
+		c.Log.Infof("Waiting for %s to be healthy on node %s", strings.Join(result.RestartedComponents, ", "), node.Name)
+		if err := c.waitForControlPlaneHealthy(ctx, node, result.RestartedComponents, start); err != nil {
+			return errors.Wrapf(err, "control plane on node %s not healthy after certificate rotation", node.Name)
+		}
+		// etcd is restarted one member at a time, so quorum must be restored before the next node
+		if slices.Contains(result.RestartedComponents, "etcd") {
+			if err := c.waitForEtcdHealthy(ctx, nodes); err != nil {
+				return errors.Wrapf(err, "wait for etcd after restart on node %s", node.Name)
+			}
+		}
 	}
 
 	return nil
 }
 
-func (c *Controller) deletePods(ctx context.Context, namespace string, selector labels.Selector) error {
-	options := metav1.ListOptions{
-		LabelSelector: selector.String(),
+func (c *Controller) listPrimaryNodes(ctx context.Context) ([]corev1.Node, error) {
+	opts := metav1.ListOptions{
+		LabelSelector: "node-role.kubernetes.io/master=",
 	}
-
-	return c.Config.Client.CoreV1().Pods(namespace).DeleteCollection(ctx, metav1.DeleteOptions{}, options)
+	nodes, err := c.Config.Client.CoreV1().Nodes().List(ctx, opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "list primary nodes")
+	}
+	if len(nodes.Items) == 0 {
+		opts = metav1.ListOptions{
+			LabelSelector: "node-role.kubernetes.io/control-plane=",
+		}
+		nodes, err = c.Config.Client.CoreV1().Nodes().List(ctx, opts)
+		if err != nil {
+			return nil, errors.Wrap(err, "list primary nodes")
+		}
+	}
+	return nodes.Items, nil
 }
 
-func (c *Controller) getRotateCertsPodConfig(nodeName string) *corev1.Pod {
-	return &corev1.Pod{
-		ObjectMeta: metav1.ObjectMeta{
-			GenerateName: "rotate-certs-",
-			Namespace:    c.Config.RotateCertsNamespace,
-			Labels: map[string]string{
-				RotateCertsLabel: RotateCertsValue,
-			},
-		},
-		Spec: corev1.PodSpec{
-			NodeSelector: map[string]string{
-				"kubernetes.io/hostname": nodeName,
-			},
-			Tolerations: []corev1.Toleration{
-				{
-					Key:      "node-role.kubernetes.io/control-plane",
-					Effect:   corev1.TaintEffectNoSchedule,
-					Operator: corev1.TolerationOpExists,
-				},
-				{
-					Key:      "node-role.kubernetes.io/master",
-					Effect:   corev1.TaintEffectNoSchedule,
-					Operator: corev1.TolerationOpExists,
-				},
-			},
-			Containers: []corev1.Container{
-				{
-					Name:            "rotate-certs",
-					Image:           c.Config.RotateCertsImage,
-					ImagePullPolicy: corev1.PullIfNotPresent,
-					Command: []string{
-						"ekco",
-						"rotate-certs",
-						fmt.Sprintf("--ttl=%s", c.Config.RotateCertsTTL),
-					},
-					Env: []corev1.EnvVar{
-						{
-							Name: "HOSTNAME",
-							ValueFrom: &corev1.EnvVarSource{
-								FieldRef: &corev1.ObjectFieldSelector{
-									FieldPath: "spec.nodeName",
-								},
-							},
-						},
-					},
-					VolumeMounts: []corev1.VolumeMount{
-						{
-							Name:      "etc-kubernetes",
-							MountPath: DefaultEtcKubernetesDir,
-						},
-					},
-					WorkingDir: DefaultEtcKubernetesDir,
-				},
-			},
-			RestartPolicy: corev1.RestartPolicyNever,
-			Volumes: []corev1.Volume{
-				{
-					Name: "etc-kubernetes",
-					VolumeSource: corev1.VolumeSource{
-						HostPath: &corev1.HostPathVolumeSource{
-							Path: DefaultEtcKubernetesDir,
-						},
-					},
-				},
+// rotateCertsTask returns the rotate-certs host task, which the other certificate tasks start from
+func (c *Controller) rotateCertsTask(nodeName string) HostTask {
+	return HostTask{
+		Name:      RotateCertsValue,
+		Namespace: c.Config.RotateCertsNamespace,
+		Node:      nodeName,
+		Image:     c.Config.RotateCertsImage,
+		Command:   rotateCertsCommand(c.certPolicy(certpolicy.ClassKubeadm)),
+		Mounts: []HostTaskMount{
+			{
+				Name:     "etc-kubernetes",
+				HostPath: DefaultEtcKubernetesDir,
 			},
+			hostBackupsMount(),
 		},
+		WorkingDir: DefaultEtcKubernetesDir,
 	}
 }
 
-// Polling is resilient to restarts of the K8s API server, which is expected when rotating certs
-func (c *Controller) pollForPodCompleted(ctx context.Context, namespace, name string) error {
-	ticker := time.NewTicker(time.Second * 2)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		case <-ticker.C:
-			pod, err := c.Config.Client.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
-			if err != nil {
-				c.Log.Debugf("Poll for pod completed: get pod %s: %v", name, err)
-				continue
-			}
-			if pod.Status.Phase == corev1.PodSucceeded {
-				return nil
-			}
-			if pod.Status.Phase == corev1.PodFailed {
-				return errPodFailed
-			}
-		}
-	}
+// certPolicy returns the rotation policy for the certificate class
+func (c *Controller) certPolicy(class string) certpolicy.Policy {
+	return c.Config.CertPolicies.Get(class, c.Config.RotateCertsTTL)
 }
 
-func (c *Controller) logPodResults(ctx context.Context, namespace, name string) {
-	req := c.Config.Client.CoreV1().Pods(namespace).GetLogs(name, &corev1.PodLogOptions{})
-	logs, err := req.Stream(ctx)
-	if err != nil {
-		c.Log.Warnf("Failed to get pod %s logs: %v", name, err)
-		return
+func rotateCertsCommand(policy certpolicy.Policy) []string {
+	command := []string{
+		"ekco",
+		"rotate-certs",
+		fmt.Sprintf("--ttl=%s", policy.RenewBefore),
+		fmt.Sprintf("--result-file=%s", hosttask.TerminationMessagePath),
 	}
-	defer logs.Close()
-
-	scanner := bufio.NewScanner(logs)
-	for scanner.Scan() {
-		line := strings.TrimSuffix(scanner.Text(), "\n")
-		if strings.HasPrefix(line, "Error") {
-			c.Log.Error(line)
-		} else if strings.HasPrefix(line, "Rotated") || strings.HasPrefix(line, "Restarting") {
-			c.Log.Info(line)
-		} else {
-			c.Log.Debug(line)
-		}
+	if policy.Validity != 0 {
+		command = append(command, fmt.Sprintf("--validity=%s", policy.Validity))
+	}
+	if policy.KeyAlgorithm != "" {
+		command = append(command, fmt.Sprintf("--key-algorithm=%s", policy.KeyAlgorithm))
 	}
+	return command
 }
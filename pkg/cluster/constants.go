@@ -19,11 +19,24 @@ const (
 	TaskLabel                = "kurl.sh/task"
 	UpdateInternalLBValue    = "update-internallb"
 	SetKubeconfigServerValue = "set-kubeconfig-server"
+	CheckLoadBalancerValue   = "check-load-balancer"
+	CleanOSDValue            = "clean-osd"
+	ListCertsValue           = "list-certs"
+	RotateCAValue            = "rotate-ca"
+	RotateKubeletCertsValue  = "rotate-kubelet-certs"
+	RegenCertValue           = "regen-cert"
+
+	OSDDownSinceAnnotation = "kurl.sh/osd-down-since"
 )
 
 var RotateCertsSelector = labels.SelectorFromSet(labels.Set{RotateCertsLabel: RotateCertsValue})
 var UpdateInternalLBSelector = labels.SelectorFromSet(labels.Set{TaskLabel: UpdateInternalLBValue})
 var SetKubeconfigServerSelector = labels.SelectorFromSet(labels.Set{TaskLabel: SetKubeconfigServerValue})
+var CheckLoadBalancerSelector = labels.SelectorFromSet(labels.Set{TaskLabel: CheckLoadBalancerValue})
+var CleanOSDSelector = labels.SelectorFromSet(labels.Set{TaskLabel: CleanOSDValue})
+var RotateCASelector = labels.SelectorFromSet(labels.Set{TaskLabel: RotateCAValue})
+var RotateKubeletCertsSelector = labels.SelectorFromSet(labels.Set{TaskLabel: RotateKubeletCertsValue})
+var RegenCertSelector = labels.SelectorFromSet(labels.Set{TaskLabel: RegenCertValue})
 
 var (
 	RookCephObjectStoreMetadataPools = []string{
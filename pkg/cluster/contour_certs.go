@@ -4,10 +4,13 @@ import (
 	"context"
 	"crypto/x509"
 	"fmt"
+	"math"
 	"time"
 
 	"github.com/pkg/errors"
 	"github.com/projectcontour/contour/pkg/certs"
+	"github.com/replicatedhq/ekco/pkg/certpolicy"
+	"github.com/replicatedhq/ekco/pkg/issuer"
 	"github.com/replicatedhq/ekco/pkg/util"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -43,13 +46,23 @@ func (c *Controller) RotateContourCerts(ctx context.Context) error {
 		return nil
 	}
 
-	if !c.shouldRotateContourCerts(caCert, contourCert, envoyCert) {
-		c.Log.Debugf("Contour certs have more than %s until expiration, skipping renewal", duration.ShortHumanDuration(c.Config.RotateCertsTTL))
-		return nil
-	}
+	if c.issuerConfigured(ContourIssuerKey) {
+		renewed, err := c.issueContourCerts(ctx, contourNamespace, contourSecretName, envoySecretName, caCert, contourCert, envoyCert)
+		if err != nil {
+			return errors.Wrap(err, "issue certs")
+		}
+		if !renewed {
+			return nil
+		}
+	} else {
+		if !c.shouldRotateContourCerts(caCert, contourCert, envoyCert) {
+			c.Log.Debugf("Contour certs have more than %s until expiration, skipping renewal", duration.ShortHumanDuration(c.certPolicy(certpolicy.ClassContour).RenewBefore))
+			return nil
+		}
 
-	if err := c.updateContourCerts(ctx, contourNamespace, contourSecretName, envoySecretName); err != nil {
-		return errors.Wrap(err, "update certs")
+		if err := c.updateContourCerts(ctx, contourNamespace, contourSecretName, envoySecretName); err != nil {
+			return errors.Wrap(err, "update certs")
+		}
 	}
 
 	c.Log.Infof("Renewed contour and envoy certs")
@@ -66,16 +79,71 @@ func (c *Controller) RotateContourCerts(ctx context.Context) error {
 }
 
 func (c *Controller) shouldRotateContourCerts(caCert, contourCert, envoyCert *x509.Certificate) bool {
-	caCertTTL := time.Until(caCert.NotAfter)
-	if caCertTTL <= c.Config.RotateCertsTTL {
-		return true
+	policy := c.certPolicy(certpolicy.ClassContour)
+	return policy.Due(caCert.NotAfter) || policy.Due(contourCert.NotAfter) || policy.Due(envoyCert.NotAfter)
+}
+
+// issueContourCerts renews the contour and envoy certs with the configured issuer if they are
+// expiring or were not issued by it. Contour and envoy verify each other with ca.crt, so the
+// issuer must return the CA.
+func (c *Controller) issueContourCerts(ctx context.Context, contourNamespace, contourSecretName, envoySecretName string, caCert, contourCert, envoyCert *x509.Certificate) (bool, error) {
+	iss, config, err := c.getIssuer(ctx, ContourIssuerKey, issuer.TypeClusterCA)
+	if err != nil {
+		return false, errors.Wrap(err, "get contour cert issuer")
+	}
+	checkIssued := func(name string, cert *x509.Certificate) (bool, error) {
+		issuedBy, err := c.secretIssuedBy(ctx, contourNamespace, name)
+		if err != nil {
+			return false, err
+		}
+		return iss.Issued(cert, issuedBy), nil
 	}
-	contourCertTTL := time.Until(contourCert.NotAfter)
-	if contourCertTTL <= c.Config.RotateCertsTTL {
-		return true
+	contourIssued, err := checkIssued(contourSecretName, contourCert)
+	if err != nil {
+		return false, err
 	}
-	envoyCertTTL := time.Until(envoyCert.NotAfter)
-	return envoyCertTTL <= c.Config.RotateCertsTTL
+	envoyIssued, err := checkIssued(envoySecretName, envoyCert)
+	if err != nil {
+		return false, err
+	}
+	if !c.shouldRotateContourCerts(caCert, contourCert, envoyCert) && contourIssued && envoyIssued {
+		c.Log.Debugf("Contour certs have more than %s until expiration, skipping renewal", duration.ShortHumanDuration(c.certPolicy(certpolicy.ClassContour).RenewBefore))
+		return false, nil
+	}
+
+	for _, s := range []struct {
+		name string
+		cert *x509.Certificate
+	}{
+		{contourSecretName, contourCert},
+		{envoySecretName, envoyCert},
+	} {
+		// contour's certificates have no extended key usages
+		if len(s.cert.ExtKeyUsage) == 0 {
+			s.cert.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+		}
+		issued, err := issueFromCert(ctx, iss, config, c.certPolicy(certpolicy.ClassContour), s.cert, contourNamespace, s.name)
+		if err != nil {
+			return false, err
+		}
+		if len(issued.CA) == 0 {
+			return false, fmt.Errorf("%s issuer did not return the CA of the %s cert", config.Type, s.name)
+		}
+
+		secret, err := c.Config.Client.CoreV1().Secrets(contourNamespace).Get(ctx, s.name, metav1.GetOptions{})
+		if err != nil {
+			return false, errors.Wrapf(err, "get secret %s/%s", contourNamespace, s.name)
+		}
+		secret.Data["ca.crt"] = issued.CA
+		secret.Data[corev1.TLSCertKey] = issued.Cert
+		secret.Data[corev1.TLSPrivateKeyKey] = issued.Key
+		setIssuedBy(secret, issued)
+		if _, err := c.Config.Client.CoreV1().Secrets(contourNamespace).Update(ctx, secret, metav1.UpdateOptions{}); err != nil {
+			return false, errors.Wrapf(err, "update secret %s/%s", contourNamespace, s.name)
+		}
+	}
+
+	return true, nil
 }
 
 func (c *Controller) readContourCerts(ctx context.Context, contourNamespace, contourSecretName, envoySecretName string) (*x509.Certificate, *x509.Certificate, *x509.Certificate, error) {
@@ -126,10 +194,17 @@ func (c *Controller) readContourCerts(ctx context.Context, contourNamespace, con
 	return caCert, contourCert, envoyCert, nil
 }
 
+// updateContourCerts generates a new CA and contour and envoy certs. Contour only generates RSA
+// keys, so the key algorithm of the contour policy applies only with an issuer.
 func (c *Controller) updateContourCerts(ctx context.Context, contourNamespace, contourSecretName, envoySecretName string) error {
+	var lifetime uint = certs.DefaultCertificateLifetime
+	if validity := c.certPolicy(certpolicy.ClassContour).Validity; validity != 0 {
+		// contour's lifetime is in days
+		lifetime = uint(math.Ceil(validity.Hours() / 24))
+	}
 	generatedCerts, err := certs.GenerateCerts(
 		&certs.Configuration{
-			Lifetime:  certs.DefaultCertificateLifetime,
+			Lifetime:  lifetime,
 			Namespace: contourNamespace,
 		})
 	if err != nil {
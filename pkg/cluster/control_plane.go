@@ -0,0 +1,275 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+	kubeadmconstants "k8s.io/kubernetes/cmd/kubeadm/app/constants"
+)
+
+// time allowed for the control plane on a primary to become healthy after its components are
+// restarted
+const controlPlaneReadyTimeout = 5 * time.Minute
+
+// waitForControlPlaneHealthy waits for the restarted static pods on the node to be ready with new
+// containers and, if kube-apiserver was restarted, for it to be back in the load balancer so that
+// the next primary is not restarted while this one is still out of rotation.
+func (c *Controller) waitForControlPlaneHealthy(ctx context.Context, node corev1.Node, components []string, since time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, controlPlaneReadyTimeout)
+	defer cancel()
+
+	if err := c.waitForControlPlanePodsReady(ctx, node.Name, components, since); err != nil {
+		return err
+	}
+	if !slices.Contains(components, kubeadmconstants.KubeAPIServer) {
+		return nil
+	}
+
+	return c.waitForAPIServerReady(ctx, node)
+}
+
+// waitForAPIServerReady waits for /readyz of the kube-apiserver on the node to pass. When the
+// internal load balancer is enabled it must also be up in the primaries backend of the load
+// balancer on every node. If the load balancer stats cannot be read, /readyz must instead pass for
+// as many consecutive checks as the load balancer needs to mark the backend up.
+func (c *Controller) waitForAPIServerReady(ctx context.Context, node corev1.Node) error {
+	ctx, cancel := context.WithTimeout(ctx, controlPlaneReadyTimeout)
+	defer cancel()
+
+	endpoint, err := c.apiServerEndpoint(ctx, node)
+	if err != nil {
+		return err
+	}
+	options := c.internalLBOptions()
+
+	ticker := time.NewTicker(options.HealthCheckInterval)
+	defer ticker.Stop()
+
+	consecutive := 0
+	for {
+		err := c.apiServerReadyz(ctx, endpoint)
+		if err != nil {
+			consecutive = 0
+		} else {
+			consecutive++
+			if !c.Config.EnableInternalLoadBalancer {
+				return nil
+			}
+			err = c.apiServerInLoadBalancers(ctx, endpoint)
+			if err == nil {
+				return nil
+			}
+			if errors.Is(err, errNoLoadBalancerStats) && consecutive >= options.HealthCheckRise {
+				return nil
+			}
+		}
+		c.Log.Debugf("Waiting for kube-apiserver on node %s: %v", node.Name, err)
+
+		select {
+		case <-ctx.Done():
+			return errors.Wrapf(ctx.Err(), "kube-apiserver at %s not ready: %v", endpoint, err)
+		case <-ticker.C:
+		}
+	}
+}
+
+var errNoLoadBalancerStats = errors.New("no load balancer stats")
+
+// apiServerInLoadBalancers returns nil if the kube-apiserver at endpoint is up in the primaries
+// backend of the load balancer on every node where it is listed in the stats.
+// errNoLoadBalancerStats is returned if it is not listed in the stats on any node.
+func (c *Controller) apiServerInLoadBalancers(ctx context.Context, endpoint string) error {
+	selector, _, _ := c.internalLBStatsCommand()
+	pods, err := c.Config.Client.CoreV1().Pods("kube-system").List(ctx, metav1.ListOptions{
+		LabelSelector: selector.String(),
+	})
+	if err != nil {
+		return errors.Wrap(err, "list internal loadbalancer pods")
+	}
+
+	listed := 0
+	var notUp []string
+	for _, pod := range pods.Items {
+		servers, err := c.readHAProxyStats(ctx, pod)
+		if err != nil {
+			c.Log.Debugf("Failed to read load balancer stats on node %s: %v", pod.Spec.NodeName, err)
+			continue
+		}
+		for _, server := range servers {
+			if !sameHostPort(server.Address, endpoint) {
+				continue
+			}
+			listed++
+			if !server.Healthy() {
+				notUp = append(notUp, pod.Spec.NodeName)
+			}
+			break
+		}
+	}
+	if listed == 0 {
+		return errNoLoadBalancerStats
+	}
+	if len(notUp) > 0 {
+		sort.Strings(notUp)
+		return fmt.Errorf("not up in the load balancer on nodes %s", strings.Join(notUp, ", "))
+	}
+	return nil
+}
+
+// sameHostPort returns true if a and b are the same host:port, ignoring the formatting of IPv6
+// addresses
+func sameHostPort(a, b string) bool {
+	aHost, aPort, err := net.SplitHostPort(a)
+	if err != nil {
+		return false
+	}
+	bHost, bPort, err := net.SplitHostPort(b)
+	if err != nil {
+		return false
+	}
+	if aPort != bPort {
+		return false
+	}
+	if aIP, bIP := net.ParseIP(aHost), net.ParseIP(bHost); aIP != nil && bIP != nil {
+		return aIP.Equal(bIP)
+	}
+	return aHost == bHost
+}
+
+// apiServerEndpoint returns the host:port kube-apiserver advertises on the node
+func (c *Controller) apiServerEndpoint(ctx context.Context, node corev1.Node) (string, error) {
+	pods, err := c.Config.Client.CoreV1().Pods("kube-system").List(ctx, metav1.ListOptions{LabelSelector: "component=kube-apiserver"})
+	if err != nil {
+		return "", errors.Wrap(err, "list kube-apiserver pods")
+	}
+	for _, pod := range pods.Items {
+		if pod.Spec.NodeName != node.Name {
+			continue
+		}
+		if endpoint := pod.Annotations[kubeadmconstants.KubeAPIServerAdvertiseAddressEndpointAnnotationKey]; endpoint != "" {
+			return endpoint, nil
+		}
+	}
+
+	ip := c.nodeInternalIP(node)
+	if ip == "" {
+		return "", fmt.Errorf("node %s has no internal IP", node.Name)
+	}
+	return net.JoinHostPort(ip, strconv.Itoa(kubeadmconstants.KubeAPIServerPort)), nil
+}
+
+// apiServerReadyz returns an error unless /readyz of the kube-apiserver at endpoint returns 200
+func (c *Controller) apiServerReadyz(ctx context.Context, endpoint string) error {
+	config := rest.CopyConfig(c.Config.ClientConfig)
+	config.Host = "https://" + endpoint
+	config.Timeout = 5 * time.Second
+	httpClient, err := rest.HTTPClientFor(config)
+	if err != nil {
+		return errors.Wrap(err, "create http client")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, config.Host+"/readyz", nil)
+	if err != nil {
+		return errors.Wrap(err, "create request")
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("readyz returned %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// waitForControlPlanePodsReady waits for the static pods of the restarted components on the node
+// to be ready with containers started after since
+func (c *Controller) waitForControlPlanePodsReady(ctx context.Context, nodeName string, components []string, since time.Time) error {
+	var staticPods []string
+	for _, component := range components {
+		if component != "kubelet" {
+			staticPods = append(staticPods, component)
+		}
+	}
+	if len(staticPods) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, controlPlaneReadyTimeout)
+	defer cancel()
+
+	ticker := time.NewTicker(time.Second * 2)
+	defer ticker.Stop()
+
+	for {
+		notReady, err := c.notReadyControlPlanePods(ctx, nodeName, staticPods, since)
+		if err != nil {
+			c.Log.Debugf("Wait for control plane pods on node %s: %v", nodeName, err)
+		} else if len(notReady) == 0 {
+			return nil
+		} else {
+			c.Log.Debugf("Waiting for %s on node %s", strings.Join(notReady, ", "), nodeName)
+		}
+
+		select {
+		case <-ctx.Done():
+			return errors.Wrapf(ctx.Err(), "%s not ready", strings.Join(notReady, ", "))
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *Controller) notReadyControlPlanePods(ctx context.Context, nodeName string, components []string, since time.Time) ([]string, error) {
+	pods, err := c.Config.Client.CoreV1().Pods("kube-system").List(ctx, metav1.ListOptions{LabelSelector: "tier=control-plane"})
+	if err != nil {
+		return nil, errors.Wrap(err, "list control plane pods")
+	}
+
+	var notReady []string
+	for _, component := range components {
+		ready := false
+		for _, pod := range pods.Items {
+			if pod.Spec.NodeName != nodeName || pod.Labels["component"] != component {
+				continue
+			}
+			ready = podReadySince(pod, since)
+		}
+		if !ready {
+			notReady = append(notReady, component)
+		}
+	}
+	return notReady, nil
+}
+
+func podReadySince(pod corev1.Pod, since time.Time) bool {
+	ready := false
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady && condition.Status == corev1.ConditionTrue {
+			ready = true
+		}
+	}
+	if !ready {
+		return false
+	}
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.State.Running == nil || status.State.Running.StartedAt.Time.Before(since.Truncate(time.Second)) {
+			return false
+		}
+	}
+	return true
+}
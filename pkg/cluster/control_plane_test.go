@@ -0,0 +1,177 @@
+package cluster
+
+import (
+	"context"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/replicatedhq/ekco/pkg/cluster/types"
+	"github.com/replicatedhq/ekco/pkg/internallb"
+	mock_k8s "github.com/replicatedhq/ekco/pkg/k8s/mock"
+	"github.com/replicatedhq/ekco/pkg/logger"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+)
+
+func TestController_waitForControlPlaneHealthy(t *testing.T) {
+	var readyzCalls atomic.Int32
+	var ready atomic.Bool
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/readyz" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		readyzCalls.Add(1)
+		if !ready.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte("[-]etcd failed"))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	caData := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+
+	since := time.Now()
+	node := corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}}
+	apiserver := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "kube-apiserver-node1",
+			Namespace: "kube-system",
+			Labels:    map[string]string{"component": "kube-apiserver", "tier": "control-plane"},
+			Annotations: map[string]string{
+				"kubeadm.kubernetes.io/kube-apiserver.advertise-address.endpoint": strings.TrimPrefix(server.URL, "https://"),
+			},
+		},
+		Spec: corev1.PodSpec{NodeName: "node1"},
+		Status: corev1.PodStatus{
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+			ContainerStatuses: []corev1.ContainerStatus{{
+				State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{StartedAt: metav1.NewTime(since.Add(time.Second))}},
+			}},
+		},
+	}
+
+	c := &Controller{
+		Config: types.ControllerConfig{
+			Client:                     fake.NewSimpleClientset(apiserver),
+			ClientConfig:               &rest.Config{TLSClientConfig: rest.TLSClientConfig{CAData: caData}},
+			EnableInternalLoadBalancer: true,
+		},
+		Log: logger.NewDiscardLogger(),
+	}
+
+	// readyz fails until the deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 1500*time.Millisecond)
+	defer cancel()
+	err := c.waitForControlPlaneHealthy(ctx, node, []string{"kube-apiserver"}, since)
+	require.ErrorContains(t, err, "readyz returned 500: [-]etcd failed")
+
+	// readyz must pass for as many checks as haproxy needs to mark the backend up
+	ready.Store(true)
+	readyzCalls.Store(0)
+	require.NoError(t, c.waitForControlPlaneHealthy(context.Background(), node, []string{"kube-apiserver"}, since))
+	require.EqualValues(t, internallb.HealthCheckRise, readyzCalls.Load())
+
+	// the mirror pod's container has not restarted since the certs were rotated
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err = c.waitForControlPlaneHealthy(ctx, node, []string{"kube-apiserver"}, since.Add(time.Minute))
+	require.ErrorContains(t, err, "kube-apiserver not ready")
+}
+
+func TestController_waitForAPIServerReady_loadBalancerStats(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var readyzCalls atomic.Int32
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		readyzCalls.Add(1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	caData := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	endpoint := strings.TrimPrefix(server.URL, "https://")
+
+	node := corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}}
+	apiserver := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "kube-apiserver-node1",
+			Namespace: "kube-system",
+			Labels:    map[string]string{"component": "kube-apiserver"},
+			Annotations: map[string]string{
+				"kubeadm.kubernetes.io/kube-apiserver.advertise-address.endpoint": endpoint,
+			},
+		},
+		Spec: corev1.PodSpec{NodeName: "node1"},
+	}
+	haproxyPod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "haproxy-node2",
+			Namespace: "kube-system",
+			Labels:    map[string]string{"app": "kurl-haproxy"},
+		},
+		Spec: corev1.PodSpec{NodeName: "node2"},
+	}
+	stats := func(status string) string {
+		return "# pxname,svname,scur,stot,status,chkfail,check_status,addr\n" +
+			"kubernetes-primaries,k8s-primary-0,0,0," + status + ",0,L7OK," + endpoint + "\n" +
+			"kubernetes-primaries,k8s-primary-1,0,0,UP,0,L7OK,10.0.0.2:6443\n"
+	}
+
+	// the server is down in haproxy for longer than haproxy needs to mark a healthy backend up
+	m := mock_k8s.NewMockSyncExecutorInterface(ctrl)
+	command := []interface{}{"wget", "-q", "-O", "-", internallb.StatsCSVURL}
+	gomock.InOrder(
+		m.EXPECT().ExecContainer(gomock.Any(), "kube-system", "haproxy-node2", "haproxy", command...).
+			Return(0, stats("DOWN"), "", nil).Times(internallb.HealthCheckRise+1),
+		m.EXPECT().ExecContainer(gomock.Any(), "kube-system", "haproxy-node2", "haproxy", command...).
+			Return(0, stats("UP"), "", nil),
+	)
+
+	c := &Controller{
+		Config: types.ControllerConfig{
+			Client:                     fake.NewSimpleClientset(apiserver, haproxyPod),
+			ClientConfig:               &rest.Config{TLSClientConfig: rest.TLSClientConfig{CAData: caData}},
+			EnableInternalLoadBalancer: true,
+		},
+		SyncExecutor: m,
+		Log:          logger.NewDiscardLogger(),
+	}
+
+	require.NoError(t, c.waitForAPIServerReady(context.Background(), node))
+	require.EqualValues(t, internallb.HealthCheckRise+2, readyzCalls.Load())
+}
+
+func Test_sameHostPort(t *testing.T) {
+	require.True(t, sameHostPort("10.0.0.1:6443", "10.0.0.1:6443"))
+	require.True(t, sameHostPort("[fd00::1]:6443", "[fd00:0::1]:6443"))
+	require.False(t, sameHostPort("10.0.0.1:6443", "10.0.0.1:6444"))
+	require.False(t, sameHostPort("10.0.0.1:6443", "10.0.0.2:6443"))
+	require.False(t, sameHostPort("10.0.0.1", "10.0.0.1:6443"))
+}
+
+func TestController_apiServerEndpoint(t *testing.T) {
+	node := corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+		Status: corev1.NodeStatus{
+			Addresses: []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "10.0.0.1"}},
+		},
+	}
+	c := &Controller{
+		Config: types.ControllerConfig{Client: fake.NewSimpleClientset()},
+		Log:    logger.NewDiscardLogger(),
+	}
+	endpoint, err := c.apiServerEndpoint(context.Background(), node)
+	require.NoError(t, err)
+	require.Equal(t, "10.0.0.1:6443", endpoint)
+}
@@ -7,6 +7,13 @@ import (
 	"github.com/replicatedhq/ekco/pkg/cluster/types"
 	"github.com/replicatedhq/ekco/pkg/k8s"
 	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/flowcontrol"
 )
 
 const (
@@ -22,15 +29,42 @@ type Controller struct {
 	Config       types.ControllerConfig
 	SyncExecutor k8s.SyncExecutorInterface
 	Log          *zap.SugaredLogger
+	Recorder     record.EventRecorder
+
+	rookVersionMtx   sync.Mutex
+	rookVersionCache *rookVersionCache
+
+	// nodes that failed to update the internal load balancer are retried with backoff
+	internalLBMtx     sync.Mutex
+	internalLBBackoff *flowcontrol.Backoff
+
+	// certificate inventories are cached and refreshed by one caller at a time
+	certInventoryMtx   sync.Mutex
+	certInventoryCache *certInventoryCache
+	certInventoryGroup singleflight.Group
 
 	sync.Mutex
 }
 
 func NewController(config types.ControllerConfig, log *zap.SugaredLogger) *Controller {
 	syncExecutor := k8s.NewSyncExecutor(config.Client.CoreV1(), config.ClientConfig)
+
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: config.Client.CoreV1().Events("")})
+	recorder := broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "ekco"})
+
 	return &Controller{
 		Config:       config,
 		SyncExecutor: syncExecutor,
 		Log:          log,
+		Recorder:     recorder,
+	}
+}
+
+// recordEventf records a Kubernetes event for the object if the controller has a recorder
+func (c *Controller) recordEventf(obj runtime.Object, eventType, reason, messageFmt string, args ...interface{}) {
+	if c.Recorder == nil {
+		return
 	}
+	c.Recorder.Eventf(obj, eventType, reason, messageFmt, args...)
 }
@@ -4,22 +4,26 @@ import (
 	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"fmt"
 	"net"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/pkg/errors"
 	clientv3 "go.etcd.io/etcd/client/v3"
+	corev1 "k8s.io/api/core/v1"
 	kubeadmconstants "k8s.io/kubernetes/cmd/kubeadm/app/constants"
 )
 
-func (c *Controller) removeEtcdPeer(ip string, remainingIPs []string) error {
+// removeEtcdPeer removes the etcd member with a peer URL on any of the ips of the purged node
+func (c *Controller) removeEtcdPeer(ips []string, remainingIPs []string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
 	defer cancel()
 
-	removedPeerURL := getEtcdPeerURL(ip)
 	etcdTLS, err := getEtcdTLS(ctx, c.Config.CertificatesDir)
 	if err != nil {
 		return errors.Wrap(err, "get etcd ca")
@@ -43,7 +47,7 @@ func (c *Controller) removeEtcdPeer(ip string, remainingIPs []string) error {
 	}
 	var purgedMemberID uint64
 	for _, member := range resp.Members {
-		if member.GetPeerURLs()[0] == removedPeerURL {
+		if etcdMemberHasIP(member.GetPeerURLs(), ips) {
 			purgedMemberID = member.GetID()
 		}
 	}
@@ -54,12 +58,90 @@ func (c *Controller) removeEtcdPeer(ip string, remainingIPs []string) error {
 		}
 		c.Log.Infof("Removed etcd member %d", purgedMemberID)
 	} else {
-		c.Log.Infof("Etcd cluster does not have member %s", removedPeerURL)
+		c.Log.Infof("Etcd cluster does not have a member on %s", strings.Join(ips, ", "))
 	}
 
 	return nil
 }
 
+// time allowed for every etcd member to become healthy after an etcd restart
+const etcdHealthyTimeout = 5 * time.Minute
+
+// waitForEtcdHealthy waits until every etcd member responds on the primary nodes
+func (c *Controller) waitForEtcdHealthy(ctx context.Context, nodes []corev1.Node) error {
+	etcdTLS, err := getEtcdTLS(ctx, c.Config.CertificatesDir)
+	if err != nil {
+		return errors.Wrap(err, "get etcd tls config")
+	}
+	var endpoints []string
+	for _, node := range nodes {
+		if ip := c.nodeInternalIP(node); ip != "" {
+			endpoints = append(endpoints, getEtcdClientURL(ip))
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, etcdHealthyTimeout)
+	defer cancel()
+
+	ticker := time.NewTicker(time.Second * 2)
+	defer ticker.Stop()
+
+	for {
+		err := etcdMembersHealthy(ctx, endpoints, etcdTLS)
+		if err == nil {
+			return nil
+		}
+		c.Log.Debugf("Waiting for etcd: %v", err)
+
+		select {
+		case <-ctx.Done():
+			return errors.Wrapf(ctx.Err(), "etcd not healthy: %v", err)
+		case <-ticker.C:
+		}
+	}
+}
+
+// etcdMembersHealthy returns an error unless every member of the etcd cluster responds to a status
+// request on its client URL
+func etcdMembersHealthy(ctx context.Context, endpoints []string, etcdTLS *tls.Config) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	etcdClient, err := clientv3.New(clientv3.Config{
+		Endpoints:   endpoints,
+		TLS:         etcdTLS,
+		DialTimeout: 5 * time.Second,
+	})
+	if err != nil {
+		return errors.Wrap(err, "new etcd client")
+	}
+	defer etcdClient.Close()
+
+	resp, err := etcdClient.MemberList(ctx)
+	if err != nil {
+		return errors.Wrap(err, "list etcd members")
+	}
+	var unhealthy []string
+	for _, member := range resp.Members {
+		if len(member.GetClientURLs()) == 0 {
+			unhealthy = append(unhealthy, fmt.Sprintf("%s has not started", member.GetName()))
+			continue
+		}
+		status, err := etcdClient.Status(ctx, member.GetClientURLs()[0])
+		if err != nil {
+			unhealthy = append(unhealthy, fmt.Sprintf("%s: %v", member.GetName(), err))
+			continue
+		}
+		if len(status.Errors) > 0 {
+			unhealthy = append(unhealthy, fmt.Sprintf("%s: %s", member.GetName(), strings.Join(status.Errors, ", ")))
+		}
+	}
+	if len(unhealthy) > 0 {
+		return fmt.Errorf("%d of %d etcd members unhealthy: %s", len(unhealthy), len(resp.Members), strings.Join(unhealthy, "; "))
+	}
+	return nil
+}
+
 func getEtcdTLS(ctx context.Context, pkiDir string) (*tls.Config, error) {
 	config := &tls.Config{}
 
@@ -93,8 +175,25 @@ func getEtcdTLS(ctx context.Context, pkiDir string) (*tls.Config, error) {
 	return config, nil
 }
 
-func getEtcdPeerURL(ip string) string {
-	return "https://" + net.JoinHostPort(ip, strconv.Itoa(kubeadmconstants.EtcdListenPeerPort))
+// etcdMemberHasIP returns true if the host of any of the peer URLs is one of the ips. IPs are
+// compared parsed so that differently formatted IPv6 addresses match.
+func etcdMemberHasIP(peerURLs []string, ips []string) bool {
+	for _, peerURL := range peerURLs {
+		u, err := url.Parse(peerURL)
+		if err != nil {
+			continue
+		}
+		peerIP := net.ParseIP(u.Hostname())
+		if peerIP == nil {
+			continue
+		}
+		for _, ip := range ips {
+			if peerIP.Equal(net.ParseIP(ip)) {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 func getEtcdClientURL(ip string) string {
@@ -0,0 +1,488 @@
+package cluster
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/replicatedhq/ekco/pkg/hostfile"
+	"github.com/replicatedhq/ekco/pkg/hosttask"
+	"github.com/replicatedhq/ekco/pkg/util"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	// time a host task may run before its job is failed
+	defaultHostTaskTimeout = 10 * time.Minute
+	// failed host task jobs are kept this long to read the logs of their pods. Jobs that succeed are
+	// deleted when they complete.
+	hostTaskTTL = 24 * time.Hour
+	// label the job controller sets on the pods of a job to the name of the job
+	hostTaskJobLabel = "job-name"
+)
+
+var errHostTaskFailed = errors.New("host task failed")
+
+// errHostTaskImagePull is returned for tasks whose image cannot be pulled
+var errHostTaskImagePull = errors.Wrap(errHostTaskFailed, "image pull failed")
+
+// HostTask is an operation run on a node in a job. The command writes a hosttask.Result to
+// hosttask.TerminationMessagePath.
+type HostTask struct {
+	// value of the task label of the job and its pod, also the name of the container
+	Name      string
+	Namespace string
+	Node      string
+	Image     string
+	Command   []string
+	// run in order before the command, with the same mounts
+	InitContainers []HostTaskContainer
+	Mounts         []HostTaskMount
+	WorkingDir     string
+	Privileged     bool
+	// use the network and DNS of the host
+	HostNetwork bool
+	// tolerate every taint rather than only those of primaries, to also run on workers
+	TolerateAll bool
+	// the task is failed if it has not completed in this time, defaultHostTaskTimeout if zero
+	Timeout time.Duration
+	// times a failed pod is replaced before the task fails
+	Retries int32
+}
+
+// HostTaskContainer is a container that runs before the command of a host task
+type HostTaskContainer struct {
+	Name string
+	// image of the task if empty
+	Image   string
+	Command []string
+}
+
+// HostTaskMount is a directory or file of the host, or a secret, mounted in the containers of a
+// host task
+type HostTaskMount struct {
+	Name string
+	// path on the host, mounted at the same path unless MountPath is set
+	HostPath     string
+	HostPathType corev1.HostPathType
+	// secret in the namespace of the task to mount instead of a host path
+	Secret    string
+	MountPath string
+	ReadOnly  bool
+}
+
+// runHostTask creates a job for the task and waits for it to complete. The result the task wrote
+// to its termination message is logged, recorded as events on the node and counted in metrics. If
+// the task fails the returned error includes the errors reported by the task and the job is left
+// for its TTL, otherwise the job is deleted.
+func (c *Controller) runHostTask(ctx context.Context, task HostTask) (*hosttask.Result, error) {
+	result, _, err := c.runHostTaskJob(ctx, task, false)
+	return result, err
+}
+
+// runHostTaskOutput is runHostTask for tasks that print output too long for a termination message
+// with hosttask.PrintOutput. The output is read from the logs of the pod before the job is deleted.
+func (c *Controller) runHostTaskOutput(ctx context.Context, task HostTask) (*hosttask.Result, []byte, error) {
+	return c.runHostTaskJob(ctx, task, true)
+}
+
+func (c *Controller) runHostTaskJob(ctx context.Context, task HostTask, readOutput bool) (*hosttask.Result, []byte, error) {
+	job, err := c.Config.Client.BatchV1().Jobs(task.Namespace).Create(ctx, task.job(), metav1.CreateOptions{})
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "create %s job", task.Name)
+	}
+
+	// the job controller fails the job at its deadline, this only stops waiting if it does not
+	waitCtx, cancel := context.WithTimeout(ctx, task.timeout()+time.Minute)
+	defer cancel()
+	err = c.pollForJobCompleted(waitCtx, job.Namespace, job.Name)
+	if err != nil && errors.Cause(err) != errHostTaskFailed {
+		return nil, nil, errors.Wrapf(err, "wait for job %s", job.Name)
+	}
+	failed := err != nil
+
+	result, message := c.getHostTaskResult(ctx, job.Namespace, job.Name)
+	if result != nil && result.Node == "" {
+		result.Node = task.Node
+	}
+	hosttask.RecordMetrics(task.Name, result, failed)
+	c.recordHostTaskResult(task.Node, task.Name, result, failed, message)
+	if errors.Is(err, errHostTaskImagePull) {
+		// the pod would otherwise keep pulling the image until the job's deadline
+		c.deleteHostTaskJob(ctx, job)
+	}
+
+	if failed {
+		switch {
+		case result != nil && result.Failed():
+			return result, nil, errors.Wrap(errHostTaskFailed, strings.Join(result.Errors, "; "))
+		case message != "":
+			return result, nil, errors.Wrap(errHostTaskFailed, message)
+		}
+		return result, nil, err
+	}
+
+	var output []byte
+	if readOutput {
+		output, err = c.getHostTaskOutput(ctx, job.Namespace, job.Name, task.Name)
+		if err != nil {
+			// the job is kept to read the logs of its pod
+			return result, nil, err
+		}
+	}
+
+	c.deleteHostTaskJob(ctx, job)
+	return result, output, nil
+}
+
+// runHostTasks runs the tasks with at most parallelism running at a time. If done is set it is
+// called with the result of each task that succeeds. It returns the error of each task by node.
+func (c *Controller) runHostTasks(ctx context.Context, tasks []HostTask, parallelism int, done func(context.Context, HostTask, *hosttask.Result) error) map[string]error {
+	return c.runHostTaskJobs(ctx, tasks, parallelism, false, func(ctx context.Context, task HostTask, result *hosttask.Result, _ []byte) error {
+		if done == nil {
+			return nil
+		}
+		return done(ctx, task, result)
+	})
+}
+
+// runHostTasksOutput is runHostTasks for tasks that print their output with
+// hosttask.PrintOutput. done is called with the output of each task that succeeds.
+func (c *Controller) runHostTasksOutput(ctx context.Context, tasks []HostTask, parallelism int, done func(context.Context, HostTask, []byte) error) map[string]error {
+	return c.runHostTaskJobs(ctx, tasks, parallelism, true, func(ctx context.Context, task HostTask, _ *hosttask.Result, output []byte) error {
+		return done(ctx, task, output)
+	})
+}
+
+func (c *Controller) runHostTaskJobs(ctx context.Context, tasks []HostTask, parallelism int, readOutput bool, done func(context.Context, HostTask, *hosttask.Result, []byte) error) map[string]error {
+	errs := map[string]error{}
+	var mtx sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, parallelism)
+
+	for _, task := range tasks {
+		wg.Add(1)
+		go func(task HostTask) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			result, output, err := c.runHostTaskJob(ctx, task, readOutput)
+			if err == nil {
+				err = done(ctx, task, result, output)
+			}
+			mtx.Lock()
+			errs[task.Node] = err
+			mtx.Unlock()
+		}(task)
+	}
+	wg.Wait()
+
+	return errs
+}
+
+// pollForJobCompleted waits for the job to complete. The error wraps errHostTaskFailed if the job
+// failed or its pod cannot pull an image, which would otherwise only fail the job at its deadline.
+func (c *Controller) pollForJobCompleted(ctx context.Context, namespace, name string) error {
+	ticker := time.NewTicker(time.Second * 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			job, err := c.Config.Client.BatchV1().Jobs(namespace).Get(ctx, name, metav1.GetOptions{})
+			if err != nil {
+				c.Log.Debugf("Poll for job completed: get job %s: %v", name, err)
+				continue
+			}
+			for _, condition := range job.Status.Conditions {
+				if condition.Status != corev1.ConditionTrue {
+					continue
+				}
+				switch condition.Type {
+				case batchv1.JobComplete:
+					return nil
+				case batchv1.JobFailed:
+					return errors.Wrapf(errHostTaskFailed, "%s: %s", condition.Reason, condition.Message)
+				}
+			}
+			pods, err := c.Config.Client.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
+				LabelSelector: labels.SelectorFromSet(labels.Set{hostTaskJobLabel: name}).String(),
+			})
+			if err != nil {
+				c.Log.Debugf("Poll for job completed: list pods of job %s: %v", name, err)
+				continue
+			}
+			for _, pod := range pods.Items {
+				if err := imagePullError(pod); err != nil {
+					return errors.Wrap(errHostTaskImagePull, err.Error())
+				}
+			}
+		}
+	}
+}
+
+// getHostTaskResult decodes the result from the termination message of the containers of the
+// latest pod of the job. If no container wrote a result the termination message of the first
+// container that failed is returned, which is the tail of its logs for containers with the
+// FallbackToLogsOnError policy.
+func (c *Controller) getHostTaskResult(ctx context.Context, namespace, jobName string) (*hosttask.Result, string) {
+	pod, err := c.getHostTaskPod(ctx, namespace, jobName)
+	if err != nil {
+		c.Log.Warnf("Failed to get result of job %s: %v", jobName, err)
+		return nil, ""
+	}
+
+	var message string
+	statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		terminated := status.State.Terminated
+		if terminated == nil || terminated.Message == "" {
+			continue
+		}
+		if result, err := hosttask.Decode(terminated.Message); err == nil {
+			return result, ""
+		}
+		if terminated.ExitCode != 0 && message == "" {
+			message = strings.TrimSpace(terminated.Message)
+		}
+	}
+	return nil, message
+}
+
+// getHostTaskOutput returns the output printed with hosttask.PrintOutput to the logs of the
+// container of the latest pod of the job
+func (c *Controller) getHostTaskOutput(ctx context.Context, namespace, jobName, container string) ([]byte, error) {
+	pod, err := c.getHostTaskPod(ctx, namespace, jobName)
+	if err != nil {
+		return nil, err
+	}
+	logs, err := c.Config.Client.CoreV1().Pods(namespace).GetLogs(pod.Name, &corev1.PodLogOptions{Container: container}).Stream(ctx)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get pod %s logs", pod.Name)
+	}
+	defer logs.Close()
+
+	output, err := hosttask.ReadOutput(logs)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read pod %s logs", pod.Name)
+	}
+	return output, nil
+}
+
+// getHostTaskPod returns the latest pod of the job
+func (c *Controller) getHostTaskPod(ctx context.Context, namespace, jobName string) (*corev1.Pod, error) {
+	pods, err := c.Config.Client.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(labels.Set{hostTaskJobLabel: jobName}).String(),
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "list pods of job %s", jobName)
+	}
+	if len(pods.Items) == 0 {
+		return nil, errors.Errorf("job %s has no pods", jobName)
+	}
+	pod := slices.MaxFunc(pods.Items, func(a, b corev1.Pod) int {
+		return a.CreationTimestamp.Compare(b.CreationTimestamp.Time)
+	})
+	return &pod, nil
+}
+
+func (c *Controller) deleteHostTaskJob(ctx context.Context, job *batchv1.Job) {
+	propagation := metav1.DeletePropagationBackground
+	err := c.Config.Client.BatchV1().Jobs(job.Namespace).Delete(ctx, job.Name, metav1.DeleteOptions{PropagationPolicy: &propagation})
+	if err != nil && !util.IsNotFoundErr(err) {
+		c.Log.Warnf("Failed to delete job %s: %v", job.Name, err)
+	}
+}
+
+func (c *Controller) recordHostTaskResult(nodeName, task string, result *hosttask.Result, failed bool, message string) {
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: nodeName}}
+
+	if result != nil {
+		for _, step := range result.Steps {
+			c.Log.Debugf("Task %s on node %s: %s", task, nodeName, step.Message)
+		}
+		for _, file := range result.ChangedFiles {
+			c.Log.Infof("Task %s changed %s on node %s", task, file, nodeName)
+		}
+		if len(result.ChangedFiles) > 0 {
+			c.recordEventf(node, corev1.EventTypeNormal, "HostTaskChangedFiles", "Task %s changed %s", task, strings.Join(result.ChangedFiles, ", "))
+		}
+		for _, component := range result.RestartedComponents {
+			c.Log.Infof("Task %s restarted %s on node %s", task, component, nodeName)
+			c.recordEventf(node, corev1.EventTypeNormal, "HostTaskRestartedComponent", "Task %s restarted %s", task, component)
+		}
+		for _, err := range result.Errors {
+			c.Log.Errorf("Task %s on node %s: %s", task, nodeName, err)
+		}
+	}
+
+	if failed {
+		reason := message
+		if result != nil && result.Failed() {
+			reason = strings.Join(result.Errors, "; ")
+		}
+		if reason == "" {
+			reason = "no result reported"
+		} else if result == nil {
+			c.Log.Errorf("Task %s on node %s: %s", task, nodeName, reason)
+		}
+		c.recordEventf(node, corev1.EventTypeWarning, "HostTaskFailed", "Task %s failed: %s", task, reason)
+	}
+}
+
+// hostBackupsMount is the directory host tasks keep the originals of the host files they change in
+func hostBackupsMount() HostTaskMount {
+	return HostTaskMount{
+		Name:         "ekco-backups",
+		HostPath:     hostfile.DefaultBackupDir,
+		HostPathType: corev1.HostPathDirectoryOrCreate,
+	}
+}
+
+func dbusMount() HostTaskMount {
+	return HostTaskMount{
+		Name:     "var-run-dbus",
+		HostPath: "/var/run/dbus",
+	}
+}
+
+func (t HostTask) timeout() time.Duration {
+	if t.Timeout == 0 {
+		return defaultHostTaskTimeout
+	}
+	return t.Timeout
+}
+
+func (t HostTask) job() *batchv1.Job {
+	deadline := int64(t.timeout().Seconds())
+	ttl := int32(hostTaskTTL.Seconds())
+	retries := t.Retries
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: t.Name + "-",
+			Namespace:    t.Namespace,
+			Labels: map[string]string{
+				TaskLabel: t.Name,
+			},
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:            &retries,
+			ActiveDeadlineSeconds:   &deadline,
+			TTLSecondsAfterFinished: &ttl,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{
+						TaskLabel: t.Name,
+					},
+				},
+				Spec: t.podSpec(),
+			},
+		},
+	}
+}
+
+func (t HostTask) podSpec() corev1.PodSpec {
+	spec := corev1.PodSpec{
+		NodeSelector: map[string]string{
+			"kubernetes.io/hostname": t.Node,
+		},
+		Tolerations: []corev1.Toleration{
+			{
+				Key:      "node-role.kubernetes.io/master",
+				Effect:   corev1.TaintEffectNoSchedule,
+				Operator: corev1.TolerationOpExists,
+			},
+			{
+				Key:      "node-role.kubernetes.io/control-plane",
+				Effect:   corev1.TaintEffectNoSchedule,
+				Operator: corev1.TolerationOpExists,
+			},
+		},
+		RestartPolicy: corev1.RestartPolicyNever,
+	}
+	if t.TolerateAll {
+		// workers have no control plane taints, but may have other taints
+		spec.Tolerations = append(spec.Tolerations, corev1.Toleration{
+			Operator: corev1.TolerationOpExists,
+		})
+	}
+	if t.HostNetwork {
+		// resolve names as the host does
+		spec.HostNetwork = true
+		spec.DNSPolicy = corev1.DNSDefault
+	}
+
+	var mounts []corev1.VolumeMount
+	for _, m := range t.Mounts {
+		mountPath := m.MountPath
+		if mountPath == "" {
+			mountPath = m.HostPath
+		}
+		mounts = append(mounts, corev1.VolumeMount{
+			Name:      m.Name,
+			MountPath: mountPath,
+			ReadOnly:  m.ReadOnly,
+		})
+		volume := corev1.Volume{Name: m.Name}
+		if m.Secret != "" {
+			volume.Secret = &corev1.SecretVolumeSource{SecretName: m.Secret}
+		} else {
+			volume.HostPath = &corev1.HostPathVolumeSource{Path: m.HostPath}
+			if m.HostPathType != "" {
+				hostPathType := m.HostPathType
+				volume.HostPath.Type = &hostPathType
+			}
+		}
+		spec.Volumes = append(spec.Volumes, volume)
+	}
+
+	for _, init := range t.InitContainers {
+		image := init.Image
+		if image == "" {
+			image = t.Image
+		}
+		spec.InitContainers = append(spec.InitContainers, t.container(init.Name, image, init.Command, mounts))
+	}
+	container := t.container(t.Name, t.Image, t.Command, mounts)
+	container.WorkingDir = t.WorkingDir
+	spec.Containers = []corev1.Container{container}
+
+	return spec
+}
+
+func (t HostTask) container(name, image string, command []string, mounts []corev1.VolumeMount) corev1.Container {
+	container := corev1.Container{
+		Name:            name,
+		Image:           image,
+		ImagePullPolicy: corev1.PullIfNotPresent,
+		Command:         command,
+		Env: []corev1.EnvVar{
+			{
+				Name: "HOSTNAME",
+				ValueFrom: &corev1.EnvVarSource{
+					FieldRef: &corev1.ObjectFieldSelector{
+						FieldPath: "spec.nodeName",
+					},
+				},
+			},
+		},
+		VolumeMounts:             mounts,
+		TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+	}
+	if t.Privileged {
+		privileged := true
+		container.SecurityContext = &corev1.SecurityContext{
+			Privileged: &privileged,
+		}
+	}
+	return container
+}
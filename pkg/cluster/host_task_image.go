@@ -0,0 +1,126 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DefaultHostTaskImage is used for host tasks when no image is configured and the image of the ekco
+// pod cannot be found
+const DefaultHostTaskImage = "replicated/ekco:latest"
+
+// namespace of the pod, mounted in every pod with a service account token
+const serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// container waiting reasons of images that cannot be pulled. ErrImagePull is not included as it may
+// be transient; the kubelet reports ImagePullBackOff once a pull has failed and it waits to retry.
+var imagePullFailedReasons = []string{"ImagePullBackOff", "InvalidImageName", "ErrImageNeverPull"}
+
+// SetDefaultHostTaskImages sets the host task and certificate rotation images that are not
+// configured to the image ekco runs, by digest, so that host tasks do not need an image that may not
+// be in the registry of an air-gapped cluster. DefaultHostTaskImage is used if the image of the ekco
+// pod cannot be found.
+func (c *Controller) SetDefaultHostTaskImages(ctx context.Context) {
+	if c.Config.HostTaskImage != "" && c.Config.RotateCertsImage != "" {
+		return
+	}
+
+	image, err := c.ownImage(ctx)
+	if err != nil {
+		c.Log.Warnf("Failed to find the image of the ekco pod, using %s for host tasks: %v", DefaultHostTaskImage, err)
+		image = DefaultHostTaskImage
+	} else {
+		c.Log.Debugf("Using image %s for host tasks", image)
+	}
+
+	if c.Config.HostTaskImage == "" {
+		c.Config.HostTaskImage = image
+	}
+	if c.Config.RotateCertsImage == "" {
+		c.Config.RotateCertsImage = image
+	}
+}
+
+// ownImage returns the image of the pod ekco runs in. The pod is found by the POD_NAME and
+// POD_NAMESPACE environment variables, or the hostname and the namespace of the service account.
+func (c *Controller) ownImage(ctx context.Context) (string, error) {
+	name := os.Getenv("POD_NAME")
+	if name == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return "", errors.Wrap(err, "get hostname")
+		}
+		name = hostname
+	}
+	namespace := os.Getenv("POD_NAMESPACE")
+	if namespace == "" {
+		data, err := os.ReadFile(serviceAccountNamespaceFile)
+		if err != nil {
+			return "", errors.Wrap(err, "read namespace")
+		}
+		namespace = strings.TrimSpace(string(data))
+	}
+
+	pod, err := c.Config.Client.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return "", errors.Wrapf(err, "get pod %s/%s", namespace, name)
+	}
+	return podImage(pod)
+}
+
+// podImage returns the image of the first container of the pod by digest, or the image in the
+// pod spec if the container runtime did not report the digest it pulled
+func podImage(pod *corev1.Pod) (string, error) {
+	if len(pod.Spec.Containers) == 0 {
+		return "", fmt.Errorf("pod %s has no containers", pod.Name)
+	}
+	container := pod.Spec.Containers[0]
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.Name == container.Name {
+			return imageByDigest(container.Image, status.ImageID), nil
+		}
+	}
+	return container.Image, nil
+}
+
+// imageByDigest returns the repository of image with the digest of imageID. The repository of the
+// image in the pod spec is kept as it is the name the nodes pull from. The image is returned as is
+// if imageID is not a repository digest, such as the ID of an image built on the node.
+func imageByDigest(image, imageID string) string {
+	i := strings.LastIndex(imageID, "@sha256:")
+	if i == -1 {
+		return image
+	}
+	digest := imageID[i+1:]
+
+	repository := image
+	if j := strings.Index(repository, "@"); j != -1 {
+		repository = repository[:j]
+	} else if j := strings.LastIndex(repository, ":"); j > strings.LastIndex(repository, "/") {
+		repository = repository[:j]
+	}
+	return repository + "@" + digest
+}
+
+// imagePullError returns an error if a container of the pod is waiting for an image that cannot be
+// pulled, which otherwise leaves the pod pending until its deadline
+func imagePullError(pod corev1.Pod) error {
+	statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		waiting := status.State.Waiting
+		if waiting == nil {
+			continue
+		}
+		if slices.Contains(imagePullFailedReasons, waiting.Reason) {
+			return fmt.Errorf("container %s of pod %s cannot pull image %s: %s: %s", status.Name, pod.Name, status.Image, waiting.Reason, waiting.Message)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package cluster
+
+import (
+	"context"
+	"testing"
+
+	"github.com/replicatedhq/ekco/pkg/cluster/types"
+	"github.com/replicatedhq/ekco/pkg/logger"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const testDigest = "sha256:4b2f5e1ef35d0d5c6c9a1ea1b2bd3b5c1ad0cce8f0a7d2a1e6a4e2a8f3c1b9d7"
+
+func Test_imageByDigest(t *testing.T) {
+	tests := []struct {
+		name    string
+		image   string
+		imageID string
+		want    string
+	}{
+		{
+			name:    "docker",
+			image:   "replicated/ekco:v0.28.0",
+			imageID: "docker-pullable://replicated/ekco@" + testDigest,
+			want:    "replicated/ekco@" + testDigest,
+		},
+		{
+			name:    "containerd keeps the repository of the pod spec",
+			image:   "replicated/ekco:v0.28.0",
+			imageID: "docker.io/replicated/ekco@" + testDigest,
+			want:    "replicated/ekco@" + testDigest,
+		},
+		{
+			name:    "registry with port",
+			image:   "registry.kurl.svc:5000/ekco:v0.28.0",
+			imageID: "registry.kurl.svc:5000/ekco@" + testDigest,
+			want:    "registry.kurl.svc:5000/ekco@" + testDigest,
+		},
+		{
+			name:    "registry with port without tag",
+			image:   "registry.kurl.svc:5000/ekco",
+			imageID: "registry.kurl.svc:5000/ekco@" + testDigest,
+			want:    "registry.kurl.svc:5000/ekco@" + testDigest,
+		},
+		{
+			name:    "already by digest",
+			image:   "replicated/ekco@" + testDigest,
+			imageID: "docker.io/replicated/ekco@" + testDigest,
+			want:    "replicated/ekco@" + testDigest,
+		},
+		{
+			name:    "image id without repository",
+			image:   "replicated/ekco:latest",
+			imageID: testDigest,
+			want:    "replicated/ekco:latest",
+		},
+		{
+			name:  "no image id",
+			image: "replicated/ekco:latest",
+			want:  "replicated/ekco:latest",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, imageByDigest(tt.image, tt.imageID))
+		})
+	}
+}
+
+func Test_imagePullError(t *testing.T) {
+	tests := []struct {
+		name    string
+		reason  string
+		wantErr bool
+	}{
+		{name: "back-off", reason: "ImagePullBackOff", wantErr: true},
+		{name: "invalid image name", reason: "InvalidImageName", wantErr: true},
+		{name: "never pull", reason: "ErrImageNeverPull", wantErr: true},
+		{name: "a single pull failure may be transient", reason: "ErrImagePull"},
+		{name: "creating", reason: "ContainerCreating"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "rotate-certs-abcde"},
+				Status: corev1.PodStatus{
+					InitContainerStatuses: []corev1.ContainerStatus{{
+						Name:  "config",
+						Image: "replicated/ekco:latest",
+						State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: tt.reason}},
+					}},
+				},
+			}
+			err := imagePullError(pod)
+			assert.Equal(t, tt.wantErr, err != nil, "imagePullError() = %v", err)
+		})
+	}
+}
+
+func TestController_SetDefaultHostTaskImages(t *testing.T) {
+	t.Setenv("POD_NAME", "ekc-operator-abcde")
+	t.Setenv("POD_NAMESPACE", "kurl")
+
+	clientset := fake.NewSimpleClientset(&corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "ekc-operator-abcde", Namespace: "kurl"},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "ekc-operator", Image: "replicated/ekco:v0.28.0"}},
+		},
+		Status: corev1.PodStatus{
+			ContainerStatuses: []corev1.ContainerStatus{
+				{Name: "ekc-operator", ImageID: "docker.io/replicated/ekco@" + testDigest},
+			},
+		},
+	})
+	c := &Controller{
+		Config: types.ControllerConfig{
+			Client:        clientset,
+			HostTaskImage: "registry.example.com/ekco:v0.28.0",
+		},
+		Log: logger.NewDiscardLogger(),
+	}
+
+	c.SetDefaultHostTaskImages(context.Background())
+	assert.Equal(t, "registry.example.com/ekco:v0.28.0", c.Config.HostTaskImage)
+	assert.Equal(t, "replicated/ekco@"+testDigest, c.Config.RotateCertsImage)
+
+	// the default image is used if the pod is not found
+	t.Setenv("POD_NAME", "missing")
+	c.Config.HostTaskImage = ""
+	c.SetDefaultHostTaskImages(context.Background())
+	assert.Equal(t, DefaultHostTaskImage, c.Config.HostTaskImage)
+	assert.Equal(t, "replicated/ekco@"+testDigest, c.Config.RotateCertsImage)
+}
@@ -0,0 +1,275 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/replicatedhq/ekco/pkg/cluster/types"
+	"github.com/replicatedhq/ekco/pkg/logger"
+	"github.com/stretchr/testify/require"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestController_runHostTask(t *testing.T) {
+	tests := []struct {
+		name       string
+		phase      corev1.PodPhase
+		message    string
+		exitCode   int32
+		waiting    *corev1.ContainerStateWaiting
+		wantErr    string
+		wantEvents []string
+	}{
+		{
+			name:       "succeeded with result",
+			phase:      corev1.PodSucceeded,
+			message:    `{"task":"rotate-certs","node":"node1","changedFiles":["/etc/kubernetes/pki/apiserver.crt"],"restartedComponents":["kube-apiserver"]}`,
+			wantEvents: []string{"Normal HostTaskChangedFiles", "Normal HostTaskRestartedComponent"},
+		},
+		{
+			name:       "failed with result",
+			phase:      corev1.PodFailed,
+			message:    `{"task":"rotate-certs","node":"node1","errors":["renew apiserver on host node1: permission denied"]}`,
+			exitCode:   1,
+			wantErr:    "renew apiserver on host node1: permission denied",
+			wantEvents: []string{"Warning HostTaskFailed"},
+		},
+		{
+			name:       "failed without result",
+			phase:      corev1.PodFailed,
+			exitCode:   137,
+			wantErr:    "BackoffLimitExceeded",
+			wantEvents: []string{"Warning HostTaskFailed"},
+		},
+		{
+			name:       "failed with logs",
+			phase:      corev1.PodFailed,
+			message:    "wipefs: error: /host/dev/sdb: probing initialization failed\n",
+			exitCode:   1,
+			wantErr:    "wipefs: error: /host/dev/sdb: probing initialization failed",
+			wantEvents: []string{"Warning HostTaskFailed"},
+		},
+		{
+			name:       "image pull failed",
+			phase:      corev1.PodPending,
+			waiting:    &corev1.ContainerStateWaiting{Reason: "ImagePullBackOff", Message: `Back-off pulling image "replicated/ekco:latest"`},
+			wantErr:    "cannot pull image replicated/ekco:latest: ImagePullBackOff",
+			wantEvents: []string{"Warning HostTaskFailed"},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			clientset := fake.NewSimpleClientset()
+			fakeHostTaskJobs(clientset, func(pod *corev1.Pod) {
+				state := corev1.ContainerState{
+					Terminated: &corev1.ContainerStateTerminated{
+						ExitCode: tt.exitCode,
+						Message:  tt.message,
+					},
+				}
+				if tt.waiting != nil {
+					state = corev1.ContainerState{Waiting: tt.waiting}
+				}
+				pod.Status = corev1.PodStatus{
+					Phase: tt.phase,
+					ContainerStatuses: []corev1.ContainerStatus{
+						{
+							Name:  pod.Spec.Containers[0].Name,
+							Image: pod.Spec.Containers[0].Image,
+							State: state,
+						},
+					},
+				}
+			})
+			recorder := record.NewFakeRecorder(10)
+			c := &Controller{
+				Config: types.ControllerConfig{
+					Client:               clientset,
+					RotateCertsNamespace: "kurl",
+					RotateCertsImage:     "replicated/ekco:latest",
+				},
+				Log:      logger.NewDiscardLogger(),
+				Recorder: recorder,
+			}
+
+			_, err := c.runHostTask(context.Background(), c.rotateCertsTask("node1"))
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("Controller.runHostTask() error = %v", err)
+			}
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Controller.runHostTask() error = %v, want %q", err, tt.wantErr)
+				}
+				if errors.Cause(err) != errHostTaskFailed {
+					t.Errorf("Controller.runHostTask() error cause = %v, want %v", errors.Cause(err), errHostTaskFailed)
+				}
+			}
+
+			close(recorder.Events)
+			var events []string
+			for event := range recorder.Events {
+				events = append(events, event)
+			}
+			if len(events) != len(tt.wantEvents) {
+				t.Fatalf("got events %v, want %v", events, tt.wantEvents)
+			}
+			for i, want := range tt.wantEvents {
+				if !strings.HasPrefix(events[i], want) {
+					t.Errorf("event %d = %q, want prefix %q", i, events[i], want)
+				}
+			}
+
+			// the jobs of failed tasks are kept for their TTL, unless the image cannot be pulled
+			jobs, err := clientset.BatchV1().Jobs("kurl").List(context.Background(), metav1.ListOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if wantJobs := len(tt.wantErr) > 0 && tt.waiting == nil; (len(jobs.Items) > 0) != wantJobs {
+				t.Errorf("got %d jobs, want jobs %t", len(jobs.Items), wantJobs)
+			}
+		})
+	}
+}
+
+func TestController_runHostTaskOutput(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	fakeHostTaskJobs(clientset, func(pod *corev1.Pod) {})
+	c := &Controller{
+		Config: types.ControllerConfig{
+			Client:               clientset,
+			RotateCertsNamespace: "kurl",
+		},
+		Log: logger.NewDiscardLogger(),
+	}
+
+	// the fake clientset returns logs without output markers
+	_, _, err := c.runHostTaskOutput(context.Background(), c.listCertsTask("node1"))
+	require.ErrorContains(t, err, "no output in logs")
+
+	// the job is kept to read the logs of its pod
+	jobs, err := clientset.BatchV1().Jobs("kurl").List(context.Background(), metav1.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, jobs.Items, 1)
+	require.Equal(t, ListCertsValue, jobs.Items[0].Labels[TaskLabel])
+}
+
+func TestHostTask_job(t *testing.T) {
+	task := HostTask{
+		Name:      RotateKubeletCertsValue,
+		Namespace: "kurl",
+		Node:      "node1",
+		Image:     "replicated/ekco:v1",
+		Command:   []string{"ekco", "rotate-kubelet-certs"},
+		InitContainers: []HostTaskContainer{
+			{Name: "validate", Image: "haproxy:lts-alpine", Command: []string{"haproxy", "-c"}},
+			{Name: "config", Command: []string{"ekco", "generate-haproxy-config"}},
+		},
+		Mounts: []HostTaskMount{
+			kubeletConfigMount(),
+			{Name: "etc", HostPath: "/etc", MountPath: "/host/etc"},
+			{Name: "kubelet-client", Secret: "kubelet-client-node1", MountPath: "/etc/ekco/kubelet-client", ReadOnly: true},
+		},
+		Privileged:  true,
+		HostNetwork: true,
+		TolerateAll: true,
+		Retries:     2,
+	}
+
+	job := task.job()
+	require.Equal(t, "rotate-kubelet-certs-", job.GenerateName)
+	require.Equal(t, RotateKubeletCertsValue, job.Labels[TaskLabel])
+	require.Equal(t, int32(2), *job.Spec.BackoffLimit)
+	require.Equal(t, int64(defaultHostTaskTimeout.Seconds()), *job.Spec.ActiveDeadlineSeconds)
+	require.Equal(t, int32(hostTaskTTL.Seconds()), *job.Spec.TTLSecondsAfterFinished)
+
+	spec := job.Spec.Template.Spec
+	require.Equal(t, RotateKubeletCertsValue, job.Spec.Template.Labels[TaskLabel])
+	require.Equal(t, map[string]string{"kubernetes.io/hostname": "node1"}, spec.NodeSelector)
+	require.Equal(t, corev1.RestartPolicyNever, spec.RestartPolicy)
+	require.True(t, spec.HostNetwork)
+	require.Equal(t, corev1.DNSDefault, spec.DNSPolicy)
+	require.Len(t, spec.Tolerations, 3)
+	require.Equal(t, corev1.Toleration{Operator: corev1.TolerationOpExists}, spec.Tolerations[2])
+
+	require.Len(t, spec.InitContainers, 2)
+	require.Equal(t, "haproxy:lts-alpine", spec.InitContainers[0].Image)
+	require.Equal(t, "replicated/ekco:v1", spec.InitContainers[1].Image)
+	require.Len(t, spec.Containers, 1)
+	container := spec.Containers[0]
+	require.Equal(t, RotateKubeletCertsValue, container.Name)
+	require.Equal(t, []string{"ekco", "rotate-kubelet-certs"}, container.Command)
+	require.Equal(t, corev1.TerminationMessageFallbackToLogsOnError, container.TerminationMessagePolicy)
+	require.True(t, *container.SecurityContext.Privileged)
+	require.Equal(t, "spec.nodeName", container.Env[0].ValueFrom.FieldRef.FieldPath)
+	require.Equal(t, []corev1.VolumeMount{
+		{Name: "kubelet-config", MountPath: DefaultKubeletConfigPath, ReadOnly: true},
+		{Name: "etc", MountPath: "/host/etc"},
+		{Name: "kubelet-client", MountPath: "/etc/ekco/kubelet-client", ReadOnly: true},
+	}, container.VolumeMounts)
+	require.Equal(t, container.VolumeMounts, spec.InitContainers[0].VolumeMounts)
+
+	fileType := corev1.HostPathFile
+	require.Equal(t, []corev1.Volume{
+		{Name: "kubelet-config", VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: DefaultKubeletConfigPath, Type: &fileType}}},
+		{Name: "etc", VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: "/etc"}}},
+		{Name: "kubelet-client", VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: "kubelet-client-node1"}}},
+	}, spec.Volumes)
+}
+
+// fakeHostTaskJobs completes the jobs created with the clientset at once. Each job gets one pod,
+// which status sets the status of. The job fails if the pod failed.
+func fakeHostTaskJobs(clientset *fake.Clientset, status func(pod *corev1.Pod)) {
+	var created atomic.Int32
+	clientset.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		job := action.(k8stesting.CreateAction).GetObject().(*batchv1.Job)
+		nodeName := job.Spec.Template.Spec.NodeSelector["kubernetes.io/hostname"]
+		job.Name = fmt.Sprintf("%s%s-%d", job.GenerateName, nodeName, created.Add(1))
+
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      job.Name + "-abcde",
+				Namespace: job.Namespace,
+				Labels:    map[string]string{hostTaskJobLabel: job.Name},
+			},
+			Spec: job.Spec.Template.Spec,
+		}
+		for k, v := range job.Spec.Template.Labels {
+			pod.Labels[k] = v
+		}
+		pod.Status.Phase = corev1.PodSucceeded
+		status(pod)
+		if err := clientset.Tracker().Create(corev1.SchemeGroupVersion.WithResource("pods"), pod, pod.Namespace); err != nil {
+			return true, nil, err
+		}
+
+		// the job of a pending pod has not completed
+		switch pod.Status.Phase {
+		case corev1.PodSucceeded:
+			job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}}
+		case corev1.PodFailed:
+			job.Status.Conditions = []batchv1.JobCondition{{
+				Type:    batchv1.JobFailed,
+				Status:  corev1.ConditionTrue,
+				Reason:  "BackoffLimitExceeded",
+				Message: "Job has reached the specified backoff limit",
+			}}
+		}
+		if err := clientset.Tracker().Create(batchv1.SchemeGroupVersion.WithResource("jobs"), job, job.Namespace); err != nil {
+			return true, nil, err
+		}
+		return true, job, nil
+	})
+}
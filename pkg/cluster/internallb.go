@@ -2,129 +2,260 @@ package cluster
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"sort"
 	"strings"
 	"sync"
 	"time"
 
+	multierror "github.com/hashicorp/go-multierror"
 	"github.com/pkg/errors"
+	"github.com/replicatedhq/ekco/pkg/hosttask"
+	"github.com/replicatedhq/ekco/pkg/internallb"
 	"github.com/replicatedhq/ekco/pkg/util"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/util/flowcontrol"
 )
 
+const (
+	// maximum number of nodes updated at once
+	internalLBUpdateParallelism = 5
+	// time allowed for the update task on one node
+	internalLBNodeUpdateTimeout = 5 * time.Minute
+	// a node that fails to update is retried after this delay, doubling up to the max
+	internalLBRetryInitial = 30 * time.Second
+	internalLBRetryMax     = 10 * time.Minute
+)
+
+// ReconcileInternalLB updates the internal load balancer on nodes whose config hash recorded in the
+// update-internallb configmap differs from the desired config. Nodes that failed to update are
+// retried with backoff without blocking the others.
 func (c *Controller) ReconcileInternalLB(ctx context.Context, nodes []corev1.Node) error {
-	var nodeProps []string
-	for _, node := range nodes {
-		nodeProps = append(nodeProps, fmt.Sprintf("%s %t %s", node.Name, util.NodeIsMaster(node), util.NodeInternalIP(node)))
+	return c.updateInternalLB(ctx, nodes, false)
+}
+
+// Update /etc/haproxy/haproxy.cfg and /etc/kubernetes/manifests/haproxy.yaml on all nodes, or the
+// ekco proxy backends file and manifest in ekco mode.
+func (c *Controller) UpdateInternalLB(ctx context.Context, nodes []corev1.Node) error {
+	return c.updateInternalLB(ctx, nodes, true)
+}
+
+func (c *Controller) updateInternalLB(ctx context.Context, nodes []corev1.Node, all bool) error {
+	primaryHosts := c.internalLBPrimaries(nodes)
+	if len(primaryHosts) == 0 {
+		c.Log.Warn("Skipping update of internal loadbalancer: no primary hosts found")
+		return nil
+	}
+	hash, err := c.internalLBConfigHash(primaryHosts)
+	if err != nil {
+		return errors.Wrap(err, "generate internal loadbalancer config")
 	}
-	sort.Strings(nodeProps)
-	nextInternalLB := strings.Join(nodeProps, ",")
 
-	client := c.Config.Client.CoreV1().ConfigMaps(c.Config.HostTaskNamespace)
-	cm, err := client.Get(ctx, UpdateInternalLBValue, metav1.GetOptions{})
+	cm, err := c.getInternalLBConfigMap(ctx)
 	if err != nil {
-		if !util.IsNotFoundErr(err) {
-			return errors.Wrapf(err, "get configmap %s/%s", c.Config.HostTaskNamespace, UpdateInternalLBValue)
-		}
+		return err
+	}
 
-		// create the configmap the first time
-		cm = &corev1.ConfigMap{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      UpdateInternalLBValue,
-				Namespace: c.Config.HostTaskNamespace,
-			},
-			Data: map[string]string{
-				UpdateInternalLBValue: "", // hasn't yet been successful
-			},
+	backoff := c.getInternalLBBackoff()
+	now := time.Now()
+	var stale []corev1.Node
+	for _, node := range nodes {
+		if all {
+			stale = append(stale, node)
+			continue
+		}
+		if cm.Data[node.Name] == hash {
+			continue
 		}
-		if _, err := client.Create(ctx, cm, metav1.CreateOptions{}); err != nil {
-			return errors.Wrapf(err, "create configmap %s/%s", c.Config.HostTaskNamespace, UpdateInternalLBValue)
+		if backoff.IsInBackOffSinceUpdate(node.Name, now) {
+			c.Log.Debugf("Internal loadbalancer update on node %s failed, retrying in %s", node.Name, backoff.Get(node.Name))
+			continue
 		}
+		stale = append(stale, node)
+	}
+	if len(stale) == 0 {
 		return nil
 	}
 
-	if nextInternalLB == cm.Data[UpdateInternalLBValue] {
-		return nil
+	c.Log.Infof("Running internal loadbalancer update task on %d nodes", len(stale))
+
+	var tasks []HostTask
+	for _, node := range stale {
+		tasks = append(tasks, c.updateInternalLBTask(node.Name, primaryHosts...))
 	}
+	results := c.runHostTasks(ctx, tasks, internalLBUpdateParallelism, c.reloadInternalLB)
 
-	if err := c.UpdateInternalLB(ctx, nodes); err != nil {
-		return err
+	var multiErr error
+	for _, node := range stale {
+		if err := results[node.Name]; err != nil {
+			backoff.Next(node.Name, now)
+			multiErr = multierror.Append(multiErr, errors.Wrapf(err, "update internal loadbalancer on node %s", node.Name))
+			continue
+		}
+		backoff.Reset(node.Name)
+		cm.Data[node.Name] = hash
 	}
-	cm.Data[UpdateInternalLBValue] = nextInternalLB
+	pruneInternalLBConfigMap(cm, nodes)
 
+	client := c.Config.Client.CoreV1().ConfigMaps(c.Config.HostTaskNamespace)
 	if _, err := client.Update(ctx, cm, metav1.UpdateOptions{}); err != nil {
-		return errors.Wrapf(err, "update configmap %s/%s", c.Config.HostTaskNamespace, UpdateInternalLBValue)
+		multiErr = multierror.Append(multiErr, errors.Wrapf(err, "update configmap %s/%s", c.Config.HostTaskNamespace, UpdateInternalLBValue))
 	}
-	return nil
+	if multiErr == nil {
+		c.Log.Info("Successfully completed internal loadbalancer update task")
+	}
+	return multiErr
 }
 
-// Update /etc/haproxy/haproxy.cfg and /etc/kubernetes/manifests/haproxy.yaml on all nodes.
-func (c *Controller) UpdateInternalLB(ctx context.Context, nodes []corev1.Node) error {
-	if err := c.deletePods(ctx, c.Config.HostTaskNamespace, UpdateInternalLBSelector); err != nil {
-		c.Log.Warnf("Failed to delete update internal loadbalancer pods: %v", err)
+// reloadInternalLB sends SIGHUP to haproxy after the update task succeeds on a node. The static pod
+// is recreated if its manifest changed, otherwise haproxy reloads its config. The ekco proxy reloads
+// the backends file itself when it changes.
+func (c *Controller) reloadInternalLB(ctx context.Context, task HostTask, _ *hosttask.Result) error {
+	if c.internalLBMode() == internallb.ModeEKCO {
+		return nil
 	}
-	var primaryHosts []string
+	if err := c.sighupPods(ctx, "kube-system", haproxySelector, "haproxy", task.Node); err != nil {
+		c.Log.Warnf("Failed to send SIGHUP to haproxy pod on node %s: %v", task.Node, err)
+	}
+	return nil
+}
 
+func (c *Controller) internalLBPrimaries(nodes []corev1.Node) []string {
+	var primaryHosts []string
 	for _, node := range nodes {
 		if !util.NodeIsMaster(node) {
 			continue
 		}
-		if host := util.NodeInternalIP(node); host != "" {
+		if host := c.nodeInternalIP(node); host != "" {
 			primaryHosts = append(primaryHosts, host)
 		}
 	}
+	sort.Strings(primaryHosts)
+	return primaryHosts
+}
 
-	if len(primaryHosts) == 0 {
-		c.Log.Warn("Skipping update of internal loadbalancer: no primary hosts found")
-		return nil
+func (c *Controller) internalLBMode() string {
+	if c.Config.InternalLoadBalancerMode == "" {
+		return internallb.ModeHAProxy
 	}
-	c.Log.Info("Running internal loadbalancer update task on all nodes")
+	return c.Config.InternalLoadBalancerMode
+}
 
-	for _, node := range nodes {
-		c.Log.Debugf("Running internal loadbalancer update task on node %s", node.Name)
+// internalLBOptions returns the configured options. haproxy binds to :: when the preferred family
+// is IPv6 or any of the primary hosts is IPv6, so that the load balancer is reachable over IPv6.
+func (c *Controller) internalLBOptions(primaryHosts ...string) internallb.Options {
+	options := c.Config.InternalLoadBalancer.WithDefaults()
+	ipv6 := c.Config.PreferredIPFamily == util.IPFamilyIPv6
+	for _, host := range primaryHosts {
+		if util.IPFamily(host) == util.IPFamilyIPv6 {
+			ipv6 = true
+		}
+	}
+	if ipv6 && options.BindAddress == internallb.DefaultBindAddress {
+		options.BindAddress = "::"
+	}
+	return options
+}
 
-		pod := c.getUpdateInternalLBPod(node.Name, primaryHosts...)
+// nodeInternalIP returns the InternalIP of the node in the preferred IP family
+func (c *Controller) nodeInternalIP(node corev1.Node) string {
+	return util.NodePreferredInternalIP(node, c.Config.PreferredIPFamily)
+}
 
-		pod, err := c.Config.Client.CoreV1().Pods(c.Config.HostTaskNamespace).Create(ctx, pod, metav1.CreateOptions{})
+// internalLBConfigHash returns a hash of the load balancer config and static pod manifest the update
+// task writes on each node
+func (c *Controller) internalLBConfigHash(primaryHosts []string) (string, error) {
+	options := c.internalLBOptions(primaryHosts...)
+	h := sha256.New()
+	if c.internalLBMode() == internallb.ModeEKCO {
+		h.Write(internallb.GenerateProxyBackends(options.UpstreamPort, primaryHosts...))
+		fmt.Fprintf(h, "\n%s\n%s\n%d\n%s", internallb.ModeEKCO, c.Config.HostTaskImage, internallb.DefaultFileversion, strings.Join(options.Args(), " "))
+	} else {
+		config, err := internallb.GenerateHAProxyConfig(options, primaryHosts...)
 		if err != nil {
-			return errors.Wrapf(err, "create update internal loadbalancer pod for node %s", node.Name)
+			return "", err
 		}
+		h.Write(config)
+		fmt.Fprintf(h, "\n%s\n%d", c.Config.InternalLoadBalancerHAProxyImage, internallb.DefaultFileversion)
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16], nil
+}
 
-		err = c.pollForPodCompleted(ctx, c.Config.HostTaskNamespace, pod.Name)
-		if err != nil {
-			if err == errPodFailed {
-				c.logPodResults(ctx, c.Config.HostTaskNamespace, pod.Name)
-			}
-			return errors.Wrapf(err, "update internal loadbalancer pod for node %s", node.Name)
+// getInternalLBConfigMap returns the configmap with the config hash last applied to each node,
+// creating it the first time
+func (c *Controller) getInternalLBConfigMap(ctx context.Context) (*corev1.ConfigMap, error) {
+	client := c.Config.Client.CoreV1().ConfigMaps(c.Config.HostTaskNamespace)
+	cm, err := client.Get(ctx, UpdateInternalLBValue, metav1.GetOptions{})
+	if err == nil {
+		if cm.Data == nil {
+			cm.Data = map[string]string{}
 		}
+		return cm, nil
 	}
-
-	if err := c.deletePods(ctx, c.Config.HostTaskNamespace, UpdateInternalLBSelector); err != nil {
-		c.Log.Warnf("Failed to delete internal loadbalancer update pods: %v", err)
+	if !util.IsNotFoundErr(err) {
+		return nil, errors.Wrapf(err, "get configmap %s/%s", c.Config.HostTaskNamespace, UpdateInternalLBValue)
 	}
 
-	if err := c.sighupPods(ctx, "kube-system", labels.SelectorFromSet(labels.Set{"app": "kurl-haproxy"}), "haproxy"); err != nil {
-		c.Log.Warnf("Failed to send SIGHUP to haproxy pods: %v", err)
-		return nil
+	cm = &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      UpdateInternalLBValue,
+			Namespace: c.Config.HostTaskNamespace,
+		},
+		Data: map[string]string{},
 	}
+	cm, err = client.Create(ctx, cm, metav1.CreateOptions{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "create configmap %s/%s", c.Config.HostTaskNamespace, UpdateInternalLBValue)
+	}
+	if cm.Data == nil {
+		cm.Data = map[string]string{}
+	}
+	return cm, nil
+}
 
-	c.Log.Info("Successfully completed internal loadbalancer update task on all nodes")
+// pruneInternalLBConfigMap removes the hashes of nodes that have left the cluster and the
+// cluster-wide fingerprint recorded by earlier versions
+func pruneInternalLBConfigMap(cm *corev1.ConfigMap, nodes []corev1.Node) {
+	names := map[string]bool{}
+	for _, node := range nodes {
+		names[node.Name] = true
+	}
+	for key := range cm.Data {
+		if !names[key] {
+			delete(cm.Data, key)
+		}
+	}
+}
 
-	return nil
+func (c *Controller) getInternalLBBackoff() *flowcontrol.Backoff {
+	c.internalLBMtx.Lock()
+	defer c.internalLBMtx.Unlock()
+	if c.internalLBBackoff == nil {
+		c.internalLBBackoff = flowcontrol.NewBackOff(internalLBRetryInitial, internalLBRetryMax)
+	}
+	return c.internalLBBackoff
 }
 
-func (c *Controller) sighupPods(ctx context.Context, namespace string, selector labels.Selector, container string) error {
+// sighupPods sends SIGHUP to the container in the pods matching selector, only on nodeName if set
+func (c *Controller) sighupPods(ctx context.Context, namespace string, selector labels.Selector, container, nodeName string) error {
 	options := metav1.ListOptions{
 		LabelSelector: selector.String(),
 	}
 
-	pods, err := c.Config.Client.CoreV1().Pods(namespace).List(ctx, options)
+	list, err := c.Config.Client.CoreV1().Pods(namespace).List(ctx, options)
 	if err != nil {
 		return errors.Wrap(err, "list pods")
 	}
+	var pods corev1.PodList
+	for _, pod := range list.Items {
+		if nodeName == "" || pod.Spec.NodeName == nodeName {
+			pods.Items = append(pods.Items, pod)
+		}
+	}
 	if len(pods.Items) == 0 {
 		return errors.New("found no pods")
 	}
@@ -173,84 +304,53 @@ func (c *Controller) sighupPods(ctx context.Context, namespace string, selector
 	}
 }
 
-func (c *Controller) getUpdateInternalLBPod(nodeName string, primaries ...string) *corev1.Pod {
-	namespace := c.Config.HostTaskNamespace
+func (c *Controller) updateInternalLBTask(nodeName string, primaries ...string) HostTask {
 	image := c.Config.HostTaskImage
 	haproxyImage := c.Config.InternalLoadBalancerHAProxyImage
 
 	hosts := strings.Join(primaries, ",")
+	args := strings.Join(append([]string{""}, c.internalLBOptions(primaries...).Args()...), " ")
 
-	return &corev1.Pod{
-		ObjectMeta: metav1.ObjectMeta{
-			GenerateName: "update-haproxy-",
-			Namespace:    namespace,
-			Labels: map[string]string{
-				TaskLabel: UpdateInternalLBValue,
-			},
+	// The generated haproxy config is checked with haproxy before it replaces the current config.
+	// It is written in place rather than moved to keep the inode of the file mounted in the haproxy
+	// pod. The manifest of the other mode is removed so that only one load balancer listens on the
+	// port.
+	configCmd := fmt.Sprintf("mkdir -p /host/etc/haproxy && /usr/bin/ekco generate-haproxy-config --primary-host=%s%s > /host/etc/haproxy/haproxy.cfg.new", hosts, args)
+	manifestCmd := fmt.Sprintf("/usr/bin/ekco generate-haproxy-manifest --primary-host=%s --new-config-file=/host/etc/haproxy/haproxy.cfg.new --config-file=/host/etc/haproxy/haproxy.cfg --file /host/etc/kubernetes/manifests/haproxy.yaml --host-root-dir=/host --image=%s --remove-file=/host/etc/kubernetes/manifests/ekco-internal-lb.yaml --result-file=%s%s", hosts, haproxyImage, hosttask.TerminationMessagePath, args)
+	validate := []HostTaskContainer{
+		{
+			Name:    "validate",
+			Image:   haproxyImage,
+			Command: []string{"haproxy", "-c", "-f", "/host/etc/haproxy/haproxy.cfg.new"},
 		},
-		Spec: corev1.PodSpec{
-			NodeSelector: map[string]string{
-				"kubernetes.io/hostname": nodeName,
-			},
-			Tolerations: []corev1.Toleration{
-				{
-					Key:      "node-role.kubernetes.io/master",
-					Effect:   corev1.TaintEffectNoSchedule,
-					Operator: corev1.TolerationOpExists,
-				},
-				{
-					Key:      "node-role.kubernetes.io/control-plane",
-					Effect:   corev1.TaintEffectNoSchedule,
-					Operator: corev1.TolerationOpExists,
-				},
-			},
-			InitContainers: []corev1.Container{
-				{
-					Name:            "config",
-					Image:           image,
-					ImagePullPolicy: corev1.PullIfNotPresent,
-					Command: []string{
-						"/bin/bash",
-						"-c",
-						fmt.Sprintf("mkdir -p /host/etc/haproxy && /usr/bin/ekco generate-haproxy-config --primary-host=%s > /host/etc/haproxy/haproxy.cfg", hosts),
-					},
-					VolumeMounts: []corev1.VolumeMount{
-						{
-							Name:      "etc",
-							MountPath: "/host/etc",
-						},
-					},
-				},
-			},
-			Containers: []corev1.Container{
-				{
-					Name:            "manifest",
-					Image:           image,
-					ImagePullPolicy: corev1.PullIfNotPresent,
-					Command: []string{
-						"/bin/bash",
-						"-c",
-						fmt.Sprintf("/usr/bin/ekco generate-haproxy-manifest --primary-host=%s --file /host/etc/kubernetes/manifests/haproxy.yaml --image=%s", hosts, haproxyImage),
-					},
-					VolumeMounts: []corev1.VolumeMount{
-						{
-							Name:      "etc",
-							MountPath: "/host/etc",
-						},
-					},
-				},
+	}
+	if c.internalLBMode() == internallb.ModeEKCO {
+		// the backends file is replaced with a rename so the proxy never reads a partial file
+		configCmd = fmt.Sprintf("mkdir -p /host/etc/ekco-lb && /usr/bin/ekco generate-internal-lb-backends --primary-host=%s%s > /host/etc/ekco-lb/backends.tmp && mv /host/etc/ekco-lb/backends.tmp /host/etc/ekco-lb/backends", hosts, args)
+		manifestCmd = fmt.Sprintf("/usr/bin/ekco generate-internal-lb-manifest --file /host/etc/kubernetes/manifests/ekco-internal-lb.yaml --host-root-dir=/host --image=%s --remove-file=/host/etc/kubernetes/manifests/haproxy.yaml --result-file=%s%s", image, hosttask.TerminationMessagePath, args)
+		validate = nil
+	}
+
+	return HostTask{
+		Name:      UpdateInternalLBValue,
+		Namespace: c.Config.HostTaskNamespace,
+		Node:      nodeName,
+		Image:     image,
+		Command:   []string{"/bin/bash", "-c", manifestCmd},
+		InitContainers: append([]HostTaskContainer{
+			{
+				Name:    "config",
+				Command: []string{"/bin/bash", "-c", configCmd},
 			},
-			RestartPolicy: corev1.RestartPolicyNever,
-			Volumes: []corev1.Volume{
-				{
-					Name: "etc",
-					VolumeSource: corev1.VolumeSource{
-						HostPath: &corev1.HostPathVolumeSource{
-							Path: "/etc",
-						},
-					},
-				},
+		}, validate...),
+		Mounts: []HostTaskMount{
+			{
+				Name:      "etc",
+				HostPath:  "/etc",
+				MountPath: "/host/etc",
 			},
+			hostBackupsMount(),
 		},
+		Timeout: internalLBNodeUpdateTimeout,
 	}
 }
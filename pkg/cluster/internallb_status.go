@@ -0,0 +1,112 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/replicatedhq/ekco/pkg/internallb"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// static pods of the internal load balancer in haproxy and ekco modes
+var (
+	haproxySelector = labels.SelectorFromSet(labels.Set{"app": "kurl-haproxy"})
+	ekcoLBSelector  = labels.SelectorFromSet(labels.Set{"app": "ekco-internal-lb"})
+)
+
+// time allowed to read the stats from haproxy on one node
+const internalLBStatsTimeout = 10 * time.Second
+
+// InternalLBStatus reads the state of the primaries backend from the load balancer on every node
+// and records it in the internal load balancer metrics. Nodes where the load balancer is not
+// running or has no healthy primaries are flagged.
+func (c *Controller) InternalLBStatus(ctx context.Context) ([]internallb.NodeStatus, error) {
+	nodes, err := c.Config.Client.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "list nodes")
+	}
+	selector, _, _ := c.internalLBStatsCommand()
+	pods, err := c.Config.Client.CoreV1().Pods("kube-system").List(ctx, metav1.ListOptions{
+		LabelSelector: selector.String(),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "list internal loadbalancer pods")
+	}
+	podsByNode := map[string]corev1.Pod{}
+	for _, pod := range pods.Items {
+		podsByNode[pod.Spec.NodeName] = pod
+	}
+
+	statuses := make([]internallb.NodeStatus, len(nodes.Items))
+	var wg sync.WaitGroup
+	for i, node := range nodes.Items {
+		pod, ok := podsByNode[node.Name]
+		if !ok {
+			statuses[i] = nodeStatusError(node.Name, errors.New("internal loadbalancer pod not found"))
+			continue
+		}
+		wg.Add(1)
+		go func(i int, nodeName string, pod corev1.Pod) {
+			defer wg.Done()
+			servers, err := c.readHAProxyStats(ctx, pod)
+			if err != nil {
+				statuses[i] = nodeStatusError(nodeName, err)
+				return
+			}
+			statuses[i] = internallb.NewNodeStatus(nodeName, servers)
+		}(i, node.Name, pod)
+	}
+	wg.Wait()
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Node < statuses[j].Node
+	})
+	internallb.RecordMetrics(statuses)
+
+	return statuses, nil
+}
+
+// internalLBStatsCommand returns the selector of the load balancer static pods and the container and
+// command that print the CSV stats. The ekco image has no wget, so the proxy fetches its own stats.
+func (c *Controller) internalLBStatsCommand() (labels.Selector, string, []string) {
+	if c.internalLBMode() == internallb.ModeEKCO {
+		return ekcoLBSelector, "proxy", []string{"/usr/bin/ekco", "internal-lb-stats"}
+	}
+	return haproxySelector, "haproxy", []string{"wget", "-q", "-O", "-", internallb.StatsCSVURL}
+}
+
+// readHAProxyStats fetches the CSV stats from the loopback stats endpoint inside the load balancer
+// pod
+func (c *Controller) readHAProxyStats(ctx context.Context, pod corev1.Pod) ([]internallb.ServerStatus, error) {
+	ctx, cancel := context.WithTimeout(ctx, internalLBStatsTimeout)
+	defer cancel()
+
+	_, container, cmd := c.internalLBStatsCommand()
+	exitCode, stdout, stderr, err := c.SyncExecutor.ExecContainer(ctx, pod.Namespace, pod.Name, container, cmd...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "exec pod %s", pod.Name)
+	}
+	if exitCode != 0 {
+		return nil, fmt.Errorf("read stats in pod %s: exitcode=%d, stderr=%q", pod.Name, exitCode, strings.TrimSpace(stderr))
+	}
+	servers, err := internallb.ParseStats([]byte(stdout))
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse stats from pod %s", pod.Name)
+	}
+	return servers, nil
+}
+
+func nodeStatusError(nodeName string, err error) internallb.NodeStatus {
+	return internallb.NodeStatus{
+		Node:               nodeName,
+		NoHealthyPrimaries: true,
+		Error:              err.Error(),
+	}
+}
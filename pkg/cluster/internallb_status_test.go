@@ -0,0 +1,83 @@
+package cluster
+
+import (
+	"context"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/replicatedhq/ekco/pkg/cluster/types"
+	"github.com/replicatedhq/ekco/pkg/internallb"
+	mock_k8s "github.com/replicatedhq/ekco/pkg/k8s/mock"
+	"github.com/replicatedhq/ekco/pkg/logger"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestController_InternalLBStatus(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	node := func(name string) *corev1.Node {
+		return &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	}
+	haproxyPod := func(nodeName string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "haproxy-" + nodeName,
+				Namespace: "kube-system",
+				Labels:    map[string]string{"app": "kurl-haproxy"},
+			},
+			Spec: corev1.PodSpec{NodeName: nodeName},
+		}
+	}
+	stats := `# pxname,svname,scur,stot,status,chkfail,check_status,addr
+kubernetes-primaries,k8s-primary-0,3,40,UP,0,L7OK,10.0.0.1:6443
+kubernetes-primaries,k8s-primary-1,0,10,DOWN,5,L4CON,10.0.0.2:6443
+kubernetes-primaries,BACKEND,3,50,UP,,,
+`
+	down := `# pxname,svname,scur,stot,status,chkfail,check_status,addr
+kubernetes-primaries,k8s-primary-0,0,40,DOWN,9,L7STS,10.0.0.1:6443
+kubernetes-primaries,k8s-primary-1,0,10,DOWN,5,L4CON,10.0.0.2:6443
+`
+
+	m := mock_k8s.NewMockSyncExecutorInterface(ctrl)
+	command := []interface{}{"wget", "-q", "-O", "-", internallb.StatsCSVURL}
+	m.EXPECT().ExecContainer(gomock.Any(), "kube-system", "haproxy-node-a", "haproxy", command...).Return(0, stats, "", nil)
+	m.EXPECT().ExecContainer(gomock.Any(), "kube-system", "haproxy-node-b", "haproxy", command...).Return(0, down, "", nil)
+	m.EXPECT().ExecContainer(gomock.Any(), "kube-system", "haproxy-node-c", "haproxy", command...).Return(1, "", "wget: can't connect to remote host\n", nil)
+
+	c := &Controller{
+		Config: types.ControllerConfig{
+			Client: fake.NewSimpleClientset(
+				node("node-a"), node("node-b"), node("node-c"), node("node-d"),
+				haproxyPod("node-a"), haproxyPod("node-b"), haproxyPod("node-c"),
+			),
+		},
+		SyncExecutor: m,
+		Log:          logger.NewDiscardLogger(),
+	}
+
+	statuses, err := c.InternalLBStatus(context.Background())
+	require.NoError(t, err)
+	require.Len(t, statuses, 4)
+
+	require.Equal(t, "node-a", statuses[0].Node)
+	require.Len(t, statuses[0].Servers, 2)
+	require.Equal(t, 1, statuses[0].HealthyPrimaries)
+	require.False(t, statuses[0].NoHealthyPrimaries)
+
+	require.Equal(t, "node-b", statuses[1].Node)
+	require.Equal(t, 0, statuses[1].HealthyPrimaries)
+	require.True(t, statuses[1].NoHealthyPrimaries)
+	require.Empty(t, statuses[1].Error)
+
+	require.Equal(t, "node-c", statuses[2].Node)
+	require.True(t, statuses[2].NoHealthyPrimaries)
+	require.Equal(t, `read stats in pod haproxy-node-c: exitcode=1, stderr="wget: can't connect to remote host"`, statuses[2].Error)
+
+	require.Equal(t, "node-d", statuses[3].Node)
+	require.True(t, statuses[3].NoHealthyPrimaries)
+	require.Equal(t, "internal loadbalancer pod not found", statuses[3].Error)
+}
@@ -0,0 +1,174 @@
+package cluster
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/replicatedhq/ekco/pkg/cluster/types"
+	"github.com/replicatedhq/ekco/pkg/internallb"
+	"github.com/replicatedhq/ekco/pkg/logger"
+	"github.com/replicatedhq/ekco/pkg/util"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestController_ReconcileInternalLB(t *testing.T) {
+	node := func(name, ip string, primary bool) corev1.Node {
+		n := corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{}},
+			Status: corev1.NodeStatus{
+				Addresses: []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: ip}},
+			},
+		}
+		if primary {
+			n.Labels[util.ControlPlaneRoleLabel] = ""
+		}
+		return n
+	}
+
+	// update tasks complete immediately and fail on node bad
+	var mtx sync.Mutex
+	var updated []string
+	clientset := fake.NewSimpleClientset()
+	fakeHostTaskJobs(clientset, func(pod *corev1.Pod) {
+		nodeName := pod.Spec.NodeSelector["kubernetes.io/hostname"]
+		if nodeName == "bad" {
+			pod.Status.Phase = corev1.PodFailed
+		}
+		mtx.Lock()
+		updated = append(updated, nodeName)
+		mtx.Unlock()
+	})
+
+	c := &Controller{
+		Config: types.ControllerConfig{
+			Client:            clientset,
+			HostTaskNamespace: "kurl",
+		},
+		Log: logger.NewDiscardLogger(),
+	}
+	ctx := context.Background()
+	nodes := []corev1.Node{
+		node("primary", "10.0.0.1", true),
+		node("worker", "10.0.0.2", false),
+		node("bad", "10.0.0.3", false),
+	}
+
+	// the failing node does not block the others
+	err := c.ReconcileInternalLB(ctx, nodes)
+	require.ErrorContains(t, err, "update internal loadbalancer on node bad")
+	require.ElementsMatch(t, []string{"primary", "worker", "bad"}, updated)
+
+	cm, err := clientset.CoreV1().ConfigMaps("kurl").Get(ctx, UpdateInternalLBValue, metav1.GetOptions{})
+	require.NoError(t, err)
+	hash := cm.Data["primary"]
+	require.NotEmpty(t, hash)
+	require.Equal(t, hash, cm.Data["worker"])
+	require.NotContains(t, cm.Data, "bad")
+	require.Equal(t, internalLBRetryInitial, c.getInternalLBBackoff().Get("bad"))
+
+	// up to date nodes are skipped and the failed node waits for its backoff
+	updated = nil
+	require.NoError(t, c.ReconcileInternalLB(ctx, nodes))
+	require.Empty(t, updated)
+
+	// a new primary changes the config of every node and nodes that left are pruned
+	c.getInternalLBBackoff().Reset("bad")
+	nodes = []corev1.Node{
+		node("primary", "10.0.0.1", true),
+		node("primary2", "10.0.0.4", true),
+		node("worker", "10.0.0.2", false),
+	}
+	require.NoError(t, c.ReconcileInternalLB(ctx, nodes))
+	require.ElementsMatch(t, []string{"primary", "primary2", "worker"}, updated)
+
+	cm, err = clientset.CoreV1().ConfigMaps("kurl").Get(ctx, UpdateInternalLBValue, metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Len(t, cm.Data, 3)
+	require.NotEqual(t, hash, cm.Data["primary"])
+}
+
+func TestController_updateInternalLBTask_mode(t *testing.T) {
+	c := &Controller{
+		Config: types.ControllerConfig{
+			HostTaskNamespace:                "kurl",
+			HostTaskImage:                    "replicated/ekco:v1",
+			InternalLoadBalancerHAProxyImage: "haproxy:lts-alpine",
+		},
+	}
+	primaries := []string{"10.0.0.1", "10.0.0.2"}
+
+	haproxyHash, err := c.internalLBConfigHash(primaries)
+	require.NoError(t, err)
+	pod := c.updateInternalLBTask("node1", primaries...).job().Spec.Template
+	require.Contains(t, pod.Spec.InitContainers[0].Command[2], "generate-haproxy-config --primary-host=10.0.0.1,10.0.0.2 > /host/etc/haproxy/haproxy.cfg.new")
+	require.Equal(t, "haproxy:lts-alpine", pod.Spec.InitContainers[1].Image)
+	require.Equal(t, []string{"haproxy", "-c", "-f", "/host/etc/haproxy/haproxy.cfg.new"}, pod.Spec.InitContainers[1].Command)
+	require.Contains(t, pod.Spec.Containers[0].Command[2], "--new-config-file=/host/etc/haproxy/haproxy.cfg.new --config-file=/host/etc/haproxy/haproxy.cfg ")
+	require.Contains(t, pod.Spec.Containers[0].Command[2], "generate-haproxy-manifest")
+	require.Contains(t, pod.Spec.Containers[0].Command[2], "--image=haproxy:lts-alpine")
+	require.Contains(t, pod.Spec.Containers[0].Command[2], "--remove-file=/host/etc/kubernetes/manifests/ekco-internal-lb.yaml")
+
+	c.Config.InternalLoadBalancer = internallb.Options{Port: 7444, Frontends: []internallb.Frontend{{Name: "konnectivity", Port: 8133, UpstreamPort: 8132}}}
+	optionsHash, err := c.internalLBConfigHash(primaries)
+	require.NoError(t, err)
+	require.NotEqual(t, haproxyHash, optionsHash)
+	pod = c.updateInternalLBTask("node1", primaries...).job().Spec.Template
+	require.Contains(t, pod.Spec.InitContainers[0].Command[2], "--primary-host=10.0.0.1,10.0.0.2 --port=7444 --frontend=konnectivity:8133:8132 > ")
+	require.True(t, strings.HasSuffix(pod.Spec.Containers[0].Command[2], " --port=7444 --frontend=konnectivity:8133:8132"))
+
+	c.Config.InternalLoadBalancer = internallb.Options{}
+	c.Config.InternalLoadBalancerMode = internallb.ModeEKCO
+	ekcoHash, err := c.internalLBConfigHash(primaries)
+	require.NoError(t, err)
+	require.NotEqual(t, haproxyHash, ekcoHash, "changing the mode updates every node")
+	pod = c.updateInternalLBTask("node1", primaries...).job().Spec.Template
+	require.Contains(t, pod.Spec.InitContainers[0].Command[2], "generate-internal-lb-backends --primary-host=10.0.0.1,10.0.0.2 > /host/etc/ekco-lb/backends.tmp && mv /host/etc/ekco-lb/backends.tmp /host/etc/ekco-lb/backends")
+	require.Contains(t, pod.Spec.Containers[0].Command[2], "generate-internal-lb-manifest")
+	require.Contains(t, pod.Spec.Containers[0].Command[2], "--image=replicated/ekco:v1")
+	require.Contains(t, pod.Spec.Containers[0].Command[2], "--remove-file=/host/etc/kubernetes/manifests/haproxy.yaml")
+	require.Len(t, pod.Spec.InitContainers, 1, "the backends file needs no validation")
+}
+
+func TestController_internalLB_ipv6(t *testing.T) {
+	node := func(name string, ips ...string) corev1.Node {
+		n := corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{util.ControlPlaneRoleLabel: ""}}}
+		for _, ip := range ips {
+			n.Status.Addresses = append(n.Status.Addresses, corev1.NodeAddress{Type: corev1.NodeInternalIP, Address: ip})
+		}
+		return n
+	}
+	c := &Controller{
+		Config: types.ControllerConfig{
+			HostTaskNamespace:                "kurl",
+			HostTaskImage:                    "replicated/ekco:v1",
+			InternalLoadBalancerHAProxyImage: "haproxy:lts-alpine",
+		},
+	}
+
+	// IPv6-only cluster
+	nodes := []corev1.Node{node("primary2", "fd00::2"), node("primary1", "fd00::1")}
+	primaries := c.internalLBPrimaries(nodes)
+	require.Equal(t, []string{"fd00::1", "fd00::2"}, primaries)
+	require.Equal(t, "::", c.internalLBOptions(primaries...).BindAddress)
+	_, err := c.internalLBConfigHash(primaries)
+	require.NoError(t, err)
+	pod := c.updateInternalLBTask("primary1", primaries...).job().Spec.Template
+	require.Contains(t, pod.Spec.InitContainers[0].Command[2], "--primary-host=fd00::1,fd00::2 --bind-address=:: > ")
+
+	// dual-stack nodes use the first InternalIP unless a family is preferred
+	nodes = []corev1.Node{node("primary1", "10.0.0.1", "fd00::1")}
+	require.Equal(t, []string{"10.0.0.1"}, c.internalLBPrimaries(nodes))
+	require.Equal(t, internallb.DefaultBindAddress, c.internalLBOptions("10.0.0.1").BindAddress)
+	c.Config.PreferredIPFamily = util.IPFamilyIPv6
+	require.Equal(t, []string{"fd00::1"}, c.internalLBPrimaries(nodes))
+	require.Equal(t, "::", c.internalLBOptions().BindAddress)
+
+	// a configured bind address is kept
+	c.Config.InternalLoadBalancer = internallb.Options{BindAddress: "fd00::10"}
+	require.Equal(t, "fd00::10", c.internalLBOptions("fd00::1").BindAddress)
+}
@@ -0,0 +1,182 @@
+package cluster
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/replicatedhq/ekco/pkg/certpolicy"
+	"github.com/replicatedhq/ekco/pkg/issuer"
+	"github.com/replicatedhq/ekco/pkg/util"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/keyutil"
+)
+
+// keys of ControllerConfig.CertIssuers
+const (
+	RegistryIssuerKey  = "registry"
+	KurlProxyIssuerKey = "kurl-proxy"
+	ContourIssuerKey   = "contour"
+)
+
+// secret in the rotate certs namespace that holds the ACME account key
+const acmeAccountSecret = "ekco-acme-account"
+
+// issuerConfigured returns true if an issuer has been configured for key
+func (c *Controller) issuerConfigured(key string) bool {
+	_, ok := c.Config.CertIssuers[key]
+	return ok
+}
+
+// getIssuer returns the issuer configured for key, or an issuer of the default type if none is
+// configured
+func (c *Controller) getIssuer(ctx context.Context, key, defaultType string) (issuer.Issuer, issuer.Config, error) {
+	config := c.Config.CertIssuers[key]
+	if config.Type == "" {
+		config.Type = defaultType
+	}
+
+	switch config.Type {
+	case issuer.TypeClusterCA:
+		iss, err := issuer.NewClusterCAIssuer(c.pkiDir())
+		return iss, config, err
+
+	case issuer.TypeCA:
+		parts := strings.Split(config.CASecret, "/")
+		if len(parts) != 2 {
+			return nil, config, fmt.Errorf("%s issuer ca_secret must be namespace/name, got %q", key, config.CASecret)
+		}
+		secret, err := c.Config.Client.CoreV1().Secrets(parts[0]).Get(ctx, parts[1], metav1.GetOptions{})
+		if err != nil {
+			return nil, config, errors.Wrapf(err, "get CA secret %s", config.CASecret)
+		}
+		iss, err := issuer.NewCAIssuerFromPEM(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey])
+		if err != nil {
+			return nil, config, errors.Wrapf(err, "load CA from secret %s", config.CASecret)
+		}
+		return iss, config, nil
+
+	case issuer.TypeSelfSigned:
+		return issuer.SelfSignedIssuer{}, config, nil
+
+	case issuer.TypeCertManager:
+		if c.Config.DynamicClient == nil {
+			return nil, config, errors.New("dynamic client is required for the cert-manager issuer")
+		}
+		return &issuer.CertManagerIssuer{
+			Client:        c.Config.Client,
+			DynamicClient: c.Config.DynamicClient,
+			IssuerName:    config.IssuerName,
+			IssuerKind:    config.IssuerKind,
+			IssuerGroup:   config.IssuerGroup,
+		}, config, nil
+
+	case issuer.TypeACME:
+		if config.ACMEDirectory == "" {
+			return nil, config, fmt.Errorf("%s issuer acme_directory is required", key)
+		}
+		return &issuer.ACMEIssuer{
+			DirectoryURL: config.ACMEDirectory,
+			Email:        config.ACMEEmail,
+			AccountKey:   c.getACMEAccountKey,
+			Solver:       issuer.DefaultHTTP01Solver,
+		}, config, nil
+	}
+
+	return nil, config, fmt.Errorf("unknown %s issuer type %q", key, config.Type)
+}
+
+func (c *Controller) pkiDir() string {
+	if c.Config.CertificatesDir != "" {
+		return c.Config.CertificatesDir
+	}
+	return "/etc/kubernetes/pki"
+}
+
+// getACMEAccountKey reads the ACME account key from its secret, generating it the first time
+func (c *Controller) getACMEAccountKey(ctx context.Context) (crypto.Signer, error) {
+	ns := c.Config.RotateCertsNamespace
+	secret, err := c.Config.Client.CoreV1().Secrets(ns).Get(ctx, acmeAccountSecret, metav1.GetOptions{})
+	if err == nil {
+		key, err := keyutil.ParsePrivateKeyPEM(secret.Data[corev1.TLSPrivateKeyKey])
+		if err != nil {
+			return nil, errors.Wrapf(err, "parse key in secret %s/%s", ns, acmeAccountSecret)
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("key in secret %s/%s is not a signer", ns, acmeAccountSecret)
+		}
+		return signer, nil
+	}
+	if !util.IsNotFoundErr(err) {
+		return nil, errors.Wrapf(err, "get secret %s/%s", ns, acmeAccountSecret)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, errors.Wrap(err, "generate ACME account key")
+	}
+	keyPEM, err := keyutil.MarshalPrivateKeyToPEM(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "encode ACME account key")
+	}
+	secret = &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      acmeAccountSecret,
+			Namespace: ns,
+		},
+		Data: map[string][]byte{
+			corev1.TLSPrivateKeyKey: keyPEM,
+		},
+	}
+	if _, err := c.Config.Client.CoreV1().Secrets(ns).Create(ctx, secret, metav1.CreateOptions{}); err != nil {
+		return nil, errors.Wrapf(err, "create secret %s/%s", ns, acmeAccountSecret)
+	}
+	return key, nil
+}
+
+// secretIssuedBy returns the issuer recorded on the secret a certificate is stored in
+func (c *Controller) secretIssuedBy(ctx context.Context, namespace, name string) (string, error) {
+	secret, err := c.Config.Client.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return "", errors.Wrapf(err, "get secret %s/%s", namespace, name)
+	}
+	return secret.Annotations[issuer.IssuedByAnnotation], nil
+}
+
+// setIssuedBy records the issuer of the certificate on the secret it is stored in
+func setIssuedBy(secret *corev1.Secret, issued *issuer.Certificate) {
+	if issued.IssuedBy == "" {
+		delete(secret.Annotations, issuer.IssuedByAnnotation)
+		return
+	}
+	if secret.Annotations == nil {
+		secret.Annotations = map[string]string{}
+	}
+	secret.Annotations[issuer.IssuedByAnnotation] = issued.IssuedBy
+}
+
+// issueFromCert issues a replacement for cert with the validity and key algorithm of the policy and
+// the names and duration overridden by the issuer config
+func issueFromCert(ctx context.Context, iss issuer.Issuer, config issuer.Config, policy certpolicy.Policy, cert *x509.Certificate, namespace, secretName string) (*issuer.Certificate, error) {
+	req := issuer.RequestFromCert(cert, namespace, secretName)
+	req.Duration = policy.Validity
+	// issuers fall back to their default if the algorithm of cert is not supported
+	req.KeyAlgorithm, _ = policy.Algorithm(cert)
+	req, err := config.Apply(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "apply issuer config")
+	}
+	issued, err := iss.Issue(ctx, req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "issue certificate with %s issuer", config.Type)
+	}
+	return issued, nil
+}
@@ -0,0 +1,255 @@
+package cluster
+
+import (
+	"context"
+	"crypto/x509"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/replicatedhq/ekco/pkg/cluster/types"
+	"github.com/replicatedhq/ekco/pkg/issuer"
+	"github.com/replicatedhq/ekco/pkg/logger"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	certutil "k8s.io/client-go/util/cert"
+	"k8s.io/client-go/util/keyutil"
+	kubeadmapi "k8s.io/kubernetes/cmd/kubeadm/app/apis/kubeadm"
+	"k8s.io/kubernetes/cmd/kubeadm/app/util/pkiutil"
+)
+
+func TestController_RotateKurlProxyCert_issuer(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+
+	caCert, caKey, err := pkiutil.NewCertificateAuthority(&pkiutil.CertConfig{
+		Config:              certutil.Config{CommonName: "corporate-ca"},
+		EncryptionAlgorithm: kubeadmapi.EncryptionAlgorithmECDSAP256,
+	})
+	req.NoError(err)
+	caKeyPEM, err := keyutil.MarshalPrivateKeyToPEM(caKey)
+	req.NoError(err)
+
+	certPEM, keyPEM, err := certutil.GenerateSelfSignedCertKey("kotsadm.default.svc.cluster.local", []net.IP{net.ParseIP("10.0.0.1")}, nil)
+	req.NoError(err)
+
+	client := fake.NewSimpleClientset(
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "corporate-ca", Namespace: "kurl"},
+			Data: map[string][]byte{
+				corev1.TLSCertKey:       pkiutil.EncodeCertPEM(caCert),
+				corev1.TLSPrivateKeyKey: caKeyPEM,
+			},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "kotsadm-tls", Namespace: "default"},
+			Data: map[string][]byte{
+				corev1.TLSCertKey:       certPEM,
+				corev1.TLSPrivateKeyKey: keyPEM,
+			},
+		},
+	)
+
+	c := &Controller{
+		Config: types.ControllerConfig{
+			Client:                 client,
+			KurlProxyCertNamespace: "default",
+			KurlProxyCertSecret:    "kotsadm-tls",
+			RotateCertsTTL:         30 * 24 * time.Hour,
+		},
+		Log: logger.NewDiscardLogger(),
+	}
+
+	// the self-signed cert is not expiring and no issuer is configured
+	req.NoError(c.RotateKurlProxyCert(ctx))
+	secret, err := client.CoreV1().Secrets("default").Get(ctx, "kotsadm-tls", metav1.GetOptions{})
+	req.NoError(err)
+	req.Equal(certPEM, secret.Data[corev1.TLSCertKey])
+
+	// the self-signed cert is replaced as soon as the CA issuer is configured
+	c.Config.CertIssuers = map[string]issuer.Config{
+		KurlProxyIssuerKey: {
+			Type:     issuer.TypeCA,
+			CASecret: "kurl/corporate-ca",
+			DNSNames: []string{"kotsadm.example.com"},
+		},
+	}
+	req.NoError(c.RotateKurlProxyCert(ctx))
+	secret, err = client.CoreV1().Secrets("default").Get(ctx, "kotsadm-tls", metav1.GetOptions{})
+	req.NoError(err)
+	certs, err := certutil.ParseCertsPEM(secret.Data[corev1.TLSCertKey])
+	req.NoError(err)
+	req.NoError(certs[0].CheckSignatureFrom(caCert))
+	req.Equal("kotsadm.default.svc.cluster.local", certs[0].Subject.CommonName)
+	req.Equal([]string{"kotsadm.example.com"}, certs[0].DNSNames)
+	req.True(certs[0].IPAddresses[0].Equal(net.ParseIP("10.0.0.1")))
+	issuedPEM := secret.Data[corev1.TLSCertKey]
+
+	// the issued cert is kept until it expires
+	req.NoError(c.RotateKurlProxyCert(ctx))
+	secret, err = client.CoreV1().Secrets("default").Get(ctx, "kotsadm-tls", metav1.GetOptions{})
+	req.NoError(err)
+	req.Equal(issuedPEM, secret.Data[corev1.TLSCertKey])
+}
+
+func TestController_RotateKurlProxyCert_certManager(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+
+	certPEM, keyPEM, err := certutil.GenerateSelfSignedCertKey("kotsadm.default.svc.cluster.local", nil, nil)
+	req.NoError(err)
+	client := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "kotsadm-tls", Namespace: "default"},
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       certPEM,
+			corev1.TLSPrivateKeyKey: keyPEM,
+		},
+	})
+
+	// act as cert-manager: issue from a CA, write the secret with the issuer annotations and mark
+	// the Certificate ready
+	ca := &issuer.CAIssuer{}
+	ca.Cert, ca.Key, err = pkiutil.NewCertificateAuthority(&pkiutil.CertConfig{
+		Config:              certutil.Config{CommonName: "corporate-ca"},
+		EncryptionAlgorithm: kubeadmapi.EncryptionAlgorithmECDSAP256,
+	})
+	req.NoError(err)
+	dynamicClient := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())
+	var created *unstructured.Unstructured
+	dynamicClient.PrependReactor("create", "certificates", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		created = action.(k8stesting.CreateAction).GetObject().(*unstructured.Unstructured).DeepCopy()
+		issued, err := ca.Issue(ctx, issuer.Request{
+			CommonName: "kotsadm.default.svc.cluster.local",
+			Usages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		})
+		if err != nil {
+			return true, nil, err
+		}
+		issuerRef, _, _ := unstructured.NestedStringMap(created.Object, "spec", "issuerRef")
+		_, err = client.CoreV1().Secrets("default").Create(ctx, &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      created.GetName(),
+				Namespace: "default",
+				Annotations: map[string]string{
+					"cert-manager.io/issuer-name":  issuerRef["name"],
+					"cert-manager.io/issuer-kind":  issuerRef["kind"],
+					"cert-manager.io/issuer-group": issuerRef["group"],
+				},
+			},
+			Data: map[string][]byte{
+				corev1.TLSCertKey:       issued.Cert,
+				corev1.TLSPrivateKeyKey: issued.Key,
+			},
+		}, metav1.CreateOptions{})
+		return false, nil, err
+	})
+	dynamicClient.PrependReactor("get", "certificates", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		obj := created.DeepCopy()
+		_ = unstructured.SetNestedSlice(obj.Object, []interface{}{
+			map[string]interface{}{"type": "Ready", "status": "True"},
+		}, "status", "conditions")
+		return true, obj, nil
+	})
+
+	c := &Controller{
+		Config: types.ControllerConfig{
+			Client:                 client,
+			DynamicClient:          dynamicClient,
+			KurlProxyCertNamespace: "default",
+			KurlProxyCertSecret:    "kotsadm-tls",
+			RotateCertsTTL:         30 * 24 * time.Hour,
+			CertIssuers: map[string]issuer.Config{
+				KurlProxyIssuerKey: {
+					Type:       issuer.TypeCertManager,
+					IssuerName: "corporate-ca",
+					IssuerKind: "ClusterIssuer",
+				},
+			},
+		},
+		Log: logger.NewDiscardLogger(),
+	}
+	rotate := func() *corev1.Secret {
+		req.NoError(c.RotateKurlProxyCert(ctx))
+		secret, err := client.CoreV1().Secrets("default").Get(ctx, "kotsadm-tls", metav1.GetOptions{})
+		req.NoError(err)
+		return secret
+	}
+
+	// the self-signed cert is replaced as soon as the issuer is configured
+	secret := rotate()
+	req.NotEqual(certPEM, secret.Data[corev1.TLSCertKey])
+	req.Equal("cert-manager:cert-manager.io/ClusterIssuer/corporate-ca", secret.Annotations[issuer.IssuedByAnnotation])
+	issuedPEM := secret.Data[corev1.TLSCertKey]
+
+	// the issued cert is kept until it expires
+	secret = rotate()
+	req.Equal(issuedPEM, secret.Data[corev1.TLSCertKey])
+
+	// and replaced if another issuer is configured
+	c.Config.CertIssuers[KurlProxyIssuerKey] = issuer.Config{Type: issuer.TypeCertManager, IssuerName: "letsencrypt"}
+	secret = rotate()
+	req.NotEqual(issuedPEM, secret.Data[corev1.TLSCertKey])
+	req.Equal("cert-manager:cert-manager.io/Issuer/letsencrypt", secret.Annotations[issuer.IssuedByAnnotation])
+}
+
+func TestController_getIssuer(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   map[string]issuer.Config
+		wantType string
+		wantErr  string
+	}{
+		{
+			name:     "default",
+			wantType: issuer.TypeSelfSigned,
+		},
+		{
+			name:     "cert-manager",
+			config:   map[string]issuer.Config{KurlProxyIssuerKey: {Type: issuer.TypeCertManager, IssuerName: "letsencrypt"}},
+			wantErr:  "dynamic client is required for the cert-manager issuer",
+			wantType: issuer.TypeCertManager,
+		},
+		{
+			name:     "invalid CA secret",
+			config:   map[string]issuer.Config{KurlProxyIssuerKey: {Type: issuer.TypeCA, CASecret: "corporate-ca"}},
+			wantErr:  `kurl-proxy issuer ca_secret must be namespace/name, got "corporate-ca"`,
+			wantType: issuer.TypeCA,
+		},
+		{
+			name:     "acme without directory",
+			config:   map[string]issuer.Config{KurlProxyIssuerKey: {Type: issuer.TypeACME}},
+			wantErr:  "kurl-proxy issuer acme_directory is required",
+			wantType: issuer.TypeACME,
+		},
+		{
+			name:     "unknown",
+			config:   map[string]issuer.Config{KurlProxyIssuerKey: {Type: "vault"}},
+			wantErr:  `unknown kurl-proxy issuer type "vault"`,
+			wantType: "vault",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Controller{
+				Config: types.ControllerConfig{
+					Client:      fake.NewSimpleClientset(),
+					CertIssuers: tt.config,
+				},
+				Log: logger.NewDiscardLogger(),
+			}
+			_, config, err := c.getIssuer(context.Background(), KurlProxyIssuerKey, issuer.TypeSelfSigned)
+			if tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+			} else {
+				require.NoError(t, err)
+			}
+			require.Equal(t, tt.wantType, config.Type)
+		})
+	}
+}
@@ -9,6 +9,8 @@ import (
 	"time"
 
 	"github.com/pkg/errors"
+	"github.com/replicatedhq/ekco/pkg/certpolicy"
+	"github.com/replicatedhq/ekco/pkg/issuer"
 	"github.com/replicatedhq/ekco/pkg/util"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/duration"
@@ -42,37 +44,50 @@ func (c *Controller) RotateKurlProxyCert(ctx context.Context) error {
 	}
 	cert := certs[0]
 
-	// 2. Abort if current cert is not expiring within TTL deadline
+	// 2. Abort if current cert is not due for renewal, unless an issuer is configured and did not
+	// issue the current cert
+	policy := c.certPolicy(certpolicy.ClassKurlProxy)
 	ttl := time.Until(cert.NotAfter)
-	if ttl > c.Config.RotateCertsTTL {
+	expiring := policy.Due(cert.NotAfter)
+	configured := c.issuerConfigured(KurlProxyIssuerKey)
+	if !expiring && !configured {
 		c.Log.Debugf("Kurl proxy cert has %s until expiration, skipping renewal", duration.ShortHumanDuration(ttl))
 		return nil
 	}
 
-	// 3. Abort if current cert is a custom uploaded cert
-	// If generated by kurl installer Subject will be "kotsadm.default.svc.cluster.local" and Issuer
-	// will be empty. If already rotated by ekco then Subject will be like
-	// "kotsadm.default.svc.cluster.local@1604697213" and Issuer like
-	// "kotsadm.default.svc.cluster.local-ca@1604697213"
-	if cert.Issuer.CommonName != "" && !strings.HasPrefix(cert.Issuer.CommonName, "kotsadm.default.svc.cluster.local") {
-		c.Log.Debugf("Custom cert issuer detected in kurl proxy secret tls.crt, skipping renewal")
+	// 3. Abort if current cert is a custom uploaded cert and no issuer is configured
+	if !configured && isCustomKurlProxyCert(cert) {
+		c.Log.Debugf("Custom cert detected in kurl proxy secret tls.crt, skipping renewal")
 		return nil
 	}
-	if !strings.HasPrefix(cert.Subject.CommonName, "kotsadm.default.svc.cluster.local") {
-		c.Log.Debugf("Custom cert subject detected in kurl proxy secret tls.crt, skipping renewal")
-		return nil
+
+	iss, config, err := c.getIssuer(ctx, KurlProxyIssuerKey, issuer.TypeSelfSigned)
+	if err != nil {
+		return errors.Wrap(err, "get kurl proxy cert issuer")
+	}
+	if !expiring {
+		if iss.Issued(cert, secret.Annotations[issuer.IssuedByAnnotation]) {
+			c.Log.Debugf("Kurl proxy cert has %s until expiration, skipping renewal", duration.ShortHumanDuration(ttl))
+			return nil
+		}
+		c.Log.Infof("Kurl proxy cert was not issued by the configured %s issuer, renewing", config.Type)
+	} else {
+		c.Log.Infof("Kurl proxy cert has %s until expiration, renewing", duration.ShortHumanDuration(ttl))
 	}
 
-	// 4. Generate a new self-signed cert
-	c.Log.Infof("Kurl proxy cert has %s until expiration, renewing", duration.ShortHumanDuration(ttl))
-	certData, keyData, err := certutil.GenerateSelfSignedCertKey("kotsadm.default.svc.cluster.local", cert.IPAddresses, cert.DNSNames)
+	// 4. Issue a new cert
+	if !configured {
+		cert.Subject.CommonName = "kotsadm.default.svc.cluster.local"
+	}
+	issued, err := issueFromCert(ctx, iss, config, policy, cert, ns, secretName)
 	if err != nil {
-		return errors.Wrapf(err, "generate self-signed cert")
+		return err
 	}
 
 	// 5. Update the secret
-	secret.Data["tls.crt"] = certData
-	secret.Data["tls.key"] = keyData
+	secret.Data["tls.crt"] = issued.Cert
+	secret.Data["tls.key"] = issued.Key
+	setIssuedBy(secret, issued)
 	if _, err := c.Config.Client.CoreV1().Secrets(ns).Update(ctx, secret, metav1.UpdateOptions{}); err != nil {
 		return errors.Wrapf(err, "update")
 	}
@@ -0,0 +1,212 @@
+package cluster
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"fmt"
+	"time"
+
+	multierror "github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+	"github.com/replicatedhq/ekco/pkg/certinventory"
+	"github.com/replicatedhq/ekco/pkg/certpolicy"
+	"github.com/replicatedhq/ekco/pkg/hosttask"
+	"github.com/replicatedhq/ekco/pkg/util"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/duration"
+	certutil "k8s.io/client-go/util/cert"
+	"k8s.io/client-go/util/keyutil"
+	kubeadmconstants "k8s.io/kubernetes/cmd/kubeadm/app/constants"
+	certsphase "k8s.io/kubernetes/cmd/kubeadm/app/phases/certs"
+)
+
+// mount path of the new kubelet client certificate secret in rotate kubelet certs tasks
+const kubeletClientCertMountPath = "/etc/ekco/kubelet-client"
+
+// RotateKubeletCerts renews the kubelet certificates expiring within the rotation TTL on every node
+// where the kubelet does not renew them itself. Client certificates are signed by the cluster CA
+// and installed by a pod on the node. Self-signed serving certificates are removed so that the
+// kubelet generates new ones when it restarts. Certificates are listed on all nodes in parallel and
+// rotated one node at a time.
+func (c *Controller) RotateKubeletCerts(ctx context.Context) error {
+	nodes, err := c.Config.Client.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return errors.Wrap(err, "list nodes")
+	}
+
+	hostCerts, errs := c.listHostCertificates(ctx, nodes.Items)
+
+	var multiErr error
+	for _, node := range nodes.Items {
+		if err := errs[node.Name]; err != nil {
+			multiErr = multierror.Append(multiErr, err)
+			continue
+		}
+		certs := hostCerts[node.Name]
+		rotateClient := c.kubeletCertDue(node.Name, certs, certinventory.KubeletClientName)
+		rotateServing := c.kubeletCertDue(node.Name, certs, certinventory.KubeletServingName)
+		if !rotateClient && !rotateServing {
+			continue
+		}
+		if err := c.rotateKubeletCertsOnNode(ctx, node.Name, rotateClient, rotateServing); err != nil {
+			multiErr = multierror.Append(multiErr, errors.Wrapf(err, "rotate kubelet certs on node %s", node.Name))
+		}
+	}
+
+	return multiErr
+}
+
+// kubeletCertDue returns true if the named certificate is not renewed by the kubelet and expires
+// within the rotation TTL
+func (c *Controller) kubeletCertDue(nodeName string, certs []certinventory.Certificate, name string) bool {
+	for _, cert := range certs {
+		if cert.Name != name {
+			continue
+		}
+		if cert.Error != "" {
+			c.Log.Warnf("Failed to read %s certificate on node %s: %s", name, nodeName, cert.Error)
+			return false
+		}
+		if !cert.Rotate {
+			c.Log.Debugf("Certificate %s on node %s is renewed by the kubelet", name, nodeName)
+			return false
+		}
+		ttl := time.Until(cert.NotAfter)
+		if !c.certPolicy(certpolicy.ClassKubelet).Due(cert.NotAfter) {
+			c.Log.Debugf("Certificate %s on node %s has %s until expiration, skipping renewal", name, nodeName, duration.ShortHumanDuration(ttl))
+			return false
+		}
+		return true
+	}
+	return false
+}
+
+func (c *Controller) rotateKubeletCertsOnNode(ctx context.Context, nodeName string, client, serving bool) error {
+	secretName := fmt.Sprintf("kubelet-client-%s", nodeName)
+
+	if client {
+		if err := c.createKubeletClientCertSecret(ctx, nodeName, secretName); err != nil {
+			return err
+		}
+		defer func() {
+			err := c.Config.Client.CoreV1().Secrets(c.Config.RotateCertsNamespace).Delete(context.Background(), secretName, metav1.DeleteOptions{})
+			if err != nil && !util.IsNotFoundErr(err) {
+				c.Log.Warnf("Failed to delete secret %s: %v", secretName, err)
+			}
+		}()
+	} else {
+		secretName = ""
+	}
+
+	c.Log.Infof("Rotating kubelet certificates on node %s", nodeName)
+	task := c.rotateKubeletCertsTask(nodeName, secretName, serving)
+	if _, err := c.runHostTask(ctx, task); err != nil {
+		return errors.Wrap(err, "rotate kubelet certs task")
+	}
+	return nil
+}
+
+// createKubeletClientCertSecret signs a new client certificate for the node's kubelet with the
+// cluster CA and stores it in a secret to be mounted in the rotate task
+func (c *Controller) createKubeletClientCertSecret(ctx context.Context, nodeName, secretName string) error {
+	caCert, caKey, err := certsphase.LoadCertificateAuthority(DefaultEtcKubernetesDir+"/pki", "ca")
+	if err != nil {
+		return errors.Wrap(err, "load cluster CA")
+	}
+	cert, key, err := newKubeletClientCert(nodeName, caCert, caKey, c.certPolicy(certpolicy.ClassKubelet))
+	if err != nil {
+		return err
+	}
+
+	certData, err := certutil.EncodeCertificates(cert)
+	if err != nil {
+		return errors.Wrap(err, "encode certificate")
+	}
+	keyData, err := keyutil.MarshalPrivateKeyToPEM(key)
+	if err != nil {
+		return errors.Wrap(err, "encode key")
+	}
+
+	client := c.Config.Client.CoreV1().Secrets(c.Config.RotateCertsNamespace)
+	err = client.Delete(ctx, secretName, metav1.DeleteOptions{})
+	if err != nil && !util.IsNotFoundErr(err) {
+		return errors.Wrapf(err, "delete secret %s", secretName)
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      secretName,
+			Namespace: c.Config.RotateCertsNamespace,
+			Labels: map[string]string{
+				TaskLabel: RotateKubeletCertsValue,
+			},
+		},
+		Type: corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       certData,
+			corev1.TLSPrivateKeyKey: keyData,
+		},
+	}
+	if _, err := client.Create(ctx, secret, metav1.CreateOptions{}); err != nil {
+		return errors.Wrapf(err, "create secret %s", secretName)
+	}
+	return nil
+}
+
+// newKubeletClientCert returns a certificate with the identity the node authorizer expects for the
+// node's kubelet, with the validity and key algorithm of the policy. The key algorithm defaults to
+// that of the cluster CA.
+func newKubeletClientCert(nodeName string, caCert *x509.Certificate, caKey crypto.Signer, policy certpolicy.Policy) (*x509.Certificate, crypto.Signer, error) {
+	algorithm, err := policy.Algorithm(caCert)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "get encryption algorithm of cluster CA")
+	}
+	notBefore := time.Now().UTC()
+	notAfter := notBefore.Add(policy.ValidityOr(kubeadmconstants.CertificateValidityPeriod))
+	config := &certConfig{
+		Config: certutil.Config{
+			CommonName:   fmt.Sprintf("system:node:%s", nodeName),
+			Organization: []string{"system:nodes"},
+			Usages:       []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		},
+		NotBefore:          &notBefore,
+		NotAfter:           &notAfter,
+		PublicKeyAlgorithm: algorithm,
+	}
+	cert, key, err := generateNewCertAndKey(caCert, caKey, config)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "generate kubelet client certificate")
+	}
+	return cert, key, nil
+}
+
+func (c *Controller) rotateKubeletCertsTask(nodeName, clientSecretName string, serving bool) HostTask {
+	task := c.rotateCertsTask(nodeName)
+	task.Name = RotateKubeletCertsValue
+	task.Command = []string{
+		"ekco",
+		"rotate-kubelet-certs",
+		fmt.Sprintf("--serving=%t", serving),
+		fmt.Sprintf("--result-file=%s", hosttask.TerminationMessagePath),
+	}
+	task.Privileged = true
+	task.TolerateAll = true
+	task.WorkingDir = ""
+	task.Mounts = []HostTaskMount{
+		kubeletPKIMount(false),
+		kubeletConfigMount(),
+		dbusMount(),
+		hostBackupsMount(),
+	}
+	if clientSecretName != "" {
+		task.Command = append(task.Command, fmt.Sprintf("--client-cert-dir=%s", kubeletClientCertMountPath))
+		task.Mounts = append(task.Mounts, HostTaskMount{
+			Name:      "kubelet-client",
+			Secret:    clientSecretName,
+			MountPath: kubeletClientCertMountPath,
+			ReadOnly:  true,
+		})
+	}
+	return task
+}
@@ -0,0 +1,70 @@
+package cluster
+
+import (
+	"testing"
+	"time"
+
+	"github.com/replicatedhq/ekco/pkg/certinventory"
+	"github.com/replicatedhq/ekco/pkg/certpolicy"
+	"github.com/replicatedhq/ekco/pkg/cluster/types"
+	"github.com/replicatedhq/ekco/pkg/logger"
+)
+
+func TestController_kubeletCertDue(t *testing.T) {
+	tests := []struct {
+		name     string
+		cert     certinventory.Certificate
+		policies certpolicy.Policies
+		want     bool
+	}{
+		{
+			name: "expiring",
+			cert: certinventory.Certificate{Name: certinventory.KubeletClientName, NotAfter: time.Now().Add(time.Hour), Rotate: true},
+			want: true,
+		},
+		{
+			name: "not expiring",
+			cert: certinventory.Certificate{Name: certinventory.KubeletClientName, NotAfter: time.Now().Add(365 * 24 * time.Hour), Rotate: true},
+			want: false,
+		},
+		{
+			name:     "within kubelet policy renew before",
+			cert:     certinventory.Certificate{Name: certinventory.KubeletClientName, NotAfter: time.Now().Add(60 * 24 * time.Hour), Rotate: true},
+			policies: certpolicy.Policies{certpolicy.ClassKubelet: {RenewBefore: 90 * 24 * time.Hour}},
+			want:     true,
+		},
+		{
+			name:     "other class policy",
+			cert:     certinventory.Certificate{Name: certinventory.KubeletClientName, NotAfter: time.Now().Add(60 * 24 * time.Hour), Rotate: true},
+			policies: certpolicy.Policies{certpolicy.ClassKubeadm: {RenewBefore: 90 * 24 * time.Hour}},
+			want:     false,
+		},
+		{
+			name: "renewed by kubelet",
+			cert: certinventory.Certificate{Name: certinventory.KubeletClientName, NotAfter: time.Now().Add(time.Hour)},
+			want: false,
+		},
+		{
+			name: "unreadable",
+			cert: certinventory.Certificate{Name: certinventory.KubeletClientName, Rotate: true, Error: "no such file"},
+			want: false,
+		},
+		{
+			name: "other certificate",
+			cert: certinventory.Certificate{Name: certinventory.KubeletServingName, NotAfter: time.Now().Add(time.Hour), Rotate: true},
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Controller{
+				Config: types.ControllerConfig{RotateCertsTTL: 30 * 24 * time.Hour, CertPolicies: tt.policies},
+				Log:    logger.NewDiscardLogger(),
+			}
+			got := c.kubeletCertDue("node1", []certinventory.Certificate{tt.cert}, certinventory.KubeletClientName)
+			if got != tt.want {
+				t.Errorf("kubeletCertDue() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
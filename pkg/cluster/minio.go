@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"math"
+	"net"
 	"net/http"
 	"strconv"
 	"time"
@@ -96,7 +97,7 @@ func (c *Controller) MigrateMinioData(ctx context.Context, utilImage string, ns
 
 	c.Log.Infof("Waiting for MinIO data to be migrated")
 
-	err = objectstore.SyncAllBuckets(ctx, fmt.Sprintf("%s:9000", podIP), minioAccessKey, minioSecretKey, fmt.Sprintf("ha-minio.%s.svc.cluster.local", ns), minioAccessKey, minioSecretKey, c.Log.Infof)
+	err = objectstore.SyncAllBuckets(ctx, net.JoinHostPort(podIP, "9000"), minioAccessKey, minioSecretKey, fmt.Sprintf("ha-minio.%s.svc.cluster.local", ns), minioAccessKey, minioSecretKey, c.Log.Infof)
 	if err != nil {
 		return fmt.Errorf("sync minio data: %w", err)
 	}
@@ -315,7 +316,7 @@ func (c *Controller) haMinioHealthy(ns string) bool {
 // to check if the cluster would stay healthy when doing this.
 // http://individual-server-address:9000/minio/health/cluster?maintenance=true (200 is ok, 412 is not)
 func (c *Controller) haMinioPodSafeToReschedule(pod corev1.Pod) bool {
-	resp, err := http.Get(fmt.Sprintf("http://%s:9000/minio/health/cluster?maintenance=true", pod.Status.PodIP))
+	resp, err := http.Get(fmt.Sprintf("http://%s/minio/health/cluster?maintenance=true", net.JoinHostPort(pod.Status.PodIP, "9000")))
 	if err != nil {
 		c.Log.Infof("Failed to hit ha-minio pod %s: %s", pod.Name, err.Error())
 		return false
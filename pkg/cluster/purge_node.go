@@ -8,7 +8,6 @@ import (
 	"github.com/pkg/errors"
 	"github.com/replicatedhq/ekco/pkg/util"
 	"gopkg.in/yaml.v2"
-	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	kubeadmapi "k8s.io/kubernetes/cmd/kubeadm/app/apis/kubeadm"
 	kubeadmconstants "k8s.io/kubernetes/cmd/kubeadm/app/constants"
@@ -76,20 +75,21 @@ func (c *Controller) PurgeNode(ctx context.Context, name string, rook bool, rook
 			}
 		}
 
-		// get etcd peer URL for purged node if it wasn't in kubeadm's ClusterStatus
-		if ip == "" && node != nil {
-			for _, addr := range node.Status.Addresses {
-				if addr.Type == corev1.NodeInternalIP {
-					ip = addr.Address
-					c.Log.Debugf("Purge node %q: got ip from Node", name)
-					break
-				}
+		// get etcd peer IPs for purged node if it wasn't in kubeadm's ClusterStatus. Dual-stack nodes
+		// have an InternalIP in each family and the member may be on either.
+		ips := []string{}
+		if ip != "" {
+			ips = append(ips, ip)
+		} else if node != nil {
+			ips = util.NodeInternalIPs(*node)
+			if len(ips) > 0 {
+				c.Log.Debugf("Purge node %q: got ips from Node", name)
 			}
 		}
 
 		// remove etcd member
-		if ip != "" {
-			if err := c.removeEtcdPeer(ip, remainingIPs); err != nil {
+		if len(ips) > 0 {
+			if err := c.removeEtcdPeer(ips, remainingIPs); err != nil {
 				return err
 			}
 		}
@@ -209,3 +209,52 @@ apiEndpoints:
 		})
 	}
 }
+
+func Test_etcdMemberHasIP(t *testing.T) {
+	tests := []struct {
+		name     string
+		peerURLs []string
+		ips      []string
+		want     bool
+	}{
+		{
+			name:     "ipv4",
+			peerURLs: []string{"https://10.128.0.3:2380"},
+			ips:      []string{"10.128.0.3"},
+			want:     true,
+		},
+		{
+			name:     "ipv4 other member",
+			peerURLs: []string{"https://10.128.0.4:2380"},
+			ips:      []string{"10.128.0.3"},
+		},
+		{
+			name:     "ipv6 formatted differently",
+			peerURLs: []string{"https://[fd00:0:0::3]:2380"},
+			ips:      []string{"fd00::3"},
+			want:     true,
+		},
+		{
+			name:     "dual-stack member on second url",
+			peerURLs: []string{"https://10.128.0.3:2380", "https://[fd00::3]:2380"},
+			ips:      []string{"fd00::3"},
+			want:     true,
+		},
+		{
+			name:     "dual-stack node",
+			peerURLs: []string{"https://[fd00::3]:2380"},
+			ips:      []string{"10.128.0.3", "fd00::3"},
+			want:     true,
+		},
+		{
+			name:     "hostname",
+			peerURLs: []string{"https://node1:2380"},
+			ips:      []string{"10.128.0.3"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, etcdMemberHasIP(tt.peerURLs, tt.ips))
+		})
+	}
+}
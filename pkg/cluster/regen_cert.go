@@ -0,0 +1,62 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/replicatedhq/ekco/pkg/hosttask"
+)
+
+// RegenCerts regenerates the serving certificates on each primary with the subject alternative
+// names added and dropped. Primaries are updated one at a time and the restarted components must
+// be healthy before the next primary is updated.
+func (c *Controller) RegenCerts(ctx context.Context, certs, addAltNames, dropAltNames []string) error {
+	nodes, err := c.listPrimaryNodes(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, node := range nodes {
+		c.Log.Infof("Regenerating %s certificates on node %s", strings.Join(certs, ", "), node.Name)
+		start := time.Now()
+		task := c.regenCertTask(node.Name, certs, addAltNames, dropAltNames)
+		result, err := c.runHostTask(ctx, task)
+		if err != nil {
+			return errors.Wrapf(err, "regen cert task for node %s", node.Name)
+		}
+		if result == nil || len(result.RestartedComponents) == 0 {
+			continue
+		}
+
+		c.Log.Infof("Waiting for %s to be healthy on node %s", strings.Join(result.RestartedComponents, ", "), node.Name)
+		if err := c.waitForControlPlaneHealthy(ctx, node, result.RestartedComponents, start); err != nil {
+			return errors.Wrapf(err, "control plane on node %s not healthy after regenerating certificates", node.Name)
+		}
+		if slices.Contains(result.RestartedComponents, "etcd") {
+			if err := c.waitForEtcdHealthy(ctx, nodes); err != nil {
+				return errors.Wrapf(err, "wait for etcd after restart on node %s", node.Name)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (c *Controller) regenCertTask(nodeName string, certs, addAltNames, dropAltNames []string) HostTask {
+	task := c.rotateCertsTask(nodeName)
+	task.Name = RegenCertValue
+	task.Command = []string{
+		"ekco",
+		"regen-cert",
+		fmt.Sprintf("--certs=%s", strings.Join(certs, ",")),
+		fmt.Sprintf("--add-alt-names=%s", strings.Join(addAltNames, ",")),
+		fmt.Sprintf("--drop-alt-names=%s", strings.Join(dropAltNames, ",")),
+		"--restart",
+		fmt.Sprintf("--result-file=%s", hosttask.TerminationMessagePath),
+	}
+	return task
+}
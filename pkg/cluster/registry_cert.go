@@ -6,22 +6,21 @@ import (
 	cryptorand "crypto/rand"
 	"crypto/x509"
 	"crypto/x509/pkix"
-	"fmt"
 	"math"
 	"math/big"
 	"time"
 
 	"github.com/pkg/errors"
+	"github.com/replicatedhq/ekco/pkg/certpolicy"
+	"github.com/replicatedhq/ekco/pkg/issuer"
 	"github.com/replicatedhq/ekco/pkg/util"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/util/duration"
 	"k8s.io/client-go/util/cert"
 	certutil "k8s.io/client-go/util/cert"
-	"k8s.io/client-go/util/keyutil"
 	kubeadmapi "k8s.io/kubernetes/cmd/kubeadm/app/apis/kubeadm"
 	kubeadmconstants "k8s.io/kubernetes/cmd/kubeadm/app/constants"
-	certsphase "k8s.io/kubernetes/cmd/kubeadm/app/phases/certs"
 	"k8s.io/kubernetes/cmd/kubeadm/app/util/pkiutil"
 )
 
@@ -51,27 +50,40 @@ func (c *Controller) RotateRegistryCert(ctx context.Context) error {
 	}
 
 	ttl := time.Until(cert.NotAfter)
-	if ttl > c.Config.RotateCertsTTL {
+	expiring := c.certPolicy(certpolicy.ClassRegistry).Due(cert.NotAfter)
+	if !expiring && !c.issuerConfigured(RegistryIssuerKey) {
 		c.Log.Debugf("Registry cert has %s until expiration, skipping renewal", duration.ShortHumanDuration(ttl))
 		return nil
 	}
 
-	caCert, caKey, err := certsphase.LoadCertificateAuthority("/etc/kubernetes/pki", "ca")
+	iss, config, err := c.getIssuer(ctx, RegistryIssuerKey, issuer.TypeClusterCA)
 	if err != nil {
-		return errors.Wrap(err, "load cluster CA")
+		return errors.Wrap(err, "get registry cert issuer")
 	}
-
-	config, err := certToConfig(cert)
-	if err != nil {
-		return errors.Wrap(err, "convert cert to config")
+	// a cert from another issuer is replaced as soon as an issuer is configured
+	if !expiring {
+		issuedBy, err := c.secretIssuedBy(ctx, ns, name)
+		if err != nil {
+			return err
+		}
+		if iss.Issued(cert, issuedBy) {
+			c.Log.Debugf("Registry cert has %s until expiration, skipping renewal", duration.ShortHumanDuration(ttl))
+			return nil
+		}
+		c.Log.Infof("Registry cert was not issued by the configured %s issuer, renewing", config.Type)
 	}
 
-	newCert, newKey, err := generateNewCertAndKey(caCert, caKey, config)
+	return c.renewRegistryCert(ctx, ns, name, cert, iss, config)
+}
+
+// renewRegistryCert issues a copy of cert and restarts the registry
+func (c *Controller) renewRegistryCert(ctx context.Context, ns, name string, cert *x509.Certificate, iss issuer.Issuer, config issuer.Config) error {
+	issued, err := issueFromCert(ctx, iss, config, c.certPolicy(certpolicy.ClassRegistry), cert, ns, name)
 	if err != nil {
-		return errors.Wrap(err, "generate new cert")
+		return err
 	}
 
-	if err := c.updateRegistryCert(ctx, ns, name, newCert, newKey); err != nil {
+	if err := c.updateRegistryCert(ctx, ns, name, issued); err != nil {
 		return errors.Wrap(err, "save new cert")
 	}
 
@@ -102,30 +114,19 @@ func (c *Controller) readRegistryCert(ctx context.Context, namespace, name strin
 	if err != nil {
 		return nil, errors.Wrapf(err, "parse registry.crt")
 	}
-	if len(certs) != 1 {
-		return nil, fmt.Errorf("expected exactly 1 cert in registry.crt, got %d", len(certs))
-	}
-
+	// certificates from an issuer may be followed by intermediates
 	return certs[0], nil
 }
 
-func (c *Controller) updateRegistryCert(ctx context.Context, namespace, name string, crt *x509.Certificate, key crypto.PrivateKey) error {
+func (c *Controller) updateRegistryCert(ctx context.Context, namespace, name string, issued *issuer.Certificate) error {
 	secret, err := c.Config.Client.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
 	if err != nil {
 		return errors.Wrapf(err, "get secret %s/%s", namespace, name)
 	}
 
-	crtData, err := cert.EncodeCertificates(crt)
-	if err != nil {
-		return errors.Wrapf(err, "encode certificate")
-	}
-	secret.Data["registry.crt"] = crtData
-
-	keyData, err := keyutil.MarshalPrivateKeyToPEM(key)
-	if err != nil {
-		return errors.Wrapf(err, "encode key")
-	}
-	secret.Data["registry.key"] = keyData
+	secret.Data["registry.crt"] = issued.Cert
+	secret.Data["registry.key"] = issued.Key
+	setIssuedBy(secret, issued)
 
 	if _, err := c.Config.Client.CoreV1().Secrets(namespace).Update(ctx, secret, metav1.UpdateOptions{}); err != nil {
 		return errors.Wrapf(err, "update secret %s/%s", namespace, name)
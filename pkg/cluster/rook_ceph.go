@@ -369,72 +369,6 @@ func (c *Controller) ReconcileMgrCount(ctx context.Context, rookVersion semver.V
 	return nil
 }
 
-const (
-	cephCSIRbdProvisionerResource    = "- name : csi-provisioner\n  resource:\n    requests:\n      memory: 128Mi\n      cpu: 100m\n    limits:\n      memory: 256Mi\n      cpu: 200m\n- name : csi-resizer\n  resource:\n    requests:\n      memory: 128Mi\n      cpu: 100m\n    limits:\n      memory: 256Mi\n      cpu: 200m\n- name : csi-attacher\n  resource:\n    requests:\n      memory: 128Mi\n      cpu: 100m\n    limits:\n      memory: 256Mi\n      cpu: 200m\n- name : csi-snapshotter\n  resource:\n    requests:\n      memory: 128Mi\n      cpu: 100m\n    limits:\n      memory: 256Mi\n      cpu: 200m\n- name : csi-rbdplugin\n  resource:\n    requests:\n      memory: 512Mi\n      cpu: 250m\n    limits:\n      memory: 1Gi\n      cpu: 500m\n- name : csi-omap-generator\n  resource:\n    requests:\n      memory: 512Mi\n      cpu: 250m\n    limits:\n      memory: 1Gi\n      cpu: 500m\n- name : liveness-prometheus\n  resource:\n    requests:\n      memory: 128Mi\n      cpu: 50m\n    limits:\n      memory: 256Mi\n      cpu: 100m\n"
-	cephCSIRbdPluginResource         = "- name : driver-registrar\n  resource:\n    requests:\n      memory: 128Mi\n      cpu: 50m\n    limits:\n      memory: 256Mi\n      cpu: 100m\n- name : csi-rbdplugin\n  resource:\n    requests:\n      memory: 512Mi\n      cpu: 250m\n    limits:\n      memory: 1Gi\n      cpu: 500m\n- name : liveness-prometheus\n  resource:\n    requests:\n      memory: 128Mi\n      cpu: 50m\n    limits:\n      memory: 256Mi\n      cpu: 100m\n"
-	cephCSICephfsProvisionerResource = "- name : csi-provisioner\n  resource:\n    requests:\n      memory: 128Mi\n      cpu: 100m\n    limits:\n      memory: 256Mi\n      cpu: 200m\n- name : csi-resizer\n  resource:\n    requests:\n      memory: 128Mi\n      cpu: 100m\n    limits:\n      memory: 256Mi\n      cpu: 200m\n- name : csi-attacher\n  resource:\n    requests:\n      memory: 128Mi\n      cpu: 100m\n    limits:\n      memory: 256Mi\n      cpu: 200m\n- name : csi-snapshotter\n  resource:\n    requests:\n      memory: 128Mi\n      cpu: 100m\n    limits:\n      memory: 256Mi\n      cpu: 200m\n- name : csi-cephfsplugin\n  resource:\n    requests:\n      memory: 512Mi\n      cpu: 250m\n    limits:\n      memory: 1Gi\n      cpu: 500m\n- name : liveness-prometheus\n  resource:\n    requests:\n      memory: 128Mi\n      cpu: 50m\n    limits:\n      memory: 256Mi\n      cpu: 100m\n"
-	cephCSICephfsPluginResource      = "- name : driver-registrar\n  resource:\n    requests:\n      memory: 128Mi\n      cpu: 50m\n    limits:\n      memory: 256Mi\n      cpu: 100m\n- name : csi-cephfsplugin\n  resource:\n    requests:\n      memory: 512Mi\n      cpu: 250m\n    limits:\n      memory: 1Gi\n      cpu: 500m\n- name : liveness-prometheus\n  resource:\n    requests:\n      memory: 128Mi\n      cpu: 50m\n    limits:\n      memory: 256Mi\n      cpu: 100m\n"
-	cephCSINfsProvisionerResource    = "- name : csi-provisioner\n  resource:\n    requests:\n      memory: 128Mi\n      cpu: 100m\n    limits:\n      memory: 256Mi\n      cpu: 200m\n- name : csi-nfsplugin\n  resource:\n    requests:\n      memory: 512Mi\n      cpu: 250m\n    limits:\n      memory: 1Gi\n      cpu: 500m\n"
-	cephCSINfsPluginResource         = "- name : driver-registrar\n  resource:\n    requests:\n      memory: 128Mi\n      cpu: 50m\n    limits:\n      memory: 256Mi\n      cpu: 100m\n- name : csi-nfsplugin\n  resource:\n    requests:\n      memory: 512Mi\n      cpu: 250m\n    limits:\n      memory: 1Gi\n      cpu: 500m\n"
-)
-
-var (
-	cephCSIResourcesPatch = []byte(fmt.Sprintf(
-		`{"data":{`+
-			`"CSI_RBD_PROVISIONER_RESOURCE":"%s",`+
-			`"CSI_RBD_PLUGIN_RESOURCE":"%s",`+
-			`"CSI_CEPHFS_PROVISIONER_RESOURCE":"%s",`+
-			`"CSI_CEPHFS_PLUGIN_RESOURCE":"%s",`+
-			`"CSI_NFS_PROVISIONER_RESOURCE":"%s",`+
-			`"CSI_NFS_PLUGIN_RESOURCE":"%s"`+
-			`}}`,
-		strings.ReplaceAll(cephCSIRbdProvisionerResource, "\n", "\\n"),
-		strings.ReplaceAll(cephCSIRbdPluginResource, "\n", "\\n"),
-		strings.ReplaceAll(cephCSICephfsProvisionerResource, "\n", "\\n"),
-		strings.ReplaceAll(cephCSICephfsPluginResource, "\n", "\\n"),
-		strings.ReplaceAll(cephCSINfsProvisionerResource, "\n", "\\n"),
-		strings.ReplaceAll(cephCSINfsPluginResource, "\n", "\\n"),
-	))
-)
-
-// SetCephCSIResources will set CSI provisioner and plugin resources to their recommendations once
-// the cluster has enough capacity at 3 nodes.
-func (c *Controller) SetCephCSIResources(ctx context.Context, rookVersion semver.Version, nodeCount int) (bool, error) {
-	if rookVersion.LT(Rookv19) {
-		return false, nil
-	}
-
-	if nodeCount < 3 {
-		return false, nil
-	}
-
-	configMap, err := c.Config.Client.CoreV1().ConfigMaps(RookCephNS).Get(ctx, "rook-ceph-operator-config", metav1.GetOptions{})
-	if err != nil {
-		return false, errors.Wrap(err, "get rook-ceph-operator-config configmap")
-	}
-
-	if !cephCSIResourcesNeedsUpdate(configMap.Data) {
-		return false, nil
-	}
-
-	c.Log.Infof("Setting Ceph CSI plugin and provisioner resources")
-
-	_, err = c.Config.Client.CoreV1().ConfigMaps(RookCephNS).Patch(ctx, "rook-ceph-operator-config", apitypes.MergePatchType, cephCSIResourcesPatch, metav1.PatchOptions{})
-	if err != nil {
-		return false, errors.Wrap(err, "patch rook-ceph-operator-config configmap")
-	}
-	return true, nil
-}
-
-func cephCSIResourcesNeedsUpdate(data map[string]string) bool {
-	return data["CSI_RBD_PROVISIONER_RESOURCE"] != cephCSIRbdProvisionerResource ||
-		data["CSI_RBD_PLUGIN_RESOURCE"] != cephCSIRbdPluginResource ||
-		data["CSI_CEPHFS_PROVISIONER_RESOURCE"] != cephCSICephfsProvisionerResource ||
-		data["CSI_CEPHFS_PLUGIN_RESOURCE"] != cephCSICephfsPluginResource ||
-		data["CSI_NFS_PROVISIONER_RESOURCE"] != cephCSINfsProvisionerResource ||
-		data["CSI_NFS_PLUGIN_RESOURCE"] != cephCSINfsPluginResource
-}
-
 // SetSharedFilesystemReplication will set the shared filesystem replication to
 // the number of OSDs in the cluster. Returns true if the resource was updated.
 func (c *Controller) SetFilesystemReplication(ctx context.Context, rookVersion semver.Version, cephVersion *semver.Version, name string, level int, doFullReconcile bool) (bool, error) {
@@ -451,6 +385,11 @@ func (c *Controller) SetFilesystemReplication(ctx context.Context, rookVersion s
 	}
 	patches := []k8s.JSONPatchOperation{}
 	for i, pool := range cephFilesystem.Spec.DataPools {
+		if isErasureCodedPool(pool.PoolSpec) {
+			// the k/m profile of an erasure coded pool is fixed at creation time
+			c.Log.Debugf("Skipping erasure coded CephFilesystem data pool %s", pool.Name)
+			continue
+		}
 		current := int(pool.Replicated.Size)
 		if current < level {
 			cephFilesystem.Spec.DataPools[i].Replicated.Size = uint(level)
@@ -506,6 +445,9 @@ func (c *Controller) SetFilesystemReplication(ctx context.Context, rookVersion s
 		if err != nil {
 			return false, errors.Wrapf(err, "scale shared cephFS metadata pool min_size")
 		}
+		if len(cephFilesystem.Spec.DataPools) > 0 && isErasureCodedPool(cephFilesystem.Spec.DataPools[0].PoolSpec) {
+			return true, nil
+		}
 		err = c.cephOSDPoolSetSize(ctx, rookVersion, cephVersion, RookCephSharedFSDataPool, level)
 		if err != nil {
 			return false, errors.Wrapf(err, "scale shared cephFS data pool size")
@@ -578,8 +520,11 @@ func (c *Controller) SetObjectStoreReplication(ctx context.Context, rookVersion
 
 	patches := []k8s.JSONPatchOperation{}
 
+	// the k/m profile of an erasure coded data pool is fixed at creation time so only the
+	// replicated metadata pools are scaled
+	dataPoolErasureCoded := isErasureCodedPool(os.Spec.DataPool)
 	current := int(os.Spec.DataPool.Replicated.Size)
-	if current < level {
+	if !dataPoolErasureCoded && current < level {
 		patches = append(patches, k8s.JSONPatchOperation{
 			Op:    k8s.JSONPatchOpReplace,
 			Path:  "/spec/dataPool/replicated/size",
@@ -625,7 +570,9 @@ func (c *Controller) SetObjectStoreReplication(ctx context.Context, rookVersion
 	// manually https://github.com/rook/rook/issues/4341
 	if rookVersion.LT(Rookv14) {
 		pools := append([]string{RookCephObjectStoreRootPool}, RookCephObjectStoreMetadataPools...)
-		pools = append(pools, RookCephObjectStoreDataPools...)
+		if !dataPoolErasureCoded {
+			pools = append(pools, RookCephObjectStoreDataPools...)
+		}
 		for _, pool := range pools {
 			err := c.cephOSDPoolSetSize(ctx, rookVersion, cephVersion, objectStorePoolName(name, pool), level)
 			if err == cephErrENOENT && slices.Contains(RookCephObjectStoreMetadataPools, pool) {
@@ -662,35 +609,41 @@ func (c *Controller) SetObjectStoreReplication(ctx context.Context, rookVersion
 
 // Toolbox is deployed with Rook 1.4 since ceph commands can't be executed in operator
 func (c *Controller) rookCephExec(ctx context.Context, rookVersion semver.Version, cmd ...string) error {
+	_, err := c.rookCephExecOutput(ctx, rookVersion, cmd...)
+	return err
+}
+
+// rookCephExecOutput is the same as rookCephExec but returns stdout
+func (c *Controller) rookCephExecOutput(ctx context.Context, rookVersion semver.Version, cmd ...string) (string, error) {
 	container, rookLabels := c.rookCephExecTarget(rookVersion)
 	opts := metav1.ListOptions{
 		LabelSelector: rookLabels,
 	}
 	pods, err := c.Config.Client.CoreV1().Pods(RookCephNS).List(ctx, opts)
 	if err != nil {
-		return errors.Wrap(err, "list Rook pods")
+		return "", errors.Wrap(err, "list Rook pods")
 	}
 	if len(pods.Items) == 0 {
-		return errors.Wrap(err, "found no Rook pods for executing ceph commands")
+		return "", errors.New("found no Rook pods for executing ceph commands")
 	}
 
 	exitCode, stdout, stderr, err := c.SyncExecutor.ExecContainer(ctx, RookCephNS, pods.Items[0].Name, container, cmd...)
 	if err != nil {
-		return err
+		return "", err
 	}
 	if exitCode == 2 {
 		c.Log.Debugf("Rook ceph exec %q exited with code %d and stderr: %s", cmd, exitCode, stderr)
-		return cephErrENOENT
+		return "", cephErrENOENT
 	}
 	if exitCode != 0 {
 		c.Log.Infof("Rook ceph exec %q exited with code %d and stderr: %s", cmd, exitCode, stderr)
 
-		return fmt.Errorf("exec %q: %d", cmd, exitCode)
+		return "", fmt.Errorf("exec %q: %d", cmd, exitCode)
 	}
 
 	c.Log.Debugf("Exec Rook ceph %q exited with code %d and stdout: %s", cmd, exitCode, stdout)
 
-	return nil
+	return stdout, nil
 }
 
 func (c *Controller) execCephOSDPurge(ctx context.Context, rookVersion semver.Version, osdID string, hostname string) error {
@@ -717,6 +670,11 @@ func (c *Controller) execCephOSDPurge(ctx context.Context, rookVersion semver.Ve
 		return errors.Wrap(err, "ceph osd purge")
 	}
 
+	// the host bucket is left in place when replacing a single OSD on a node that stays in the cluster
+	if hostname == "" {
+		return nil
+	}
+
 	// This removes the phantom OSD from the output of `ceph osd tree`
 	exitCode, stdout, stderr, err = c.SyncExecutor.ExecContainer(ctx, RookCephNS, pods.Items[0].Name, container, "ceph", "osd", "crush", "rm", hostname)
 	if exitCode != 0 {
@@ -787,6 +745,12 @@ func (c *Controller) WaitCephFilesystem(ctx context.Context, rookVersion semver.
 	}
 }
 
+// isErasureCodedPool returns true if the pool is configured with an erasure coding profile rather
+// than replication.
+func isErasureCodedPool(pool cephv1.PoolSpec) bool {
+	return pool.ErasureCoded.DataChunks > 0 || pool.ErasureCoded.CodingChunks > 0
+}
+
 func objectStorePoolName(storeName, poolName string) string {
 	if strings.HasPrefix(poolName, ".") {
 		return poolName
@@ -964,33 +928,7 @@ func (c *Controller) cephOSDPoolSetPgNumMin(ctx context.Context, rookVersion sem
 	return c.rookCephExec(ctx, rookVersion, args...)
 }
 
-// GetRookVersion gets the Rook version from the container image tag of the rook-ceph-operator
-// deployment in the rook-ceph namespace.
-func (c *Controller) GetRookVersion(ctx context.Context) (*semver.Version, error) {
-	_, err := c.Config.Client.CoreV1().Namespaces().Get(ctx, RookCephNS, metav1.GetOptions{})
-	if err != nil {
-		return nil, errors.Wrap(err, "get rook-ceph namespace")
-	}
-
-	deploy, err := c.Config.Client.AppsV1().Deployments(RookCephNS).Get(ctx, "rook-ceph-operator", metav1.GetOptions{})
-	if err != nil {
-		return nil, errors.Wrap(err, "get rook-ceph-operator deployment")
-	}
-	for _, container := range deploy.Spec.Template.Spec.Containers {
-		if container.Name == "rook-ceph-operator" {
-			parts := strings.Split(deploy.Spec.Template.Spec.Containers[0].Image, ":")
-			imageTag := parts[len(parts)-1]
-			rookVersion, err := semver.Parse(strings.TrimPrefix(imageTag, "v"))
-			if err != nil {
-				return nil, errors.Wrap(err, "parse semver")
-			}
-			return &rookVersion, nil
-		}
-	}
-	return nil, errors.New("rook-ceph-operator container not found in deployment")
-}
-
-func (c *Controller) ensureCephClusterHelm(ctx context.Context, rookStorageClassName string) error {
+func (c *Controller) ensureCephClusterHelm(ctx context.Context, rookStorageClassName string, nodeCount int) error {
 	cephClusterChartArchive, _, err := charts.LatestChartByName("rook-ceph-cluster")
 	if err != nil {
 		return fmt.Errorf("unable to get rook-ceph-cluster chartfile: %w", err)
@@ -1032,13 +970,53 @@ func (c *Controller) ensureCephClusterHelm(ctx context.Context, rookStorageClass
 		cephVersion["image"] = c.Config.RookCephImage
 	}
 
+	dataChunks, codingChunks := c.Config.CephObjectStoreECDataChunks, c.Config.CephObjectStoreECCodingChunks
+	if dataChunks > 0 && codingChunks > 0 {
+		if nodeCount >= dataChunks+codingChunks {
+			c.Log.Infof("Using erasure coded object store data pools with %d data chunks and %d coding chunks", dataChunks, codingChunks)
+			if err := setObjectStoreErasureCoding(chartValues, dataChunks, codingChunks); err != nil {
+				return fmt.Errorf("failed to set object store erasure coding: %w", err)
+			}
+		} else {
+			c.Log.Debugf("Using replicated object store data pools: %d nodes is not enough for %d+%d erasure coding", nodeCount, dataChunks, codingChunks)
+		}
+	}
+
 	if err = helmMgr.InstallChartArchive(cephClusterChartArchive, chartValues, "", "rook-ceph"); err != nil {
 		return fmt.Errorf("unable to apply chart: %w", err)
 	}
 	return nil
 }
 
-func (c *Controller) EnsureCephCluster(ctx context.Context, rookStorageClassName string) error {
+// setObjectStoreErasureCoding replaces the replicated data pool of every object store in the
+// rook-ceph-cluster chart values with an erasure coded pool using the given profile. Metadata pools
+// remain replicated.
+func setObjectStoreErasureCoding(chartValues map[string]interface{}, dataChunks, codingChunks int) error {
+	objectStores, ok := chartValues["cephObjectStores"].([]interface{})
+	if !ok {
+		return fmt.Errorf("failed to parse cephObjectStores as []interface{}")
+	}
+	for _, objectStore := range objectStores {
+		store, ok := objectStore.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("failed to parse cephObjectStore as map[string]interface{}")
+		}
+		spec, ok := store["spec"].(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("failed to parse cephObjectStore spec as map[string]interface{}")
+		}
+		spec["dataPool"] = map[string]interface{}{
+			"failureDomain": "host",
+			"erasureCoded": map[string]interface{}{
+				"dataChunks":   dataChunks,
+				"codingChunks": codingChunks,
+			},
+		}
+	}
+	return nil
+}
+
+func (c *Controller) EnsureCephCluster(ctx context.Context, rookStorageClassName string, nodeCount int) error {
 	_, err := c.GetCephCluster(ctx)
 	if err != nil {
 		if !util.IsNotFoundErr(err) {
@@ -1047,7 +1025,7 @@ func (c *Controller) EnsureCephCluster(ctx context.Context, rookStorageClassName
 	}
 
 	// create CephCluster
-	if err = c.ensureCephClusterHelm(ctx, rookStorageClassName); err != nil {
+	if err = c.ensureCephClusterHelm(ctx, rookStorageClassName, nodeCount); err != nil {
 		return err
 	}
 
@@ -9,6 +9,7 @@ import (
 
 	"github.com/blang/semver"
 	"github.com/golang/mock/gomock"
+	"github.com/pkg/errors"
 	"github.com/replicatedhq/ekco/pkg/cluster/types"
 	mock_k8s "github.com/replicatedhq/ekco/pkg/k8s/mock"
 	"github.com/replicatedhq/ekco/pkg/logger"
@@ -17,9 +18,11 @@ import (
 	rookfake "github.com/rook/rook/pkg/client/clientset/versioned/fake"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes/fake"
+	"sigs.k8s.io/yaml"
 )
 
 var cephPacific = semver.MustParse("16.2.6-0")
@@ -101,23 +104,53 @@ func TestController_SetCephCSIResources(t *testing.T) {
 		Data: map[string]string{},
 	}
 
+	// the format written by previous versions of EKCO
+	oldConfigMap := configMap.DeepCopy()
+	oldConfigMap.Data = map[string]string{
+		"CSI_RBD_PROVISIONER_RESOURCE":    "- name : csi-provisioner\n  resource:\n    requests:\n      memory: 128Mi\n      cpu: 100m\n    limits:\n      memory: 256Mi\n      cpu: 200m\n- name : csi-resizer\n  resource:\n    requests:\n      memory: 128Mi\n      cpu: 100m\n    limits:\n      memory: 256Mi\n      cpu: 200m\n- name : csi-attacher\n  resource:\n    requests:\n      memory: 128Mi\n      cpu: 100m\n    limits:\n      memory: 256Mi\n      cpu: 200m\n- name : csi-snapshotter\n  resource:\n    requests:\n      memory: 128Mi\n      cpu: 100m\n    limits:\n      memory: 256Mi\n      cpu: 200m\n- name : csi-rbdplugin\n  resource:\n    requests:\n      memory: 512Mi\n      cpu: 250m\n    limits:\n      memory: 1Gi\n      cpu: 500m\n- name : csi-omap-generator\n  resource:\n    requests:\n      memory: 512Mi\n      cpu: 250m\n    limits:\n      memory: 1Gi\n      cpu: 500m\n- name : liveness-prometheus\n  resource:\n    requests:\n      memory: 128Mi\n      cpu: 50m\n    limits:\n      memory: 256Mi\n      cpu: 100m\n",
+		"CSI_RBD_PLUGIN_RESOURCE":         "- name : driver-registrar\n  resource:\n    requests:\n      memory: 128Mi\n      cpu: 50m\n    limits:\n      memory: 256Mi\n      cpu: 100m\n- name : csi-rbdplugin\n  resource:\n    requests:\n      memory: 512Mi\n      cpu: 250m\n    limits:\n      memory: 1Gi\n      cpu: 500m\n- name : liveness-prometheus\n  resource:\n    requests:\n      memory: 128Mi\n      cpu: 50m\n    limits:\n      memory: 256Mi\n      cpu: 100m\n",
+		"CSI_CEPHFS_PROVISIONER_RESOURCE": "- name : csi-provisioner\n  resource:\n    requests:\n      memory: 128Mi\n      cpu: 100m\n    limits:\n      memory: 256Mi\n      cpu: 200m\n- name : csi-resizer\n  resource:\n    requests:\n      memory: 128Mi\n      cpu: 100m\n    limits:\n      memory: 256Mi\n      cpu: 200m\n- name : csi-attacher\n  resource:\n    requests:\n      memory: 128Mi\n      cpu: 100m\n    limits:\n      memory: 256Mi\n      cpu: 200m\n- name : csi-snapshotter\n  resource:\n    requests:\n      memory: 128Mi\n      cpu: 100m\n    limits:\n      memory: 256Mi\n      cpu: 200m\n- name : csi-cephfsplugin\n  resource:\n    requests:\n      memory: 512Mi\n      cpu: 250m\n    limits:\n      memory: 1Gi\n      cpu: 500m\n- name : liveness-prometheus\n  resource:\n    requests:\n      memory: 128Mi\n      cpu: 50m\n    limits:\n      memory: 256Mi\n      cpu: 100m\n",
+		"CSI_CEPHFS_PLUGIN_RESOURCE":      "- name : driver-registrar\n  resource:\n    requests:\n      memory: 128Mi\n      cpu: 50m\n    limits:\n      memory: 256Mi\n      cpu: 100m\n- name : csi-cephfsplugin\n  resource:\n    requests:\n      memory: 512Mi\n      cpu: 250m\n    limits:\n      memory: 1Gi\n      cpu: 500m\n- name : liveness-prometheus\n  resource:\n    requests:\n      memory: 128Mi\n      cpu: 50m\n    limits:\n      memory: 256Mi\n      cpu: 100m\n",
+		"CSI_NFS_PROVISIONER_RESOURCE":    "- name : csi-provisioner\n  resource:\n    requests:\n      memory: 128Mi\n      cpu: 100m\n    limits:\n      memory: 256Mi\n      cpu: 200m\n- name : csi-nfsplugin\n  resource:\n    requests:\n      memory: 512Mi\n      cpu: 250m\n    limits:\n      memory: 1Gi\n      cpu: 500m\n",
+		"CSI_NFS_PLUGIN_RESOURCE":         "- name : driver-registrar\n  resource:\n    requests:\n      memory: 128Mi\n      cpu: 50m\n    limits:\n      memory: 256Mi\n      cpu: 100m\n- name : csi-nfsplugin\n  resource:\n    requests:\n      memory: 512Mi\n      cpu: 250m\n    limits:\n      memory: 1Gi\n      cpu: 500m\n",
+	}
+
+	newNodes := func(count int, cpu, memory string) []corev1.Node {
+		nodes := []corev1.Node{}
+		for i := 0; i < count; i++ {
+			nodes = append(nodes, corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("node%d", i)},
+				Status: corev1.NodeStatus{
+					Allocatable: corev1.ResourceList{
+						corev1.ResourceCPU:    resource.MustParse(cpu),
+						corev1.ResourceMemory: resource.MustParse(memory),
+					},
+				},
+			})
+		}
+		return nodes
+	}
+
 	type args struct {
-		nodeCount int
+		nodes []corev1.Node
 	}
 	tests := []struct {
-		name        string
-		resources   []runtime.Object
-		rookVersion semver.Version
-		args        args
-		want        bool
-		wantErr     bool
+		name          string
+		resources     []runtime.Object
+		rookVersion   semver.Version
+		profile       string
+		customProfile string
+		args          args
+		want          bool
+		wantErr       bool
+		wantPluginCPU string
 	}{
 		{
 			name:        "1 node",
 			resources:   []runtime.Object{configMap},
 			rookVersion: semver.MustParse("1.9.12"),
 			args: args{
-				nodeCount: 1,
+				nodes: newNodes(1, "8", "16Gi"),
 			},
 			want: false,
 		},
@@ -126,16 +159,87 @@ func TestController_SetCephCSIResources(t *testing.T) {
 			resources:   []runtime.Object{configMap},
 			rookVersion: semver.MustParse("1.9.12"),
 			args: args{
-				nodeCount: 3,
+				nodes: newNodes(3, "8", "16Gi"),
+			},
+			want:          true,
+			wantPluginCPU: "250m",
+		},
+		{
+			name:        "3 small nodes",
+			resources:   []runtime.Object{configMap},
+			rookVersion: semver.MustParse("1.9.12"),
+			args: args{
+				nodes: newNodes(3, "2", "4Gi"),
+			},
+			want:          true,
+			wantPluginCPU: "100m",
+		},
+		{
+			name:        "3 large nodes",
+			resources:   []runtime.Object{configMap},
+			rookVersion: semver.MustParse("1.9.12"),
+			args: args{
+				nodes: newNodes(3, "32", "64Gi"),
+			},
+			want:          true,
+			wantPluginCPU: "500m",
+		},
+		{
+			name:        "3 nodes previously set in a different format",
+			resources:   []runtime.Object{oldConfigMap},
+			rookVersion: semver.MustParse("1.9.12"),
+			args: args{
+				nodes: newNodes(3, "8", "16Gi"),
+			},
+			want:          false,
+			wantPluginCPU: "250m",
+		},
+		{
+			name:        "explicit profile on 1 node",
+			resources:   []runtime.Object{configMap},
+			rookVersion: semver.MustParse("1.9.12"),
+			profile:     "large",
+			args: args{
+				nodes: newNodes(1, "2", "4Gi"),
+			},
+			want:          true,
+			wantPluginCPU: "500m",
+		},
+		{
+			name:        "custom profile",
+			resources:   []runtime.Object{configMap},
+			rookVersion: semver.MustParse("1.9.12"),
+			profile:     "custom",
+			customProfile: `sidecar:
+  requests: {cpu: 10m, memory: 32Mi}
+plugin:
+  requests: {cpu: 20m, memory: 64Mi}
+  limits: {memory: 128Mi}
+registrar: {}
+liveness: {}
+`,
+			args: args{
+				nodes: newNodes(3, "8", "16Gi"),
+			},
+			want:          true,
+			wantPluginCPU: "20m",
+		},
+		{
+			name:        "unknown profile",
+			resources:   []runtime.Object{configMap},
+			rookVersion: semver.MustParse("1.9.12"),
+			profile:     "huge",
+			args: args{
+				nodes: newNodes(3, "8", "16Gi"),
 			},
-			want: true,
+			wantErr: true,
 		},
 		{
 			name:        "rook 1.8",
 			resources:   []runtime.Object{configMap},
 			rookVersion: semver.MustParse("1.8.10"),
 			args: args{
-				nodeCount: 3,
+				nodes: newNodes(3, "8", "16Gi"),
 			},
 			want: false,
 		},
@@ -145,11 +249,13 @@ func TestController_SetCephCSIResources(t *testing.T) {
 			clientset := fake.NewSimpleClientset(tt.resources...)
 			c := &Controller{
 				Config: types.ControllerConfig{
-					Client: clientset,
+					Client:                 clientset,
+					CephCSIResourceProfile: tt.profile,
+					CephCSICustomResources: tt.customProfile,
 				},
 				Log: logger.NewDiscardLogger(),
 			}
-			got, err := c.SetCephCSIResources(context.Background(), tt.rookVersion, tt.args.nodeCount)
+			got, err := c.SetCephCSIResources(context.Background(), tt.rookVersion, tt.args.nodes)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Controller.SetCephCSIResources() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -158,7 +264,23 @@ func TestController_SetCephCSIResources(t *testing.T) {
 				t.Errorf("Controller.SetCephCSIResources() = %v, want %v", got, tt.want)
 			}
 
-			got, err = c.SetCephCSIResources(context.Background(), tt.rookVersion, tt.args.nodeCount)
+			if tt.wantPluginCPU != "" {
+				cm, err := clientset.CoreV1().ConfigMaps("rook-ceph").Get(context.Background(), "rook-ceph-operator-config", metav1.GetOptions{})
+				if err != nil {
+					t.Fatalf("ConfigMaps.Get(\"rook-ceph-operator-config\") error = %v", err)
+				}
+				containers := []cephCSIContainerResource{}
+				if err := yaml.Unmarshal([]byte(cm.Data["CSI_RBD_PLUGIN_RESOURCE"]), &containers); err != nil {
+					t.Fatalf("unmarshal CSI_RBD_PLUGIN_RESOURCE: %v", err)
+				}
+				for _, container := range containers {
+					if container.Name == "csi-rbdplugin" && container.Resource.Requests.Cpu().String() != tt.wantPluginCPU {
+						t.Errorf("csi-rbdplugin cpu request = %s, want %s", container.Resource.Requests.Cpu(), tt.wantPluginCPU)
+					}
+				}
+			}
+
+			got, err = c.SetCephCSIResources(context.Background(), tt.rookVersion, tt.args.nodes)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Controller.SetCephCSIResources() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -207,6 +329,7 @@ func TestController_GetRookVersion(t *testing.T) {
 		want              *semver.Version
 		wantErr           bool
 		wantIsNotFoundErr bool
+		wantUnknown       bool
 	}{
 		{
 			name: "1.9.12",
@@ -224,6 +347,70 @@ func TestController_GetRookVersion(t *testing.T) {
 			},
 			want: newSemver("1.0.4-9065b09-20210625"),
 		},
+		{
+			name: "digest pinned image",
+			resources: []runtime.Object{
+				rookCephOperatorDeployment("rook/ceph:v1.12.3@sha256:4f4c1f8bb38dc7ab3bd9f0ab2d3ee0bf1f8b3ba9c6e0ae4bc3e4fa1b4c5d6e7f"),
+				&corev1.Namespace{TypeMeta: metav1.TypeMeta{Kind: "Namespace", APIVersion: "v1"}, ObjectMeta: metav1.ObjectMeta{Name: "rook-ceph"}},
+			},
+			want: newSemver("1.12.3"),
+		},
+		{
+			name: "registry with port",
+			resources: []runtime.Object{
+				rookCephOperatorDeployment("registry.local:5000/rook/ceph:v1.12.3"),
+				&corev1.Namespace{TypeMeta: metav1.TypeMeta{Kind: "Namespace", APIVersion: "v1"}, ObjectMeta: metav1.ObjectMeta{Name: "rook-ceph"}},
+			},
+			want: newSemver("1.12.3"),
+		},
+		{
+			name: "operator is not the first container",
+			resources: []runtime.Object{
+				func() *appsv1.Deployment {
+					deploy := rookCephOperatorDeployment("rook/ceph:v1.12.3")
+					deploy.Spec.Template.Spec.Containers = append([]corev1.Container{{Name: "sidecar", Image: "busybox:1.36.1"}}, deploy.Spec.Template.Spec.Containers...)
+					return deploy
+				}(),
+				&corev1.Namespace{TypeMeta: metav1.TypeMeta{Kind: "Namespace", APIVersion: "v1"}, ObjectMeta: metav1.ObjectMeta{Name: "rook-ceph"}},
+			},
+			want: newSemver("1.12.3"),
+		},
+		{
+			name: "helm chart label with custom image tag",
+			resources: []runtime.Object{
+				func() *appsv1.Deployment {
+					deploy := rookCephOperatorDeployment("registry.local:5000/rook/ceph:custom")
+					deploy.Labels = map[string]string{"helm.sh/chart": "rook-ceph-v1.12.3"}
+					return deploy
+				}(),
+				&corev1.Namespace{TypeMeta: metav1.TypeMeta{Kind: "Namespace", APIVersion: "v1"}, ObjectMeta: metav1.ObjectMeta{Name: "rook-ceph"}},
+			},
+			want: newSemver("1.12.3"),
+		},
+		{
+			name: "CephCluster daemon label with custom image tag",
+			resources: []runtime.Object{
+				rookCephOperatorDeployment("registry.local:5000/rook/ceph:custom"),
+				&appsv1.Deployment{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "rook-ceph-mon-a",
+						Namespace: "rook-ceph",
+						Labels:    map[string]string{"app": "rook-ceph-mon", "rook-version": "v1.12.3"},
+					},
+				},
+				&corev1.Namespace{TypeMeta: metav1.TypeMeta{Kind: "Namespace", APIVersion: "v1"}, ObjectMeta: metav1.ObjectMeta{Name: "rook-ceph"}},
+			},
+			want: newSemver("1.12.3"),
+		},
+		{
+			name: "unknown",
+			resources: []runtime.Object{
+				rookCephOperatorDeployment("registry.local:5000/rook/ceph@sha256:4f4c1f8bb38dc7ab3bd9f0ab2d3ee0bf1f8b3ba9c6e0ae4bc3e4fa1b4c5d6e7f"),
+				&corev1.Namespace{TypeMeta: metav1.TypeMeta{Kind: "Namespace", APIVersion: "v1"}, ObjectMeta: metav1.ObjectMeta{Name: "rook-ceph"}},
+			},
+			wantErr:     true,
+			wantUnknown: true,
+		},
 		{
 			name: "invalid semver",
 			resources: []runtime.Object{
@@ -266,6 +453,9 @@ func TestController_GetRookVersion(t *testing.T) {
 					t.Errorf("Controller.GetRookVersion() error = %T, want k8serrors.IsNotFound", err)
 				}
 			}
+			if tt.wantUnknown && !errors.Is(err, ErrRookVersionUnknown) {
+				t.Errorf("Controller.GetRookVersion() error = %v, want ErrRookVersionUnknown", err)
+			}
 			if !tt.wantErr {
 				if !(*tt.want).Equals(*got) {
 					t.Errorf("Controller.GetRookVersion() = %s, want %s", *got, *tt.want)
@@ -1119,12 +1309,13 @@ func TestController_SetFilesystemReplication(t *testing.T) {
 		doFullReconcile bool
 	}
 	tests := []struct {
-		name          string
-		rookResources []runtime.Object
-		args          args
-		want          bool
-		wantLevel     uint
-		wantErr       bool
+		name             string
+		rookResources    []runtime.Object
+		args             args
+		want             bool
+		wantLevel        uint
+		wantErasureCoded bool
+		wantErr          bool
 	}{
 		{
 			name: "filesystem replication should stay at 1, rook version 1.9.12",
@@ -1261,6 +1452,53 @@ func TestController_SetFilesystemReplication(t *testing.T) {
 			wantLevel: 3,
 			wantErr:   false,
 		},
+		{
+			name: "erasure coded data pool should be left alone, metadata pool should increase from 1 to 3, rook version 1.9.12",
+			rookResources: []runtime.Object{
+				&cephv1.CephFilesystem{
+					TypeMeta: metav1.TypeMeta{
+						APIVersion: "ceph.rook.io/v1",
+						Kind:       "CephFilesystem",
+					},
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "myfs",
+						Namespace: "rook-ceph",
+					},
+					Spec: cephv1.FilesystemSpec{
+						MetadataPool: cephv1.NamedPoolSpec{
+							Name: "myfs-metadata",
+							PoolSpec: cephv1.PoolSpec{
+								Replicated: cephv1.ReplicatedSpec{
+									Size: 1,
+								},
+							},
+						},
+						DataPools: []cephv1.NamedPoolSpec{
+							{
+								Name: "myfs-data0",
+								PoolSpec: cephv1.PoolSpec{
+									ErasureCoded: cephv1.ErasureCodedSpec{
+										DataChunks:   3,
+										CodingChunks: 2,
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+			args: args{
+				rookVersion:     semver.MustParse("1.9.12"),
+				cephVersion:     &cephPacific,
+				name:            "myfs",
+				level:           3,
+				doFullReconcile: false,
+			},
+			want:             true,
+			wantLevel:        3,
+			wantErasureCoded: true,
+			wantErr:          false,
+		},
 		// TODO: rookVersion 1.0.4
 	}
 	for _, tt := range tests {
@@ -1288,7 +1526,12 @@ func TestController_SetFilesystemReplication(t *testing.T) {
 			if cephFs.Spec.MetadataPool.Replicated.Size != tt.wantLevel {
 				t.Errorf("CephFilesystem.Spec.MetadataPool.Replicated.Size = %d, want %d", cephFs.Spec.MetadataPool.Replicated.Size, tt.wantLevel)
 			}
-			if cephFs.Spec.DataPools[0].Replicated.Size != tt.wantLevel {
+			if tt.wantErasureCoded {
+				dataPool := cephFs.Spec.DataPools[0]
+				if dataPool.Replicated.Size != 0 || dataPool.ErasureCoded.DataChunks != 3 || dataPool.ErasureCoded.CodingChunks != 2 {
+					t.Errorf("CephFilesystem.Spec.DataPools[0] = %+v, want unchanged erasure coded pool", dataPool.PoolSpec)
+				}
+			} else if cephFs.Spec.DataPools[0].Replicated.Size != tt.wantLevel {
 				t.Errorf("CephFilesystem.Spec.DataPools[0].Replicated.Size = %d, want %d", cephFs.Spec.DataPools[0].Replicated.Size, tt.wantLevel)
 			}
 		})
@@ -1311,6 +1554,7 @@ func TestController_SetObjectStoreReplication(t *testing.T) {
 		args                    args
 		want                    bool
 		wantLevel               uint
+		wantErasureCoded        bool
 		wantErr                 bool
 	}{
 		{
@@ -1494,6 +1738,45 @@ func TestController_SetObjectStoreReplication(t *testing.T) {
 			wantLevel: 1,
 			wantErr:   false,
 		},
+		{
+			name: "erasure coded data pool should be left alone, metadata pool should increase from 1 to 3, rook version 1.9.12",
+			rookResources: []runtime.Object{
+				&cephv1.CephObjectStore{
+					TypeMeta: metav1.TypeMeta{
+						APIVersion: "ceph.rook.io/v1",
+						Kind:       "CephObjectStore",
+					},
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "my-store",
+						Namespace: "rook-ceph",
+					},
+					Spec: cephv1.ObjectStoreSpec{
+						MetadataPool: cephv1.PoolSpec{
+							Replicated: cephv1.ReplicatedSpec{
+								Size: 1,
+							},
+						},
+						DataPool: cephv1.PoolSpec{
+							ErasureCoded: cephv1.ErasureCodedSpec{
+								DataChunks:   3,
+								CodingChunks: 2,
+							},
+						},
+					},
+				},
+			},
+			args: args{
+				rookVersion:     semver.MustParse("1.9.12"),
+				cephVersion:     &cephPacific,
+				name:            "my-store",
+				level:           3,
+				doFullReconcile: false,
+			},
+			want:             true,
+			wantLevel:        3,
+			wantErasureCoded: true,
+			wantErr:          false,
+		},
 		// TODO: rookVersion 1.0.4
 	}
 	for _, tt := range tests {
@@ -1533,9 +1816,55 @@ func TestController_SetObjectStoreReplication(t *testing.T) {
 			if cephOS.Spec.MetadataPool.Replicated.Size != tt.wantLevel {
 				t.Errorf("CephObjectStore.Spec.MetadataPool.Replicated.Size = %d, want %d", cephOS.Spec.MetadataPool.Replicated.Size, tt.args.level)
 			}
-			if cephOS.Spec.DataPool.Replicated.Size != tt.wantLevel {
+			if tt.wantErasureCoded {
+				dataPool := cephOS.Spec.DataPool
+				if dataPool.Replicated.Size != 0 || dataPool.ErasureCoded.DataChunks != 3 || dataPool.ErasureCoded.CodingChunks != 2 {
+					t.Errorf("CephObjectStore.Spec.DataPool = %+v, want unchanged erasure coded pool", dataPool)
+				}
+			} else if cephOS.Spec.DataPool.Replicated.Size != tt.wantLevel {
 				t.Errorf("CephObjectStore.Spec.DataPool.Replicated.Size = %d, want %d", cephOS.Spec.DataPool.Replicated.Size, tt.args.level)
 			}
 		})
 	}
 }
+
+func Test_setObjectStoreErasureCoding(t *testing.T) {
+	values := map[string]interface{}{
+		"cephObjectStores": []interface{}{
+			map[string]interface{}{
+				"name": "rook-ceph-store",
+				"spec": map[string]interface{}{
+					"metadataPool": map[string]interface{}{
+						"replicated": map[string]interface{}{"size": 3},
+					},
+					"dataPool": map[string]interface{}{
+						"replicated": map[string]interface{}{"size": 3},
+					},
+				},
+			},
+		},
+	}
+
+	err := setObjectStoreErasureCoding(values, 3, 2)
+	if err != nil {
+		t.Fatalf("setObjectStoreErasureCoding() error = %v", err)
+	}
+
+	spec := values["cephObjectStores"].([]interface{})[0].(map[string]interface{})["spec"].(map[string]interface{})
+	wantDataPool := map[string]interface{}{
+		"failureDomain": "host",
+		"erasureCoded": map[string]interface{}{
+			"dataChunks":   3,
+			"codingChunks": 2,
+		},
+	}
+	if !reflect.DeepEqual(spec["dataPool"], wantDataPool) {
+		t.Errorf("dataPool = %v, want %v", spec["dataPool"], wantDataPool)
+	}
+	wantMetadataPool := map[string]interface{}{
+		"replicated": map[string]interface{}{"size": 3},
+	}
+	if !reflect.DeepEqual(spec["metadataPool"], wantMetadataPool) {
+		t.Errorf("metadataPool = %v, want %v", spec["metadataPool"], wantMetadataPool)
+	}
+}
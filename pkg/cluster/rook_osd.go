@@ -0,0 +1,408 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/blang/semver"
+	"github.com/pkg/errors"
+	"github.com/replicatedhq/ekco/pkg/util"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+)
+
+type cephOSDDump struct {
+	OSDs []cephOSDDumpOSD `json:"osds"`
+}
+
+type cephOSDDumpOSD struct {
+	ID   int    `json:"osd"`
+	UUID string `json:"uuid"`
+	Up   int    `json:"up"`
+	In   int    `json:"in"`
+}
+
+func parseCephOSDDump(s string) (map[string]cephOSDDumpOSD, error) {
+	dump := cephOSDDump{}
+	if err := json.Unmarshal([]byte(s), &dump); err != nil {
+		return nil, errors.Wrap(err, "unmarshal ceph osd dump")
+	}
+	osds := map[string]cephOSDDumpOSD{}
+	for _, osd := range dump.OSDs {
+		osds[strconv.Itoa(osd.ID)] = osd
+	}
+	return osds, nil
+}
+
+// pendingOSDClean is an OSD being replaced that has passed safe-to-destroy. It is recorded in the
+// clean-osd configmap until the OSD is purged, its deployment deleted and its device cleaned, so
+// that a failed step is retried on the next reconcile.
+type pendingOSDClean struct {
+	// the kubernetes.io/hostname label of the node
+	Hostname  string `json:"hostname"`
+	UUID      string `json:"uuid"`
+	BlockPath string `json:"blockPath,omitempty"`
+}
+
+// ReconcileFailedOSDs replaces OSDs that have been down for longer than downToleration on nodes
+// that are still Ready. Nodes that are not ready are handled by the node purge. An OSD is marked
+// out and, once Ceph reports it is safe to destroy, it is purged, its deployment deleted and its
+// device cleaned so that Rook will prepare a new OSD on it. At most one OSD is replaced at a time
+// and a replacement that fails after the OSD is safe to destroy is retried before any other.
+func (c *Controller) ReconcileFailedOSDs(ctx context.Context, rookVersion semver.Version, nodes []corev1.Node, downToleration time.Duration) error {
+	cm, err := c.getCleanOSDConfigMap(ctx)
+	if err != nil {
+		return err
+	}
+	if len(cm.Data) > 0 {
+		osds, err := c.cephOSDDump(ctx, rookVersion)
+		if err != nil {
+			return err
+		}
+		return c.finishOSDReplacements(ctx, rookVersion, cm, osds)
+	}
+
+	opts := metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(map[string]string{"app": "rook-ceph-osd"}).String(),
+	}
+	deploys, err := c.Config.Client.AppsV1().Deployments(RookCephNS).List(ctx, opts)
+	if err != nil {
+		return errors.Wrap(err, "list Rook OSD deployments")
+	}
+	if len(deploys.Items) == 0 {
+		return nil
+	}
+
+	osds, err := c.cephOSDDump(ctx, rookVersion)
+	if err != nil {
+		return err
+	}
+
+	// OSD deployments select their node by the hostname label, which may differ from the node name
+	readyNodes := map[string]bool{}
+	for _, node := range nodes {
+		hostname := node.Labels[corev1.LabelHostname]
+		if hostname == "" {
+			hostname = node.Name
+		}
+		readyNodes[hostname] = util.NodeIsReady(node)
+	}
+
+	for i := range deploys.Items {
+		deploy := &deploys.Items[i]
+		osdID := deploy.Labels["ceph-osd-id"]
+		hostname := deploy.Spec.Template.Spec.NodeSelector[corev1.LabelHostname]
+
+		downSince, isTracked := deploy.Annotations[OSDDownSinceAnnotation]
+		osd, ok := osds[osdID]
+		if !ok {
+			// the OSD was purged outside of ekco
+			if isTracked {
+				return c.deleteOSDDeployment(ctx, deploy, osdID, hostname)
+			}
+			continue
+		}
+
+		if osd.Up == 1 {
+			if isTracked {
+				c.Log.Infof("OSD %s on node %s is up", osdID, hostname)
+				if err := c.annotateOSDDeployment(ctx, deploy.Name, nil); err != nil {
+					return err
+				}
+				c.recordEventf(deploy, corev1.EventTypeNormal, "OSDRecovered", "OSD %s on node %s is up", osdID, hostname)
+			}
+			continue
+		}
+		if !readyNodes[hostname] {
+			continue
+		}
+
+		if !isTracked {
+			c.Log.Infof("OSD %s on ready node %s is down", osdID, hostname)
+			now := time.Now()
+			if err := c.annotateOSDDeployment(ctx, deploy.Name, &now); err != nil {
+				return err
+			}
+			c.recordEventf(deploy, corev1.EventTypeWarning, "OSDDown", "OSD %s on ready node %s is down", osdID, hostname)
+			continue
+		}
+		since, err := time.Parse(time.RFC3339, downSince)
+		if err != nil {
+			c.Log.Warnf("Failed to parse annotation %s on deployment %s: %v", OSDDownSinceAnnotation, deploy.Name, err)
+			continue
+		}
+		if time.Since(since) < downToleration {
+			continue
+		}
+
+		return c.replaceFailedOSD(ctx, rookVersion, cm, osds, deploy, hostname)
+	}
+
+	return nil
+}
+
+func (c *Controller) cephOSDDump(ctx context.Context, rookVersion semver.Version) (map[string]cephOSDDumpOSD, error) {
+	stdout, err := c.rookCephExecOutput(ctx, rookVersion, "ceph", "osd", "dump", "--format", "json")
+	if err != nil {
+		return nil, errors.Wrap(err, "ceph osd dump")
+	}
+	return parseCephOSDDump(stdout)
+}
+
+func (c *Controller) replaceFailedOSD(ctx context.Context, rookVersion semver.Version, cm *corev1.ConfigMap, osds map[string]cephOSDDumpOSD, deploy *appsv1.Deployment, hostname string) error {
+	osdID := deploy.Labels["ceph-osd-id"]
+	osd := osds[osdID]
+
+	if osd.In == 1 {
+		if err := c.rookCephExec(ctx, rookVersion, "ceph", "osd", "out", osdID); err != nil {
+			return errors.Wrapf(err, "mark OSD %s out", osdID)
+		}
+		c.Log.Infof("Marked OSD %s on node %s out", osdID, hostname)
+		c.recordEventf(deploy, corev1.EventTypeNormal, "OSDMarkedOut", "Marked OSD %s on node %s out", osdID, hostname)
+	}
+
+	// safe-to-destroy exits non-zero until all placement groups have been backfilled to other OSDs
+	if err := c.rookCephExec(ctx, rookVersion, "ceph", "osd", "safe-to-destroy", fmt.Sprintf("osd.%s", osdID)); err != nil {
+		c.Log.Infof("Waiting for data to backfill from OSD %s on node %s", osdID, hostname)
+		c.recordEventf(deploy, corev1.EventTypeNormal, "OSDBackfilling", "Waiting for data to backfill from OSD %s on node %s", osdID, hostname)
+		return nil
+	}
+
+	pending := pendingOSDClean{
+		Hostname:  hostname,
+		UUID:      osd.UUID,
+		BlockPath: osdBlockPath(deploy),
+	}
+	data, err := json.Marshal(pending)
+	if err != nil {
+		return errors.Wrap(err, "marshal pending OSD clean")
+	}
+	cm.Data[osdID] = string(data)
+	cm, err = c.Config.Client.CoreV1().ConfigMaps(cm.Namespace).Update(ctx, cm, metav1.UpdateOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "update configmap %s/%s", c.Config.HostTaskNamespace, CleanOSDValue)
+	}
+
+	return c.finishOSDReplacements(ctx, rookVersion, cm, osds)
+}
+
+// finishOSDReplacements purges each OSD recorded in the clean-osd configmap, deletes its
+// deployment and cleans its device once its pod has terminated. The Rook operator would
+// otherwise scale the deployment up again and the OSD could start on the device while it is
+// being cleaned.
+func (c *Controller) finishOSDReplacements(ctx context.Context, rookVersion semver.Version, cm *corev1.ConfigMap, osds map[string]cephOSDDumpOSD) error {
+	ids := make([]string, 0, len(cm.Data))
+	for osdID := range cm.Data {
+		ids = append(ids, osdID)
+	}
+	sort.Strings(ids)
+
+	for _, osdID := range ids {
+		pending := pendingOSDClean{}
+		if err := json.Unmarshal([]byte(cm.Data[osdID]), &pending); err != nil {
+			return errors.Wrapf(err, "unmarshal pending clean of OSD %s", osdID)
+		}
+		hostname := pending.Hostname
+
+		deploy, err := c.Config.Client.AppsV1().Deployments(RookCephNS).Get(ctx, fmt.Sprintf("rook-ceph-osd-%s", osdID), metav1.GetOptions{})
+		if err != nil {
+			if !util.IsNotFoundErr(err) {
+				return errors.Wrapf(err, "get deployment for OSD %s", osdID)
+			}
+			deploy = nil
+		}
+
+		if _, ok := osds[osdID]; ok {
+			if err := c.execCephOSDPurge(ctx, rookVersion, osdID, ""); err != nil {
+				return errors.Wrapf(err, "purge OSD %s", osdID)
+			}
+			c.Log.Infof("Purged OSD %s on node %s", osdID, hostname)
+			if deploy != nil {
+				c.recordEventf(deploy, corev1.EventTypeNormal, "OSDPurged", "Purged OSD %s on node %s", osdID, hostname)
+			}
+		}
+
+		if deploy != nil {
+			if err := c.deleteOSDDeployment(ctx, deploy, osdID, hostname); err != nil {
+				return err
+			}
+		}
+
+		running, err := c.osdPodsExist(ctx, osdID)
+		if err != nil {
+			return err
+		}
+		if running {
+			c.Log.Infof("Waiting for pod of OSD %s on node %s to terminate before cleaning its device", osdID, hostname)
+			continue
+		}
+
+		if err := c.cleanOSDDevice(ctx, hostname, pending); err != nil {
+			c.Log.Warnf("Failed to clean device for OSD %s on node %s: %v", osdID, hostname, err)
+			if deploy != nil {
+				c.recordEventf(deploy, corev1.EventTypeWarning, "OSDCleanFailed", "Failed to clean device for OSD %s on node %s: %v", osdID, hostname, err)
+			}
+			return errors.Wrapf(err, "clean device for OSD %s", osdID)
+		}
+		c.Log.Infof("Cleaned device for OSD %s on node %s", osdID, hostname)
+		if deploy != nil {
+			c.recordEventf(deploy, corev1.EventTypeNormal, "OSDDeviceCleaned", "Cleaned device for OSD %s on node %s", osdID, hostname)
+		}
+
+		delete(cm.Data, osdID)
+		cm, err = c.Config.Client.CoreV1().ConfigMaps(cm.Namespace).Update(ctx, cm, metav1.UpdateOptions{})
+		if err != nil {
+			return errors.Wrapf(err, "update configmap %s/%s", c.Config.HostTaskNamespace, CleanOSDValue)
+		}
+	}
+
+	return nil
+}
+
+func (c *Controller) osdPodsExist(ctx context.Context, osdID string) (bool, error) {
+	opts := metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(map[string]string{"app": "rook-ceph-osd", "ceph-osd-id": osdID}).String(),
+	}
+	pods, err := c.Config.Client.CoreV1().Pods(RookCephNS).List(ctx, opts)
+	if err != nil {
+		return false, errors.Wrapf(err, "list pods for OSD %s", osdID)
+	}
+	return len(pods.Items) > 0, nil
+}
+
+// getCleanOSDConfigMap returns the configmap of OSDs being replaced by OSD ID, creating it if it
+// does not exist
+func (c *Controller) getCleanOSDConfigMap(ctx context.Context) (*corev1.ConfigMap, error) {
+	client := c.Config.Client.CoreV1().ConfigMaps(c.Config.HostTaskNamespace)
+	cm, err := client.Get(ctx, CleanOSDValue, metav1.GetOptions{})
+	if err != nil {
+		if !util.IsNotFoundErr(err) {
+			return nil, errors.Wrapf(err, "get configmap %s/%s", c.Config.HostTaskNamespace, CleanOSDValue)
+		}
+		cm = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      CleanOSDValue,
+				Namespace: c.Config.HostTaskNamespace,
+			},
+		}
+		cm, err = client.Create(ctx, cm, metav1.CreateOptions{})
+		if err != nil {
+			return nil, errors.Wrapf(err, "create configmap %s/%s", c.Config.HostTaskNamespace, CleanOSDValue)
+		}
+	}
+	if cm.Data == nil {
+		cm.Data = map[string]string{}
+	}
+	return cm, nil
+}
+
+func (c *Controller) deleteOSDDeployment(ctx context.Context, deploy *appsv1.Deployment, osdID, hostname string) error {
+	background := metav1.DeletePropagationBackground
+	err := c.Config.Client.AppsV1().Deployments(RookCephNS).Delete(ctx, deploy.Name, metav1.DeleteOptions{
+		PropagationPolicy: &background,
+	})
+	if err != nil && !util.IsNotFoundErr(err) {
+		return errors.Wrapf(err, "delete deployment %s", deploy.Name)
+	}
+	c.Log.Infof("Deleted OSD Deployment %s", deploy.Name)
+	c.recordEventf(deploy, corev1.EventTypeNormal, "OSDDeploymentDeleted", "Deleted deployment for OSD %s on node %s", osdID, hostname)
+
+	return nil
+}
+
+// annotateOSDDeployment sets the down-since annotation, or removes it if downSince is nil
+func (c *Controller) annotateOSDDeployment(ctx context.Context, name string, downSince *time.Time) error {
+	var value interface{}
+	if downSince != nil {
+		value = downSince.UTC().Format(time.RFC3339)
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{
+				OSDDownSinceAnnotation: value,
+			},
+		},
+	})
+	if err != nil {
+		return errors.Wrap(err, "marshal patch")
+	}
+	_, err = c.Config.Client.AppsV1().Deployments(RookCephNS).Patch(ctx, name, k8stypes.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "annotate deployment %s", name)
+	}
+	return nil
+}
+
+func (c *Controller) cleanOSDDevice(ctx context.Context, hostname string, pending pendingOSDClean) error {
+	dataDirHostPath := "/var/lib/rook"
+	if cluster, err := c.GetCephCluster(ctx); err != nil {
+		c.Log.Warnf("Failed to get CephCluster, using default dataDirHostPath %s: %v", dataDirHostPath, err)
+	} else if cluster.Spec.DataDirHostPath != "" {
+		dataDirHostPath = cluster.Spec.DataDirHostPath
+	}
+
+	task := c.cleanOSDTask(hostname, dataDirHostPath, pending.UUID, pending.BlockPath)
+	if _, err := c.runHostTask(ctx, task); err != nil {
+		return errors.Wrapf(err, "clean OSD task for node %s", hostname)
+	}
+
+	return nil
+}
+
+// osdBlockPath returns the device the OSD was running on from the Rook OSD deployment env
+func osdBlockPath(deploy *appsv1.Deployment) string {
+	for _, container := range deploy.Spec.Template.Spec.Containers {
+		for _, env := range container.Env {
+			if env.Name == "ROOK_BLOCK_PATH" {
+				return env.Value
+			}
+		}
+	}
+	return ""
+}
+
+// cleanOSDTask removes the data dir of the OSD and wipes its device. The UUID and device are
+// passed to the script as arguments rather than in the script so that they are not interpreted by
+// the shell.
+func (c *Controller) cleanOSDTask(nodeName, dataDirHostPath, osdUUID, blockPath string) HostTask {
+	script := fmt.Sprintf(`set -e
+if [ -n "$1" ]; then rm -rf /host/rook/%s/*_"$1"; fi
+# the device may have been physically replaced and will not need to be wiped
+if [ -n "$2" ] && [ -b "/host$2" ]; then wipefs --all "/host$2"; dd if=/dev/zero of="/host$2" bs=1M count=100 oflag=direct,dsync; fi
+`, RookCephNS)
+
+	return HostTask{
+		Name:      CleanOSDValue,
+		Namespace: c.Config.HostTaskNamespace,
+		Node:      nodeName,
+		Image:     c.Config.HostTaskImage,
+		Command: []string{
+			"/bin/bash",
+			"-c",
+			script,
+			CleanOSDValue,
+			osdUUID,
+			blockPath,
+		},
+		Mounts: []HostTaskMount{
+			{
+				Name:      "dev",
+				HostPath:  "/dev",
+				MountPath: "/host/dev",
+			},
+			{
+				Name:      "rook",
+				HostPath:  dataDirHostPath,
+				MountPath: "/host/rook",
+			},
+		},
+		Privileged: true,
+	}
+}
@@ -0,0 +1,400 @@
+package cluster
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/blang/semver"
+	"github.com/golang/mock/gomock"
+	"github.com/replicatedhq/ekco/pkg/cluster/types"
+	mock_k8s "github.com/replicatedhq/ekco/pkg/k8s/mock"
+	"github.com/replicatedhq/ekco/pkg/logger"
+	"github.com/replicatedhq/ekco/pkg/util"
+	rookfake "github.com/rook/rook/pkg/client/clientset/versioned/fake"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const cephOSDDumpOut = `{"epoch":42,"osds":[{"osd":0,"uuid":"1b2f5c3e-7d9a-4f0e-8c1a-2e6d3b4a5f60","up":1,"in":1},{"osd":1,"uuid":"9e8d7c6b-5a4f-4e3d-2c1b-0a9f8e7d6c5b","up":0,"in":1}]}`
+
+func TestParseCephOSDDump(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    map[string]cephOSDDumpOSD
+		wantErr bool
+	}{
+		{
+			name:  "one up one down",
+			input: cephOSDDumpOut,
+			want: map[string]cephOSDDumpOSD{
+				"0": {ID: 0, UUID: "1b2f5c3e-7d9a-4f0e-8c1a-2e6d3b4a5f60", Up: 1, In: 1},
+				"1": {ID: 1, UUID: "9e8d7c6b-5a4f-4e3d-2c1b-0a9f8e7d6c5b", Up: 0, In: 1},
+			},
+		},
+		{
+			name:  "no osds",
+			input: `{"epoch":1,"osds":[]}`,
+			want:  map[string]cephOSDDumpOSD{},
+		},
+		{
+			name:    "invalid",
+			input:   "Error EINVAL",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseCephOSDDump(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseCephOSDDump() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseCephOSDDump() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestController_ReconcileFailedOSDs(t *testing.T) {
+	toolsPod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "rook-ceph-tools-5b8b8b8b8b-5b8b8",
+			Namespace: "rook-ceph",
+			Labels: map[string]string{
+				"app": "rook-ceph-tools",
+			},
+		},
+	}
+	osdDeployment := func(id, hostname, downSince string) *appsv1.Deployment {
+		deploy := &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "rook-ceph-osd-" + id,
+				Namespace: "rook-ceph",
+				Labels: map[string]string{
+					"app":         "rook-ceph-osd",
+					"ceph-osd-id": id,
+				},
+			},
+			Spec: appsv1.DeploymentSpec{
+				Template: corev1.PodTemplateSpec{
+					Spec: corev1.PodSpec{
+						NodeSelector: map[string]string{
+							"kubernetes.io/hostname": hostname,
+						},
+					},
+				},
+			},
+		}
+		if downSince != "" {
+			deploy.Annotations = map[string]string{OSDDownSinceAnnotation: downSince}
+		}
+		return deploy
+	}
+	readyNodes := []corev1.Node{
+		{ObjectMeta: metav1.ObjectMeta{Name: "node1"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "node2"}},
+	}
+	notReadyNodes := []corev1.Node{
+		{ObjectMeta: metav1.ObjectMeta{Name: "node1"}},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "node2"},
+			Spec: corev1.NodeSpec{
+				Taints: []corev1.Taint{{Key: util.UnreachableTaint, Effect: corev1.TaintEffectNoExecute}},
+			},
+		},
+	}
+	twoHoursAgo := time.Now().Add(-2 * time.Hour).UTC().Format(time.RFC3339)
+
+	tests := []struct {
+		name                    string
+		deployments             []*appsv1.Deployment
+		nodes                   []corev1.Node
+		mockSyncExecutorExpects func(*mock_k8s.MockSyncExecutorInterface)
+		pending                 map[string]string
+		osdPods                 []string
+		cleanFails              bool
+		wantTracked             map[string]bool
+		wantDeleted             []string
+		wantPending             []string
+		wantErr                 bool
+	}{
+		{
+			name: "down osd on ready node is tracked",
+			deployments: []*appsv1.Deployment{
+				osdDeployment("0", "node1", ""),
+				osdDeployment("1", "node2", ""),
+			},
+			nodes: readyNodes,
+			mockSyncExecutorExpects: func(m *mock_k8s.MockSyncExecutorInterface) {
+				m.EXPECT().ExecContainer(gomock.Any(), "rook-ceph", "rook-ceph-tools-5b8b8b8b8b-5b8b8", "rook-ceph-tools", "ceph", "osd", "dump", "--format", "json").
+					Return(0, cephOSDDumpOut, "", nil)
+			},
+			wantTracked: map[string]bool{
+				"rook-ceph-osd-0": false,
+				"rook-ceph-osd-1": true,
+			},
+		},
+		{
+			name: "down osd on unreachable node is left to node purge",
+			deployments: []*appsv1.Deployment{
+				osdDeployment("0", "node1", ""),
+				osdDeployment("1", "node2", ""),
+			},
+			nodes: notReadyNodes,
+			mockSyncExecutorExpects: func(m *mock_k8s.MockSyncExecutorInterface) {
+				m.EXPECT().ExecContainer(gomock.Any(), "rook-ceph", "rook-ceph-tools-5b8b8b8b8b-5b8b8", "rook-ceph-tools", "ceph", "osd", "dump", "--format", "json").
+					Return(0, cephOSDDumpOut, "", nil)
+			},
+			wantTracked: map[string]bool{
+				"rook-ceph-osd-0": false,
+				"rook-ceph-osd-1": false,
+			},
+		},
+		{
+			name: "recovered osd is no longer tracked",
+			deployments: []*appsv1.Deployment{
+				osdDeployment("0", "node1", twoHoursAgo),
+				osdDeployment("1", "node2", ""),
+			},
+			nodes: readyNodes,
+			mockSyncExecutorExpects: func(m *mock_k8s.MockSyncExecutorInterface) {
+				m.EXPECT().ExecContainer(gomock.Any(), "rook-ceph", "rook-ceph-tools-5b8b8b8b8b-5b8b8", "rook-ceph-tools", "ceph", "osd", "dump", "--format", "json").
+					Return(0, cephOSDDumpOut, "", nil)
+			},
+			wantTracked: map[string]bool{
+				"rook-ceph-osd-0": false,
+				"rook-ceph-osd-1": true,
+			},
+		},
+		{
+			name: "osd down past toleration is marked out and waits for backfill",
+			deployments: []*appsv1.Deployment{
+				osdDeployment("0", "node1", ""),
+				osdDeployment("1", "node2", twoHoursAgo),
+			},
+			nodes: readyNodes,
+			mockSyncExecutorExpects: func(m *mock_k8s.MockSyncExecutorInterface) {
+				m.EXPECT().ExecContainer(gomock.Any(), "rook-ceph", "rook-ceph-tools-5b8b8b8b8b-5b8b8", "rook-ceph-tools", "ceph", "osd", "dump", "--format", "json").
+					Return(0, cephOSDDumpOut, "", nil)
+				m.EXPECT().ExecContainer(gomock.Any(), "rook-ceph", "rook-ceph-tools-5b8b8b8b8b-5b8b8", "rook-ceph-tools", "ceph", "osd", "out", "1").
+					Return(0, "", "marked out osd.1.", nil)
+				m.EXPECT().ExecContainer(gomock.Any(), "rook-ceph", "rook-ceph-tools-5b8b8b8b8b-5b8b8", "rook-ceph-tools", "ceph", "osd", "safe-to-destroy", "osd.1").
+					Return(16, "", "Error EBUSY: 12 pgs not active+clean", nil)
+			},
+			wantTracked: map[string]bool{
+				"rook-ceph-osd-0": false,
+				"rook-ceph-osd-1": true,
+			},
+		},
+		{
+			name: "down osd on node with a hostname label other than its name is tracked",
+			deployments: []*appsv1.Deployment{
+				osdDeployment("0", "node1", ""),
+				osdDeployment("1", "node2", ""),
+			},
+			nodes: []corev1.Node{
+				{ObjectMeta: metav1.ObjectMeta{Name: "node1.example.com", Labels: map[string]string{"kubernetes.io/hostname": "node1"}}},
+				{ObjectMeta: metav1.ObjectMeta{Name: "node2.example.com", Labels: map[string]string{"kubernetes.io/hostname": "node2"}}},
+			},
+			mockSyncExecutorExpects: func(m *mock_k8s.MockSyncExecutorInterface) {
+				m.EXPECT().ExecContainer(gomock.Any(), "rook-ceph", "rook-ceph-tools-5b8b8b8b8b-5b8b8", "rook-ceph-tools", "ceph", "osd", "dump", "--format", "json").
+					Return(0, cephOSDDumpOut, "", nil)
+			},
+			wantTracked: map[string]bool{
+				"rook-ceph-osd-0": false,
+				"rook-ceph-osd-1": true,
+			},
+		},
+		{
+			name: "failed clean of a purged osd is recorded for retry",
+			deployments: []*appsv1.Deployment{
+				osdDeployment("0", "node1", ""),
+				osdDeployment("1", "node2", twoHoursAgo),
+			},
+			nodes: readyNodes,
+			mockSyncExecutorExpects: func(m *mock_k8s.MockSyncExecutorInterface) {
+				m.EXPECT().ExecContainer(gomock.Any(), "rook-ceph", "rook-ceph-tools-5b8b8b8b8b-5b8b8", "rook-ceph-tools", "ceph", "osd", "dump", "--format", "json").
+					Return(0, cephOSDDumpOut, "", nil)
+				m.EXPECT().ExecContainer(gomock.Any(), "rook-ceph", "rook-ceph-tools-5b8b8b8b8b-5b8b8", "rook-ceph-tools", "ceph", "osd", "out", "1").
+					Return(0, "", "marked out osd.1.", nil)
+				m.EXPECT().ExecContainer(gomock.Any(), "rook-ceph", "rook-ceph-tools-5b8b8b8b8b-5b8b8", "rook-ceph-tools", "ceph", "osd", "safe-to-destroy", "osd.1").
+					Return(0, "OSD(s) 1 are safe to destroy without reducing data durability.", "", nil)
+				m.EXPECT().ExecContainer(gomock.Any(), "rook-ceph", "rook-ceph-tools-5b8b8b8b8b-5b8b8", "rook-ceph-tools", "ceph", "osd", "down", "1").
+					Return(0, "", "", nil)
+				m.EXPECT().ExecContainer(gomock.Any(), "rook-ceph", "rook-ceph-tools-5b8b8b8b8b-5b8b8", "rook-ceph-tools", "ceph", "osd", "purge", "1", "--yes-i-really-mean-it").
+					Return(0, "", "purged osd.1", nil)
+			},
+			cleanFails: true,
+			wantTracked: map[string]bool{
+				"rook-ceph-osd-0": false,
+			},
+			wantDeleted: []string{"rook-ceph-osd-1"},
+			wantPending: []string{"1"},
+			wantErr:     true,
+		},
+		{
+			name: "osd is purged, its deployment deleted and its device cleaned",
+			deployments: []*appsv1.Deployment{
+				osdDeployment("0", "node1", ""),
+				osdDeployment("1", "node2", twoHoursAgo),
+			},
+			nodes: readyNodes,
+			mockSyncExecutorExpects: func(m *mock_k8s.MockSyncExecutorInterface) {
+				m.EXPECT().ExecContainer(gomock.Any(), "rook-ceph", "rook-ceph-tools-5b8b8b8b8b-5b8b8", "rook-ceph-tools", "ceph", "osd", "dump", "--format", "json").
+					Return(0, cephOSDDumpOut, "", nil)
+				m.EXPECT().ExecContainer(gomock.Any(), "rook-ceph", "rook-ceph-tools-5b8b8b8b8b-5b8b8", "rook-ceph-tools", "ceph", "osd", "out", "1").
+					Return(0, "", "marked out osd.1.", nil)
+				m.EXPECT().ExecContainer(gomock.Any(), "rook-ceph", "rook-ceph-tools-5b8b8b8b8b-5b8b8", "rook-ceph-tools", "ceph", "osd", "safe-to-destroy", "osd.1").
+					Return(0, "OSD(s) 1 are safe to destroy without reducing data durability.", "", nil)
+				m.EXPECT().ExecContainer(gomock.Any(), "rook-ceph", "rook-ceph-tools-5b8b8b8b8b-5b8b8", "rook-ceph-tools", "ceph", "osd", "down", "1").
+					Return(0, "", "", nil)
+				m.EXPECT().ExecContainer(gomock.Any(), "rook-ceph", "rook-ceph-tools-5b8b8b8b8b-5b8b8", "rook-ceph-tools", "ceph", "osd", "purge", "1", "--yes-i-really-mean-it").
+					Return(0, "", "purged osd.1", nil)
+			},
+			wantTracked: map[string]bool{
+				"rook-ceph-osd-0": false,
+			},
+			wantDeleted: []string{"rook-ceph-osd-1"},
+		},
+		{
+			name: "pending clean is retried before other osds are replaced",
+			deployments: []*appsv1.Deployment{
+				osdDeployment("0", "node1", ""),
+				osdDeployment("1", "node2", twoHoursAgo),
+			},
+			nodes:   readyNodes,
+			pending: map[string]string{"2": `{"hostname":"node2","uuid":"5f4e3d2c-1b0a-4f9e-8d7c-6b5a4f3e2d1c","blockPath":"/dev/sdc"}`},
+			mockSyncExecutorExpects: func(m *mock_k8s.MockSyncExecutorInterface) {
+				m.EXPECT().ExecContainer(gomock.Any(), "rook-ceph", "rook-ceph-tools-5b8b8b8b8b-5b8b8", "rook-ceph-tools", "ceph", "osd", "dump", "--format", "json").
+					Return(0, cephOSDDumpOut, "", nil)
+			},
+			wantTracked: map[string]bool{
+				"rook-ceph-osd-0": false,
+				"rook-ceph-osd-1": true,
+			},
+		},
+		{
+			name:    "pending clean waits for the osd pod to terminate",
+			nodes:   readyNodes,
+			pending: map[string]string{"2": `{"hostname":"node2","uuid":"5f4e3d2c-1b0a-4f9e-8d7c-6b5a4f3e2d1c","blockPath":"/dev/sdc"}`},
+			osdPods: []string{"2"},
+			mockSyncExecutorExpects: func(m *mock_k8s.MockSyncExecutorInterface) {
+				m.EXPECT().ExecContainer(gomock.Any(), "rook-ceph", "rook-ceph-tools-5b8b8b8b8b-5b8b8", "rook-ceph-tools", "ceph", "osd", "dump", "--format", "json").
+					Return(0, cephOSDDumpOut, "", nil)
+			},
+			wantPending: []string{"2"},
+		},
+		{
+			name: "deployment of purged osd is deleted",
+			deployments: []*appsv1.Deployment{
+				osdDeployment("0", "node1", ""),
+				osdDeployment("2", "node2", twoHoursAgo),
+			},
+			nodes: readyNodes,
+			mockSyncExecutorExpects: func(m *mock_k8s.MockSyncExecutorInterface) {
+				m.EXPECT().ExecContainer(gomock.Any(), "rook-ceph", "rook-ceph-tools-5b8b8b8b8b-5b8b8", "rook-ceph-tools", "ceph", "osd", "dump", "--format", "json").
+					Return(0, cephOSDDumpOut, "", nil)
+			},
+			wantTracked: map[string]bool{
+				"rook-ceph-osd-0": false,
+			},
+			wantDeleted: []string{"rook-ceph-osd-2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			m := mock_k8s.NewMockSyncExecutorInterface(ctrl)
+			if tt.mockSyncExecutorExpects != nil {
+				tt.mockSyncExecutorExpects(m)
+			}
+
+			clientset := fake.NewSimpleClientset(toolsPod)
+			if tt.pending != nil {
+				cm := &corev1.ConfigMap{
+					ObjectMeta: metav1.ObjectMeta{Name: CleanOSDValue, Namespace: "kurl"},
+					Data:       tt.pending,
+				}
+				if _, err := clientset.CoreV1().ConfigMaps("kurl").Create(context.Background(), cm, metav1.CreateOptions{}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, osdID := range tt.osdPods {
+				pod := &corev1.Pod{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "rook-ceph-osd-" + osdID + "-abcde",
+						Namespace: "rook-ceph",
+						Labels:    map[string]string{"app": "rook-ceph-osd", "ceph-osd-id": osdID},
+					},
+				}
+				if _, err := clientset.CoreV1().Pods("rook-ceph").Create(context.Background(), pod, metav1.CreateOptions{}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, deploy := range tt.deployments {
+				_, err := clientset.AppsV1().Deployments("rook-ceph").Create(context.Background(), deploy, metav1.CreateOptions{})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			fakeHostTaskJobs(clientset, func(pod *corev1.Pod) {
+				if tt.cleanFails {
+					pod.Status.Phase = corev1.PodFailed
+				}
+			})
+			c := &Controller{
+				Config: types.ControllerConfig{
+					Client:            clientset,
+					CephV1:            rookfake.NewSimpleClientset().CephV1(),
+					HostTaskNamespace: "kurl",
+				},
+				SyncExecutor: m,
+				Log:          logger.NewDiscardLogger(),
+			}
+
+			err := c.ReconcileFailedOSDs(context.Background(), semver.MustParse("1.9.12"), tt.nodes, time.Hour)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Controller.ReconcileFailedOSDs() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			for name, wantTracked := range tt.wantTracked {
+				deploy, err := clientset.AppsV1().Deployments("rook-ceph").Get(context.Background(), name, metav1.GetOptions{})
+				if err != nil {
+					t.Errorf("Deployments.Get(%q) error = %v", name, err)
+					continue
+				}
+				_, tracked := deploy.Annotations[OSDDownSinceAnnotation]
+				if tracked != wantTracked {
+					t.Errorf("Deployment %s annotation %s present = %v, want %v", name, OSDDownSinceAnnotation, tracked, wantTracked)
+				}
+			}
+			for _, name := range tt.wantDeleted {
+				_, err := clientset.AppsV1().Deployments("rook-ceph").Get(context.Background(), name, metav1.GetOptions{})
+				if !util.IsNotFoundErr(err) {
+					t.Errorf("Deployments.Get(%q) error = %v, want not found", name, err)
+				}
+			}
+
+			cm, err := clientset.CoreV1().ConfigMaps("kurl").Get(context.Background(), CleanOSDValue, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("ConfigMaps.Get(%q) error = %v", CleanOSDValue, err)
+			}
+			var pending []string
+			for osdID := range cm.Data {
+				pending = append(pending, osdID)
+			}
+			if !reflect.DeepEqual(pending, tt.wantPending) {
+				t.Errorf("pending OSD cleans = %v, want %v", pending, tt.wantPending)
+			}
+		})
+	}
+}
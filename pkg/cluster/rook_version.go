@@ -0,0 +1,179 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/blang/semver"
+	"github.com/pkg/errors"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+)
+
+// ErrRookVersionUnknown is returned by GetRookVersion when Rook is installed but its version cannot
+// be determined. Rook is not managed until the version is known.
+var ErrRookVersionUnknown = errors.New("rook version unknown")
+
+var rookVersionRX = regexp.MustCompile(`^v?(\d+\.\d+\.\d+(?:-[0-9A-Za-z.-]+)?)$`)
+
+// rookVersionCache holds the version discovered for a generation of the rook-ceph-operator
+// deployment, or the error if discovery failed
+type rookVersionCache struct {
+	uid        k8stypes.UID
+	generation int64
+	version    semver.Version
+	err        error
+}
+
+// GetRookVersion returns the version of the running Rook operator. The version is discovered from
+// the version labels of the rook-ceph-operator deployment, then the tag of its rook-ceph-operator
+// container image, then the rook-version label the operator sets on the CephCluster daemons it has
+// reconciled. The result, including a failure, is cached until the operator deployment changes. NotFound errors are
+// returned if Rook is not installed and ErrRookVersionUnknown if no version could be found.
+func (c *Controller) GetRookVersion(ctx context.Context) (*semver.Version, error) {
+	_, err := c.Config.Client.CoreV1().Namespaces().Get(ctx, RookCephNS, metav1.GetOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "get rook-ceph namespace")
+	}
+
+	deploy, err := c.Config.Client.AppsV1().Deployments(RookCephNS).Get(ctx, "rook-ceph-operator", metav1.GetOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "get rook-ceph-operator deployment")
+	}
+
+	c.rookVersionMtx.Lock()
+	defer c.rookVersionMtx.Unlock()
+
+	if cached := c.rookVersionCache; cached != nil && cached.uid == deploy.UID && cached.generation == deploy.Generation {
+		if cached.err != nil {
+			return nil, cached.err
+		}
+		version := cached.version
+		return &version, nil
+	}
+
+	version, source, err := c.discoverRookVersion(ctx, deploy)
+	if err != nil {
+		c.recordEventf(deploy, corev1.EventTypeWarning, "RookVersionUnknown", "Rook will not be managed: %v", err)
+		c.rookVersionCache = &rookVersionCache{
+			uid:        deploy.UID,
+			generation: deploy.Generation,
+			err:        err,
+		}
+		return nil, err
+	}
+	c.Log.Debugf("Discovered Rook version %s from %s", version, source)
+
+	c.rookVersionCache = &rookVersionCache{
+		uid:        deploy.UID,
+		generation: deploy.Generation,
+		version:    version,
+	}
+	return &version, nil
+}
+
+// returns the version and a description of where it was found
+func (c *Controller) discoverRookVersion(ctx context.Context, deploy *appsv1.Deployment) (semver.Version, string, error) {
+	if version, label, ok := rookVersionFromLabels(deploy.Labels); ok {
+		return version, fmt.Sprintf("rook-ceph-operator label %s", label), nil
+	}
+
+	var imageErr error
+	image := rookOperatorImage(deploy)
+	if image == "" {
+		imageErr = errors.New("rook-ceph-operator container not found in deployment")
+	} else if version, err := rookImageVersion(image); err != nil {
+		imageErr = err
+	} else {
+		return version, fmt.Sprintf("rook-ceph-operator image %s", image), nil
+	}
+
+	version, err := c.rookVersionFromCephDaemons(ctx)
+	if err == nil {
+		return version, "CephCluster daemon rook-version label", nil
+	}
+	c.Log.Debugf("Failed to get Rook version from CephCluster daemons: %v", err)
+
+	return semver.Version{}, "", errors.Wrapf(ErrRookVersionUnknown, "%v", imageErr)
+}
+
+func rookVersionFromLabels(deployLabels map[string]string) (semver.Version, string, bool) {
+	for _, label := range []string{"app.kubernetes.io/version", "helm.sh/chart", "chart"} {
+		value, ok := deployLabels[label]
+		if !ok {
+			continue
+		}
+		version, err := parseRookVersion(strings.TrimPrefix(value, "rook-ceph-"))
+		if err == nil {
+			return version, label, true
+		}
+	}
+	return semver.Version{}, "", false
+}
+
+func rookOperatorImage(deploy *appsv1.Deployment) string {
+	for _, container := range deploy.Spec.Template.Spec.Containers {
+		if container.Name == "rook-ceph-operator" {
+			return container.Image
+		}
+	}
+	return ""
+}
+
+// rookImageVersion parses the version from the tag of an image such as
+// registry.local:5000/rook/ceph:v1.12.3@sha256:...
+func rookImageVersion(image string) (semver.Version, error) {
+	name := strings.SplitN(image, "@", 2)[0]
+	// a colon before the last slash is a registry port
+	repo := name[strings.LastIndex(name, "/")+1:]
+	i := strings.LastIndex(repo, ":")
+	if i == -1 {
+		return semver.Version{}, fmt.Errorf("image %s has no tag", image)
+	}
+	version, err := parseRookVersion(repo[i+1:])
+	if err != nil {
+		return semver.Version{}, errors.Wrapf(err, "image %s", image)
+	}
+	return version, nil
+}
+
+func parseRookVersion(s string) (semver.Version, error) {
+	matches := rookVersionRX.FindStringSubmatch(s)
+	if matches == nil {
+		return semver.Version{}, fmt.Errorf("%q is not a version", s)
+	}
+	return semver.Parse(matches[1])
+}
+
+// rookVersionFromCephDaemons returns the lowest rook-version label of the mon, mgr and osd
+// deployments. These are set by the operator when it reconciles the CephCluster.
+func (c *Controller) rookVersionFromCephDaemons(ctx context.Context) (semver.Version, error) {
+	selector, err := labels.Parse("app in (rook-ceph-mon,rook-ceph-mgr,rook-ceph-osd),rook-version")
+	if err != nil {
+		return semver.Version{}, errors.Wrap(err, "parse selector")
+	}
+	deploys, err := c.Config.Client.AppsV1().Deployments(RookCephNS).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return semver.Version{}, errors.Wrap(err, "list CephCluster daemon deployments")
+	}
+
+	var lowest *semver.Version
+	for _, deploy := range deploys.Items {
+		version, err := parseRookVersion(deploy.Labels["rook-version"])
+		if err != nil {
+			continue
+		}
+		if lowest == nil || version.LT(*lowest) {
+			lowest = &version
+		}
+	}
+	if lowest == nil {
+		return semver.Version{}, errors.New("no CephCluster daemons with a rook-version label")
+	}
+	return *lowest, nil
+}
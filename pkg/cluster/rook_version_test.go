@@ -0,0 +1,166 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/replicatedhq/ekco/pkg/cluster/types"
+	"github.com/replicatedhq/ekco/pkg/logger"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func Test_rookImageVersion(t *testing.T) {
+	tests := []struct {
+		image   string
+		want    string
+		wantErr bool
+	}{
+		{image: "rook/ceph:v1.9.12", want: "1.9.12"},
+		{image: "rook/ceph:1.9.12", want: "1.9.12"},
+		{image: "kurlsh/rook-ceph:v1.0.4-9065b09-20210625", want: "1.0.4-9065b09-20210625"},
+		{image: "registry.local:5000/rook/ceph:v1.12.3", want: "1.12.3"},
+		{image: "registry.local:5000/rook/ceph:v1.12.3@sha256:4f4c1f8bb38dc7ab3bd9f0ab2d3ee0bf", want: "1.12.3"},
+		{image: "registry.local:5000/rook/ceph@sha256:4f4c1f8bb38dc7ab3bd9f0ab2d3ee0bf", wantErr: true},
+		{image: "registry.local:5000/rook/ceph", wantErr: true},
+		{image: "rook/ceph:master", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.image, func(t *testing.T) {
+			got, err := rookImageVersion(tt.image)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("rookImageVersion() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && got.String() != tt.want {
+				t.Errorf("rookImageVersion() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestController_GetRookVersion_cache(t *testing.T) {
+	deploy := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       "rook-ceph-operator",
+			Namespace:  "rook-ceph",
+			UID:        "0ba5e0ba-1f1c-4e4c-9d5b-4c6f6a0b8e21",
+			Generation: 1,
+		},
+		Spec: appsv1.DeploymentSpec{
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Name:  "rook-ceph-operator",
+							Image: "rook/ceph:v1.9.12",
+						},
+					},
+				},
+			},
+		},
+	}
+	clientset := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "rook-ceph"}},
+		deploy,
+	)
+	c := &Controller{
+		Config: types.ControllerConfig{
+			Client: clientset,
+		},
+		Log: logger.NewDiscardLogger(),
+	}
+
+	got, err := c.GetRookVersion(context.Background())
+	if err != nil {
+		t.Fatalf("Controller.GetRookVersion() error = %v", err)
+	}
+	if got.String() != "1.9.12" {
+		t.Errorf("Controller.GetRookVersion() = %s, want 1.9.12", got)
+	}
+
+	// the cached version is used while the deployment generation is unchanged
+	c.rookVersionCache.version.Minor = 8
+	got, err = c.GetRookVersion(context.Background())
+	if err != nil {
+		t.Fatalf("Controller.GetRookVersion() error = %v", err)
+	}
+	if got.String() != "1.8.12" {
+		t.Errorf("Controller.GetRookVersion() = %s, want cached 1.8.12", got)
+	}
+
+	deploy.Generation = 2
+	deploy.Spec.Template.Spec.Containers[0].Image = "rook/ceph:v1.12.3"
+	if _, err := clientset.AppsV1().Deployments("rook-ceph").Update(context.Background(), deploy, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	got, err = c.GetRookVersion(context.Background())
+	if err != nil {
+		t.Fatalf("Controller.GetRookVersion() error = %v", err)
+	}
+	if got.String() != "1.12.3" {
+		t.Errorf("Controller.GetRookVersion() = %s, want 1.12.3", got)
+	}
+}
+
+func TestController_GetRookVersion_cacheUnknown(t *testing.T) {
+	deploy := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       "rook-ceph-operator",
+			Namespace:  "rook-ceph",
+			UID:        "0ba5e0ba-1f1c-4e4c-9d5b-4c6f6a0b8e21",
+			Generation: 1,
+		},
+		Spec: appsv1.DeploymentSpec{
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Name:  "rook-ceph-operator",
+							Image: "rook/ceph:latest",
+						},
+					},
+				},
+			},
+		},
+	}
+	clientset := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "rook-ceph"}},
+		deploy,
+	)
+	recorder := record.NewFakeRecorder(10)
+	c := &Controller{
+		Config: types.ControllerConfig{
+			Client: clientset,
+		},
+		Log:      logger.NewDiscardLogger(),
+		Recorder: recorder,
+	}
+
+	// the failure is cached and the event recorded once while the deployment generation is unchanged
+	for i := 0; i < 2; i++ {
+		if _, err := c.GetRookVersion(context.Background()); !errors.Is(err, ErrRookVersionUnknown) {
+			t.Fatalf("Controller.GetRookVersion() error = %v, want ErrRookVersionUnknown", err)
+		}
+	}
+	if len(recorder.Events) != 1 {
+		t.Errorf("Controller.GetRookVersion() recorded %d events, want 1", len(recorder.Events))
+	}
+
+	deploy.Generation = 2
+	deploy.Spec.Template.Spec.Containers[0].Image = "rook/ceph:v1.12.3"
+	if _, err := clientset.AppsV1().Deployments("rook-ceph").Update(context.Background(), deploy, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.GetRookVersion(context.Background())
+	if err != nil {
+		t.Fatalf("Controller.GetRookVersion() error = %v", err)
+	}
+	if got.String() != "1.12.3" {
+		t.Errorf("Controller.GetRookVersion() = %s, want 1.12.3", got)
+	}
+}
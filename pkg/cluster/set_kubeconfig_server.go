@@ -3,34 +3,197 @@ package cluster
 import (
 	"context"
 	"fmt"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/pkg/errors"
+	"github.com/replicatedhq/ekco/pkg/hosttask"
+	"github.com/replicatedhq/ekco/pkg/kubeconfig"
 	"github.com/replicatedhq/ekco/pkg/util"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
 )
 
-func (c *Controller) SetKubeconfigServer(ctx context.Context, node corev1.Node, server string) error {
-	c.Log.Infof("Scheduling set-kubeconfig-server task on node %s", node.Name)
+// key of the kubeconfig in the kube-proxy ConfigMap written by kubeadm
+const kubeProxyKubeconfigKey = "kubeconfig.conf"
 
-	admin := util.NodeIsMaster(node)
-	pod := c.setKubeconfigServerPod(node.Name, server, admin)
+// time allowed for a node to report Ready through the new server after kubelet is restarted
+const nodeReadyTimeout = 5 * time.Minute
 
-	pod, err := c.Config.Client.CoreV1().Pods(c.Config.HostTaskNamespace).Create(context.TODO(), pod, metav1.CreateOptions{})
-	if err != nil {
-		return errors.Wrapf(err, "create set-kubeconfig-server pod on node %s", node.Name)
+// time allowed for the check of the load balancer from a node, including retries
+const checkLoadBalancerTimeout = 2 * time.Minute
+
+// ChangeKubeconfigServer switches the kubeconfigs on the nodes to server. Every node must first be
+// able to resolve server, verify its certificate and reach /readyz through it. The nodes are then
+// updated one at a time and each must be Ready again before the next is updated. A node restores
+// its own kubeconfigs if its kubelet cannot renew the lease of the node through server, as the
+// operator cannot run tasks on it then. If a node fails, the nodes updated before it are rolled back
+// to the server they used before.
+func (c *Controller) ChangeKubeconfigServer(ctx context.Context, nodes []corev1.Node, server string) error {
+	previous := map[string]string{}
+	var failed []string
+	for _, node := range nodes {
+		current, err := c.CheckLoadBalancer(ctx, node, server)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", node.Name, err))
+			continue
+		}
+		previous[node.Name] = current
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("load balancer %s check failed on nodes %s", server, strings.Join(failed, "; "))
+	}
+
+	var updated []corev1.Node
+	for _, node := range nodes {
+		start := time.Now()
+		err := c.SetKubeconfigServer(ctx, node, server)
+		if err == nil {
+			err = c.waitForNodeReady(ctx, node.Name, start)
+		}
+		if err != nil {
+			c.Log.Errorf("Failed to switch node %s to %s, rolling back: %v", node.Name, server, err)
+			c.rollbackKubeconfigServer(context.WithoutCancel(ctx), updated, previous)
+			return errors.Wrapf(err, "switch node %s to %s", node.Name, server)
+		}
+		// the node is only rolled back by the operator once it is Ready through server
+		updated = append(updated, node)
+	}
+
+	var servers []string
+	for _, prev := range previous {
+		if prev != "" && !slices.Contains(servers, prev) {
+			servers = append(servers, prev)
+		}
+	}
+	if err := c.updateKubeProxyServer(ctx, servers, server); err != nil {
+		return errors.Wrap(err, "update kube-proxy kubeconfig")
+	}
+
+	return nil
+}
+
+// updateKubeProxyServer sets server in the kubeconfig of kube-proxy if it uses one of the previous
+// servers of the nodes and restarts kube-proxy
+func (c *Controller) updateKubeProxyServer(ctx context.Context, previous []string, server string) error {
+	cm, err := c.Config.Client.CoreV1().ConfigMaps("kube-system").Get(ctx, "kube-proxy", metav1.GetOptions{})
+	if util.IsNotFoundErr(err) {
+		return nil
+	} else if err != nil {
+		return errors.Wrap(err, "get kube-proxy configmap")
+	}
+	data := []byte(cm.Data[kubeProxyKubeconfigKey])
+	if len(data) == 0 {
+		return nil
+	}
+
+	changed := false
+	for _, p := range previous {
+		updated, ok, err := kubeconfig.UpdateServerData(data, p, server)
+		if err != nil {
+			return errors.Wrap(err, "update kubeconfig")
+		}
+		data = updated
+		changed = changed || ok
+	}
+	if !changed {
+		return nil
+	}
+
+	cm.Data[kubeProxyKubeconfigKey] = string(data)
+	if _, err := c.Config.Client.CoreV1().ConfigMaps("kube-system").Update(ctx, cm, metav1.UpdateOptions{}); err != nil {
+		return errors.Wrap(err, "update kube-proxy configmap")
 	}
+	c.Log.Infof("Set server %s in kube-proxy configmap", server)
 
-	err = c.pollForPodCompleted(ctx, c.Config.HostTaskNamespace, pod.Name)
+	patch := fmt.Sprintf(`{"spec":{"template":{"metadata":{"annotations":{"kubectl.kubernetes.io/restartedAt":"%s"}}}}}`, time.Now().Format(time.RFC3339))
+	if _, err := c.Config.Client.AppsV1().DaemonSets("kube-system").Patch(ctx, "kube-proxy", k8stypes.StrategicMergePatchType, []byte(patch), metav1.PatchOptions{}); err != nil {
+		return errors.Wrap(err, "restart kube-proxy")
+	}
+	return nil
+}
+
+// rollbackKubeconfigServer sets the server of the nodes back to their previous server, most recently
+// updated first
+func (c *Controller) rollbackKubeconfigServer(ctx context.Context, nodes []corev1.Node, previous map[string]string) {
+	for _, node := range slices.Backward(nodes) {
+		server := previous[node.Name]
+		if server == "" {
+			c.Log.Warnf("Not rolling back node %s: previous server unknown", node.Name)
+			continue
+		}
+		if err := c.SetKubeconfigServer(ctx, node, server); err != nil {
+			c.Log.Errorf("Failed to roll back node %s to %s: %v", node.Name, server, err)
+			continue
+		}
+		c.Log.Infof("Rolled back node %s to %s", node.Name, server)
+	}
+}
+
+// CheckLoadBalancer runs the check-load-balancer task on the node and returns the server its
+// kubelet.conf uses
+func (c *Controller) CheckLoadBalancer(ctx context.Context, node corev1.Node, server string) (string, error) {
+	c.Log.Infof("Checking load balancer %s from node %s", server, node.Name)
+
+	result, err := c.runHostTask(ctx, c.checkLoadBalancerTask(node.Name, server))
 	if err != nil {
-		if err == errPodFailed {
-			c.logPodResults(ctx, c.Config.HostTaskNamespace, pod.Name)
+		return "", errors.Wrapf(err, "run check-load-balancer task on node %s", node.Name)
+	}
+	if result == nil {
+		return "", nil
+	}
+	return result.Outputs["server"], nil
+}
+
+// waitForNodeReady waits for the node to be Ready with its lease renewed after since, which the
+// kubelet does only once it is connected to the API server
+func (c *Controller) waitForNodeReady(ctx context.Context, nodeName string, since time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, nodeReadyTimeout)
+	defer cancel()
+
+	ticker := time.NewTicker(time.Second * 2)
+	defer ticker.Stop()
+
+	for {
+		err := c.nodeReadySince(ctx, nodeName, since)
+		if err == nil {
+			return nil
+		}
+		c.Log.Debugf("Waiting for node %s: %v", nodeName, err)
+
+		select {
+		case <-ctx.Done():
+			return errors.Wrapf(ctx.Err(), "node %s not ready: %v", nodeName, err)
+		case <-ticker.C:
 		}
-		return errors.Wrapf(err, "run set-kubeconfig-server pod on node %s", node.Name)
 	}
+}
 
-	if err := c.deletePods(ctx, c.Config.HostTaskNamespace, SetKubeconfigServerSelector); err != nil {
-		c.Log.Warnf("Failed to delete set-kubeconfig-server pods: %v", err)
+func (c *Controller) nodeReadySince(ctx context.Context, nodeName string, since time.Time) error {
+	node, err := c.Config.Client.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+	if err != nil {
+		return errors.Wrap(err, "get node")
+	}
+	if !util.NodeIsReady(*node) {
+		return fmt.Errorf("not ready")
+	}
+	lease, err := c.Config.Client.CoordinationV1().Leases("kube-node-lease").Get(ctx, nodeName, metav1.GetOptions{})
+	if err != nil {
+		return errors.Wrap(err, "get lease")
+	}
+	if lease.Spec.RenewTime == nil || lease.Spec.RenewTime.Time.Before(since.Truncate(time.Second)) {
+		return fmt.Errorf("lease not renewed")
+	}
+	return nil
+}
+
+func (c *Controller) SetKubeconfigServer(ctx context.Context, node corev1.Node, server string) error {
+	c.Log.Infof("Scheduling set-kubeconfig-server task on node %s", node.Name)
+
+	if _, err := c.runHostTask(ctx, c.setKubeconfigServerTask(node.Name, server)); err != nil {
+		return errors.Wrapf(err, "run set-kubeconfig-server task on node %s", node.Name)
 	}
 
 	c.Log.Infof("Successfully completed set-kubeconfig-server task on node %s", node.Name)
@@ -38,78 +201,66 @@ func (c *Controller) SetKubeconfigServer(ctx context.Context, node corev1.Node,
 	return nil
 }
 
-func (c *Controller) setKubeconfigServerPod(nodeName string, server string, admin bool) *corev1.Pod {
-	t := true
-	return &corev1.Pod{
-		ObjectMeta: metav1.ObjectMeta{
-			GenerateName: "set-kubeconfig-server-",
-			Namespace:    c.Config.HostTaskNamespace,
-			Labels: map[string]string{
-				TaskLabel: SetKubeconfigServerValue,
+func (c *Controller) setKubeconfigServerTask(nodeName string, server string) HostTask {
+	command := []string{
+		"ekco",
+		"set-kubeconfig-server",
+		fmt.Sprintf("--server=%s", server),
+		"--host-root-dir=/host",
+		fmt.Sprintf("--result-file=%s", hosttask.TerminationMessagePath),
+		fmt.Sprintf("--node-name=%s", nodeName),
+	}
+	for _, glob := range c.Config.KubeconfigGlobs {
+		command = append(command, fmt.Sprintf("--kubeconfig-glob=%s", glob))
+	}
+
+	return HostTask{
+		Name:      SetKubeconfigServerValue,
+		Namespace: c.Config.HostTaskNamespace,
+		Node:      nodeName,
+		Image:     c.Config.HostTaskImage,
+		Command:   command,
+		Mounts: []HostTaskMount{
+			dbusMount(),
+			{
+				Name:      "host-root",
+				HostPath:  "/",
+				MountPath: "/host",
 			},
+			hostBackupsMount(),
 		},
-		Spec: corev1.PodSpec{
-			NodeSelector: map[string]string{
-				"kubernetes.io/hostname": nodeName,
-			},
-			Tolerations: []corev1.Toleration{
-				{
-					Key:      "node-role.kubernetes.io/master",
-					Effect:   corev1.TaintEffectNoSchedule,
-					Operator: corev1.TolerationOpExists,
-				},
-				{
-					Key:      "node-role.kubernetes.io/control-plane",
-					Effect:   corev1.TaintEffectNoSchedule,
-					Operator: corev1.TolerationOpExists,
-				},
-			},
-			Containers: []corev1.Container{
-				{
-					Name:            "set-kubeconfig-server",
-					Image:           c.Config.HostTaskImage,
-					ImagePullPolicy: corev1.PullIfNotPresent,
-					Command: []string{
-						"ekco",
-						"set-kubeconfig-server",
-						fmt.Sprintf("--server=%s", server),
-						"--host-etc-dir=/host/etc",
-						fmt.Sprintf("--admin=%t", admin),
-					},
-					VolumeMounts: []corev1.VolumeMount{
-						{
-							Name:      "var-run-dbus",
-							MountPath: "/var/run/dbus",
-						},
-						{
-							Name:      "host-etc-dir",
-							MountPath: "/host/etc",
-						},
-					},
-					SecurityContext: &corev1.SecurityContext{
-						Privileged: &t,
-					},
-				},
-			},
-			RestartPolicy: corev1.RestartPolicyNever,
-			Volumes: []corev1.Volume{
-				{
-					Name: "var-run-dbus",
-					VolumeSource: corev1.VolumeSource{
-						HostPath: &corev1.HostPathVolumeSource{
-							Path: "/var/run/dbus",
-						},
-					},
-				},
-				{
-					Name: "host-etc-dir",
-					VolumeSource: corev1.VolumeSource{
-						HostPath: &corev1.HostPathVolumeSource{
-							Path: "/etc",
-						},
-					},
-				},
+		Privileged: true,
+		// the new server is checked from the host network as the kubelet connects to it
+		HostNetwork: true,
+	}
+}
+
+// checkLoadBalancerTask resolves and connects to the server from the host network as the kubelet
+// does. It is retried as a failure may be transient.
+func (c *Controller) checkLoadBalancerTask(nodeName string, server string) HostTask {
+	return HostTask{
+		Name:      CheckLoadBalancerValue,
+		Namespace: c.Config.HostTaskNamespace,
+		Node:      nodeName,
+		Image:     c.Config.HostTaskImage,
+		Command: []string{
+			"ekco",
+			"check-load-balancer",
+			fmt.Sprintf("--server=%s", server),
+			"--host-etc-dir=/host/etc",
+			fmt.Sprintf("--result-file=%s", hosttask.TerminationMessagePath),
+		},
+		Mounts: []HostTaskMount{
+			{
+				Name:      "host-etc-dir",
+				HostPath:  "/etc",
+				MountPath: "/host/etc",
+				ReadOnly:  true,
 			},
 		},
+		Privileged:  true,
+		HostNetwork: true,
+		Timeout:     checkLoadBalancerTimeout,
+		Retries:     2,
 	}
 }
@@ -0,0 +1,189 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/replicatedhq/ekco/pkg/cluster/types"
+	"github.com/replicatedhq/ekco/pkg/logger"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestController_ChangeKubeconfigServer(t *testing.T) {
+	const oldServer = "https://10.128.0.3:6443"
+	const newServer = "https://lb.example.com:6443"
+
+	tests := []struct {
+		name string
+		// nodes the check task fails on
+		checkFails []string
+		// nodes the set task fails on when switching to the new server
+		setFails    []string
+		wantErr     string
+		wantServers []string
+	}{
+		{
+			name:        "success",
+			wantServers: []string{"node1=" + newServer, "node2=" + newServer},
+		},
+		{
+			name:       "check fails",
+			checkFails: []string{"node2"},
+			wantErr:    "load balancer https://lb.example.com:6443 check failed on nodes node2: ",
+		},
+		{
+			// node2 restores its own kubeconfigs
+			name:     "rolled back",
+			setFails: []string{"node2"},
+			wantErr:  "switch node node2 to https://lb.example.com:6443",
+			wantServers: []string{
+				"node1=" + newServer,
+				"node2=" + newServer,
+				"node1=" + oldServer,
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var mtx sync.Mutex
+			var servers []string
+			clientset := fake.NewSimpleClientset()
+			fakeHostTaskJobs(clientset, func(pod *corev1.Pod) {
+				nodeName := pod.Spec.NodeSelector["kubernetes.io/hostname"]
+				command := strings.Join(pod.Spec.Containers[0].Command, " ")
+
+				mtx.Lock()
+				failed := false
+				message := ""
+				switch pod.Labels[TaskLabel] {
+				case CheckLoadBalancerValue:
+					failed = slices.Contains(tt.checkFails, nodeName)
+					message = fmt.Sprintf(`{"task":"check-load-balancer","outputs":{"server":%q}}`, oldServer)
+				case SetKubeconfigServerValue:
+					server := strings.TrimPrefix(pod.Spec.Containers[0].Command[2], "--server=")
+					servers = append(servers, nodeName+"="+server)
+					failed = server == newServer && slices.Contains(tt.setFails, nodeName)
+				}
+				mtx.Unlock()
+
+				if failed {
+					pod.Status.Phase = corev1.PodFailed
+					message = fmt.Sprintf(`{"task":"%s","errors":["%s failed"]}`, pod.Labels[TaskLabel], command)
+				}
+				if message != "" {
+					pod.Status.ContainerStatuses = []corev1.ContainerStatus{{
+						Name:  pod.Spec.Containers[0].Name,
+						State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Message: message}},
+					}}
+				}
+			})
+
+			ctx := context.Background()
+			var nodes []corev1.Node
+			renewed := metav1.NewMicroTime(time.Now().Add(time.Hour))
+			for _, name := range []string{"node1", "node2"} {
+				node := corev1.Node{
+					ObjectMeta: metav1.ObjectMeta{Name: name},
+					Status: corev1.NodeStatus{
+						Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}},
+					},
+				}
+				_, err := clientset.CoreV1().Nodes().Create(ctx, &node, metav1.CreateOptions{})
+				require.NoError(t, err)
+				lease := &coordinationv1.Lease{
+					ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "kube-node-lease"},
+					Spec:       coordinationv1.LeaseSpec{RenewTime: &renewed},
+				}
+				_, err = clientset.CoordinationV1().Leases("kube-node-lease").Create(ctx, lease, metav1.CreateOptions{})
+				require.NoError(t, err)
+				nodes = append(nodes, node)
+			}
+
+			c := &Controller{
+				Config: types.ControllerConfig{
+					Client:            clientset,
+					HostTaskNamespace: "kurl",
+				},
+				Log: logger.NewDiscardLogger(),
+			}
+			err := c.ChangeKubeconfigServer(ctx, nodes, newServer)
+			if tt.wantErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.ErrorContains(t, err, tt.wantErr)
+			}
+			require.Equal(t, tt.wantServers, servers)
+		})
+	}
+}
+
+func TestController_nodeReadySince(t *testing.T) {
+	now := time.Now()
+	renewed := metav1.NewMicroTime(now.Add(-time.Minute))
+	clientset := fake.NewSimpleClientset(
+		&corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+			Status: corev1.NodeStatus{
+				Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}},
+			},
+		},
+		&coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{Name: "node1", Namespace: "kube-node-lease"},
+			Spec:       coordinationv1.LeaseSpec{RenewTime: &renewed},
+		},
+	)
+	c := &Controller{Config: types.ControllerConfig{Client: clientset}}
+	ctx := context.Background()
+
+	require.NoError(t, c.nodeReadySince(ctx, "node1", now.Add(-time.Hour)))
+	require.EqualError(t, c.nodeReadySince(ctx, "node1", now), "lease not renewed")
+}
+
+func TestController_updateKubeProxyServer(t *testing.T) {
+	kubeconfig := func(server string) string {
+		return "apiVersion: v1\nkind: Config\nclusters:\n- name: default\n  cluster:\n    server: " + server + "\n"
+	}
+	clientset := fake.NewSimpleClientset(
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "kube-proxy", Namespace: "kube-system"},
+			Data:       map[string]string{kubeProxyKubeconfigKey: kubeconfig("https://10.128.0.3:6443")},
+		},
+		&appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Name: "kube-proxy", Namespace: "kube-system"}},
+	)
+	c := &Controller{
+		Config: types.ControllerConfig{Client: clientset},
+		Log:    logger.NewDiscardLogger(),
+	}
+	ctx := context.Background()
+
+	// kube-proxy does not use the previous server of the nodes
+	require.NoError(t, c.updateKubeProxyServer(ctx, []string{"https://10.128.0.4:6443"}, "https://localhost:6444"))
+	ds, err := clientset.AppsV1().DaemonSets("kube-system").Get(ctx, "kube-proxy", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Empty(t, ds.Spec.Template.Annotations)
+
+	require.NoError(t, c.updateKubeProxyServer(ctx, []string{"https://10.128.0.4:6443", "https://10.128.0.3:6443"}, "https://localhost:6444"))
+	cm, err := clientset.CoreV1().ConfigMaps("kube-system").Get(ctx, "kube-proxy", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Contains(t, cm.Data[kubeProxyKubeconfigKey], "server: https://localhost:6444")
+	ds, err = clientset.AppsV1().DaemonSets("kube-system").Get(ctx, "kube-proxy", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Contains(t, ds.Spec.Template.Annotations, "kubectl.kubernetes.io/restartedAt")
+
+	// no kube-proxy
+	c.Config.Client = fake.NewSimpleClientset()
+	require.NoError(t, c.updateKubeProxyServer(ctx, []string{"https://10.128.0.3:6443"}, "https://localhost:6444"))
+}
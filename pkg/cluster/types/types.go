@@ -3,6 +3,9 @@ package types
 import (
 	"time"
 
+	"github.com/replicatedhq/ekco/pkg/certpolicy"
+	"github.com/replicatedhq/ekco/pkg/internallb"
+	"github.com/replicatedhq/ekco/pkg/issuer"
 	cephv1 "github.com/rook/rook/pkg/client/clientset/versioned/typed/ceph.rook.io/v1"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
@@ -33,12 +36,23 @@ type ControllerConfig struct {
 	ContourNamespace                      string
 	ContourCertSecret                     string
 	EnvoyCertSecret                       string
+	CertIssuers                           map[string]issuer.Config
+	CertPolicies                          certpolicy.Policies
+	DynamicClient                         dynamic.Interface
 	RestartFailedEnvoyPods                bool
 	EnvoyPodsNotReadyDuration             time.Duration
 	HostTaskImage                         string
 	HostTaskNamespace                     string
+	KubeconfigGlobs                       []string
 	EnableInternalLoadBalancer            bool
 	InternalLoadBalancerHAProxyImage      string
+	InternalLoadBalancerMode              string
+	InternalLoadBalancer                  internallb.Options
+	PreferredIPFamily                     string
 	AutoApproveKubeletCertSigningRequests bool
 	RookCephImage                         string
+	CephObjectStoreECDataChunks           int
+	CephObjectStoreECCodingChunks         int
+	CephCSIResourceProfile                string
+	CephCSICustomResources                string
 }
@@ -2,6 +2,10 @@ package ekcoops
 
 import (
 	"time"
+
+	"github.com/replicatedhq/ekco/pkg/certpolicy"
+	"github.com/replicatedhq/ekco/pkg/internallb"
+	"github.com/replicatedhq/ekco/pkg/issuer"
 )
 
 type Config struct {
@@ -34,6 +38,11 @@ type Config struct {
 	CephObjectStore        string `mapstructure:"ceph_object_store"`
 	MinCephPoolReplication int    `mapstructure:"min_ceph_pool_replication"`
 	MaxCephPoolReplication int    `mapstructure:"max_ceph_pool_replication"`
+	// erasure coding profile for the object store data pool when the CephCluster is created by
+	// EKCO on a cluster with at least data + coding chunks nodes. Replication of erasure coded
+	// pools is never adjusted.
+	CephObjectStoreECDataChunks   int `mapstructure:"ceph_object_store_ec_data_chunks"`
+	CephObjectStoreECCodingChunks int `mapstructure:"ceph_object_store_ec_coding_chunks"`
 	// when set, priority class to be applied to rook deployments and daemonsets
 	RookPriorityClass string `mapstructure:"rook_priority_class"`
 	// Whether to reconcile CephFilesystem MDS placement when the cluster is scaled beyond one
@@ -43,6 +52,19 @@ type Config struct {
 	// Whether to set Ceph CSI provisioner and plugin resources to their recommendations once the
 	// cluster has enough capacity at 3 nodes.
 	ReconcileCephCSIResources bool `mapstructure:"reconcile_ceph_csi_resources"`
+	// Ceph CSI resource profile: small, medium, large or custom. The default auto profile is chosen
+	// from the allocatable resources of the smallest node once the cluster has 3 nodes.
+	CephCSIResourceProfile string `mapstructure:"ceph_csi_resource_profile"`
+	// YAML with sidecar, plugin, registrar and liveness resource requirements for the custom profile
+	CephCSICustomResources string `mapstructure:"ceph_csi_custom_resources"`
+	// Whether to replace OSDs that have been down for longer than OSDDownToleration on a node that
+	// is still ready. The OSD is marked out, purged once its data has been backfilled and its device
+	// is wiped so that Rook will create a new OSD.
+	ReplaceFailedOSDs bool `mapstructure:"replace_failed_osds"`
+	// how long an OSD must be down before it is replaced
+	OSDDownToleration time.Duration `mapstructure:"osd_down_toleration"`
+	// when set, the CephCluster will be upgraded to this Ceph image once the cluster is healthy
+	CephUpgradeImage string `mapstructure:"ceph_upgrade_image"`
 
 	// kubernetes certificates directory
 	CertificatesDir string `mapstructure:"certificates_dir"`
@@ -50,34 +72,48 @@ type Config struct {
 	ReconcileInterval time.Duration `mapstructure:"reconcile_interval"`
 	ReconcileTimeout  time.Duration `mapstructure:"reconcile_timeout"`
 
-	RotateCerts                           bool          `mapstructure:"rotate_certs"`
-	RotateCertsImage                      string        `mapstructure:"rotate_certs_image"`
-	RotateCertsNamespace                  string        `mapstructure:"rotate_certs_namespace"`
-	RotateCertsCheckInterval              time.Duration `mapstructure:"rotate_certs_check_interval"`
-	RotateCertsTTL                        time.Duration `mapstructure:"rotate_certs_ttl"`
-	RegistryCertNamespace                 string        `mapstructure:"registry_cert_namespace"`
-	RegistryCertSecret                    string        `mapstructure:"registry_cert_secret"`
-	KurlProxyCertNamespace                string        `mapstructure:"kurl_proxy_cert_namespace"`
-	KurlProxyCertSecret                   string        `mapstructure:"kurl_proxy_cert_secret"`
-	KotsadmKubeletCertNamespace           string        `mapstructure:"kotsadm_kubelet_cert_namespace"`
-	KotsadmKubeletCertSecret              string        `mapstructure:"kotsadm_kubelet_cert_secret"`
-	ContourNamespace                      string        `mapstructure:"contour_namespace"`
-	ContourCertNamespace                  string        `mapstructure:"contour_cert_namespace"` // deprecated
-	ContourCertSecret                     string        `mapstructure:"contour_cert_secret"`
-	EnvoyCertSecret                       string        `mapstructure:"envoy_cert_secret"`
-	RestartFailedEnvoyPods                bool          `mapstructure:"restart_failed_envoy_pods"`
-	EnvoyPodsNotReadyDuration             time.Duration `mapstructure:"envoy_pods_not_ready_duration"`
-	EnableInternalLoadBalancer            bool          `mapstructure:"enable_internal_load_balancer"`
-	InternalLoadBalancerHAProxyImage      string        `mapstructure:"internal_load_balancer_haproxy_image"`
-	InternalLoadBalancerPort              int           `mapstructure:"internal_load_balancer_port"`
-	HostTaskImage                         string        `mapstructure:"host_task_image"`
-	HostTaskNamespace                     string        `mapstructure:"host_task_namespace"`
-	PodImageOverrides                     []string      `mapstructure:"pod_image_overrides"`
-	AutoApproveKubeletCertSigningRequests bool          `mapstructure:"auto_approve_kubelet_csrs"`
-	RookMinimumNodeCount                  int           `mapstructure:"rook_minimum_node_count"`
-	RookStorageClass                      string        `mapstructure:"rook_storage_class"`
-	RookCephImage                         string        `mapstructure:"rook_ceph_image"`
-	StorageMigrationAuthToken             string        `mapstructure:"storage_migration_auth_token"`
+	RotateCerts                           bool               `mapstructure:"rotate_certs"`
+	RotateCertsImage                      string             `mapstructure:"rotate_certs_image"`
+	RotateCertsNamespace                  string             `mapstructure:"rotate_certs_namespace"`
+	RotateCertsCheckInterval              time.Duration      `mapstructure:"rotate_certs_check_interval"`
+	RotateCertsTTL                        time.Duration      `mapstructure:"rotate_certs_ttl"`
+	RegistryCertNamespace                 string             `mapstructure:"registry_cert_namespace"`
+	RegistryCertSecret                    string             `mapstructure:"registry_cert_secret"`
+	KurlProxyCertNamespace                string             `mapstructure:"kurl_proxy_cert_namespace"`
+	KurlProxyCertSecret                   string             `mapstructure:"kurl_proxy_cert_secret"`
+	KotsadmKubeletCertNamespace           string             `mapstructure:"kotsadm_kubelet_cert_namespace"`
+	KotsadmKubeletCertSecret              string             `mapstructure:"kotsadm_kubelet_cert_secret"`
+	ContourNamespace                      string             `mapstructure:"contour_namespace"`
+	ContourCertNamespace                  string             `mapstructure:"contour_cert_namespace"` // deprecated
+	ContourCertSecret                     string             `mapstructure:"contour_cert_secret"`
+	EnvoyCertSecret                       string             `mapstructure:"envoy_cert_secret"`
+	RestartFailedEnvoyPods                bool               `mapstructure:"restart_failed_envoy_pods"`
+	EnvoyPodsNotReadyDuration             time.Duration      `mapstructure:"envoy_pods_not_ready_duration"`
+	EnableInternalLoadBalancer            bool               `mapstructure:"enable_internal_load_balancer"`
+	InternalLoadBalancerHAProxyImage      string             `mapstructure:"internal_load_balancer_haproxy_image"`
+	InternalLoadBalancerMode              string             `mapstructure:"internal_load_balancer_mode"`
+	InternalLoadBalancerPort              int                `mapstructure:"internal_load_balancer_port"`
+	InternalLoadBalancer                  internallb.Options `mapstructure:"internal_load_balancer"`
+	HostTaskImage                         string             `mapstructure:"host_task_image"`
+	PreferredIPFamily                     string             `mapstructure:"preferred_ip_family"`
+	HostTaskNamespace                     string             `mapstructure:"host_task_namespace"`
+	KubeconfigGlobs                       []string           `mapstructure:"kubeconfig_globs"`
+	PodImageOverrides                     []string           `mapstructure:"pod_image_overrides"`
+	AutoApproveKubeletCertSigningRequests bool               `mapstructure:"auto_approve_kubelet_csrs"`
+	RookMinimumNodeCount                  int                `mapstructure:"rook_minimum_node_count"`
+	RookStorageClass                      string             `mapstructure:"rook_storage_class"`
+	RookCephImage                         string             `mapstructure:"rook_ceph_image"`
+	StorageMigrationAuthToken             string             `mapstructure:"storage_migration_auth_token"`
+
+	// issuers for the certificates ekco renews, keyed by registry, kurl-proxy or contour. Secrets
+	// without an issuer keep the default: the cluster CA for the registry and self-signed
+	// certificates for kurl proxy and contour.
+	CertIssuers map[string]issuer.Config `mapstructure:"cert_issuers"`
+
+	// rotation policies keyed by kubeadm, kubelet, registry, kurl-proxy or contour. Classes without
+	// a policy renew within rotate_certs_ttl of expiration with the issuer's validity and the key
+	// algorithm of the certificate being replaced.
+	CertPolicies certpolicy.Policies `mapstructure:"cert_policies"`
 
 	// options for HA minio
 	EnableHAMinio  bool   `mapstructure:"enable_ha_minio"`  // should minio be scaled to multiple replicas on 3+ nodes
@@ -0,0 +1,105 @@
+package ekcoops
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/replicatedhq/ekco/pkg/util"
+	certificatesv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const nodeUserPrefix = "system:node:"
+
+// usages a kubelet may request for its serving certificate. Key encipherment is only requested
+// with RSA keys.
+var kubeletServingUsages = map[certificatesv1.KeyUsage]bool{
+	certificatesv1.UsageDigitalSignature: true,
+	certificatesv1.UsageKeyEncipherment:  true,
+	certificatesv1.UsageServerAuth:       true,
+}
+
+// kubeletServingCSRDenyReason returns why a kubelet-serving CSR must be denied or an empty string
+// if it was created by the node the certificate is for and only requests that node's addresses.
+// An error is returned if the CSR could not be checked.
+func (o *Operator) kubeletServingCSRDenyReason(ctx context.Context, csr certificatesv1.CertificateSigningRequest) (string, error) {
+	if !strings.HasPrefix(csr.Spec.Username, nodeUserPrefix) {
+		return fmt.Sprintf("requested by %q, not a node", csr.Spec.Username), nil
+	}
+	nodeName := strings.TrimPrefix(csr.Spec.Username, nodeUserPrefix)
+
+	node, err := o.client.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+	if err != nil {
+		if util.IsNotFoundErr(err) {
+			return fmt.Sprintf("node %s not found", nodeName), nil
+		}
+		return "", errors.Wrapf(err, "get node %s", nodeName)
+	}
+
+	if err := validateKubeletServingRequest(csr, node); err != nil {
+		return err.Error(), nil
+	}
+	return "", nil
+}
+
+func validateKubeletServingRequest(csr certificatesv1.CertificateSigningRequest, node *corev1.Node) error {
+	block, _ := pem.Decode(csr.Spec.Request)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return errors.New("request is not a PEM encoded certificate request")
+	}
+	req, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return errors.Wrap(err, "parse certificate request")
+	}
+
+	if csr.Spec.Username != nodeUserPrefix+node.Name {
+		return fmt.Errorf("requested by %q, not node %s", csr.Spec.Username, node.Name)
+	}
+	if req.Subject.CommonName != csr.Spec.Username {
+		return fmt.Errorf("common name %q does not match requesting user %q", req.Subject.CommonName, csr.Spec.Username)
+	}
+	if len(req.Subject.Organization) != 1 || req.Subject.Organization[0] != "system:nodes" {
+		return fmt.Errorf("organization %v is not [system:nodes]", req.Subject.Organization)
+	}
+
+	if len(req.EmailAddresses) > 0 || len(req.URIs) > 0 {
+		return errors.New("email and URI SANs are not allowed")
+	}
+	if len(req.DNSNames) == 0 && len(req.IPAddresses) == 0 {
+		return errors.New("no DNS or IP SANs")
+	}
+	addresses := map[string]bool{}
+	for _, address := range node.Status.Addresses {
+		addresses[address.Address] = true
+	}
+	for _, dnsName := range req.DNSNames {
+		if !addresses[dnsName] {
+			return fmt.Errorf("DNS SAN %s is not an address of node %s", dnsName, node.Name)
+		}
+	}
+	for _, ip := range req.IPAddresses {
+		if !addresses[ip.String()] {
+			return fmt.Errorf("IP SAN %s is not an address of node %s", ip, node.Name)
+		}
+	}
+
+	hasServerAuth := false
+	for _, usage := range csr.Spec.Usages {
+		if !kubeletServingUsages[usage] {
+			return fmt.Errorf("usage %q is not allowed", usage)
+		}
+		if usage == certificatesv1.UsageServerAuth {
+			hasServerAuth = true
+		}
+	}
+	if !hasServerAuth {
+		return fmt.Errorf("usage %q is required", certificatesv1.UsageServerAuth)
+	}
+
+	return nil
+}
@@ -0,0 +1,202 @@
+package ekcoops
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/replicatedhq/ekco/pkg/logger"
+	certificatesv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func Test_validateKubeletServingRequest(t *testing.T) {
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+		Status: corev1.NodeStatus{
+			Addresses: []corev1.NodeAddress{
+				{Type: corev1.NodeHostName, Address: "node1"},
+				{Type: corev1.NodeInternalIP, Address: "10.0.0.1"},
+			},
+		},
+	}
+	servingUsages := []certificatesv1.KeyUsage{certificatesv1.UsageDigitalSignature, certificatesv1.UsageServerAuth}
+
+	tests := []struct {
+		name     string
+		username string
+		template x509.CertificateRequest
+		usages   []certificatesv1.KeyUsage
+		wantErr  string
+	}{
+		{
+			name:     "valid",
+			username: "system:node:node1",
+			template: x509.CertificateRequest{
+				Subject:     pkix.Name{CommonName: "system:node:node1", Organization: []string{"system:nodes"}},
+				DNSNames:    []string{"node1"},
+				IPAddresses: []net.IP{net.ParseIP("10.0.0.1")},
+			},
+			usages: servingUsages,
+		},
+		{
+			name:     "common name of another node",
+			username: "system:node:node1",
+			template: x509.CertificateRequest{
+				Subject:     pkix.Name{CommonName: "system:node:node2", Organization: []string{"system:nodes"}},
+				IPAddresses: []net.IP{net.ParseIP("10.0.0.1")},
+			},
+			usages:  servingUsages,
+			wantErr: "does not match requesting user",
+		},
+		{
+			name:     "requested by another node",
+			username: "system:node:node2",
+			template: x509.CertificateRequest{
+				Subject:     pkix.Name{CommonName: "system:node:node2", Organization: []string{"system:nodes"}},
+				IPAddresses: []net.IP{net.ParseIP("10.0.0.1")},
+			},
+			usages:  servingUsages,
+			wantErr: "not node node1",
+		},
+		{
+			name:     "IP of another node",
+			username: "system:node:node1",
+			template: x509.CertificateRequest{
+				Subject:     pkix.Name{CommonName: "system:node:node1", Organization: []string{"system:nodes"}},
+				IPAddresses: []net.IP{net.ParseIP("10.0.0.2")},
+			},
+			usages:  servingUsages,
+			wantErr: "IP SAN 10.0.0.2 is not an address of node node1",
+		},
+		{
+			name:     "arbitrary DNS name",
+			username: "system:node:node1",
+			template: x509.CertificateRequest{
+				Subject:  pkix.Name{CommonName: "system:node:node1", Organization: []string{"system:nodes"}},
+				DNSNames: []string{"kubernetes.default.svc"},
+			},
+			usages:  servingUsages,
+			wantErr: "DNS SAN kubernetes.default.svc is not an address",
+		},
+		{
+			name:     "client auth usage",
+			username: "system:node:node1",
+			template: x509.CertificateRequest{
+				Subject:     pkix.Name{CommonName: "system:node:node1", Organization: []string{"system:nodes"}},
+				IPAddresses: []net.IP{net.ParseIP("10.0.0.1")},
+			},
+			usages:  append(servingUsages, certificatesv1.UsageClientAuth),
+			wantErr: `usage "client auth" is not allowed`,
+		},
+		{
+			name:     "missing organization",
+			username: "system:node:node1",
+			template: x509.CertificateRequest{
+				Subject:     pkix.Name{CommonName: "system:node:node1"},
+				IPAddresses: []net.IP{net.ParseIP("10.0.0.1")},
+			},
+			usages:  servingUsages,
+			wantErr: "is not [system:nodes]",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			csr := certificatesv1.CertificateSigningRequest{
+				Spec: certificatesv1.CertificateSigningRequestSpec{
+					Request:    newTestCSR(t, tt.template),
+					SignerName: certificatesv1.KubeletServingSignerName,
+					Username:   tt.username,
+					Usages:     tt.usages,
+				},
+			}
+			err := validateKubeletServingRequest(csr, node)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("validateKubeletServingRequest() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("validateKubeletServingRequest() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func newTestCSR(t *testing.T, template x509.CertificateRequest) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &template, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+}
+
+func TestOperator_reconcileCertificateSigningRequests(t *testing.T) {
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+		Status: corev1.NodeStatus{
+			Addresses: []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "10.0.0.1"}},
+		},
+	}
+	newCSR := func(name, username, ip string) *certificatesv1.CertificateSigningRequest {
+		return &certificatesv1.CertificateSigningRequest{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: certificatesv1.CertificateSigningRequestSpec{
+				Request: newTestCSR(t, x509.CertificateRequest{
+					Subject:     pkix.Name{CommonName: username, Organization: []string{"system:nodes"}},
+					IPAddresses: []net.IP{net.ParseIP(ip)},
+				}),
+				SignerName: certificatesv1.KubeletServingSignerName,
+				Username:   username,
+				Usages:     []certificatesv1.KeyUsage{certificatesv1.UsageDigitalSignature, certificatesv1.UsageServerAuth},
+			},
+		}
+	}
+
+	client := fake.NewSimpleClientset(
+		node,
+		newCSR("valid", "system:node:node1", "10.0.0.1"),
+		newCSR("other-ip", "system:node:node1", "10.0.0.2"),
+		newCSR("not-a-node", "system:serviceaccount:default:default", "10.0.0.1"),
+		newCSR("unknown-node", "system:node:node2", "10.0.0.1"),
+	)
+	o := &Operator{client: client, log: logger.NewDiscardLogger()}
+
+	if err := o.reconcileCertificateSigningRequests(context.Background()); err != nil {
+		t.Fatalf("reconcileCertificateSigningRequests() error = %v", err)
+	}
+
+	want := map[string]certificatesv1.RequestConditionType{
+		"valid":        certificatesv1.CertificateApproved,
+		"other-ip":     certificatesv1.CertificateDenied,
+		"not-a-node":   certificatesv1.CertificateDenied,
+		"unknown-node": certificatesv1.CertificateDenied,
+	}
+	for name, conditionType := range want {
+		csr, err := client.CertificatesV1().CertificateSigningRequests().Get(context.Background(), name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(csr.Status.Conditions) != 1 || csr.Status.Conditions[0].Type != conditionType {
+			t.Errorf("csr %s conditions = %v, want %s", name, csr.Status.Conditions, conditionType)
+			continue
+		}
+		if conditionType == certificatesv1.CertificateDenied && csr.Status.Conditions[0].Message == "" {
+			t.Errorf("csr %s denied without a reason", name)
+		}
+	}
+}
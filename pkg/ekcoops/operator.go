@@ -61,7 +61,9 @@ func (o *Operator) Reconcile(ctx context.Context, nodes []corev1.Node, doFullRec
 
 	var rookVersion *semver.Version
 	rv, err := o.controller.GetRookVersion(ctx)
-	if err != nil && !util.IsNotFoundErr(err) {
+	if errors.Is(err, cluster.ErrRookVersionUnknown) {
+		o.log.Warnf("Skipping Rook management: %v", err)
+	} else if err != nil && !util.IsNotFoundErr(err) {
 		o.log.Errorf("Failed to get Rook version: %v", err)
 	} else if err == nil {
 		rookVersion = rv
@@ -94,6 +96,11 @@ func (o *Operator) Reconcile(ctx context.Context, nodes []corev1.Node, doFullRec
 		if err := o.controller.ReconcileInternalLB(ctx, nodes); err != nil {
 			multiErr = multierror.Append(multiErr, errors.Wrap(err, "update internal loadbalancer"))
 		}
+		if doFullReconcile {
+			if err := o.checkInternalLB(ctx); err != nil {
+				multiErr = multierror.Append(multiErr, errors.Wrap(err, "check internal loadbalancer"))
+			}
+		}
 	}
 
 	if err := o.ReconcilePrometheus(ctx, len(nodes)); err != nil {
@@ -202,9 +209,22 @@ func (o *Operator) reconcileRook(ctx context.Context, rookVersion semver.Version
 	}
 
 	if o.config.ReconcileCephCSIResources {
-		_, err := o.controller.SetCephCSIResources(ctx, rookVersion, len(nodes))
+		_, err := o.controller.SetCephCSIResources(ctx, rookVersion, nodes)
 		if err != nil {
-			multiErr = multierror.Append(multiErr, errors.Wrapf(err, "patch filesystem %s mds placement", o.config.CephFilesystem))
+			multiErr = multierror.Append(multiErr, errors.Wrap(err, "set ceph csi resources"))
+		}
+	}
+
+	if o.config.ReplaceFailedOSDs {
+		err := o.controller.ReconcileFailedOSDs(ctx, rookVersion, nodes, o.config.OSDDownToleration)
+		if err != nil {
+			multiErr = multierror.Append(multiErr, errors.Wrap(err, "replace failed OSDs"))
+		}
+	}
+
+	if o.config.CephUpgradeImage != "" {
+		if _, err := o.controller.ReconcileCephUpgrade(ctx, rookVersion, o.config.CephUpgradeImage); err != nil {
+			multiErr = multierror.Append(multiErr, errors.Wrapf(err, "upgrade ceph to %s", o.config.CephUpgradeImage))
 		}
 	}
 
@@ -327,8 +347,17 @@ func (o *Operator) RotateCerts(ctx context.Context, force bool) error {
 		if err := o.controller.UpdateKubeletClientCertSecret(ctx); err != nil {
 			multiErr = multierror.Append(multiErr, errors.Wrap(err, "update kotsadm kubelet client cert secret"))
 		}
-		if err := o.controller.RotateAllCerts(ctx); err != nil {
-			multiErr = multierror.Append(multiErr, errors.Wrap(err, "rotate certs on primaries"))
+		if inProgress, err := o.controller.CARotationInProgress(ctx); err != nil {
+			multiErr = multierror.Append(multiErr, errors.Wrap(err, "check CA rotation"))
+		} else if inProgress {
+			o.log.Infof("Skipping cert rotation on primaries while CA rotation is in progress")
+		} else {
+			if err := o.controller.RotateAllCerts(ctx); err != nil {
+				multiErr = multierror.Append(multiErr, errors.Wrap(err, "rotate certs on primaries"))
+			}
+			if err := o.controller.RotateKubeletCerts(ctx); err != nil {
+				multiErr = multierror.Append(multiErr, errors.Wrap(err, "rotate kubelet certs"))
+			}
 		}
 
 		return multiErr
@@ -373,13 +402,31 @@ func (o *Operator) reconcileCertificateSigningRequests(ctx context.Context) erro
 			continue
 		}
 		if len(csr.Status.Conditions) == 0 && len(csr.Status.Certificate) == 0 {
+			denyReason, err := o.kubeletServingCSRDenyReason(ctx, csr)
+			if err != nil {
+				return errors.Wrapf(err, "validate csr %s", csr.Name)
+			}
+			if denyReason != "" {
+				csr.Status.Conditions = append(csr.Status.Conditions, certificatesv1.CertificateSigningRequestCondition{
+					Type:    certificatesv1.CertificateDenied,
+					Reason:  "ekcoDeny",
+					Message: denyReason,
+					Status:  corev1.ConditionTrue,
+				})
+				_, err = o.client.CertificatesV1().CertificateSigningRequests().UpdateApproval(ctx, csr.Name, &csr, metav1.UpdateOptions{})
+				if err != nil {
+					return errors.Wrapf(err, "deny csr %s", csr.Name)
+				}
+				o.log.Warnf("Denied kubelet serving CSR %s: %s", csr.Name, denyReason)
+				continue
+			}
 			csr.Status.Conditions = append(csr.Status.Conditions, certificatesv1.CertificateSigningRequestCondition{
 				Type:    certificatesv1.CertificateApproved,
 				Reason:  "ekcoApprove",
 				Message: "automated ekco approval of kubelet csr request",
 				Status:  corev1.ConditionTrue,
 			})
-			_, err := o.client.CertificatesV1().CertificateSigningRequests().UpdateApproval(ctx, csr.Name, &csr, metav1.UpdateOptions{})
+			_, err = o.client.CertificatesV1().CertificateSigningRequests().UpdateApproval(ctx, csr.Name, &csr, metav1.UpdateOptions{})
 			if err != nil {
 				return errors.Wrapf(err, "approve csr %s", csr.Name)
 			}
@@ -476,10 +523,30 @@ func (o *Operator) reconcileRookCluster(ctx context.Context) error {
 
 	if m, w := util.NodeReadyCounts(nodes.Items); m+w >= o.config.RookMinimumNodeCount {
 		o.log.Debugf("reconcileRookCluster(): Rook minimum node count of %d has been met by this cluster.\n", o.config.RookMinimumNodeCount)
-		err := o.controller.EnsureCephCluster(ctx, o.config.RookStorageClass)
+		err := o.controller.EnsureCephCluster(ctx, o.config.RookStorageClass, m+w)
 		if err != nil {
 			return errors.Wrap(err, "ensure ceph cluster")
 		}
 	}
 	return nil
 }
+
+// checkInternalLB warns about nodes where the internal load balancer has no healthy primaries. It
+// execs into the load balancer pod on every node so is only run on full reconciles.
+func (o *Operator) checkInternalLB(ctx context.Context) error {
+	statuses, err := o.controller.InternalLBStatus(ctx)
+	if err != nil {
+		return err
+	}
+	for _, status := range statuses {
+		if !status.NoHealthyPrimaries {
+			continue
+		}
+		if status.Error != "" {
+			o.log.Warnf("Internal load balancer on node %s has no healthy primaries: %s", status.Node, status.Error)
+		} else {
+			o.log.Warnf("Internal load balancer on node %s has no healthy primaries", status.Node)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,396 @@
+// Package hostfile changes files on the host for host tasks. Files are replaced atomically so that a
+// crash never leaves them partially written, and the originals are kept in a backup that
+// `ekco host restore` can roll back to.
+package hostfile
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultBackupDir is where the originals of changed host files are kept on the host
+const DefaultBackupDir = "/var/lib/ekco/backups"
+
+// MaxBackups is the number of backups kept in a backup directory. The oldest are removed when a
+// task starts a new backup.
+const MaxBackups = 20
+
+const (
+	// name of the file in a backup listing the backed up files
+	indexFile = "files.json"
+	// backed up files are kept under this directory of the backup at their path on the host
+	filesDir = "files"
+	// appended to the name of a backup once it has been restored
+	restoredSuffix = ".restored"
+)
+
+// every Backups of a task in this process shares one backup
+var (
+	started = time.Now().UTC()
+	mtx     sync.Mutex
+)
+
+// Backups writes host files, keeping the originals in a backup named after the time the process
+// started and the task. The zero value writes files without keeping backups.
+type Backups struct {
+	// directory of the backups, empty to not keep backups
+	Dir string
+	// path the host root filesystem is mounted at, stripped from paths to record their path on
+	// the host
+	HostRoot string
+	Task     string
+}
+
+// BackupFile is a file in a backup
+type BackupFile struct {
+	// path on the host
+	Path string `json:"path"`
+	// false if the file did not exist and is removed on restore
+	Existed bool        `json:"existed"`
+	Mode    os.FileMode `json:"mode,omitempty"`
+	// owner of the file, restored along with its mode
+	UID int `json:"uid,omitempty"`
+	GID int `json:"gid,omitempty"`
+}
+
+// Name returns the name of the backup of the task in this process
+func (b Backups) Name() string {
+	return fmt.Sprintf("%s-%s", started.Format("20060102T150405.000000000Z"), b.Task)
+}
+
+// WriteFile backs up the file and replaces it with data
+func (b Backups) WriteFile(path string, data []byte, perm os.FileMode) error {
+	if err := b.Backup(path); err != nil {
+		return err
+	}
+	return WriteFileAtomic(path, data, perm)
+}
+
+// WriteFileInPlace backs up the file and overwrites it with data without replacing it, so that
+// containers with the file bind mounted see the change. It returns true if the file changed.
+func (b Backups) WriteFileInPlace(path string, data []byte, perm os.FileMode) (bool, error) {
+	current, err := os.ReadFile(path)
+	if err == nil && bytes.Equal(current, data) {
+		return false, nil
+	} else if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return false, errors.Wrapf(err, "read %s", path)
+	}
+	if err := b.Backup(path); err != nil {
+		return false, err
+	}
+	if err := os.WriteFile(path, data, perm); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Remove backs up the file and removes it. It returns true if the file existed.
+func (b Backups) Remove(path string) (bool, error) {
+	if _, err := os.Lstat(path); errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err := b.Backup(path); err != nil {
+		return false, err
+	}
+	if err := os.Remove(path); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// RestartStaticPod moves the manifest of a static pod out of the manifests directory and back so
+// that the kubelet recreates the pod. The manifest is backed up first so that it can be restored if
+// the task does not get to move it back.
+func (b Backups) RestartStaticPod(manifest string) error {
+	if err := b.Backup(manifest); err != nil {
+		return err
+	}
+	tmp := filepath.Join(filepath.Dir(filepath.Dir(manifest)), filepath.Base(manifest))
+	if err := os.Rename(manifest, tmp); err != nil {
+		return errors.Wrapf(err, "mv %s to %s", manifest, tmp)
+	}
+	if err := os.Rename(tmp, manifest); err != nil {
+		return errors.Wrapf(err, "mv %s to %s", tmp, manifest)
+	}
+	return nil
+}
+
+// Backup copies the file to the backup unless it is already in it, so that the backup has the file
+// as it was before the task first changed it
+func (b Backups) Backup(path string) error {
+	if b.Dir == "" {
+		return nil
+	}
+	mtx.Lock()
+	defer mtx.Unlock()
+
+	dir := filepath.Join(b.Dir, b.Name())
+	files, err := readIndex(dir)
+	if err != nil {
+		return err
+	}
+	hostPath := b.hostPath(path)
+	for _, f := range files {
+		if f.Path == hostPath {
+			return nil
+		}
+	}
+	if len(files) == 0 {
+		if err := prune(b.Dir, MaxBackups-1); err != nil {
+			return err
+		}
+	}
+
+	backup := BackupFile{Path: hostPath}
+	data, err := os.ReadFile(path)
+	if err == nil {
+		info, err := os.Stat(path)
+		if err != nil {
+			return errors.Wrapf(err, "stat %s", path)
+		}
+		backup.Existed = true
+		backup.Mode = info.Mode().Perm()
+		backup.UID, backup.GID = fileOwner(info)
+		copyPath := filepath.Join(dir, filesDir, hostPath)
+		if err := os.MkdirAll(filepath.Dir(copyPath), 0700); err != nil {
+			return errors.Wrap(err, "create backup directory")
+		}
+		if err := WriteFileAtomic(copyPath, data, 0600); err != nil {
+			return errors.Wrapf(err, "back up %s", hostPath)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return errors.Wrapf(err, "read %s", path)
+	}
+
+	if err := writeIndex(dir, append(files, backup)); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (b Backups) hostPath(path string) string {
+	if b.HostRoot == "" || b.HostRoot == "/" {
+		return path
+	}
+	return "/" + strings.TrimPrefix(strings.TrimPrefix(path, b.HostRoot), "/")
+}
+
+// List returns the names of the backups in dir that have not been restored, newest first
+func List(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "read %s", dir)
+	}
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() && !strings.HasSuffix(entry.Name(), restoredSuffix) {
+			names = append(names, entry.Name())
+		}
+	}
+	// names start with the time of the backup
+	sort.Sort(sort.Reverse(sort.StringSlice(names)))
+	return names, nil
+}
+
+// prune removes the oldest backups in dir, restored or not, so that at most keep remain
+func prune(dir string, keep int) error {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return errors.Wrapf(err, "read %s", dir)
+	}
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	// names start with the time of the backup
+	sort.Strings(names)
+	for len(names) > keep {
+		if err := os.RemoveAll(filepath.Join(dir, names[0])); err != nil {
+			return errors.Wrapf(err, "remove backup %s", names[0])
+		}
+		names = names[1:]
+	}
+	return nil
+}
+
+// RemoveFromBackups deletes the copies of the files at the paths on the host from every backup in
+// dir, for files such as CA keys that should not be kept once they are no longer in use. It
+// returns the number of copies deleted.
+func RemoveFromBackups(dir string, paths []string) (int, error) {
+	mtx.Lock()
+	defer mtx.Unlock()
+
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	} else if err != nil {
+		return 0, errors.Wrapf(err, "read %s", dir)
+	}
+	removed := 0
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		backupDir := filepath.Join(dir, entry.Name())
+		files, err := readIndex(backupDir)
+		if err != nil {
+			return removed, err
+		}
+		var kept []BackupFile
+		for _, f := range files {
+			if !f.Existed || !slices.Contains(paths, f.Path) {
+				kept = append(kept, f)
+				continue
+			}
+			if err := os.Remove(filepath.Join(backupDir, filesDir, f.Path)); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return removed, errors.Wrapf(err, "remove backup of %s", f.Path)
+			}
+			removed++
+		}
+		if len(kept) == len(files) {
+			continue
+		}
+		if err := writeIndex(backupDir, kept); err != nil {
+			return removed, err
+		}
+	}
+	return removed, nil
+}
+
+// Restore puts back the files of the backup on the host mounted at hostRoot, removing files that
+// did not exist before, and marks the backup restored. It returns the files restored.
+func Restore(dir, name, hostRoot string) ([]BackupFile, error) {
+	mtx.Lock()
+	defer mtx.Unlock()
+
+	backupDir := filepath.Join(dir, name)
+	files, err := readIndex(backupDir)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("backup %s not found", name)
+	}
+
+	for _, f := range files {
+		path := filepath.Join(hostRoot, f.Path)
+		if !f.Existed {
+			if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return nil, errors.Wrapf(err, "remove %s", f.Path)
+			}
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(backupDir, filesDir, f.Path))
+		if err != nil {
+			return nil, errors.Wrapf(err, "read backup of %s", f.Path)
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return nil, errors.Wrapf(err, "create directory of %s", f.Path)
+		}
+		if err := WriteFileAtomic(path, data, f.Mode); err != nil {
+			return nil, errors.Wrapf(err, "restore %s", f.Path)
+		}
+		if err := os.Lchown(path, f.UID, f.GID); err != nil {
+			return nil, errors.Wrapf(err, "restore owner of %s", f.Path)
+		}
+	}
+
+	if err := os.Rename(backupDir, backupDir+restoredSuffix); err != nil {
+		return nil, errors.Wrap(err, "mark backup restored")
+	}
+	return files, nil
+}
+
+func readIndex(dir string) ([]BackupFile, error) {
+	data, err := os.ReadFile(filepath.Join(dir, indexFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "read backup index")
+	}
+	var files []BackupFile
+	if err := json.Unmarshal(data, &files); err != nil {
+		return nil, errors.Wrap(err, "unmarshal backup index")
+	}
+	return files, nil
+}
+
+func writeIndex(dir string, files []BackupFile) error {
+	data, err := json.MarshalIndent(files, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "marshal backup index")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return errors.Wrap(err, "create backup directory")
+	}
+	return errors.Wrap(WriteFileAtomic(filepath.Join(dir, indexFile), data, 0600), "write backup index")
+}
+
+// WriteFileAtomic writes data to a temporary file in the directory of path, syncs it and renames it
+// over path. The temporary file starts with a dot so that the kubelet ignores it in the manifests
+// directory. A file that is replaced keeps its owner.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if info, err := os.Stat(path); err == nil {
+		uid, gid := fileOwner(info)
+		if uid != os.Geteuid() || gid != os.Getegid() {
+			if err := tmp.Chown(uid, gid); err != nil {
+				tmp.Close()
+				return err
+			}
+		}
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	// persist the rename
+	if dir, err := os.Open(filepath.Dir(path)); err == nil {
+		_ = dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
+
+func fileOwner(info os.FileInfo) (int, int) {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return int(stat.Uid), int(stat.Gid)
+	}
+	return 0, 0
+}
@@ -0,0 +1,240 @@
+package hostfile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackups_Restore(t *testing.T) {
+	hostRoot := t.TempDir()
+	backups := Backups{Dir: t.TempDir(), HostRoot: hostRoot, Task: "set-kubeconfig-server"}
+
+	manifests := filepath.Join(hostRoot, "etc/kubernetes/manifests")
+	require.NoError(t, os.MkdirAll(manifests, 0755))
+	kubeletConf := filepath.Join(hostRoot, "etc/kubernetes/kubelet.conf")
+	require.NoError(t, os.WriteFile(kubeletConf, []byte("original"), 0600))
+	haproxy := filepath.Join(manifests, "haproxy.yaml")
+	require.NoError(t, os.WriteFile(haproxy, []byte("haproxy"), 0644))
+	proxy := filepath.Join(manifests, "ekco-internal-lb.yaml")
+
+	// the backup keeps the file as it was before the first change
+	require.NoError(t, backups.WriteFile(kubeletConf, []byte("first"), 0600))
+	require.NoError(t, backups.WriteFile(kubeletConf, []byte("second"), 0600))
+	require.NoError(t, backups.WriteFile(proxy, []byte("proxy"), 0644))
+	removed, err := backups.Remove(haproxy)
+	require.NoError(t, err)
+	assert.True(t, removed)
+	removed, err = backups.Remove(haproxy)
+	require.NoError(t, err)
+	assert.False(t, removed)
+
+	data, err := os.ReadFile(kubeletConf)
+	require.NoError(t, err)
+	assert.Equal(t, "second", string(data))
+
+	// no temporary files are left next to the files
+	entries, err := os.ReadDir(manifests)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "ekco-internal-lb.yaml", entries[0].Name())
+
+	names, err := List(backups.Dir)
+	require.NoError(t, err)
+	require.Equal(t, []string{backups.Name()}, names)
+
+	files, err := Restore(backups.Dir, backups.Name(), hostRoot)
+	require.NoError(t, err)
+	assert.Equal(t, []BackupFile{
+		{Path: "/etc/kubernetes/kubelet.conf", Existed: true, Mode: 0600, UID: os.Getuid(), GID: os.Getgid()},
+		{Path: "/etc/kubernetes/manifests/ekco-internal-lb.yaml"},
+		{Path: "/etc/kubernetes/manifests/haproxy.yaml", Existed: true, Mode: 0644, UID: os.Getuid(), GID: os.Getgid()},
+	}, files)
+
+	data, err = os.ReadFile(kubeletConf)
+	require.NoError(t, err)
+	assert.Equal(t, "original", string(data))
+	info, err := os.Stat(kubeletConf)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	data, err = os.ReadFile(haproxy)
+	require.NoError(t, err)
+	assert.Equal(t, "haproxy", string(data))
+	_, err = os.Stat(proxy)
+	assert.True(t, os.IsNotExist(err))
+
+	// a restored backup is not restored again
+	names, err = List(backups.Dir)
+	require.NoError(t, err)
+	assert.Empty(t, names)
+	_, err = Restore(backups.Dir, backups.Name(), hostRoot)
+	assert.EqualError(t, err, "backup "+backups.Name()+" not found")
+}
+
+func TestBackups_owner(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("changing the owner of files requires root")
+	}
+	hostRoot := t.TempDir()
+	backups := Backups{Dir: t.TempDir(), HostRoot: hostRoot, Task: "set-kubeconfig-server"}
+
+	config := filepath.Join(hostRoot, "config")
+	require.NoError(t, os.WriteFile(config, []byte("original"), 0600))
+	require.NoError(t, os.Chown(config, 1000, 1001))
+
+	requireOwner := func(uid, gid int) {
+		info, err := os.Stat(config)
+		require.NoError(t, err)
+		gotUID, gotGID := fileOwner(info)
+		assert.Equal(t, uid, gotUID)
+		assert.Equal(t, gid, gotGID)
+	}
+
+	// the replaced file keeps its owner
+	require.NoError(t, backups.WriteFile(config, []byte("updated"), 0600))
+	requireOwner(1000, 1001)
+
+	// the removed file is restored with its owner
+	require.NoError(t, os.Remove(config))
+	files, err := Restore(backups.Dir, backups.Name(), hostRoot)
+	require.NoError(t, err)
+	assert.Equal(t, []BackupFile{{Path: "/config", Existed: true, Mode: 0600, UID: 1000, GID: 1001}}, files)
+	requireOwner(1000, 1001)
+}
+
+func TestBackups_RestartStaticPod(t *testing.T) {
+	dir := t.TempDir()
+	backups := Backups{Dir: filepath.Join(dir, "backups"), Task: "rotate-certs"}
+
+	manifests := filepath.Join(dir, "kubernetes/manifests")
+	require.NoError(t, os.MkdirAll(manifests, 0755))
+	manifest := filepath.Join(manifests, "kube-apiserver.yaml")
+	require.NoError(t, os.WriteFile(manifest, []byte("kube-apiserver"), 0600))
+
+	require.NoError(t, backups.RestartStaticPod(manifest))
+
+	data, err := os.ReadFile(manifest)
+	require.NoError(t, err)
+	assert.Equal(t, "kube-apiserver", string(data))
+	_, err = os.Stat(filepath.Join(dir, "kubernetes/kube-apiserver.yaml"))
+	assert.True(t, os.IsNotExist(err))
+	data, err = os.ReadFile(filepath.Join(backups.Dir, backups.Name(), filesDir, manifest))
+	require.NoError(t, err)
+	assert.Equal(t, "kube-apiserver", string(data))
+}
+
+func TestBackups_WriteFileInPlace(t *testing.T) {
+	hostRoot := t.TempDir()
+	backups := Backups{Dir: t.TempDir(), HostRoot: hostRoot, Task: "update-internallb"}
+
+	config := filepath.Join(hostRoot, "haproxy.cfg")
+	require.NoError(t, os.WriteFile(config, []byte("original"), 0644))
+	before, err := os.Stat(config)
+	require.NoError(t, err)
+
+	changed, err := backups.WriteFileInPlace(config, []byte("updated"), 0644)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	changed, err = backups.WriteFileInPlace(config, []byte("updated"), 0644)
+	require.NoError(t, err)
+	assert.False(t, changed)
+
+	// the file is not replaced, so bind mounts of it see the change
+	after, err := os.Stat(config)
+	require.NoError(t, err)
+	assert.True(t, os.SameFile(before, after))
+	data, err := os.ReadFile(config)
+	require.NoError(t, err)
+	assert.Equal(t, "updated", string(data))
+
+	_, err = Restore(backups.Dir, backups.Name(), hostRoot)
+	require.NoError(t, err)
+	data, err = os.ReadFile(config)
+	require.NoError(t, err)
+	assert.Equal(t, "original", string(data))
+}
+
+func TestBackups_prune(t *testing.T) {
+	hostRoot := t.TempDir()
+	backups := Backups{Dir: t.TempDir(), HostRoot: hostRoot, Task: "rotate-certs"}
+
+	var names []string
+	for i := 0; i < MaxBackups; i++ {
+		name := fmt.Sprintf("20240101T0000%02d.000000000Z-rotate-certs", i)
+		if i%2 == 1 {
+			name += restoredSuffix
+		}
+		require.NoError(t, os.Mkdir(filepath.Join(backups.Dir, name), 0700))
+		names = append(names, name)
+	}
+
+	// starting a new backup removes the oldest
+	require.NoError(t, backups.WriteFile(filepath.Join(hostRoot, "first"), []byte("first"), 0600))
+	require.NoError(t, backups.WriteFile(filepath.Join(hostRoot, "second"), []byte("second"), 0600))
+	entries, err := os.ReadDir(backups.Dir)
+	require.NoError(t, err)
+	require.Len(t, entries, MaxBackups)
+	assert.Equal(t, names[1], entries[0].Name())
+	assert.Equal(t, backups.Name(), entries[len(entries)-1].Name())
+}
+
+func TestRemoveFromBackups(t *testing.T) {
+	hostRoot := t.TempDir()
+	backups := Backups{Dir: t.TempDir(), HostRoot: hostRoot, Task: "rotate-ca"}
+
+	key := filepath.Join(hostRoot, "ca.key")
+	require.NoError(t, os.WriteFile(key, []byte("old key"), 0600))
+	cert := filepath.Join(hostRoot, "ca.crt")
+	require.NoError(t, os.WriteFile(cert, []byte("old cert"), 0644))
+	require.NoError(t, backups.WriteFile(key, []byte("new key"), 0600))
+	require.NoError(t, backups.WriteFile(cert, []byte("new cert"), 0644))
+
+	removed, err := RemoveFromBackups(backups.Dir, []string{"/ca.key"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	removed, err = RemoveFromBackups(backups.Dir, []string{"/ca.key"})
+	require.NoError(t, err)
+	assert.Equal(t, 0, removed)
+	_, err = os.Stat(filepath.Join(backups.Dir, backups.Name(), filesDir, "ca.key"))
+	assert.True(t, os.IsNotExist(err))
+
+	// the rest of the backup can still be restored
+	files, err := Restore(backups.Dir, backups.Name(), hostRoot)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	assert.Equal(t, "/ca.crt", files[0].Path)
+	data, err := os.ReadFile(cert)
+	require.NoError(t, err)
+	assert.Equal(t, "old cert", string(data))
+	data, err = os.ReadFile(key)
+	require.NoError(t, err)
+	assert.Equal(t, "new key", string(data))
+}
+
+func TestList(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"20240101T000000.000000000Z-rotate-certs",
+		"20240301T000000.000000000Z-update-internallb",
+		"20240201T000000.000000000Z-set-kubeconfig-server.restored",
+		"20240102T000000.000000000Z-set-kubeconfig-server",
+	} {
+		require.NoError(t, os.Mkdir(filepath.Join(dir, name), 0700))
+	}
+
+	names, err := List(dir)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"20240301T000000.000000000Z-update-internallb",
+		"20240102T000000.000000000Z-set-kubeconfig-server",
+		"20240101T000000.000000000Z-rotate-certs",
+	}, names)
+
+	names, err = List(filepath.Join(dir, "missing"))
+	require.NoError(t, err)
+	assert.Empty(t, names)
+}
@@ -0,0 +1,237 @@
+// Package hosttask defines the result that commands run in host task pods report to the ekco
+// operator. Results are written as JSON to the container termination message. Output too long
+// for a termination message is printed to the logs of the pod between markers.
+package hosttask
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// TerminationMessagePath is where host task pods read the result from
+const TerminationMessagePath = "/dev/termination-log"
+
+// Kubernetes truncates termination messages longer than this
+const maxMessageLength = 4096
+
+// lines around output printed to the logs of a host task, for output that does not fit in a
+// termination message
+const (
+	outputBegin = "--- ekco output begin ---"
+	outputEnd   = "--- ekco output end ---"
+)
+
+// Result is written by a host task command when it exits
+type Result struct {
+	Task                string   `json:"task"`
+	Node                string   `json:"node,omitempty"`
+	Steps               []Step   `json:"steps,omitempty"`
+	ChangedFiles        []string `json:"changedFiles,omitempty"`
+	RestartedComponents []string `json:"restartedComponents,omitempty"`
+	Errors              []string `json:"errors,omitempty"`
+	// values read on the host that the operator needs, such as the server a kubeconfig uses
+	Outputs map[string]string `json:"outputs,omitempty"`
+}
+
+// ChangedFilesCount returns the number of files changed, including those left out of ChangedFiles
+// when the result was shortened to fit in a termination message
+func (r *Result) ChangedFilesCount() int {
+	return listLength(r.ChangedFiles)
+}
+
+// Step is an action taken, or skipped, by a host task
+type Step struct {
+	Name    string `json:"name"`
+	Message string `json:"message,omitempty"`
+}
+
+func NewResult(task, node string) *Result {
+	return &Result{
+		Task: task,
+		Node: node,
+	}
+}
+
+// Stepf records a step and prints it for the pod logs
+func (r *Result) Stepf(name string, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	fmt.Println(message)
+	r.Steps = append(r.Steps, Step{Name: name, Message: message})
+}
+
+func (r *Result) ChangedFile(path string) {
+	r.ChangedFiles = append(r.ChangedFiles, path)
+}
+
+func (r *Result) RestartedComponent(component string) {
+	r.RestartedComponents = append(r.RestartedComponents, component)
+}
+
+// SetOutput records a value for the operator
+func (r *Result) SetOutput(key, value string) {
+	if r.Outputs == nil {
+		r.Outputs = map[string]string{}
+	}
+	r.Outputs[key] = value
+}
+
+// Error records err and returns it
+func (r *Result) Error(err error) error {
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		r.Errors = append(r.Errors, err.Error())
+	}
+	return err
+}
+
+// Failed returns true if the task recorded any errors
+func (r *Result) Failed() bool {
+	return len(r.Errors) > 0
+}
+
+// Write writes the result to path. If path is empty nothing is written. Steps are dropped, oldest
+// first, until the result fits in a termination message. If it still does not fit the errors and
+// changed files are cut to the first few, then to only a count, and outputs are dropped.
+func (r *Result) Write(path string) error {
+	if path == "" {
+		return nil
+	}
+	data, err := r.message()
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return errors.Wrapf(err, "write result to %s", path)
+	}
+	return nil
+}
+
+// number of errors and changed files kept when a result is too long for a termination message
+const truncatedListLength = 10
+
+// message returns the JSON of the result, shortened to fit in a termination message
+func (r *Result) message() ([]byte, error) {
+	result := *r
+	data, err := json.Marshal(result)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal result")
+	}
+	for len(data) > maxMessageLength && len(result.Steps) > 0 {
+		result.Steps = result.Steps[1:]
+		data, err = json.Marshal(result)
+		if err != nil {
+			return nil, errors.Wrap(err, "marshal result")
+		}
+	}
+
+	// errors are never dropped entirely so that a failed result is still failed
+	for _, shorten := range []func(){
+		func() {
+			result.Errors = truncateList(result.Errors, truncatedListLength)
+			result.ChangedFiles = truncateList(result.ChangedFiles, truncatedListLength)
+		},
+		func() { result.Outputs = nil },
+		func() {
+			result.Errors = truncateList(result.Errors, 1)
+			result.ChangedFiles = truncateList(result.ChangedFiles, 0)
+		},
+		func() {
+			// a single error may be longer than a termination message
+			errs := make([]string, 0, len(result.Errors))
+			for _, err := range result.Errors {
+				errs = append(errs, truncateString(err, maxMessageLength/4))
+			}
+			result.Errors = errs
+		},
+	} {
+		if len(data) <= maxMessageLength {
+			break
+		}
+		shorten()
+		data, err = json.Marshal(result)
+		if err != nil {
+			return nil, errors.Wrap(err, "marshal result")
+		}
+	}
+	return data, nil
+}
+
+// the last item of a truncated list
+const truncatedItemFormat = "...and %d more"
+
+// truncateList returns the first n items of list followed by an item with the number of the others
+func truncateList(list []string, n int) []string {
+	if len(list) <= n+1 {
+		return list
+	}
+	truncated := append([]string{}, list[:n]...)
+	return append(truncated, fmt.Sprintf(truncatedItemFormat, len(list)-n))
+}
+
+// listLength returns the number of items in a list before it was truncated
+func listLength(list []string) int {
+	if len(list) == 0 {
+		return 0
+	}
+	var more int
+	if _, err := fmt.Sscanf(list[len(list)-1], truncatedItemFormat, &more); err != nil {
+		return len(list)
+	}
+	return len(list) - 1 + more
+}
+
+func truncateString(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
+
+// Decode parses a result from a termination message
+func Decode(message string) (*Result, error) {
+	result := &Result{}
+	if err := json.Unmarshal([]byte(message), result); err != nil {
+		return nil, errors.Wrap(err, "unmarshal result")
+	}
+	return result, nil
+}
+
+// PrintOutput prints data between output markers so that the operator can read it from the logs
+// of the pod with ReadOutput, apart from any other lines the command logs
+func PrintOutput(w io.Writer, data []byte) error {
+	data = bytes.TrimRight(data, "\n")
+	_, err := fmt.Fprintf(w, "%s\n%s\n%s\n", outputBegin, data, outputEnd)
+	return err
+}
+
+// ReadOutput returns the last output printed by PrintOutput in logs
+func ReadOutput(logs io.Reader) ([]byte, error) {
+	var output, current *bytes.Buffer
+	scanner := bufio.NewScanner(logs)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == outputBegin:
+			current = &bytes.Buffer{}
+		case line == outputEnd && current != nil:
+			output, current = current, nil
+		case current != nil:
+			current.WriteString(line)
+			current.WriteByte('\n')
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "read logs")
+	}
+	if output == nil {
+		return nil, errors.New("no output in logs")
+	}
+	return output.Bytes(), nil
+}
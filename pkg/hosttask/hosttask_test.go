@@ -0,0 +1,168 @@
+package hosttask
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResult_Write(t *testing.T) {
+	tests := []struct {
+		name      string
+		steps     int
+		wantSteps int
+	}{
+		{name: "fits", steps: 3, wantSteps: 3},
+		{name: "oldest steps dropped", steps: 200, wantSteps: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := NewResult("rotate-certs", "node1")
+			for i := 0; i < tt.steps; i++ {
+				result.Stepf("rotate", "Rotated %s on host node1", strings.Repeat("x", 20))
+			}
+			result.ChangedFile("/etc/kubernetes/pki/apiserver.crt")
+			result.RestartedComponent("kube-apiserver")
+			result.SetOutput("server", "https://10.128.0.3:6443")
+			_ = result.Error(errors.New("renew front-proxy-client"))
+
+			path := filepath.Join(t.TempDir(), "termination-log")
+			if err := result.Write(path); err != nil {
+				t.Fatalf("Result.Write() error = %v", err)
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(data) > maxMessageLength {
+				t.Errorf("Result.Write() wrote %d bytes, want at most %d", len(data), maxMessageLength)
+			}
+
+			got, err := Decode(string(data))
+			if err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			if got.Task != "rotate-certs" || got.Node != "node1" {
+				t.Errorf("Decode() task = %q node = %q", got.Task, got.Node)
+			}
+			if tt.wantSteps > 0 && len(got.Steps) != tt.wantSteps {
+				t.Errorf("Decode() steps = %d, want %d", len(got.Steps), tt.wantSteps)
+			}
+			if tt.wantSteps == 0 && len(got.Steps) >= tt.steps {
+				t.Errorf("Decode() steps = %d, want fewer than %d", len(got.Steps), tt.steps)
+			}
+			if len(got.ChangedFiles) != 1 || len(got.RestartedComponents) != 1 || !got.Failed() || got.Outputs["server"] != "https://10.128.0.3:6443" {
+				t.Errorf("Decode() = %+v", got)
+			}
+			// the result is not modified when steps are dropped
+			if len(result.Steps) != tt.steps {
+				t.Errorf("Result.Write() modified steps")
+			}
+		})
+	}
+}
+
+func TestResult_Write_truncated(t *testing.T) {
+	tests := []struct {
+		name             string
+		errors           int
+		errorLength      int
+		changedFiles     int
+		wantErrors       []string
+		wantChangedFiles int
+	}{
+		{
+			name:             "many errors and changed files",
+			errors:           100,
+			errorLength:      40,
+			changedFiles:     100,
+			wantErrors:       []string{"...and 90 more"},
+			wantChangedFiles: truncatedListLength + 1,
+		},
+		{
+			name:             "long errors",
+			errors:           5,
+			errorLength:      5000,
+			changedFiles:     3,
+			wantErrors:       []string{"...and 4 more"},
+			wantChangedFiles: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := NewResult("update-internallb", "node1")
+			for i := 0; i < tt.errors; i++ {
+				_ = result.Error(fmt.Errorf("%d: %s", i, strings.Repeat("x", tt.errorLength)))
+			}
+			for i := 0; i < tt.changedFiles; i++ {
+				result.ChangedFile(fmt.Sprintf("/etc/kubernetes/%s-%d.conf", strings.Repeat("x", 40), i))
+			}
+			result.SetOutput("server", "https://10.128.0.3:6443")
+
+			path := filepath.Join(t.TempDir(), "termination-log")
+			if err := result.Write(path); err != nil {
+				t.Fatalf("Result.Write() error = %v", err)
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(data) > maxMessageLength {
+				t.Errorf("Result.Write() wrote %d bytes, want at most %d", len(data), maxMessageLength)
+			}
+
+			got, err := Decode(string(data))
+			if err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			if !got.Failed() || !strings.HasPrefix(got.Errors[0], "0: ") {
+				t.Errorf("Decode() errors = %v, want the first error", got.Errors)
+			}
+			if last := got.Errors[len(got.Errors)-1]; last != tt.wantErrors[len(tt.wantErrors)-1] {
+				t.Errorf("Decode() last error = %q, want %q", last, tt.wantErrors[len(tt.wantErrors)-1])
+			}
+			if len(got.ChangedFiles) != tt.wantChangedFiles {
+				t.Errorf("Decode() changed files = %d, want %d", len(got.ChangedFiles), tt.wantChangedFiles)
+			}
+			if count := got.ChangedFilesCount(); count != tt.changedFiles {
+				t.Errorf("Result.ChangedFilesCount() = %d, want %d", count, tt.changedFiles)
+			}
+			// the result is not modified when it is shortened
+			if len(result.Errors) != tt.errors || len(result.Errors[0]) < tt.errorLength {
+				t.Errorf("Result.Write() modified errors")
+			}
+		})
+	}
+}
+
+func TestDecode_notJSON(t *testing.T) {
+	if _, err := Decode("wipefs: error: /dev/sdb: probing initialization failed"); err == nil {
+		t.Error("Decode() expected error")
+	}
+}
+
+func TestReadOutput(t *testing.T) {
+	var logs bytes.Buffer
+	logs.WriteString("Using kubelet config /var/lib/kubelet/config.yaml\n")
+	if err := PrintOutput(&logs, []byte(`[{"name":"apiserver"}]`+"\n")); err != nil {
+		t.Fatal(err)
+	}
+	logs.WriteString("done\n")
+
+	got, err := ReadOutput(&logs)
+	if err != nil {
+		t.Fatalf("ReadOutput() error = %v", err)
+	}
+	if string(got) != `[{"name":"apiserver"}]`+"\n" {
+		t.Errorf("ReadOutput() = %q", got)
+	}
+
+	// output that was not ended, such as when the command was killed, is not returned
+	if _, err := ReadOutput(strings.NewReader(outputBegin + "\n[{\n")); err == nil {
+		t.Error("ReadOutput() expected error")
+	}
+}
@@ -0,0 +1,42 @@
+package hosttask
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	runsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ekco_host_task_runs_total",
+		Help: "Host task pods run by ekco by task and result",
+	}, []string{"task", "result"})
+
+	changedFilesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ekco_host_task_changed_files_total",
+		Help: "Files changed on hosts by host tasks",
+	}, []string{"task"})
+
+	restartsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ekco_host_task_component_restarts_total",
+		Help: "Components restarted by host tasks",
+	}, []string{"task", "component"})
+)
+
+func init() {
+	prometheus.MustRegister(runsTotal, changedFilesTotal, restartsTotal)
+}
+
+// RecordMetrics counts a completed run of a host task
+func RecordMetrics(task string, result *Result, failed bool) {
+	status := "succeeded"
+	if failed {
+		status = "failed"
+	}
+	runsTotal.WithLabelValues(task, status).Inc()
+	if result == nil {
+		return
+	}
+	changedFilesTotal.WithLabelValues(task).Add(float64(result.ChangedFilesCount()))
+	for _, component := range result.RestartedComponents {
+		restartsTotal.WithLabelValues(task, component).Inc()
+	}
+}
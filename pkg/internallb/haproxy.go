@@ -8,26 +8,46 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/replicatedhq/ekco/pkg/hostfile"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/util/yaml"
 )
 
 const HAProxyImage = "haproxy:lts-alpine"
 
+// By default haproxy checks /readyz on each primary at this interval, marks a down backend up after
+// rise consecutive passing checks and marks an up backend down after fall consecutive failing
+// checks.
+const (
+	HealthCheckInterval = time.Second
+	HealthCheckRise     = 3
+	HealthCheckFall     = 2
+)
+
 //go:embed haproxy.cfg
 var haproxyCfg string
-var haproxyConfigTmpl = template.Must(template.New("").Parse(haproxyCfg))
+var haproxyConfigTmpl = template.Must(template.New("").Funcs(template.FuncMap{"duration": haproxyDuration, "hostport": hostPort}).Parse(haproxyCfg))
 
 //go:embed haproxy.yaml
 var haproxyManifest string
 var haproxyManifestTmpl = template.Must(template.New("").Parse(haproxyManifest))
 
-func GenerateHAProxyConfig(primaries ...string) ([]byte, error) {
+//go:embed proxy.yaml
+var proxyManifest string
+var proxyManifestTmpl = template.Must(template.New("").Parse(proxyManifest))
+
+func GenerateHAProxyConfig(options Options, primaries ...string) ([]byte, error) {
 	var buf bytes.Buffer
 
-	data := map[string]interface{}{
-		"Primaries": primaries,
+	data := struct {
+		Options
+		Primaries []string
+	}{
+		Options:   options.WithDefaults(),
+		Primaries: primaries,
 	}
 	err := haproxyConfigTmpl.Execute(&buf, data)
 	if err != nil {
@@ -42,9 +62,28 @@ func GenerateHAProxyConfig(primaries ...string) ([]byte, error) {
 const DefaultFileversion = 0
 
 // GenerateHAProxyManifest writes the generated manifest to the file only if it does not exist or
-// the fileversion has changed. This avoids a few seconds of downtime when the pod is restarted
-// unnecessarily.
-func GenerateHAProxyManifest(filename, image string, fileversion int) (bool, error) {
+// the image, fileversion or port has changed. This avoids a few seconds of downtime when the pod is
+// restarted unnecessarily.
+func GenerateHAProxyManifest(backups hostfile.Backups, filename, image string, fileversion int, options Options) (bool, error) {
+	// the port of the liveness probe is the only option in the haproxy manifest
+	options = Options{Port: options.Port}
+	return generateManifest(backups, haproxyManifestTmpl, filename, image, fileversion, options)
+}
+
+// GenerateProxyManifest writes the manifest of the ekco proxy static pod to the file only if it
+// does not exist or the image, fileversion or options have changed
+func GenerateProxyManifest(backups hostfile.Backups, filename, image string, fileversion int, options Options) (bool, error) {
+	options = proxyManifestOptions(options)
+	return generateManifest(backups, proxyManifestTmpl, filename, image, fileversion, options)
+}
+
+// RemoveManifest removes the static pod manifest of the other internal load balancer mode. It
+// returns true if the file existed.
+func RemoveManifest(backups hostfile.Backups, filename string) (bool, error) {
+	return backups.Remove(filename)
+}
+
+func generateManifest(backups hostfile.Backups, tmpl *template.Template, filename, image string, fileversion int, options Options) (bool, error) {
 	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
 		return false, err
 	}
@@ -58,33 +97,57 @@ func GenerateHAProxyManifest(filename, image string, fileversion int) (bool, err
 
 		currentFileversion := getFileversion(pod)
 		currentImage := getImage(pod)
-		if currentFileversion == fileversion && currentImage == image {
+		currentOptions := pod.GetAnnotations()[OptionsAnnotation]
+		if currentFileversion == fileversion && currentImage == image && currentOptions == optionsAnnotation(options) {
 			return false, nil
 		}
 	} else if !errors.Is(err, os.ErrNotExist) {
 		return false, err
 	}
 
-	manifest, err := generateHAProxyManifest(image, fileversion)
+	manifest, err := executeManifest(tmpl, image, fileversion, options)
 	if err != nil {
 		return false, err
 	}
 
-	if err := os.WriteFile(filename, manifest, 0644); err != nil {
+	if err := backups.WriteFile(filename, manifest, 0644); err != nil {
 		return false, err
 	}
 
 	return true, nil
 }
 
-func generateHAProxyManifest(image string, fileversion int) ([]byte, error) {
+func generateHAProxyManifest(image string, fileversion int, options Options) ([]byte, error) {
+	return executeManifest(haproxyManifestTmpl, image, fileversion, Options{Port: options.Port})
+}
+
+func generateProxyManifest(image string, fileversion int, options Options) ([]byte, error) {
+	return executeManifest(proxyManifestTmpl, image, fileversion, proxyManifestOptions(options))
+}
+
+// proxyManifestOptions returns the options passed to the ekco proxy in its manifest. The upstream
+// port is in the backends file.
+func proxyManifestOptions(options Options) Options {
+	return Options{
+		Port:                options.Port,
+		HealthCheckInterval: options.HealthCheckInterval,
+		HealthCheckRise:     options.HealthCheckRise,
+		HealthCheckFall:     options.HealthCheckFall,
+		ConnectTimeout:      options.ConnectTimeout,
+	}
+}
+
+func executeManifest(tmpl *template.Template, image string, fileversion int, options Options) ([]byte, error) {
 	var buf bytes.Buffer
-	data := map[string]string{
+	data := map[string]interface{}{
 		"Image":       image,
 		"Fileversion": strconv.Itoa(fileversion),
+		"Port":        options.WithDefaults().Port,
+		"Args":        options.Args(),
+		"Options":     optionsAnnotation(options),
 	}
 
-	err := haproxyManifestTmpl.Execute(&buf, data)
+	err := tmpl.Execute(&buf, data)
 	if err != nil {
 		return nil, err
 	}
@@ -94,6 +157,14 @@ func generateHAProxyManifest(image string, fileversion int) ([]byte, error) {
 
 const HAProxyFileversionAnnotation = "kurl.sh/haproxy-fileversion"
 
+// OptionsAnnotation records the non-default options of a manifest. It is omitted with the defaults
+// so that manifests written before the options existed are not rewritten.
+const OptionsAnnotation = "kurl.sh/internal-lb-options"
+
+func optionsAnnotation(options Options) string {
+	return strings.Join(options.Args(), " ")
+}
+
 func getFileversion(pod corev1.Pod) int {
 	annotations := pod.GetAnnotations()
 	str := annotations[HAProxyFileversionAnnotation]
@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 	"testing"
 
+	"github.com/replicatedhq/ekco/pkg/hostfile"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	corev1 "k8s.io/api/core/v1"
@@ -18,7 +19,7 @@ func TestGenerateHAProxyConfig(t *testing.T) {
 		"10.128.0.3",
 		"10.128.0.4",
 	}
-	out, err := GenerateHAProxyConfig(primaries...)
+	out, err := GenerateHAProxyConfig(DefaultOptions(), primaries...)
 	assert.NoError(t, err)
 
 	expect := `# /etc/haproxy/haproxy.cfg
@@ -48,6 +49,14 @@ defaults
     timeout server       86400s
     timeout tunnel       86400s
 
+frontend stats
+    bind 127.0.0.1:6445
+    mode http
+    option dontlog-normal
+    stats enable
+    stats uri /stats
+    stats refresh 10s
+
 frontend kubernetes-api
     bind 0.0.0.0:6444 v4v6
     option tcplog
@@ -65,7 +74,7 @@ backend kubernetes-primaries
 }
 
 func Test_generateHAProxyManifest(t *testing.T) {
-	out, err := generateHAProxyManifest("haproxy:1.1.1", 0)
+	out, err := generateHAProxyManifest("haproxy:1.1.1", 0, DefaultOptions())
 	assert.NoError(t, err)
 
 	expect := `apiVersion: v1
@@ -180,7 +189,7 @@ func TestGenerateHAProxyManifestNew(t *testing.T) {
 	filename := filepath.Join(dir, "haproxy.yaml")
 	image := "haproxy:lts-alpine"
 
-	didUpdate, err := GenerateHAProxyManifest(filename, image, 0)
+	didUpdate, err := GenerateHAProxyManifest(hostfile.Backups{}, filename, image, 0, DefaultOptions())
 	require.NoError(t, err)
 
 	require.True(t, didUpdate)
@@ -207,10 +216,10 @@ func TestGenerateHAProxyManifestExistingNoUpdate(t *testing.T) {
 	filename := filepath.Join(dir, "haproxy.yaml")
 	image := "haproxy:lts-alpine"
 
-	_, err = GenerateHAProxyManifest(filename, image, 0)
+	_, err = GenerateHAProxyManifest(hostfile.Backups{}, filename, image, 0, DefaultOptions())
 	require.NoError(t, err)
 
-	didUpdate, err := GenerateHAProxyManifest(filename, image, 0)
+	didUpdate, err := GenerateHAProxyManifest(hostfile.Backups{}, filename, image, 0, DefaultOptions())
 	require.NoError(t, err)
 
 	require.False(t, didUpdate)
@@ -224,12 +233,12 @@ func TestGenerateHAProxyManifestNewImage(t *testing.T) {
 	filename := filepath.Join(dir, "haproxy.yaml")
 	image := "haproxy:lts-alpine"
 
-	_, err = GenerateHAProxyManifest(filename, image, 0)
+	_, err = GenerateHAProxyManifest(hostfile.Backups{}, filename, image, 0, DefaultOptions())
 	require.NoError(t, err)
 
 	image = "haproxy:2.6.2-alpine3.16"
 
-	didUpdate, err := GenerateHAProxyManifest(filename, image, 0)
+	didUpdate, err := GenerateHAProxyManifest(hostfile.Backups{}, filename, image, 0, DefaultOptions())
 	require.NoError(t, err)
 
 	require.True(t, didUpdate)
@@ -256,10 +265,10 @@ func TestGenerateHAProxyManifestNewFileversion(t *testing.T) {
 	filename := filepath.Join(dir, "haproxy.yaml")
 	image := "haproxy:lts-alpine"
 
-	_, err = GenerateHAProxyManifest(filename, image, 0)
+	_, err = GenerateHAProxyManifest(hostfile.Backups{}, filename, image, 0, DefaultOptions())
 	require.NoError(t, err)
 
-	didUpdate, err := GenerateHAProxyManifest(filename, image, 1)
+	didUpdate, err := GenerateHAProxyManifest(hostfile.Backups{}, filename, image, 1, DefaultOptions())
 	require.NoError(t, err)
 
 	require.True(t, didUpdate)
@@ -277,3 +286,35 @@ func TestGenerateHAProxyManifestNewFileversion(t *testing.T) {
 	assert.Equal(t, 1, currentFileversion)
 	assert.Equal(t, image, currentImage)
 }
+
+func TestGenerateProxyManifest(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "ekco-internal-lb.yaml")
+
+	changed, err := GenerateProxyManifest(hostfile.Backups{}, filename, "replicated/ekco:v1", 0, DefaultOptions())
+	require.NoError(t, err)
+	require.True(t, changed)
+
+	changed, err = GenerateProxyManifest(hostfile.Backups{}, filename, "replicated/ekco:v1", 0, DefaultOptions())
+	require.NoError(t, err)
+	require.False(t, changed)
+
+	changed, err = GenerateProxyManifest(hostfile.Backups{}, filename, "replicated/ekco:v2", 0, DefaultOptions())
+	require.NoError(t, err)
+	require.True(t, changed)
+
+	out, err := os.ReadFile(filename)
+	require.NoError(t, err)
+	pod := corev1.Pod{}
+	require.NoError(t, yaml.Unmarshal(out, &pod))
+	require.Equal(t, "replicated/ekco:v2", getImage(pod))
+	require.Equal(t, map[string]string{"app": "ekco-internal-lb"}, pod.Labels)
+	require.Equal(t, []string{"/usr/bin/ekco", "internal-lb-proxy", "--backends-file=" + BackendsFile}, pod.Spec.Containers[0].Command)
+	require.Equal(t, BackendsDir, pod.Spec.Volumes[0].HostPath.Path)
+
+	removed, err := RemoveManifest(hostfile.Backups{}, filename)
+	require.NoError(t, err)
+	require.True(t, removed)
+	removed, err = RemoveManifest(hostfile.Backups{}, filename)
+	require.NoError(t, err)
+	require.False(t, removed)
+}
@@ -0,0 +1,59 @@
+package internallb
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	serverUp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ekco_internallb_backend_up",
+		Help: "Whether haproxy on the node is sending connections to the primary",
+	}, []string{"node", "server", "address"})
+
+	serverCheckFailures = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ekco_internallb_backend_check_failures",
+		Help: "Failed health checks of the primary by haproxy on the node since haproxy started",
+	}, []string{"node", "server", "address"})
+
+	serverSessions = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ekco_internallb_backend_sessions",
+		Help: "Current sessions to the primary through haproxy on the node",
+	}, []string{"node", "server", "address"})
+
+	healthyPrimaries = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ekco_internallb_healthy_primaries",
+		Help: "Primaries haproxy on the node is sending connections to",
+	}, []string{"node"})
+
+	statsErrors = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ekco_internallb_stats_error",
+		Help: "Whether the haproxy stats on the node could not be read",
+	}, []string{"node"})
+)
+
+func init() {
+	prometheus.MustRegister(serverUp, serverCheckFailures, serverSessions, healthyPrimaries, statsErrors)
+}
+
+// RecordMetrics replaces the internal load balancer metrics with the statuses
+func RecordMetrics(statuses []NodeStatus) {
+	for _, vec := range []*prometheus.GaugeVec{serverUp, serverCheckFailures, serverSessions, healthyPrimaries, statsErrors} {
+		vec.Reset()
+	}
+	for _, status := range statuses {
+		healthyPrimaries.WithLabelValues(status.Node).Set(float64(status.HealthyPrimaries))
+		statsErrors.WithLabelValues(status.Node).Set(boolToFloat(status.Error != ""))
+		for _, server := range status.Servers {
+			serverUp.WithLabelValues(status.Node, server.Name, server.Address).Set(boolToFloat(server.Healthy()))
+			serverCheckFailures.WithLabelValues(status.Node, server.Name, server.Address).Set(float64(server.CheckFailures))
+			serverSessions.WithLabelValues(status.Node, server.Name, server.Address).Set(float64(server.CurrentSessions))
+		}
+	}
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,234 @@
+package internallb
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaults of the generated load balancer config
+const (
+	DefaultBindAddress    = "0.0.0.0"
+	DefaultPort           = 6444
+	DefaultUpstreamPort   = 6443
+	DefaultBalance        = "roundrobin"
+	DefaultConnectTimeout = 10 * time.Second
+	DefaultIdleTimeout    = 86400 * time.Second
+)
+
+// BalanceAlgorithms are the balance algorithms supported by haproxy. The ekco proxy only supports roundrobin.
+var BalanceAlgorithms = []string{"roundrobin", "leastconn", "source"}
+
+var frontendNameRegexp = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+
+// Options configures the internal load balancer on every node. Zero values are replaced with the
+// defaults by WithDefaults.
+type Options struct {
+	// address haproxy binds the frontends to. This is :: when a primary has an IPv6 address, which
+	// accepts both IPv4 and IPv6 connections. The ekco proxy always listens on both families.
+	BindAddress string `mapstructure:"-"`
+	// port the API server is load balanced on. This is set from internal_load_balancer_port.
+	Port                int           `mapstructure:"-"`
+	UpstreamPort        int           `mapstructure:"upstream_port"`
+	Balance             string        `mapstructure:"balance"`
+	HealthCheckInterval time.Duration `mapstructure:"health_check_interval"`
+	HealthCheckRise     int           `mapstructure:"health_check_rise"`
+	HealthCheckFall     int           `mapstructure:"health_check_fall"`
+	ConnectTimeout      time.Duration `mapstructure:"connect_timeout"`
+	// client, server and tunnel timeout of haproxy. Connections through the ekco proxy do not time
+	// out.
+	IdleTimeout time.Duration `mapstructure:"idle_timeout"`
+	// additional ports load balanced across the primaries with TCP health checks, haproxy only
+	Frontends []Frontend `mapstructure:"frontends"`
+}
+
+// Frontend load balances Port on every node to UpstreamPort on the primaries
+type Frontend struct {
+	Name         string `mapstructure:"name"`
+	Port         int    `mapstructure:"port"`
+	UpstreamPort int    `mapstructure:"upstream_port"`
+}
+
+// DefaultOptions returns the options of the config generated before the load balancer was
+// configurable
+func DefaultOptions() Options {
+	return Options{}.WithDefaults()
+}
+
+// WithDefaults returns the options with unset fields set to their defaults
+func (o Options) WithDefaults() Options {
+	if o.BindAddress == "" {
+		o.BindAddress = DefaultBindAddress
+	}
+	if o.Port == 0 {
+		o.Port = DefaultPort
+	}
+	if o.UpstreamPort == 0 {
+		o.UpstreamPort = DefaultUpstreamPort
+	}
+	if o.Balance == "" {
+		o.Balance = DefaultBalance
+	}
+	if o.HealthCheckInterval == 0 {
+		o.HealthCheckInterval = HealthCheckInterval
+	}
+	if o.HealthCheckRise == 0 {
+		o.HealthCheckRise = HealthCheckRise
+	}
+	if o.HealthCheckFall == 0 {
+		o.HealthCheckFall = HealthCheckFall
+	}
+	if o.ConnectTimeout == 0 {
+		o.ConnectTimeout = DefaultConnectTimeout
+	}
+	if o.IdleTimeout == 0 {
+		o.IdleTimeout = DefaultIdleTimeout
+	}
+	return o
+}
+
+// Validate returns an error if the options cannot be used in the given mode
+func (o Options) Validate(mode string) error {
+	o = o.WithDefaults()
+
+	// haproxy.cfg durations are in milliseconds
+	if o.HealthCheckInterval < time.Millisecond || o.ConnectTimeout < time.Millisecond || o.IdleTimeout < time.Millisecond {
+		return fmt.Errorf("durations must be at least 1ms")
+	}
+	if o.HealthCheckRise < 0 || o.HealthCheckFall < 0 {
+		return fmt.Errorf("health_check_rise and health_check_fall must not be negative")
+	}
+	if net.ParseIP(o.BindAddress) == nil {
+		return fmt.Errorf("bind address %q is not an IP address", o.BindAddress)
+	}
+	if !slices.Contains(BalanceAlgorithms, o.Balance) {
+		return fmt.Errorf("balance must be one of %s", strings.Join(BalanceAlgorithms, ", "))
+	}
+	if mode == ModeEKCO {
+		if o.Balance != DefaultBalance {
+			return fmt.Errorf("balance %s is not supported by the ekco proxy", o.Balance)
+		}
+		if len(o.Frontends) > 0 {
+			return fmt.Errorf("frontends are not supported by the ekco proxy")
+		}
+	}
+
+	_, statsPort, _ := strings.Cut(StatsAddress, ":")
+	ports := map[string]string{statsPort: "stats"}
+	addPort := func(name string, port int) error {
+		if port < 1 || port > 65535 {
+			return fmt.Errorf("%s port %d out of range", name, port)
+		}
+		p := strconv.Itoa(port)
+		if other, ok := ports[p]; ok {
+			return fmt.Errorf("%s port %d is already used by %s", name, port, other)
+		}
+		ports[p] = name
+		return nil
+	}
+	if err := addPort("kubernetes-api", o.Port); err != nil {
+		return err
+	}
+	if o.UpstreamPort < 1 || o.UpstreamPort > 65535 {
+		return fmt.Errorf("upstream port %d out of range", o.UpstreamPort)
+	}
+	for _, f := range o.Frontends {
+		if !frontendNameRegexp.MatchString(f.Name) {
+			return fmt.Errorf("invalid frontend name %q", f.Name)
+		}
+		if f.Name == "stats" || f.Name == "kubernetes-api" {
+			return fmt.Errorf("frontend name %s is reserved", f.Name)
+		}
+		if err := addPort(f.Name, f.Port); err != nil {
+			return err
+		}
+		if f.UpstreamPort < 1 || f.UpstreamPort > 65535 {
+			return fmt.Errorf("%s upstream port %d out of range", f.Name, f.UpstreamPort)
+		}
+	}
+	return nil
+}
+
+// ListenAddress returns the address the API server is load balanced on
+func (o Options) ListenAddress() string {
+	return fmt.Sprintf(":%d", o.WithDefaults().Port)
+}
+
+// Args returns the flags of the internal load balancer commands for the options that differ from
+// the defaults, to pass the options to the commands run in the update pod
+func (o Options) Args() []string {
+	o = o.WithDefaults()
+	d := DefaultOptions()
+
+	var args []string
+	if o.BindAddress != d.BindAddress {
+		args = append(args, fmt.Sprintf("--bind-address=%s", o.BindAddress))
+	}
+	if o.Port != d.Port {
+		args = append(args, fmt.Sprintf("--port=%d", o.Port))
+	}
+	if o.UpstreamPort != d.UpstreamPort {
+		args = append(args, fmt.Sprintf("--upstream-port=%d", o.UpstreamPort))
+	}
+	if o.Balance != d.Balance {
+		args = append(args, fmt.Sprintf("--balance=%s", o.Balance))
+	}
+	if o.HealthCheckInterval != d.HealthCheckInterval {
+		args = append(args, fmt.Sprintf("--health-check-interval=%s", o.HealthCheckInterval))
+	}
+	if o.HealthCheckRise != d.HealthCheckRise {
+		args = append(args, fmt.Sprintf("--health-check-rise=%d", o.HealthCheckRise))
+	}
+	if o.HealthCheckFall != d.HealthCheckFall {
+		args = append(args, fmt.Sprintf("--health-check-fall=%d", o.HealthCheckFall))
+	}
+	if o.ConnectTimeout != d.ConnectTimeout {
+		args = append(args, fmt.Sprintf("--connect-timeout=%s", o.ConnectTimeout))
+	}
+	if o.IdleTimeout != d.IdleTimeout {
+		args = append(args, fmt.Sprintf("--idle-timeout=%s", o.IdleTimeout))
+	}
+	for _, f := range o.Frontends {
+		args = append(args, fmt.Sprintf("--frontend=%s", f))
+	}
+	return args
+}
+
+// String returns the frontend in the format of the --frontend flag
+func (f Frontend) String() string {
+	return fmt.Sprintf("%s:%d:%d", f.Name, f.Port, f.UpstreamPort)
+}
+
+// ParseFrontend parses a frontend in the format name:port:upstream-port
+func ParseFrontend(s string) (Frontend, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 {
+		return Frontend{}, fmt.Errorf("frontend %q must be name:port:upstream-port", s)
+	}
+	port, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return Frontend{}, fmt.Errorf("frontend %q port: %w", s, err)
+	}
+	upstreamPort, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return Frontend{}, fmt.Errorf("frontend %q upstream port: %w", s, err)
+	}
+	return Frontend{Name: parts[0], Port: port, UpstreamPort: upstreamPort}, nil
+}
+
+// hostPort formats the address of host and port, bracketing IPv6 addresses
+func hostPort(host string, port int) string {
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// haproxyDuration formats d in the time format of haproxy.cfg
+func haproxyDuration(d time.Duration) string {
+	if d%time.Second == 0 {
+		return fmt.Sprintf("%ds", d/time.Second)
+	}
+	return fmt.Sprintf("%dms", d/time.Millisecond)
+}
@@ -0,0 +1,224 @@
+package internallb
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/replicatedhq/ekco/pkg/hostfile"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+func TestOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		options Options
+		mode    string
+		wantErr string
+	}{
+		{
+			name: "defaults",
+			mode: ModeHAProxy,
+		},
+		{
+			name: "konnectivity frontend",
+			options: Options{
+				Port:      7444,
+				Balance:   "leastconn",
+				Frontends: []Frontend{{Name: "konnectivity", Port: 8133, UpstreamPort: 8132}},
+			},
+			mode: ModeHAProxy,
+		},
+		{
+			name:    "ipv6 bind address",
+			options: Options{BindAddress: "::"},
+			mode:    ModeHAProxy,
+		},
+		{
+			name:    "invalid bind address",
+			options: Options{BindAddress: "[::]"},
+			mode:    ModeHAProxy,
+			wantErr: `bind address "[::]" is not an IP address`,
+		},
+		{
+			name:    "unknown balance",
+			options: Options{Balance: "random"},
+			mode:    ModeHAProxy,
+			wantErr: "balance must be one of roundrobin, leastconn, source",
+		},
+		{
+			name:    "stats port",
+			options: Options{Port: 6445},
+			mode:    ModeHAProxy,
+			wantErr: "kubernetes-api port 6445 is already used by stats",
+		},
+		{
+			name:    "duplicate frontend port",
+			options: Options{Frontends: []Frontend{{Name: "registry", Port: 6444, UpstreamPort: 443}}},
+			mode:    ModeHAProxy,
+			wantErr: "registry port 6444 is already used by kubernetes-api",
+		},
+		{
+			name:    "reserved frontend name",
+			options: Options{Frontends: []Frontend{{Name: "stats", Port: 8443, UpstreamPort: 443}}},
+			mode:    ModeHAProxy,
+			wantErr: "frontend name stats is reserved",
+		},
+		{
+			name:    "invalid frontend name",
+			options: Options{Frontends: []Frontend{{Name: "Registry", Port: 8443, UpstreamPort: 443}}},
+			mode:    ModeHAProxy,
+			wantErr: `invalid frontend name "Registry"`,
+		},
+		{
+			name:    "upstream port out of range",
+			options: Options{UpstreamPort: 70000},
+			mode:    ModeHAProxy,
+			wantErr: "upstream port 70000 out of range",
+		},
+		{
+			name:    "sub-millisecond interval",
+			options: Options{HealthCheckInterval: time.Microsecond},
+			mode:    ModeHAProxy,
+			wantErr: "durations must be at least 1ms",
+		},
+		{
+			name:    "ekco balance",
+			options: Options{Balance: "source"},
+			mode:    ModeEKCO,
+			wantErr: "balance source is not supported by the ekco proxy",
+		},
+		{
+			name:    "ekco frontends",
+			options: Options{Frontends: []Frontend{{Name: "konnectivity", Port: 8133, UpstreamPort: 8132}}},
+			mode:    ModeEKCO,
+			wantErr: "frontends are not supported by the ekco proxy",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.options.Validate(tt.mode)
+			if tt.wantErr == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestOptionsArgs(t *testing.T) {
+	require.Empty(t, DefaultOptions().Args())
+	require.Empty(t, Options{}.Args())
+
+	options := Options{
+		Port:                7444,
+		HealthCheckInterval: 1500 * time.Millisecond,
+		HealthCheckRise:     2,
+		IdleTimeout:         time.Hour,
+		Frontends:           []Frontend{{Name: "konnectivity", Port: 8133, UpstreamPort: 8132}},
+	}
+	require.Equal(t, []string{
+		"--port=7444",
+		"--health-check-interval=1.5s",
+		"--health-check-rise=2",
+		"--idle-timeout=1h0m0s",
+		"--frontend=konnectivity:8133:8132",
+	}, options.Args())
+
+	f, err := ParseFrontend("konnectivity:8133:8132")
+	require.NoError(t, err)
+	require.Equal(t, options.Frontends[0], f)
+	_, err = ParseFrontend("konnectivity:8133")
+	require.Error(t, err)
+}
+
+func TestGenerateHAProxyConfigOptions(t *testing.T) {
+	out, err := GenerateHAProxyConfig(Options{
+		Port:                7444,
+		Balance:             "leastconn",
+		HealthCheckInterval: 500 * time.Millisecond,
+		HealthCheckFall:     3,
+		ConnectTimeout:      5 * time.Second,
+		IdleTimeout:         time.Hour,
+		Frontends:           []Frontend{{Name: "konnectivity", Port: 8133, UpstreamPort: 8132}},
+	}, "10.128.0.3", "10.128.0.4")
+	require.NoError(t, err)
+
+	cfg := string(out)
+	require.Contains(t, cfg, "    timeout connect      5s\n    timeout client       3600s\n    timeout server       3600s\n    timeout tunnel       3600s\n")
+	require.Contains(t, cfg, "    bind 0.0.0.0:7444 v4v6\n")
+	require.Contains(t, cfg, `    balance leastconn
+    server k8s-primary-0 10.128.0.3:6443 weight 1 verify none check check-ssl inter 500ms fall 3 rise 3
+    server k8s-primary-1 10.128.0.4:6443 weight 1 verify none check check-ssl inter 500ms fall 3 rise 3
+`)
+	require.Contains(t, cfg, `
+frontend konnectivity
+    bind 0.0.0.0:8133 v4v6
+    option tcplog
+    default_backend konnectivity-primaries
+
+backend konnectivity-primaries
+    balance leastconn
+    server konnectivity-primary-0 10.128.0.3:8132 weight 1 check inter 500ms fall 3 rise 3
+    server konnectivity-primary-1 10.128.0.4:8132 weight 1 check inter 500ms fall 3 rise 3
+`)
+}
+
+func TestGenerateHAProxyConfigIPv6(t *testing.T) {
+	options := Options{
+		BindAddress: "::",
+		Frontends:   []Frontend{{Name: "konnectivity", Port: 8133, UpstreamPort: 8132}},
+	}
+	require.Equal(t, []string{"--bind-address=::", "--frontend=konnectivity:8133:8132"}, options.Args())
+
+	out, err := GenerateHAProxyConfig(options, "fd00::3", "fd00::4")
+	require.NoError(t, err)
+
+	cfg := string(out)
+	require.Contains(t, cfg, "    bind [::]:6444 v4v6\n")
+	require.Contains(t, cfg, "    bind [::]:8133 v4v6\n")
+	require.Contains(t, cfg, "    server k8s-primary-0 [fd00::3]:6443 weight 1 verify none check check-ssl inter 1s fall 2 rise 3\n")
+	require.Contains(t, cfg, "    server konnectivity-primary-1 [fd00::4]:8132 weight 1 check inter 1s fall 2 rise 3\n")
+}
+
+func TestGenerateManifestOptions(t *testing.T) {
+	dir := t.TempDir()
+	haproxyFile := filepath.Join(dir, "haproxy.yaml")
+	proxyFile := filepath.Join(dir, "ekco-internal-lb.yaml")
+	readPod := func(filename string) corev1.Pod {
+		contents, err := os.ReadFile(filename)
+		require.NoError(t, err)
+		pod := corev1.Pod{}
+		require.NoError(t, yaml.Unmarshal(contents, &pod))
+		return pod
+	}
+
+	// options only in haproxy.cfg do not change the haproxy manifest
+	changed, err := GenerateHAProxyManifest(hostfile.Backups{}, haproxyFile, HAProxyImage, 0, DefaultOptions())
+	require.NoError(t, err)
+	require.True(t, changed)
+	changed, err = GenerateHAProxyManifest(hostfile.Backups{}, haproxyFile, HAProxyImage, 0, Options{Balance: "leastconn", IdleTimeout: time.Hour})
+	require.NoError(t, err)
+	require.False(t, changed)
+	require.NotContains(t, readPod(haproxyFile).Annotations, OptionsAnnotation)
+
+	changed, err = GenerateHAProxyManifest(hostfile.Backups{}, haproxyFile, HAProxyImage, 0, Options{Port: 7444})
+	require.NoError(t, err)
+	require.True(t, changed)
+	pod := readPod(haproxyFile)
+	require.Equal(t, "--port=7444", pod.Annotations[OptionsAnnotation])
+	require.Equal(t, 7444, pod.Spec.Containers[0].LivenessProbe.HTTPGet.Port.IntValue())
+
+	changed, err = GenerateProxyManifest(hostfile.Backups{}, proxyFile, "replicated/ekco:v1", 0, Options{Port: 7444, HealthCheckRise: 2, IdleTimeout: time.Hour})
+	require.NoError(t, err)
+	require.True(t, changed)
+	pod = readPod(proxyFile)
+	require.Equal(t, []string{"/usr/bin/ekco", "internal-lb-proxy", "--backends-file=" + BackendsFile, "--port=7444", "--health-check-rise=2"}, pod.Spec.Containers[0].Command)
+	changed, err = GenerateProxyManifest(hostfile.Backups{}, proxyFile, "replicated/ekco:v1", 0, Options{Port: 7444, HealthCheckRise: 2})
+	require.NoError(t, err)
+	require.False(t, changed, "the proxy has no idle timeout")
+}
@@ -0,0 +1,410 @@
+package internallb
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// internal load balancer modes
+const (
+	// ModeHAProxy runs haproxy in a static pod
+	ModeHAProxy = "haproxy"
+	// ModeEKCO runs the ekco TCP proxy in a static pod with the ekco image
+	ModeEKCO = "ekco"
+)
+
+const (
+	// BackendsDir holds the backends file read by the ekco proxy. The directory rather than the
+	// file is mounted in the static pod so that the file can be replaced atomically.
+	BackendsDir  = "/etc/ekco-lb"
+	BackendsFile = BackendsDir + "/backends"
+)
+
+// GenerateProxyBackends returns the backends file of the ekco proxy for the primaries, the
+// equivalent of the server lines generated by GenerateHAProxyConfig
+func GenerateProxyBackends(upstreamPort int, primaries ...string) []byte {
+	var buf bytes.Buffer
+	for _, host := range primaries {
+		fmt.Fprintln(&buf, hostPort(host, upstreamPort))
+	}
+	return buf.Bytes()
+}
+
+// ParseProxyBackends returns the addresses in a backends file. Blank lines and lines starting with #
+// are ignored.
+func ParseProxyBackends(data []byte) []string {
+	var backends []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		backends = append(backends, line)
+	}
+	return backends
+}
+
+// Proxy is a TCP load balancer for the API servers. It balances connections round-robin across the
+// backends that pass health checks on /readyz, and reloads the backends when the file changes.
+type Proxy struct {
+	ListenAddress string
+	// address of the stats and health endpoints. Stats are served in haproxy's CSV format.
+	StatsAddress string
+	BackendsFile string
+	Log          *zap.SugaredLogger
+
+	HealthCheckInterval time.Duration
+	HealthCheckTimeout  time.Duration
+	HealthCheckRise     int
+	HealthCheckFall     int
+	DialTimeout         time.Duration
+	// how often the backends file is checked for changes
+	ReloadInterval time.Duration
+
+	mtx      sync.Mutex
+	backends []*proxyBackend
+	next     int
+	// contents of the backends file last loaded
+	loaded []byte
+}
+
+type proxyBackend struct {
+	address string
+	stop    chan struct{}
+
+	mtx           sync.Mutex
+	healthy       bool
+	passes        int
+	failures      int
+	checkStatus   string
+	checkFailures int64
+
+	currentSessions atomic.Int64
+	totalSessions   atomic.Int64
+}
+
+// NewProxy returns a proxy with the port, health check settings and connect timeout of the options
+func NewProxy(options Options, statsAddress, backendsFile string, log *zap.SugaredLogger) *Proxy {
+	options = options.WithDefaults()
+	return &Proxy{
+		ListenAddress:       options.ListenAddress(),
+		StatsAddress:        statsAddress,
+		BackendsFile:        backendsFile,
+		Log:                 log,
+		HealthCheckInterval: options.HealthCheckInterval,
+		HealthCheckTimeout:  2 * time.Second,
+		HealthCheckRise:     options.HealthCheckRise,
+		HealthCheckFall:     options.HealthCheckFall,
+		DialTimeout:         options.ConnectTimeout,
+		ReloadInterval:      time.Second,
+	}
+}
+
+// Run serves until ctx is cancelled. Reload may be called at any time to reread the backends file.
+func (p *Proxy) Run(ctx context.Context) error {
+	if err := p.Reload(); err != nil {
+		return err
+	}
+	defer p.stopBackends()
+
+	listener, err := net.Listen("tcp", p.ListenAddress)
+	if err != nil {
+		return fmt.Errorf("listen on %s: %w", p.ListenAddress, err)
+	}
+	defer listener.Close()
+
+	statsServer := &http.Server{Addr: p.StatsAddress, Handler: p.statsHandler()}
+	statsErr := make(chan error, 1)
+	go func() {
+		if err := statsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			statsErr <- fmt.Errorf("serve stats on %s: %w", p.StatsAddress, err)
+		}
+	}()
+	defer func() { _ = statsServer.Close() }()
+
+	go func() {
+		<-ctx.Done()
+		_ = listener.Close()
+	}()
+	go p.watchBackends(ctx)
+
+	p.Log.Infof("Proxying %s to %d backends", listener.Addr(), len(p.Backends()))
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-ctx.Done():
+				return nil
+			case err := <-statsErr:
+				return err
+			default:
+			}
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			return fmt.Errorf("accept: %w", err)
+		}
+		go p.handle(conn)
+	}
+}
+
+// Backends returns the addresses of the backends
+func (p *Proxy) Backends() []string {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	var addresses []string
+	for _, b := range p.backends {
+		addresses = append(addresses, b.address)
+	}
+	return addresses
+}
+
+// Reload rereads the backends file. Backends that are still listed keep their health state and
+// connections to removed backends are left open.
+func (p *Proxy) Reload() error {
+	data, err := os.ReadFile(p.BackendsFile)
+	if err != nil {
+		return fmt.Errorf("read backends file: %w", err)
+	}
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if p.loaded != nil && bytes.Equal(data, p.loaded) {
+		return nil
+	}
+	initial := p.loaded == nil
+	p.loaded = data
+
+	current := map[string]*proxyBackend{}
+	for _, b := range p.backends {
+		current[b.address] = b
+	}
+	var backends []*proxyBackend
+	for _, address := range ParseProxyBackends(data) {
+		if b, ok := current[address]; ok {
+			backends = append(backends, b)
+			delete(current, address)
+			continue
+		}
+		// like haproxy, backends are up when the proxy starts. Backends added later must pass
+		// their checks first.
+		b := &proxyBackend{address: address, healthy: initial, stop: make(chan struct{})}
+		backends = append(backends, b)
+		go p.checkBackend(b)
+		if !initial {
+			p.Log.Infof("Added backend %s", address)
+		}
+	}
+	for address, b := range current {
+		close(b.stop)
+		p.Log.Infof("Removed backend %s", address)
+	}
+	p.backends = backends
+	return nil
+}
+
+func (p *Proxy) watchBackends(ctx context.Context) {
+	ticker := time.NewTicker(p.ReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := p.Reload(); err != nil {
+				p.Log.Warnf("Failed to reload backends: %v", err)
+			}
+		}
+	}
+}
+
+func (p *Proxy) stopBackends() {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	for _, b := range p.backends {
+		close(b.stop)
+	}
+	p.backends = nil
+}
+
+// checkBackend checks /readyz on the backend until it is removed. The certificate is not verified,
+// as with verify none in haproxy.cfg.
+func (p *Proxy) checkBackend(b *proxyBackend) {
+	client := &http.Client{
+		Timeout: p.HealthCheckTimeout,
+		Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+			DisableKeepAlives: true,
+		},
+	}
+	url := fmt.Sprintf("https://%s/readyz", b.address)
+
+	ticker := time.NewTicker(p.HealthCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-ticker.C:
+		}
+
+		status := "L7OK"
+		resp, err := client.Get(url)
+		if err != nil {
+			status = "L4CON"
+		} else {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				status = "L7STS"
+			}
+		}
+		p.recordCheck(b, status == "L7OK", status)
+	}
+}
+
+func (p *Proxy) recordCheck(b *proxyBackend, passed bool, status string) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	b.checkStatus = status
+	if passed {
+		b.failures = 0
+		b.passes++
+		if !b.healthy && b.passes >= p.HealthCheckRise {
+			b.healthy = true
+			p.Log.Infof("Backend %s is UP", b.address)
+		}
+		return
+	}
+	b.passes = 0
+	b.failures++
+	b.checkFailures++
+	if b.healthy && b.failures >= p.HealthCheckFall {
+		b.healthy = false
+		p.Log.Warnf("Backend %s is DOWN: %s", b.address, status)
+	}
+}
+
+// pick returns the healthy backends starting with the next in round-robin order
+func (p *Proxy) pick() []*proxyBackend {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	var healthy []*proxyBackend
+	n := len(p.backends)
+	for i := 0; i < n; i++ {
+		b := p.backends[(p.next+i)%n]
+		b.mtx.Lock()
+		if b.healthy {
+			healthy = append(healthy, b)
+		}
+		b.mtx.Unlock()
+	}
+	if n > 0 {
+		p.next = (p.next + 1) % n
+	}
+	return healthy
+}
+
+func (p *Proxy) handle(conn net.Conn) {
+	defer conn.Close()
+
+	// try up to 3 backends, as with retries in haproxy.cfg
+	var upstream net.Conn
+	var backend *proxyBackend
+	for i, b := range p.pick() {
+		if i == 3 {
+			break
+		}
+		c, err := net.DialTimeout("tcp", b.address, p.DialTimeout)
+		if err != nil {
+			p.Log.Debugf("Failed to connect to backend %s: %v", b.address, err)
+			continue
+		}
+		upstream, backend = c, b
+		break
+	}
+	if upstream == nil {
+		p.Log.Debugf("No healthy backend for connection from %s", conn.RemoteAddr())
+		return
+	}
+	defer upstream.Close()
+
+	backend.currentSessions.Add(1)
+	backend.totalSessions.Add(1)
+	defer backend.currentSessions.Add(-1)
+
+	done := make(chan struct{}, 2)
+	go func() {
+		_, _ = io.Copy(upstream, conn)
+		closeWrite(upstream)
+		done <- struct{}{}
+	}()
+	go func() {
+		_, _ = io.Copy(conn, upstream)
+		closeWrite(conn)
+		done <- struct{}{}
+	}()
+	<-done
+	<-done
+}
+
+func closeWrite(conn net.Conn) {
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		_ = tcp.CloseWrite()
+	}
+}
+
+func (p *Proxy) statsHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	// haproxy selects the CSV format with ;csv after the path
+	mux.HandleFunc(StatsPath+";csv", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write(p.StatsCSV())
+	})
+	return mux
+}
+
+// StatsCSV returns the state of the backends in the subset of haproxy's CSV stats format read by
+// ParseStats
+func (p *Proxy) StatsCSV() []byte {
+	p.mtx.Lock()
+	backends := append([]*proxyBackend{}, p.backends...)
+	p.mtx.Unlock()
+
+	var buf bytes.Buffer
+	buf.WriteString("# pxname,svname,scur,stot,status,chkfail,check_status,addr\n")
+	for i, b := range backends {
+		b.mtx.Lock()
+		status := "DOWN"
+		if b.healthy {
+			status = "UP"
+		}
+		fmt.Fprintf(&buf, "%s,k8s-primary-%d,%d,%d,%s,%s,%s,%s\n",
+			PrimariesBackend, i, b.currentSessions.Load(), b.totalSessions.Load(), status,
+			strconv.FormatInt(b.checkFailures, 10), b.checkStatus, b.address)
+		b.mtx.Unlock()
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,151 @@
+package internallb
+
+import (
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/replicatedhq/ekco/pkg/logger"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateProxyBackends(t *testing.T) {
+	out := GenerateProxyBackends(DefaultUpstreamPort, "10.128.0.3", "10.128.0.4", "fd00::5")
+	require.Equal(t, "10.128.0.3:6443\n10.128.0.4:6443\n[fd00::5]:6443\n", string(out))
+	require.Equal(t, []string{"10.128.0.3:6443", "10.128.0.4:6443", "[fd00::5]:6443"}, ParseProxyBackends(out))
+
+	require.Equal(t, []string{"10.0.0.1:6443"}, ParseProxyBackends([]byte("# primaries\n\n  10.0.0.1:6443  \n")))
+}
+
+func newTestProxy(t *testing.T, backends ...string) *Proxy {
+	filename := filepath.Join(t.TempDir(), "backends")
+	require.NoError(t, os.WriteFile(filename, GenerateProxyBackends(DefaultUpstreamPort, backends...), 0644))
+
+	p := NewProxy(Options{Port: 16444}, "127.0.0.1:0", filename, logger.NewDiscardLogger())
+	// checks are recorded by the tests
+	p.HealthCheckInterval = time.Hour
+	p.DialTimeout = time.Second
+	require.NoError(t, p.Reload())
+	t.Cleanup(p.stopBackends)
+	return p
+}
+
+func pickAddresses(p *Proxy) []string {
+	var addresses []string
+	for _, b := range p.pick() {
+		addresses = append(addresses, b.address)
+	}
+	return addresses
+}
+
+func TestProxyRoundRobin(t *testing.T) {
+	p := newTestProxy(t, "10.0.0.1", "10.0.0.2", "10.0.0.3")
+
+	require.Equal(t, []string{"10.0.0.1:6443", "10.0.0.2:6443", "10.0.0.3:6443"}, pickAddresses(p))
+	require.Equal(t, []string{"10.0.0.2:6443", "10.0.0.3:6443", "10.0.0.1:6443"}, pickAddresses(p))
+	require.Equal(t, []string{"10.0.0.3:6443", "10.0.0.1:6443", "10.0.0.2:6443"}, pickAddresses(p))
+	require.Equal(t, []string{"10.0.0.1:6443", "10.0.0.2:6443", "10.0.0.3:6443"}, pickAddresses(p))
+
+	// unhealthy backends are skipped
+	p.recordCheck(p.backends[1], false, "L4CON")
+	p.recordCheck(p.backends[1], false, "L4CON")
+	require.Equal(t, []string{"10.0.0.3:6443", "10.0.0.1:6443"}, pickAddresses(p))
+}
+
+func TestProxyHealthTransitions(t *testing.T) {
+	p := newTestProxy(t, "10.0.0.1")
+	b := p.backends[0]
+	require.True(t, b.healthy, "backends are up on start")
+
+	p.recordCheck(b, false, "L7STS")
+	require.True(t, b.healthy, "up until fall consecutive failures")
+	p.recordCheck(b, true, "L7OK")
+	p.recordCheck(b, false, "L7STS")
+	require.True(t, b.healthy, "a pass resets the failures")
+	p.recordCheck(b, false, "L4CON")
+	require.False(t, b.healthy)
+	require.Empty(t, p.pick())
+
+	for i := 1; i < HealthCheckRise; i++ {
+		p.recordCheck(b, true, "L7OK")
+		require.False(t, b.healthy, "down until rise consecutive passes")
+	}
+	p.recordCheck(b, true, "L7OK")
+	require.True(t, b.healthy)
+
+	servers, err := ParseStats(p.StatsCSV())
+	require.NoError(t, err)
+	require.Equal(t, []ServerStatus{
+		{Name: "k8s-primary-0", Address: "10.0.0.1:6443", Status: "UP", CheckStatus: "L7OK", CheckFailures: 3},
+	}, servers)
+}
+
+func TestProxyReload(t *testing.T) {
+	p := newTestProxy(t, "10.0.0.1", "10.0.0.2")
+	first := p.backends[0]
+	p.recordCheck(first, false, "L4CON")
+
+	require.NoError(t, os.WriteFile(p.BackendsFile, GenerateProxyBackends(DefaultUpstreamPort, "10.0.0.1", "10.0.0.3"), 0644))
+	require.NoError(t, p.Reload())
+
+	require.Equal(t, []string{"10.0.0.1:6443", "10.0.0.3:6443"}, p.Backends())
+	require.Same(t, first, p.backends[0], "existing backends keep their health state")
+	require.Equal(t, 1, first.failures)
+	require.False(t, p.backends[1].healthy, "added backends must pass checks first")
+
+	// an unchanged file is not reloaded
+	require.NoError(t, p.Reload())
+	require.Same(t, first, p.backends[0])
+
+	require.NoError(t, os.Remove(p.BackendsFile))
+	require.Error(t, p.Reload())
+	require.Equal(t, []string{"10.0.0.1:6443", "10.0.0.3:6443"}, p.Backends(), "backends are kept when the file cannot be read")
+}
+
+func TestProxyHandle(t *testing.T) {
+	upstream, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer upstream.Close()
+	go func() {
+		conn, err := upstream.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = io.Copy(conn, conn)
+	}()
+
+	// the first backend refuses connections so the proxy retries the next
+	refused, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	refusedAddress := refused.Addr().String()
+	refused.Close()
+
+	p := newTestProxy(t)
+	require.NoError(t, os.WriteFile(p.BackendsFile, []byte(refusedAddress+"\n"+upstream.Addr().String()+"\n"), 0644))
+	p.loaded = nil
+	require.NoError(t, p.Reload())
+
+	client, server := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		p.handle(server)
+		close(done)
+	}()
+
+	_, err = client.Write([]byte("ping"))
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(client, buf)
+	require.NoError(t, err)
+	require.Equal(t, "ping", string(buf))
+	client.Close()
+	<-done
+
+	require.Equal(t, int64(1), p.backends[1].totalSessions.Load())
+	require.Equal(t, int64(0), p.backends[1].currentSessions.Load())
+	require.Equal(t, int64(0), p.backends[0].totalSessions.Load())
+}
@@ -0,0 +1,126 @@
+package internallb
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// haproxy serves stats on this loopback address of each node. It must match the stats frontend in
+// haproxy.cfg.
+const (
+	StatsAddress = "127.0.0.1:6445"
+	StatsPath    = "/stats"
+)
+
+// StatsCSVURL is the URL of the stats in CSV format
+var StatsCSVURL = fmt.Sprintf("http://%s%s;csv", StatsAddress, StatsPath)
+
+// name of the backend with the primaries in haproxy.cfg
+const PrimariesBackend = "kubernetes-primaries"
+
+// ServerStatus is the state of a primary in the haproxy backend on one node
+type ServerStatus struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	// UP, DOWN, NOLB, MAINT or a transition such as "UP 1/3"
+	Status string `json:"status"`
+	// result of the last health check, e.g. L7OK or L4CON
+	CheckStatus string `json:"checkStatus"`
+	// failed health checks since haproxy started
+	CheckFailures   int64 `json:"checkFailures"`
+	CurrentSessions int64 `json:"currentSessions"`
+	TotalSessions   int64 `json:"totalSessions"`
+}
+
+// Healthy returns true if haproxy is sending connections to the server
+func (s ServerStatus) Healthy() bool {
+	return strings.HasPrefix(s.Status, "UP")
+}
+
+// NodeStatus is the state of the haproxy backend on one node
+type NodeStatus struct {
+	Node    string         `json:"node"`
+	Servers []ServerStatus `json:"servers"`
+	// number of servers that are up
+	HealthyPrimaries int `json:"healthyPrimaries"`
+	// haproxy on the node cannot reach the API server. Set when no server is up or the stats could
+	// not be read.
+	NoHealthyPrimaries bool `json:"noHealthyPrimaries"`
+	// error reading the stats
+	Error string `json:"error,omitempty"`
+}
+
+// NewNodeStatus returns the status of a node with the servers and counts of healthy servers
+func NewNodeStatus(node string, servers []ServerStatus) NodeStatus {
+	status := NodeStatus{Node: node, Servers: servers}
+	for _, server := range servers {
+		if server.Healthy() {
+			status.HealthyPrimaries++
+		}
+	}
+	status.NoHealthyPrimaries = status.HealthyPrimaries == 0
+	return status
+}
+
+// ParseStats returns the servers in the primaries backend from haproxy's CSV stats
+func ParseStats(data []byte) ([]ServerStatus, error) {
+	r := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("# "))))
+	r.FieldsPerRecord = -1
+
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("read stats header: %w", err)
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[name] = i
+	}
+	for _, name := range []string{"pxname", "svname", "status"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("stats missing column %s", name)
+		}
+	}
+	field := func(record []string, name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return record[i]
+	}
+	number := func(record []string, name string) int64 {
+		n, _ := strconv.ParseInt(field(record, name), 10, 64)
+		return n
+	}
+
+	var servers []ServerStatus
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read stats: %w", err)
+		}
+		if field(record, "pxname") != PrimariesBackend {
+			continue
+		}
+		name := field(record, "svname")
+		if name == "FRONTEND" || name == "BACKEND" {
+			continue
+		}
+		servers = append(servers, ServerStatus{
+			Name:            name,
+			Address:         field(record, "addr"),
+			Status:          field(record, "status"),
+			CheckStatus:     field(record, "check_status"),
+			CheckFailures:   number(record, "chkfail"),
+			CurrentSessions: number(record, "scur"),
+			TotalSessions:   number(record, "stot"),
+		})
+	}
+	return servers, nil
+}
@@ -0,0 +1,37 @@
+package internallb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testStatsCSV = `# pxname,svname,qcur,qmax,scur,smax,slim,stot,bin,bout,dreq,dresp,ereq,econ,eresp,wretr,wredis,status,weight,act,bck,chkfail,chkdown,lastchg,downtime,qlimit,pid,iid,sid,throttle,lbtot,tracked,type,rate,rate_lim,rate_max,check_status,check_code,check_duration,hrsp_1xx,hrsp_2xx,hrsp_3xx,hrsp_4xx,hrsp_5xx,hrsp_other,hanafail,req_rate,req_rate_max,req_tot,cli_abrt,srv_abrt,comp_in,comp_out,comp_byp,comp_rsp,lastsess,last_chk,last_agt,qtime,ctime,rtime,ttime,agent_status,agent_code,agent_duration,check_desc,agent_desc,check_rise,check_fall,check_health,agent_rise,agent_fall,agent_health,addr
+stats,FRONTEND,,,1,1,20000,4,0,0,0,0,0,,,,,OPEN,,,,,,,,,1,2,0,,,,0,1,0,1,,,,0,3,0,0,0,0,,1,1,4,,,0,0,0,0,,,,,,,,,,,,,,,,,,,,,
+kubernetes-api,FRONTEND,,,12,30,20000,410,0,0,0,0,0,,,,,OPEN,,,,,,,,,1,3,0,,,,0,0,0,5,,,,,,,,,,,0,0,0,,,0,0,0,0,,,,,,,,,,,,,,,,,,,,,
+kubernetes-primaries,k8s-primary-0,0,0,8,20,,300,0,0,,0,,0,0,0,0,UP,1,1,0,2,1,3600,5,,1,4,1,,300,,2,0,,4,L7OK,200,3,,,,,,,,,,,0,0,,,,,1,,,0,0,0,0,,,,Layer7 check passed,,2,3,4,,,,10.128.0.3:6443
+kubernetes-primaries,k8s-primary-1,0,0,4,10,,110,0,0,,0,,0,0,0,0,DOWN,1,1,0,17,3,120,600,,1,4,2,,110,,2,0,,3,L4CON,,0,,,,,,,,,,,0,0,,,,,30,,,0,0,0,0,,,,Connection refused,,2,3,0,,,,10.128.0.4:6443
+kubernetes-primaries,k8s-primary-2,0,0,0,0,,0,0,0,,0,,0,0,0,0,UP 1/3,1,1,0,0,0,10,0,,1,4,3,,0,,2,0,,0,L7STS,503,2,,,,,,,,,,,0,0,,,,,-1,,,0,0,0,0,,,,Layer7 wrong status,,2,3,2,,,,10.128.0.5:6443
+kubernetes-primaries,BACKEND,0,0,12,30,2000,410,0,0,0,0,,0,0,0,0,UP,2,2,0,,1,3600,5,,1,4,0,,410,,1,0,,5,,,,,,,,,,,,,,0,0,0,0,0,0,1,,,0,0,0,0,,,,,,,,,,,,,,
+`
+
+func TestParseStats(t *testing.T) {
+	servers, err := ParseStats([]byte(testStatsCSV))
+	require.NoError(t, err)
+	require.Equal(t, []ServerStatus{
+		{Name: "k8s-primary-0", Address: "10.128.0.3:6443", Status: "UP", CheckStatus: "L7OK", CheckFailures: 2, CurrentSessions: 8, TotalSessions: 300},
+		{Name: "k8s-primary-1", Address: "10.128.0.4:6443", Status: "DOWN", CheckStatus: "L4CON", CheckFailures: 17, CurrentSessions: 4, TotalSessions: 110},
+		{Name: "k8s-primary-2", Address: "10.128.0.5:6443", Status: "UP 1/3", CheckStatus: "L7STS", CheckFailures: 0, CurrentSessions: 0, TotalSessions: 0},
+	}, servers)
+
+	status := NewNodeStatus("node1", servers)
+	require.Equal(t, 2, status.HealthyPrimaries)
+	require.False(t, status.NoHealthyPrimaries)
+
+	status = NewNodeStatus("node1", servers[1:2])
+	require.Equal(t, 0, status.HealthyPrimaries)
+	require.True(t, status.NoHealthyPrimaries)
+
+	_, err = ParseStats([]byte("# a,b,c\n"))
+	require.EqualError(t, err, "stats missing column pxname")
+}
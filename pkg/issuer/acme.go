@@ -0,0 +1,212 @@
+package issuer
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/acme"
+	"k8s.io/client-go/util/keyutil"
+	"k8s.io/kubernetes/cmd/kubeadm/app/util/pkiutil"
+)
+
+// HTTP01ChallengePath is the path prefix the HTTP01Solver serves
+const HTTP01ChallengePath = "/.well-known/acme-challenge/"
+
+// time allowed for an ACME order to complete
+const acmeTimeout = 5 * time.Minute
+
+// ACMEIssuer orders certificates from an ACME directory. Authorizations are completed with HTTP-01
+// challenges served by the ekco server on port 8080, so requests to port 80 of each DNS name for
+// HTTP01ChallengePath must be routed to it, such as by the Service and Ingress in
+// deploy/acme-http01.yaml.
+type ACMEIssuer struct {
+	DirectoryURL string
+	Email        string
+	// AccountKey returns the key of the ACME account, creating it if it does not exist
+	AccountKey func(ctx context.Context) (crypto.Signer, error)
+	Solver     *HTTP01Solver
+}
+
+var _ Issuer = &ACMEIssuer{}
+
+func (i *ACMEIssuer) Issue(ctx context.Context, req Request) (*Certificate, error) {
+	ctx, cancel := context.WithTimeout(ctx, acmeTimeout)
+	defer cancel()
+
+	accountKey, err := i.AccountKey(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "get ACME account key")
+	}
+	client := &acme.Client{Key: accountKey, DirectoryURL: i.DirectoryURL}
+
+	account := &acme.Account{}
+	if i.Email != "" {
+		account.Contact = []string{"mailto:" + i.Email}
+	}
+	if _, err := client.Register(ctx, account, acme.AcceptTOS); err != nil && err != acme.ErrAccountAlreadyExists {
+		return nil, errors.Wrap(err, "register ACME account")
+	}
+
+	ids := acme.DomainIDs(req.DNSNames...)
+	for _, ip := range req.IPAddresses {
+		ids = append(ids, acme.IPIDs(ip.String())...)
+	}
+	if len(ids) == 0 {
+		return nil, errors.New("ACME certificates require at least one DNS name or IP address")
+	}
+	order, err := client.AuthorizeOrder(ctx, ids)
+	if err != nil {
+		return nil, errors.Wrap(err, "create ACME order")
+	}
+
+	for _, authzURL := range order.AuthzURLs {
+		if err := i.authorize(ctx, client, authzURL); err != nil {
+			return nil, err
+		}
+	}
+
+	order, err = client.WaitOrder(ctx, order.URI)
+	if err != nil {
+		return nil, errors.Wrap(err, "wait for ACME order")
+	}
+
+	var key crypto.Signer
+	if req.KeyAlgorithm != "" {
+		key, err = pkiutil.NewPrivateKey(req.KeyAlgorithm)
+	} else {
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "generate key")
+	}
+	template := &x509.CertificateRequest{
+		DNSNames:    req.DNSNames,
+		IPAddresses: req.IPAddresses,
+	}
+	// ACME servers require the common name to be one of the names
+	for _, dnsName := range req.DNSNames {
+		if dnsName == req.CommonName {
+			template.Subject = pkix.Name{CommonName: req.CommonName}
+		}
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, template, key)
+	if err != nil {
+		return nil, errors.Wrap(err, "create certificate request")
+	}
+	chain, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return nil, errors.Wrap(err, "finalize ACME order")
+	}
+
+	var certPEM []byte
+	for _, der := range chain {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, errors.Wrap(err, "parse issued certificate")
+		}
+		certPEM = append(certPEM, pkiutil.EncodeCertPEM(cert)...)
+	}
+	keyPEM, err := keyutil.MarshalPrivateKeyToPEM(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "encode key")
+	}
+	return &Certificate{
+		Cert:     certPEM,
+		Key:      keyPEM,
+		IssuedBy: i.issuedBy(),
+	}, nil
+}
+
+func (i *ACMEIssuer) authorize(ctx context.Context, client *acme.Client, authzURL string) error {
+	authz, err := client.GetAuthorization(ctx, authzURL)
+	if err != nil {
+		return errors.Wrap(err, "get ACME authorization")
+	}
+	if authz.Status == acme.StatusValid {
+		return nil
+	}
+
+	var challenge *acme.Challenge
+	for _, c := range authz.Challenges {
+		if c.Type == "http-01" {
+			challenge = c
+		}
+	}
+	if challenge == nil {
+		return errors.Errorf("no http-01 challenge offered for %s", authz.Identifier.Value)
+	}
+
+	response, err := client.HTTP01ChallengeResponse(challenge.Token)
+	if err != nil {
+		return errors.Wrap(err, "get http-01 challenge response")
+	}
+	i.Solver.Set(challenge.Token, response)
+	defer i.Solver.Delete(challenge.Token)
+
+	if _, err := client.Accept(ctx, challenge); err != nil {
+		return errors.Wrapf(err, "accept http-01 challenge for %s", authz.Identifier.Value)
+	}
+	if _, err := client.WaitAuthorization(ctx, authz.URI); err != nil {
+		return errors.Wrapf(err, "authorize %s", authz.Identifier.Value)
+	}
+	return nil
+}
+
+// Issued returns true if the certificate was ordered from the directory of the issuer
+func (i *ACMEIssuer) Issued(cert *x509.Certificate, issuedBy string) bool {
+	return issuedBy == i.issuedBy()
+}
+
+func (i *ACMEIssuer) issuedBy() string {
+	return "acme:" + i.DirectoryURL
+}
+
+// HTTP01Solver serves the responses to pending HTTP-01 challenges
+type HTTP01Solver struct {
+	mtx       sync.Mutex
+	responses map[string]string
+}
+
+// DefaultHTTP01Solver is used by ACME issuers and served by the ekco server
+var DefaultHTTP01Solver = NewHTTP01Solver()
+
+func NewHTTP01Solver() *HTTP01Solver {
+	return &HTTP01Solver{responses: map[string]string{}}
+}
+
+func (s *HTTP01Solver) Set(token, response string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.responses[token] = response
+}
+
+func (s *HTTP01Solver) Delete(token string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	delete(s.responses, token)
+}
+
+func (s *HTTP01Solver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimPrefix(r.URL.Path, HTTP01ChallengePath)
+
+	s.mtx.Lock()
+	response, ok := s.responses[token]
+	s.mtx.Unlock()
+
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	_, _ = w.Write([]byte(response))
+}
@@ -0,0 +1,106 @@
+package issuer
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/replicatedhq/ekco/pkg/util"
+	certutil "k8s.io/client-go/util/cert"
+	"k8s.io/client-go/util/keyutil"
+	kubeadmconstants "k8s.io/kubernetes/cmd/kubeadm/app/constants"
+	certsphase "k8s.io/kubernetes/cmd/kubeadm/app/phases/certs"
+	"k8s.io/kubernetes/cmd/kubeadm/app/util/pkiutil"
+)
+
+// CAIssuer signs certificates with a CA key that ekco can read
+type CAIssuer struct {
+	Cert *x509.Certificate
+	Key  crypto.Signer
+}
+
+var _ Issuer = &CAIssuer{}
+
+// NewClusterCAIssuer loads the kubeadm cluster CA from the pki directory
+func NewClusterCAIssuer(pkiDir string) (*CAIssuer, error) {
+	cert, key, err := certsphase.LoadCertificateAuthority(pkiDir, "ca")
+	if err != nil {
+		return nil, errors.Wrapf(err, "load cluster CA from %s", filepath.Join(pkiDir, "ca.crt"))
+	}
+	return &CAIssuer{Cert: cert, Key: key}, nil
+}
+
+// NewCAIssuerFromPEM parses a CA certificate and key
+func NewCAIssuerFromPEM(certPEM, keyPEM []byte) (*CAIssuer, error) {
+	certs, err := certutil.ParseCertsPEM(certPEM)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse CA certificate")
+	}
+	if !certs[0].IsCA {
+		return nil, errors.New("certificate is not a CA")
+	}
+	key, err := keyutil.ParsePrivateKeyPEM(keyPEM)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse CA key")
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("CA key is not a signer")
+	}
+	return &CAIssuer{Cert: certs[0], Key: signer}, nil
+}
+
+func (i *CAIssuer) Issue(ctx context.Context, req Request) (*Certificate, error) {
+	if len(req.Usages) == 0 {
+		return nil, errors.New("must specify at least one ExtKeyUsage")
+	}
+	algorithm := req.KeyAlgorithm
+	if algorithm == "" {
+		var err error
+		algorithm, err = util.GetEncryptionAlgorithmType(i.Cert)
+		if err != nil {
+			return nil, errors.Wrap(err, "get CA encryption algorithm")
+		}
+	}
+
+	duration := req.Duration
+	if duration == 0 {
+		duration = kubeadmconstants.CertificateValidityPeriod
+	}
+	notBefore := time.Now().UTC()
+
+	cert, key, err := pkiutil.NewCertAndKey(i.Cert, i.Key, &pkiutil.CertConfig{
+		Config: certutil.Config{
+			CommonName:   req.CommonName,
+			Organization: req.Organization,
+			AltNames: certutil.AltNames{
+				DNSNames: req.DNSNames,
+				IPs:      req.IPAddresses,
+			},
+			Usages:    req.Usages,
+			NotBefore: notBefore,
+		},
+		NotAfter:            notBefore.Add(duration),
+		EncryptionAlgorithm: algorithm,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "sign certificate")
+	}
+
+	keyPEM, err := keyutil.MarshalPrivateKeyToPEM(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "encode key")
+	}
+	return &Certificate{
+		Cert: pkiutil.EncodeCertPEM(cert),
+		Key:  keyPEM,
+		CA:   pkiutil.EncodeCertPEM(i.Cert),
+	}, nil
+}
+
+func (i *CAIssuer) Issued(cert *x509.Certificate, issuedBy string) bool {
+	return cert.CheckSignatureFrom(i.Cert) == nil
+}
@@ -0,0 +1,241 @@
+package issuer
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/replicatedhq/ekco/pkg/util"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	kubeadmapi "k8s.io/kubernetes/cmd/kubeadm/app/apis/kubeadm"
+)
+
+var CertificateGVR = schema.GroupVersionResource{
+	Group:    "cert-manager.io",
+	Version:  "v1",
+	Resource: "certificates",
+}
+
+// time allowed for cert-manager to issue a certificate
+const certManagerTimeout = 5 * time.Minute
+
+// annotations cert-manager sets on the secrets of Certificates
+const (
+	certManagerIssuerNameAnnotation  = "cert-manager.io/issuer-name"
+	certManagerIssuerKindAnnotation  = "cert-manager.io/issuer-kind"
+	certManagerIssuerGroupAnnotation = "cert-manager.io/issuer-group"
+)
+
+// CertManagerIssuer creates a cert-manager Certificate for each request and copies the issued
+// certificate from the secret cert-manager writes. The Certificate and its secret are deleted once
+// the certificate has been read, since ekco stores it in its own secret with different keys.
+type CertManagerIssuer struct {
+	Client        kubernetes.Interface
+	DynamicClient dynamic.Interface
+	IssuerName    string
+	IssuerKind    string
+	IssuerGroup   string
+	// how often to check the Certificate status
+	PollInterval time.Duration
+}
+
+var _ Issuer = &CertManagerIssuer{}
+
+func (i *CertManagerIssuer) Issue(ctx context.Context, req Request) (*Certificate, error) {
+	if i.IssuerName == "" {
+		return nil, errors.New("cert-manager issuer name is required")
+	}
+	name := fmt.Sprintf("%s-ekco", req.SecretName)
+	client := i.DynamicClient.Resource(CertificateGVR).Namespace(req.Namespace)
+
+	// remove the Certificate from a previous attempt so that the status is for this request
+	if err := i.cleanup(ctx, req.Namespace, name); err != nil {
+		return nil, err
+	}
+	if _, err := client.Create(ctx, i.certificate(req, name), metav1.CreateOptions{}); err != nil {
+		return nil, errors.Wrapf(err, "create certificate %s/%s", req.Namespace, name)
+	}
+	// a Certificate left behind by a failed cleanup is removed before the next request
+	defer func() { _ = i.cleanup(context.Background(), req.Namespace, name) }()
+
+	ctx, cancel := context.WithTimeout(ctx, certManagerTimeout)
+	defer cancel()
+	pollInterval := i.PollInterval
+	if pollInterval == 0 {
+		pollInterval = 5 * time.Second
+	}
+
+	for {
+		obj, err := client.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, errors.Wrapf(err, "get certificate %s/%s", req.Namespace, name)
+		}
+		ready, message := certificateReady(obj)
+		if ready {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return nil, errors.Wrapf(ctx.Err(), "wait for certificate %s/%s: %s", req.Namespace, name, message)
+		case <-time.After(pollInterval):
+		}
+	}
+
+	secret, err := i.Client.CoreV1().Secrets(req.Namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "get secret %s/%s", req.Namespace, name)
+	}
+	// cert-manager records the issuer of the certificate on the secret
+	annotations := secret.Annotations
+	issuedBy := certManagerIssuedBy(annotations[certManagerIssuerGroupAnnotation], annotations[certManagerIssuerKindAnnotation], annotations[certManagerIssuerNameAnnotation])
+	if annotations[certManagerIssuerNameAnnotation] == "" {
+		issuedBy = certManagerIssuedBy(i.issuerGroup(), i.issuerKind(), i.IssuerName)
+	}
+	return &Certificate{
+		Cert:     secret.Data[corev1.TLSCertKey],
+		Key:      secret.Data[corev1.TLSPrivateKeyKey],
+		CA:       secret.Data["ca.crt"],
+		IssuedBy: issuedBy,
+	}, nil
+}
+
+// Issued returns true if the certificate was issued by the configured cert-manager issuer, as
+// recorded from the issuer annotations cert-manager sets on the secrets it writes
+func (i *CertManagerIssuer) Issued(cert *x509.Certificate, issuedBy string) bool {
+	return issuedBy == certManagerIssuedBy(i.issuerGroup(), i.issuerKind(), i.IssuerName)
+}
+
+func (i *CertManagerIssuer) issuerKind() string {
+	if i.IssuerKind == "" {
+		return "Issuer"
+	}
+	return i.IssuerKind
+}
+
+func (i *CertManagerIssuer) issuerGroup() string {
+	if i.IssuerGroup == "" {
+		return "cert-manager.io"
+	}
+	return i.IssuerGroup
+}
+
+func certManagerIssuedBy(group, kind, name string) string {
+	return fmt.Sprintf("cert-manager:%s/%s/%s", group, kind, name)
+}
+
+func (i *CertManagerIssuer) certificate(req Request, name string) *unstructured.Unstructured {
+	spec := map[string]interface{}{
+		"secretName": name,
+		"commonName": req.CommonName,
+		"issuerRef": map[string]interface{}{
+			"name":  i.IssuerName,
+			"kind":  i.issuerKind(),
+			"group": i.issuerGroup(),
+		},
+	}
+	if len(req.DNSNames) > 0 {
+		spec["dnsNames"] = toInterfaceSlice(req.DNSNames)
+	}
+	if len(req.IPAddresses) > 0 {
+		var ips []string
+		for _, ip := range req.IPAddresses {
+			ips = append(ips, ip.String())
+		}
+		spec["ipAddresses"] = toInterfaceSlice(ips)
+	}
+	if len(req.Organization) > 0 {
+		spec["subject"] = map[string]interface{}{
+			"organizations": toInterfaceSlice(req.Organization),
+		}
+	}
+	if req.Duration != 0 {
+		spec["duration"] = req.Duration.String()
+	}
+	if privateKey := certManagerPrivateKey(req.KeyAlgorithm); privateKey != nil {
+		spec["privateKey"] = privateKey
+	}
+	var usages []string
+	for _, usage := range req.Usages {
+		switch usage {
+		case x509.ExtKeyUsageServerAuth:
+			usages = append(usages, "server auth")
+		case x509.ExtKeyUsageClientAuth:
+			usages = append(usages, "client auth")
+		}
+	}
+	if len(usages) > 0 {
+		spec["usages"] = toInterfaceSlice(append([]string{"digital signature", "key encipherment"}, usages...))
+	}
+
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "cert-manager.io/v1",
+			"kind":       "Certificate",
+			"metadata": map[string]interface{}{
+				"name":      name,
+				"namespace": req.Namespace,
+			},
+			"spec": spec,
+		},
+	}
+}
+
+func (i *CertManagerIssuer) cleanup(ctx context.Context, namespace, name string) error {
+	err := i.DynamicClient.Resource(CertificateGVR).Namespace(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+	if err != nil && !util.IsNotFoundErr(err) {
+		return errors.Wrapf(err, "delete certificate %s/%s", namespace, name)
+	}
+	err = i.Client.CoreV1().Secrets(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+	if err != nil && !util.IsNotFoundErr(err) {
+		return errors.Wrapf(err, "delete secret %s/%s", namespace, name)
+	}
+	return nil
+}
+
+// certificateReady returns true if the Certificate has a Ready condition with status True, or
+// the message of the Ready condition
+func certificateReady(obj *unstructured.Unstructured) (bool, string) {
+	conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	for _, c := range conditions {
+		condition, ok := c.(map[string]interface{})
+		if !ok || condition["type"] != "Ready" {
+			continue
+		}
+		message, _ := condition["message"].(string)
+		return condition["status"] == "True", message
+	}
+	return false, "not ready"
+}
+
+func toInterfaceSlice(in []string) []interface{} {
+	out := make([]interface{}, 0, len(in))
+	for _, s := range in {
+		out = append(out, s)
+	}
+	return out
+}
+
+// certManagerPrivateKey returns the Certificate privateKey for a kubeadm key algorithm, or nil to
+// keep the issuer's default
+func certManagerPrivateKey(algorithm kubeadmapi.EncryptionAlgorithmType) map[string]interface{} {
+	switch algorithm {
+	case kubeadmapi.EncryptionAlgorithmRSA2048:
+		return map[string]interface{}{"algorithm": "RSA", "size": int64(2048)}
+	case kubeadmapi.EncryptionAlgorithmRSA3072:
+		return map[string]interface{}{"algorithm": "RSA", "size": int64(3072)}
+	case kubeadmapi.EncryptionAlgorithmRSA4096:
+		return map[string]interface{}{"algorithm": "RSA", "size": int64(4096)}
+	case kubeadmapi.EncryptionAlgorithmECDSAP256:
+		return map[string]interface{}{"algorithm": "ECDSA", "size": int64(256)}
+	case kubeadmapi.EncryptionAlgorithmECDSAP384:
+		return map[string]interface{}{"algorithm": "ECDSA", "size": int64(384)}
+	}
+	return nil
+}
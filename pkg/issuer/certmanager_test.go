@@ -0,0 +1,138 @@
+package issuer
+
+import (
+	"context"
+	"crypto/x509"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestCertManagerIssuer(t *testing.T) {
+	req := require.New(t)
+	client := fake.NewSimpleClientset()
+	dynamicClient := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())
+
+	var created *unstructured.Unstructured
+	// act as cert-manager: write the secret and mark the Certificate ready
+	dynamicClient.PrependReactor("create", "certificates", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		created = action.(k8stesting.CreateAction).GetObject().(*unstructured.Unstructured).DeepCopy()
+		_, err := client.CoreV1().Secrets("default").Create(context.Background(), &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "kotsadm-tls-ekco",
+				Namespace: "default",
+				Annotations: map[string]string{
+					certManagerIssuerNameAnnotation:  "corporate-ca",
+					certManagerIssuerKindAnnotation:  "ClusterIssuer",
+					certManagerIssuerGroupAnnotation: "cert-manager.io",
+				},
+			},
+			Data: map[string][]byte{
+				corev1.TLSCertKey:       []byte("cert"),
+				corev1.TLSPrivateKeyKey: []byte("key"),
+				"ca.crt":                []byte("ca"),
+			},
+		}, metav1.CreateOptions{})
+		return false, nil, err
+	})
+	dynamicClient.PrependReactor("get", "certificates", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		obj := created.DeepCopy()
+		_ = unstructured.SetNestedSlice(obj.Object, []interface{}{
+			map[string]interface{}{"type": "Ready", "status": "True"},
+		}, "status", "conditions")
+		return true, obj, nil
+	})
+
+	iss := &CertManagerIssuer{
+		Client:        client,
+		DynamicClient: dynamicClient,
+		IssuerName:    "corporate-ca",
+		IssuerKind:    "ClusterIssuer",
+		PollInterval:  time.Millisecond,
+	}
+	issued, err := iss.Issue(context.Background(), Request{
+		CommonName: "kotsadm.default.svc.cluster.local",
+		DNSNames:   []string{"kotsadm.example.com"},
+		Usages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		Duration:   time.Hour,
+		Namespace:  "default",
+		SecretName: "kotsadm-tls",
+	})
+	req.NoError(err)
+	req.Equal(&Certificate{
+		Cert:     []byte("cert"),
+		Key:      []byte("key"),
+		CA:       []byte("ca"),
+		IssuedBy: "cert-manager:cert-manager.io/ClusterIssuer/corporate-ca",
+	}, issued)
+	req.True(iss.Issued(nil, issued.IssuedBy))
+	req.False(iss.Issued(nil, ""))
+	req.False((&CertManagerIssuer{IssuerName: "corporate-ca"}).Issued(nil, issued.IssuedBy))
+
+	spec, _, _ := unstructured.NestedMap(created.Object, "spec")
+	req.Equal(map[string]interface{}{
+		"secretName": "kotsadm-tls-ekco",
+		"commonName": "kotsadm.default.svc.cluster.local",
+		"dnsNames":   []interface{}{"kotsadm.example.com"},
+		"duration":   "1h0m0s",
+		"usages":     []interface{}{"digital signature", "key encipherment", "server auth"},
+		"issuerRef": map[string]interface{}{
+			"name":  "corporate-ca",
+			"kind":  "ClusterIssuer",
+			"group": "cert-manager.io",
+		},
+	}, spec)
+
+	// the Certificate and its secret are removed once the certificate has been copied
+	_, err = client.CoreV1().Secrets("default").Get(context.Background(), "kotsadm-tls-ekco", metav1.GetOptions{})
+	req.Error(err)
+}
+
+func Test_certificateReady(t *testing.T) {
+	tests := []struct {
+		name        string
+		conditions  []interface{}
+		wantReady   bool
+		wantMessage string
+	}{
+		{
+			name:        "no conditions",
+			wantMessage: "not ready",
+		},
+		{
+			name: "issuing",
+			conditions: []interface{}{
+				map[string]interface{}{"type": "Issuing", "status": "True"},
+				map[string]interface{}{"type": "Ready", "status": "False", "message": "Issuing certificate as Secret does not exist"},
+			},
+			wantMessage: "Issuing certificate as Secret does not exist",
+		},
+		{
+			name: "ready",
+			conditions: []interface{}{
+				map[string]interface{}{"type": "Ready", "status": "True", "message": "Certificate is up to date and has not expired"},
+			},
+			wantReady:   true,
+			wantMessage: "Certificate is up to date and has not expired",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj := &unstructured.Unstructured{Object: map[string]interface{}{}}
+			if tt.conditions != nil {
+				require.NoError(t, unstructured.SetNestedSlice(obj.Object, tt.conditions, "status", "conditions"))
+			}
+			ready, message := certificateReady(obj)
+			require.Equal(t, tt.wantReady, ready)
+			require.Equal(t, tt.wantMessage, message)
+		})
+	}
+}
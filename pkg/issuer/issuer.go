@@ -0,0 +1,138 @@
+// Package issuer issues the TLS certificates ekco stores in secrets for the registry, kurl proxy
+// and contour. The issuer used for each secret is selected by configuration.
+package issuer
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"regexp"
+	"time"
+
+	"github.com/pkg/errors"
+	certutil "k8s.io/client-go/util/cert"
+	kubeadmapi "k8s.io/kubernetes/cmd/kubeadm/app/apis/kubeadm"
+)
+
+const (
+	// TypeClusterCA signs with the kubeadm cluster CA
+	TypeClusterCA = "cluster-ca"
+	// TypeCA signs with a CA stored in a secret
+	TypeCA = "ca"
+	// TypeSelfSigned generates a new CA for each certificate
+	TypeSelfSigned = "self-signed"
+	// TypeCertManager requests the certificate from a cert-manager Issuer or ClusterIssuer
+	TypeCertManager = "cert-manager"
+	// TypeACME orders the certificate from an ACME directory with HTTP-01 challenges
+	TypeACME = "acme"
+)
+
+// Config selects and configures the issuer for a secret. Fields that do not apply to the type are
+// ignored.
+type Config struct {
+	Type string `mapstructure:"type"`
+	// namespace/name of a secret with tls.crt and tls.key of the CA for the ca type
+	CASecret string `mapstructure:"ca_secret"`
+	// cert-manager issuer reference. Kind defaults to Issuer in the namespace of the secret.
+	IssuerName  string `mapstructure:"issuer_name"`
+	IssuerKind  string `mapstructure:"issuer_kind"`
+	IssuerGroup string `mapstructure:"issuer_group"`
+	// ACME directory URL and account contact email
+	ACMEDirectory string `mapstructure:"acme_directory"`
+	ACMEEmail     string `mapstructure:"acme_email"`
+	// override the names copied from the existing certificate
+	DNSNames    []string `mapstructure:"dns_names"`
+	IPAddresses []string `mapstructure:"ip_addresses"`
+	// requested lifetime of the certificate. Issuers that choose the lifetime ignore this.
+	Duration time.Duration `mapstructure:"duration"`
+}
+
+// Request describes the certificate to issue
+type Request struct {
+	CommonName   string
+	Organization []string
+	DNSNames     []string
+	IPAddresses  []net.IP
+	Usages       []x509.ExtKeyUsage
+	Duration     time.Duration
+	// key algorithm of the certificate. Issuers use their default when empty.
+	KeyAlgorithm kubeadmapi.EncryptionAlgorithmType
+	// namespace and name of the secret the certificate will be stored in. Issuers that create
+	// resources name them after the secret.
+	Namespace  string
+	SecretName string
+}
+
+// IssuedByAnnotation is set on the secret an issued certificate is stored in to the IssuedBy of the
+// certificate, for issuers that cannot tell from the certificate whether they issued it
+const IssuedByAnnotation = "kurl.sh/cert-issued-by"
+
+// Certificate is an issued certificate and key
+type Certificate struct {
+	// PEM encoded certificate followed by any intermediates
+	Cert []byte
+	// PEM encoded private key
+	Key []byte
+	// PEM encoded CA that signed the certificate, if known
+	CA []byte
+	// identifies the issuer to store in IssuedByAnnotation, empty if the issuer checks the
+	// certificate itself
+	IssuedBy string
+}
+
+// Issuer issues certificates
+type Issuer interface {
+	Issue(ctx context.Context, req Request) (*Certificate, error)
+	// Issued returns false if the certificate was not issued by this issuer and should be replaced
+	// even if it is not expiring. issuedBy is the IssuedByAnnotation of the secret the certificate
+	// is stored in.
+	Issued(cert *x509.Certificate, issuedBy string) bool
+}
+
+// kubernetes and ekco append @<unix timestamp> to the common name of self-signed certificates
+var selfSignedSuffix = regexp.MustCompile(`@[0-9]+$`)
+
+// RequestFromCert returns a request for a certificate with the same subject, names and usages as
+// cert
+func RequestFromCert(cert *x509.Certificate, namespace, secretName string) Request {
+	return Request{
+		CommonName:   selfSignedSuffix.ReplaceAllString(cert.Subject.CommonName, ""),
+		Organization: cert.Subject.Organization,
+		DNSNames:     cert.DNSNames,
+		IPAddresses:  cert.IPAddresses,
+		Usages:       cert.ExtKeyUsage,
+		Namespace:    namespace,
+		SecretName:   secretName,
+	}
+}
+
+// Apply overrides the names and duration of the request with those set in the config
+func (c Config) Apply(req Request) (Request, error) {
+	if len(c.DNSNames) > 0 {
+		req.DNSNames = c.DNSNames
+	}
+	if len(c.IPAddresses) > 0 {
+		req.IPAddresses = nil
+		for _, address := range c.IPAddresses {
+			ip := net.ParseIP(address)
+			if ip == nil {
+				return req, fmt.Errorf("invalid IP address %q", address)
+			}
+			req.IPAddresses = append(req.IPAddresses, ip)
+		}
+	}
+	if c.Duration != 0 {
+		req.Duration = c.Duration
+	}
+	return req, nil
+}
+
+// Leaf returns the first certificate
+func (c *Certificate) Leaf() (*x509.Certificate, error) {
+	certs, err := certutil.ParseCertsPEM(c.Cert)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse issued certificate")
+	}
+	return certs[0], nil
+}
@@ -0,0 +1,219 @@
+package issuer
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	certutil "k8s.io/client-go/util/cert"
+	"k8s.io/client-go/util/keyutil"
+	kubeadmapi "k8s.io/kubernetes/cmd/kubeadm/app/apis/kubeadm"
+	"k8s.io/kubernetes/cmd/kubeadm/app/util/pkiutil"
+)
+
+func newTestCA(t *testing.T, name string) *CAIssuer {
+	cert, key, err := pkiutil.NewCertificateAuthority(&pkiutil.CertConfig{
+		Config:              certutil.Config{CommonName: name},
+		EncryptionAlgorithm: kubeadmapi.EncryptionAlgorithmECDSAP256,
+	})
+	require.NoError(t, err)
+	return &CAIssuer{Cert: cert, Key: key}
+}
+
+func TestCAIssuer(t *testing.T) {
+	req := require.New(t)
+	ca := newTestCA(t, "corporate-ca")
+	other := newTestCA(t, "other-ca")
+
+	issued, err := ca.Issue(context.Background(), Request{
+		CommonName:  "registry.kurl.svc.cluster.local",
+		DNSNames:    []string{"registry.kurl.svc.cluster.local"},
+		IPAddresses: []net.IP{net.ParseIP("10.96.0.10")},
+		Usages:      []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		Duration:    24 * time.Hour,
+	})
+	req.NoError(err)
+	req.Equal(pkiutil.EncodeCertPEM(ca.Cert), issued.CA)
+
+	leaf, err := issued.Leaf()
+	req.NoError(err)
+	req.Equal("registry.kurl.svc.cluster.local", leaf.Subject.CommonName)
+	req.Equal([]string{"registry.kurl.svc.cluster.local"}, leaf.DNSNames)
+	req.True(leaf.IPAddresses[0].Equal(net.ParseIP("10.96.0.10")))
+	req.WithinDuration(time.Now().Add(24*time.Hour), leaf.NotAfter, time.Minute)
+	req.True(ca.Issued(leaf, ""))
+	req.False(other.Issued(leaf, ""))
+
+	_, err = keyutil.ParsePrivateKeyPEM(issued.Key)
+	req.NoError(err)
+
+	_, err = ca.Issue(context.Background(), Request{CommonName: "no-usages"})
+	req.Error(err)
+}
+
+func TestNewCAIssuerFromPEM(t *testing.T) {
+	req := require.New(t)
+	ca := newTestCA(t, "corporate-ca")
+	keyPEM, err := keyutil.MarshalPrivateKeyToPEM(ca.Key)
+	req.NoError(err)
+
+	loaded, err := NewCAIssuerFromPEM(pkiutil.EncodeCertPEM(ca.Cert), keyPEM)
+	req.NoError(err)
+	req.Equal(ca.Cert.Raw, loaded.Cert.Raw)
+
+	leafPEM, leafKeyPEM, err := certutil.GenerateSelfSignedCertKey("leaf", nil, nil)
+	req.NoError(err)
+	certs, err := certutil.ParseCertsPEM(leafPEM)
+	req.NoError(err)
+	// GenerateSelfSignedCertKey returns the leaf followed by its CA
+	req.Len(certs, 2)
+	_, err = NewCAIssuerFromPEM(leafPEM, leafKeyPEM)
+	req.EqualError(err, "certificate is not a CA")
+}
+
+func TestSelfSignedIssuer(t *testing.T) {
+	req := require.New(t)
+	iss := SelfSignedIssuer{}
+
+	issued, err := iss.Issue(context.Background(), Request{
+		CommonName: "kotsadm.default.svc.cluster.local",
+		DNSNames:   []string{"kotsadm.example.com"},
+	})
+	req.NoError(err)
+	leaf, err := issued.Leaf()
+	req.NoError(err)
+	req.True(iss.Issued(leaf, ""))
+	req.Contains(leaf.DNSNames, "kotsadm.example.com")
+	req.IsType(&rsa.PublicKey{}, leaf.PublicKey)
+
+	issued, err = iss.Issue(context.Background(), Request{
+		CommonName:   "kotsadm.default.svc.cluster.local",
+		Duration:     90 * 24 * time.Hour,
+		KeyAlgorithm: kubeadmapi.EncryptionAlgorithmECDSAP256,
+	})
+	req.NoError(err)
+	leaf, err = issued.Leaf()
+	req.NoError(err)
+	req.True(iss.Issued(leaf, ""))
+	req.IsType(&ecdsa.PublicKey{}, leaf.PublicKey)
+	req.Equal(90*24*time.Hour, leaf.NotAfter.Sub(leaf.NotBefore))
+
+	ca := newTestCA(t, "corporate-ca")
+	issued, err = ca.Issue(context.Background(), Request{
+		CommonName: "kotsadm.default.svc.cluster.local",
+		Usages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	req.NoError(err)
+	leaf, err = issued.Leaf()
+	req.NoError(err)
+	req.False(iss.Issued(leaf, ""))
+}
+
+func TestACMEIssuer_Issued(t *testing.T) {
+	iss := &ACMEIssuer{DirectoryURL: "https://acme-v02.api.letsencrypt.org/directory"}
+	require.True(t, iss.Issued(nil, "acme:https://acme-v02.api.letsencrypt.org/directory"))
+	// certificates from another directory or issuer are replaced
+	require.False(t, iss.Issued(nil, "acme:https://acme-staging-v02.api.letsencrypt.org/directory"))
+	require.False(t, iss.Issued(nil, ""))
+}
+
+func TestRequestFromCert(t *testing.T) {
+	req := require.New(t)
+	certPEM, _, err := certutil.GenerateSelfSignedCertKey("kotsadm.default.svc.cluster.local", []net.IP{net.ParseIP("10.0.0.1")}, []string{"kotsadm.example.com"})
+	req.NoError(err)
+	certs, err := certutil.ParseCertsPEM(certPEM)
+	req.NoError(err)
+
+	r := RequestFromCert(certs[0], "default", "kotsadm-tls")
+	req.Equal("kotsadm.default.svc.cluster.local", r.CommonName)
+	req.Equal([]string{"kotsadm.default.svc.cluster.local", "kotsadm.example.com"}, r.DNSNames)
+	req.Equal([]x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}, r.Usages)
+	req.Equal("default", r.Namespace)
+	req.Equal("kotsadm-tls", r.SecretName)
+}
+
+func TestConfigApply(t *testing.T) {
+	orig := Request{
+		CommonName:  "registry",
+		DNSNames:    []string{"registry.kurl.svc"},
+		IPAddresses: []net.IP{net.ParseIP("10.96.0.10")},
+	}
+
+	tests := []struct {
+		name    string
+		config  Config
+		want    Request
+		wantErr bool
+	}{
+		{
+			name:   "empty",
+			config: Config{Type: TypeCA},
+			want:   orig,
+		},
+		{
+			name: "override names and duration",
+			config: Config{
+				DNSNames:    []string{"registry.example.com"},
+				IPAddresses: []string{"192.168.1.10"},
+				Duration:    90 * 24 * time.Hour,
+			},
+			want: Request{
+				CommonName:  "registry",
+				DNSNames:    []string{"registry.example.com"},
+				IPAddresses: []net.IP{net.ParseIP("192.168.1.10")},
+				Duration:    90 * 24 * time.Hour,
+			},
+		},
+		{
+			name:    "invalid IP",
+			config:  Config{IPAddresses: []string{"registry"}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.config.Apply(orig)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestHTTP01Solver(t *testing.T) {
+	req := require.New(t)
+	solver := NewHTTP01Solver()
+	server := httptest.NewServer(solver)
+	defer server.Close()
+
+	get := func(token string) (int, string) {
+		resp, err := http.Get(server.URL + HTTP01ChallengePath + token)
+		req.NoError(err)
+		defer resp.Body.Close()
+		body := make([]byte, 64)
+		n, _ := resp.Body.Read(body)
+		return resp.StatusCode, string(body[:n])
+	}
+
+	code, _ := get("abc")
+	req.Equal(http.StatusNotFound, code)
+
+	solver.Set("abc", "abc.thumbprint")
+	code, body := get("abc")
+	req.Equal(http.StatusOK, code)
+	req.Equal("abc.thumbprint", body)
+
+	solver.Delete("abc")
+	code, _ = get("abc")
+	req.Equal(http.StatusNotFound, code)
+}
@@ -0,0 +1,97 @@
+package issuer
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	certutil "k8s.io/client-go/util/cert"
+	"k8s.io/client-go/util/keyutil"
+	kubeadmapi "k8s.io/kubernetes/cmd/kubeadm/app/apis/kubeadm"
+	"k8s.io/kubernetes/cmd/kubeadm/app/util/pkiutil"
+	netutils "k8s.io/utils/net"
+)
+
+// lifetime of self-signed certificates when the request does not set a duration, the same as
+// certutil.GenerateSelfSignedCertKey
+const selfSignedValidityPeriod = 365 * 24 * time.Hour
+
+// SelfSignedIssuer generates a certificate signed by a new CA. The common names have the
+// <host>@<timestamp> and <host>-ca@<timestamp> format of certutil.GenerateSelfSignedCertKey.
+type SelfSignedIssuer struct{}
+
+var _ Issuer = SelfSignedIssuer{}
+
+func (SelfSignedIssuer) Issue(ctx context.Context, req Request) (*Certificate, error) {
+	duration := req.Duration
+	if duration == 0 {
+		duration = selfSignedValidityPeriod
+	}
+	algorithm := req.KeyAlgorithm
+	if algorithm == "" {
+		algorithm = kubeadmapi.EncryptionAlgorithmRSA2048
+	}
+	usages := req.Usages
+	if len(usages) == 0 {
+		usages = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	}
+	// valid an hour earlier to avoid flakes due to clock skew
+	notBefore := time.Now().Add(-time.Hour)
+	now := time.Now().Unix()
+
+	caCert, caKey, err := pkiutil.NewCertificateAuthority(&pkiutil.CertConfig{
+		Config: certutil.Config{
+			CommonName: fmt.Sprintf("%s-ca@%d", req.CommonName, now),
+			NotBefore:  notBefore,
+		},
+		NotAfter:            notBefore.Add(duration),
+		EncryptionAlgorithm: algorithm,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "generate self-signed CA")
+	}
+
+	altNames := certutil.AltNames{}
+	if ip := netutils.ParseIPSloppy(req.CommonName); ip != nil {
+		altNames.IPs = append(altNames.IPs, ip)
+	} else {
+		altNames.DNSNames = append(altNames.DNSNames, req.CommonName)
+	}
+	altNames.IPs = append(altNames.IPs, req.IPAddresses...)
+	altNames.DNSNames = append(altNames.DNSNames, req.DNSNames...)
+
+	cert, key, err := pkiutil.NewCertAndKey(caCert, caKey, &pkiutil.CertConfig{
+		Config: certutil.Config{
+			CommonName:   fmt.Sprintf("%s@%d", req.CommonName, now),
+			Organization: req.Organization,
+			AltNames:     altNames,
+			Usages:       usages,
+			NotBefore:    notBefore,
+		},
+		NotAfter:            notBefore.Add(duration),
+		EncryptionAlgorithm: algorithm,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "generate self-signed cert")
+	}
+
+	keyPEM, err := keyutil.MarshalPrivateKeyToPEM(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "encode key")
+	}
+	caPEM := pkiutil.EncodeCertPEM(caCert)
+	return &Certificate{
+		// certificate followed by the CA, as with certutil.GenerateSelfSignedCertKey
+		Cert: append(pkiutil.EncodeCertPEM(cert), caPEM...),
+		Key:  keyPEM,
+		CA:   caPEM,
+	}, nil
+}
+
+func (SelfSignedIssuer) Issued(cert *x509.Certificate, issuedBy string) bool {
+	host := selfSignedSuffix.ReplaceAllString(cert.Subject.CommonName, "")
+	return strings.HasPrefix(cert.Issuer.CommonName, host+"-ca@")
+}
@@ -0,0 +1,196 @@
+package kubeconfig
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// timeout of each check of a new server
+const checkTimeout = 10 * time.Second
+
+// GetCluster returns the server and certificate authority of the current context's cluster in the
+// kubeconfig file. Relative certificate authority paths are resolved from the directory of the file
+// and absolute paths under /etc are read from hostEtcDir.
+func GetCluster(file, hostEtcDir string) (string, []byte, error) {
+	config, err := clientcmd.LoadFromFile(file)
+	if err != nil {
+		return "", nil, err
+	}
+	clusterName := ""
+	if kubeContext, ok := config.Contexts[config.CurrentContext]; ok {
+		clusterName = kubeContext.Cluster
+	}
+	cluster, ok := config.Clusters[clusterName]
+	if !ok {
+		// kubeadm kubeconfigs have a single cluster
+		for _, c := range config.Clusters {
+			cluster = c
+			break
+		}
+	}
+	if cluster == nil {
+		return "", nil, fmt.Errorf("no cluster in %s", file)
+	}
+	if len(cluster.CertificateAuthorityData) > 0 {
+		return cluster.Server, cluster.CertificateAuthorityData, nil
+	}
+	if cluster.CertificateAuthority == "" {
+		return "", nil, fmt.Errorf("no certificate authority in %s", file)
+	}
+	caFile := cluster.CertificateAuthority
+	if strings.HasPrefix(caFile, "/etc/") {
+		caFile = filepath.Join(hostEtcDir, strings.TrimPrefix(caFile, "/etc/"))
+	} else if !filepath.IsAbs(caFile) {
+		caFile = filepath.Join(filepath.Dir(file), caFile)
+	}
+	ca, err := os.ReadFile(caFile)
+	if err != nil {
+		return "", nil, fmt.Errorf("read certificate authority: %w", err)
+	}
+	return cluster.Server, ca, nil
+}
+
+// ResolveServer returns the addresses of the host of server. IP addresses are returned as is.
+func ResolveServer(ctx context.Context, server string) ([]string, error) {
+	u, err := url.Parse(server)
+	if err != nil {
+		return nil, err
+	}
+	host := u.Hostname()
+	if net.ParseIP(host) != nil {
+		return []string{host}, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+	if err != nil {
+		return nil, fmt.Errorf("resolve %s: %w", host, err)
+	}
+	return addrs, nil
+}
+
+// CheckServerCertificate connects to server and verifies that its certificate is signed by the
+// certificate authority and valid for the host of server
+func CheckServerCertificate(ctx context.Context, server string, ca []byte) error {
+	u, err := url.Parse(server)
+	if err != nil {
+		return err
+	}
+	tlsConfig, err := serverTLSConfig(u, ca)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+	dialer := &tls.Dialer{Config: tlsConfig}
+	conn, err := dialer.DialContext(ctx, "tcp", serverAddress(u))
+	if err != nil {
+		return fmt.Errorf("tls handshake with %s: %w", u.Host, err)
+	}
+	return conn.Close()
+}
+
+// CheckServerReadyz returns an error unless /readyz of server returns 200. The request is
+// anonymous.
+func CheckServerReadyz(ctx context.Context, server string, ca []byte) error {
+	u, err := url.Parse(server)
+	if err != nil {
+		return err
+	}
+	tlsConfig, err := serverTLSConfig(u, ca)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{
+		Timeout:   checkTimeout,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig, DisableKeepAlives: true},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(server, "/")+"/readyz", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("readyz returned %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// CheckNodeLease connects to the server of the kubelet kubeconfig file with the kubelet's
+// credentials and returns an error unless the lease of the node was renewed after since. Paths in
+// the kubeconfig are read from the host mounted at hostRoot.
+func CheckNodeLease(ctx context.Context, file, hostRoot, nodeName string, since time.Time) error {
+	config, err := clientcmd.LoadFromFile(file)
+	if err != nil {
+		return err
+	}
+	hostPath := func(path string) string {
+		if path == "" {
+			return ""
+		}
+		if filepath.IsAbs(path) {
+			return filepath.Join(hostRoot, path)
+		}
+		return filepath.Join(filepath.Dir(file), path)
+	}
+	for _, cluster := range config.Clusters {
+		cluster.CertificateAuthority = hostPath(cluster.CertificateAuthority)
+	}
+	for _, authInfo := range config.AuthInfos {
+		authInfo.ClientCertificate = hostPath(authInfo.ClientCertificate)
+		authInfo.ClientKey = hostPath(authInfo.ClientKey)
+		authInfo.TokenFile = hostPath(authInfo.TokenFile)
+	}
+	restConfig, err := clientcmd.NewDefaultClientConfig(*config, &clientcmd.ConfigOverrides{}).ClientConfig()
+	if err != nil {
+		return err
+	}
+	restConfig.Timeout = checkTimeout
+	client, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return err
+	}
+
+	lease, err := client.CoordinationV1().Leases("kube-node-lease").Get(ctx, nodeName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("get lease of node %s: %w", nodeName, err)
+	}
+	if lease.Spec.RenewTime == nil || lease.Spec.RenewTime.Time.Before(since.Truncate(time.Second)) {
+		return fmt.Errorf("lease of node %s not renewed", nodeName)
+	}
+	return nil
+}
+
+func serverTLSConfig(u *url.URL, ca []byte) (*tls.Config, error) {
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificates in certificate authority")
+	}
+	return &tls.Config{RootCAs: pool, ServerName: u.Hostname()}, nil
+}
+
+func serverAddress(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	return net.JoinHostPort(u.Hostname(), "443")
+}
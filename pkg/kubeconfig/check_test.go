@@ -0,0 +1,118 @@
+package kubeconfig
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckServer(t *testing.T) {
+	var ready atomic.Bool
+	ready.Store(true)
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/readyz" || !ready.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+
+	// the certificate is valid for 127.0.0.1 and example.com
+	dir := t.TempDir()
+	file := filepath.Join(dir, "kubelet.conf")
+	require.NoError(t, os.WriteFile(file, []byte(`apiVersion: v1
+clusters:
+- cluster:
+    certificate-authority-data: `+base64.StdEncoding.EncodeToString(ca)+`
+    server: https://10.128.0.3:6443
+  name: kubernetes
+contexts:
+- context:
+    cluster: kubernetes
+    user: system:node:node1
+  name: system:node:node1@kubernetes
+current-context: system:node:node1@kubernetes
+kind: Config
+`), 0600))
+	server, gotCA, err := GetCluster(file, dir)
+	require.NoError(t, err)
+	assert.Equal(t, "https://10.128.0.3:6443", server)
+	assert.Equal(t, ca, gotCA)
+
+	ctx := context.Background()
+	addrs, err := ResolveServer(ctx, srv.URL)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"127.0.0.1"}, addrs)
+
+	require.NoError(t, CheckServerCertificate(ctx, srv.URL, ca))
+	require.NoError(t, CheckServerReadyz(ctx, srv.URL, ca))
+
+	// the certificate does not cover localhost
+	localhost := strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)
+	err = CheckServerCertificate(ctx, localhost, ca)
+	require.ErrorContains(t, err, "certificate is valid for")
+
+	ready.Store(false)
+	err = CheckServerReadyz(ctx, srv.URL, ca)
+	require.ErrorContains(t, err, "readyz returned 503")
+}
+
+func TestCheckNodeLease(t *testing.T) {
+	renewed := time.Now().UTC().Truncate(time.Second)
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/apis/coordination.k8s.io/v1/namespaces/kube-node-lease/leases/node1" || r.Header.Get("Authorization") != "Bearer node-token" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"apiVersion":"coordination.k8s.io/v1","kind":"Lease","metadata":{"name":"node1","namespace":"kube-node-lease"},"spec":{"renewTime":%q}}`,
+			renewed.Format("2006-01-02T15:04:05.000000Z07:00"))
+	}))
+	defer srv.Close()
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+
+	// the certificate authority and token are at absolute paths on the host
+	hostRoot := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(hostRoot, "etc/kubernetes/pki"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(hostRoot, "etc/kubernetes/pki/ca.crt"), ca, 0600))
+	require.NoError(t, os.MkdirAll(filepath.Join(hostRoot, "var/lib/kubelet"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(hostRoot, "var/lib/kubelet/token"), []byte("node-token"), 0600))
+	file := filepath.Join(hostRoot, "etc/kubernetes/kubelet.conf")
+	require.NoError(t, os.WriteFile(file, []byte(`apiVersion: v1
+clusters:
+- cluster:
+    certificate-authority: /etc/kubernetes/pki/ca.crt
+    server: `+srv.URL+`
+  name: kubernetes
+contexts:
+- context:
+    cluster: kubernetes
+    user: default-auth
+  name: default-context
+current-context: default-context
+kind: Config
+users:
+- name: default-auth
+  user:
+    tokenFile: /var/lib/kubelet/token
+`), 0600))
+
+	ctx := context.Background()
+	require.NoError(t, CheckNodeLease(ctx, file, hostRoot, "node1", renewed.Add(-time.Minute)))
+	require.EqualError(t, CheckNodeLease(ctx, file, hostRoot, "node1", renewed.Add(time.Minute)), "lease of node node1 not renewed")
+	require.ErrorContains(t, CheckNodeLease(ctx, file, hostRoot, "node2", renewed), "get lease of node node2")
+}
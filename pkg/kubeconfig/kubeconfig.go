@@ -1,46 +1,160 @@
 package kubeconfig
 
 import (
-	"io"
+	"fmt"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strings"
 
+	"github.com/replicatedhq/ekco/pkg/hostfile"
 	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 )
 
-func SetServer(file string, server string) error {
-	f, err := os.OpenFile(file, os.O_RDWR, 0)
-	if err != nil {
-		return err
+// components restarted when their kubeconfig changes
+const (
+	RestartKubeletComponent = "kubelet"
+)
+
+// File is a kubeconfig on the host
+type File struct {
+	// absolute path on the host
+	Path string
+	// component to restart when the file changes: kubelet or the name of a static pod
+	Restart string
+	// set the server of every cluster rather than only clusters that use the previous server
+	AllClusters bool
+}
+
+// KnownFiles are the kubeconfigs kubeadm writes and the kubeconfig of root. kube-controller-manager
+// and kube-scheduler use the local API server on recent versions of kubeadm and are only updated if
+// they use the previous server.
+var KnownFiles = []File{
+	{Path: "/etc/kubernetes/kubelet.conf", Restart: RestartKubeletComponent, AllClusters: true},
+	{Path: "/etc/kubernetes/admin.conf"},
+	{Path: "/etc/kubernetes/super-admin.conf"},
+	{Path: "/etc/kubernetes/controller-manager.conf", Restart: "kube-controller-manager"},
+	{Path: "/etc/kubernetes/scheduler.conf", Restart: "kube-scheduler"},
+	{Path: "/root/.kube/config"},
+}
+
+// Discover returns the known kubeconfigs and the files matching globs that exist on the host mounted
+// at hostRoot. Globs are absolute paths on the host.
+func Discover(hostRoot string, globs []string) ([]File, error) {
+	var files []File
+	seen := map[string]bool{}
+	add := func(file File) {
+		if seen[file.Path] {
+			return
+		}
+		if info, err := os.Stat(filepath.Join(hostRoot, file.Path)); err != nil || !info.Mode().IsRegular() {
+			return
+		}
+		seen[file.Path] = true
+		files = append(files, file)
 	}
-	bs, err := io.ReadAll(f)
-	if err != nil {
-		return err
+
+	for _, file := range KnownFiles {
+		add(file)
 	}
+	for _, glob := range globs {
+		if !filepath.IsAbs(glob) {
+			return nil, fmt.Errorf("kubeconfig glob %q is not an absolute path", glob)
+		}
+		matches, err := filepath.Glob(filepath.Join(hostRoot, glob))
+		if err != nil {
+			return nil, fmt.Errorf("kubeconfig glob %q: %w", glob, err)
+		}
+		for _, match := range matches {
+			rel, err := filepath.Rel(hostRoot, match)
+			if err != nil {
+				continue
+			}
+			add(File{Path: "/" + rel})
+		}
+	}
+	return files, nil
+}
 
-	config, err := clientcmd.Load(bs)
+// ValidateServer returns an error unless server is an http or https URL with a host. IPv6 addresses
+// must be in brackets, e.g. https://[fd00::1]:6443.
+func ValidateServer(server string) error {
+	u, err := url.Parse(server)
 	if err != nil {
-		return err
+		return fmt.Errorf("parse server %q: %w", server, err)
+	}
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return fmt.Errorf("server %q must start with https://", server)
+	}
+	if u.Hostname() == "" {
+		return fmt.Errorf("server %q has no host", server)
 	}
+	if !strings.HasPrefix(u.Host, "[") && strings.Count(u.Host, ":") > 1 {
+		return fmt.Errorf("IPv6 address in server %q must be in brackets", server)
+	}
+	return nil
+}
 
-	for key := range config.Clusters {
-		config.Clusters[key].Server = server
+// UpdateServer sets the server of the clusters in the kubeconfig file that use previous, or of every
+// cluster if previous is empty, and returns true if the file changed. The original is kept in the
+// backups.
+func UpdateServer(backups hostfile.Backups, file string, previous, server string) (bool, error) {
+	if err := ValidateServer(server); err != nil {
+		return false, err
 	}
 
+	bs, err := os.ReadFile(file)
+	if err != nil {
+		return false, err
+	}
+	config, err := clientcmd.Load(bs)
+	if err != nil {
+		return false, err
+	}
+	if !setClustersServer(config, previous, server) {
+		return false, nil
+	}
 	updated, err := clientcmd.Write(*config)
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	if err := f.Truncate(0); err != nil {
-		return err
+	info, err := os.Stat(file)
+	if err != nil {
+		return false, err
 	}
-	if _, err := f.Seek(0, 0); err != nil {
-		return err
+	if err := backups.WriteFile(file, updated, info.Mode().Perm()); err != nil {
+		return false, err
 	}
+	return true, nil
+}
 
-	if _, err := f.Write(updated); err != nil {
-		return err
+// UpdateServerData is UpdateServer for a kubeconfig that is not in a file, such as the kubeconfig
+// of kube-proxy in its ConfigMap
+func UpdateServerData(data []byte, previous, server string) ([]byte, bool, error) {
+	config, err := clientcmd.Load(data)
+	if err != nil {
+		return nil, false, err
 	}
+	if !setClustersServer(config, previous, server) {
+		return data, false, nil
+	}
+	updated, err := clientcmd.Write(*config)
+	if err != nil {
+		return nil, false, err
+	}
+	return updated, true, nil
+}
 
-	return nil
+func setClustersServer(config *clientcmdapi.Config, previous, server string) bool {
+	changed := false
+	for key, cluster := range config.Clusters {
+		if cluster.Server == server || (previous != "" && cluster.Server != previous) {
+			continue
+		}
+		config.Clusters[key].Server = server
+		changed = true
+	}
+	return changed
 }
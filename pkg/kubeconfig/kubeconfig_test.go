@@ -2,12 +2,16 @@ package kubeconfig
 
 import (
 	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/replicatedhq/ekco/pkg/hostfile"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/tools/clientcmd"
 )
 
-func TestSetServer(t *testing.T) {
+func TestUpdateServer_allClusters(t *testing.T) {
 	var kubeconfig = `apiVersion: v1
 clusters:
 - cluster:
@@ -40,7 +44,7 @@ users:
 		return
 	}
 
-	if err := SetServer(f.Name(), "https://localhost:6444"); err != nil {
+	if _, err := UpdateServer(hostfile.Backups{}, f.Name(), "", "https://localhost:6444"); err != nil {
 		t.Fatal(err)
 		return
 	}
@@ -72,3 +76,89 @@ users:
 `
 	assert.Equal(t, expect, string(got))
 }
+
+func TestValidateServer(t *testing.T) {
+	tests := []struct {
+		server  string
+		wantErr bool
+	}{
+		{server: "https://localhost:6444"},
+		{server: "https://10.128.0.3:6443"},
+		{server: "https://[fd00::1]:6443"},
+		{server: "https://[::1]"},
+		{server: "https://fd00::1:6443", wantErr: true},
+		{server: "10.128.0.3:6443", wantErr: true},
+		{server: "https://", wantErr: true},
+		{server: "https://[fd00::1:6443", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.server, func(t *testing.T) {
+			err := ValidateServer(tt.server)
+			assert.Equal(t, tt.wantErr, err != nil, "ValidateServer() error = %v", err)
+		})
+	}
+}
+
+func testKubeconfig(servers ...string) string {
+	config := "apiVersion: v1\nkind: Config\nclusters:\n"
+	for i, server := range servers {
+		config += "- name: cluster" + string(rune('a'+i)) + "\n  cluster:\n    server: " + server + "\n"
+	}
+	return config
+}
+
+func TestUpdateServer(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "config")
+	require.NoError(t, os.WriteFile(file, []byte(testKubeconfig("https://10.128.0.3:6443", "https://other.example.com")), 0600))
+	backups := hostfile.Backups{Dir: filepath.Join(dir, "backups"), HostRoot: dir, Task: "set-kubeconfig-server"}
+
+	changed, err := UpdateServer(backups, file, "https://10.128.0.3:6443", "https://[fd00::1]:6444")
+	require.NoError(t, err)
+	require.True(t, changed)
+
+	config, err := clientcmd.LoadFromFile(file)
+	require.NoError(t, err)
+	assert.Equal(t, "https://[fd00::1]:6444", config.Clusters["clustera"].Server)
+	assert.Equal(t, "https://other.example.com", config.Clusters["clusterb"].Server, "clusters using other servers are kept")
+	info, err := os.Stat(file)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	backup, err := clientcmd.LoadFromFile(filepath.Join(backups.Dir, backups.Name(), "files/config"))
+	require.NoError(t, err)
+	assert.Equal(t, "https://10.128.0.3:6443", backup.Clusters["clustera"].Server)
+
+	changed, err = UpdateServer(backups, file, "https://10.128.0.3:6443", "https://[fd00::1]:6444")
+	require.NoError(t, err)
+	assert.False(t, changed)
+
+	_, err = UpdateServer(backups, file, "", "https://fd00::1:6444")
+	require.Error(t, err)
+}
+
+func TestDiscover(t *testing.T) {
+	root := t.TempDir()
+	for _, path := range []string{
+		"/etc/kubernetes/kubelet.conf",
+		"/etc/kubernetes/scheduler.conf",
+		"/root/.kube/config",
+		"/home/admin/.kube/config",
+	} {
+		require.NoError(t, os.MkdirAll(filepath.Join(root, filepath.Dir(path)), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(root, path), []byte(testKubeconfig("https://10.128.0.3:6443")), 0600))
+	}
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "/home/empty/.kube/config"), 0755))
+
+	files, err := Discover(root, []string{"/home/*/.kube/config", "/root/.kube/config"})
+	require.NoError(t, err)
+	assert.Equal(t, []File{
+		{Path: "/etc/kubernetes/kubelet.conf", Restart: RestartKubeletComponent, AllClusters: true},
+		{Path: "/etc/kubernetes/scheduler.conf", Restart: "kube-scheduler"},
+		{Path: "/root/.kube/config"},
+		{Path: "/home/admin/.kube/config"},
+	}, files)
+
+	_, err = Discover(root, []string{"home/*/.kube/config"})
+	require.Error(t, err)
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"sync"
 
 	"github.com/replicatedhq/ekco/pkg/cluster"
@@ -266,7 +267,7 @@ func migrateObjectStorage(ctx context.Context, minioNS string, controllers types
 	}
 
 	// migrate all data from minio to rook
-	err = objectstore.SyncAllBuckets(ctx, fmt.Sprintf("%s:9000", minioPodIP), minioAccessKey, minioSecretKey, rookEndpoint, rookAccessKey, rookSecretKey, addLogs)
+	err = objectstore.SyncAllBuckets(ctx, net.JoinHostPort(minioPodIP, "9000"), minioAccessKey, minioSecretKey, rookEndpoint, rookAccessKey, rookSecretKey, addLogs)
 	if err != nil {
 		return fmt.Errorf("migrate data from minio to rook: %w", err)
 	}
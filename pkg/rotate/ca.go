@@ -0,0 +1,519 @@
+package rotate
+
+import (
+	"context"
+	"crypto"
+	cryptorand "crypto/rand"
+	"crypto/x509"
+	"fmt"
+	"math"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/replicatedhq/ekco/pkg/hostfile"
+	"github.com/replicatedhq/ekco/pkg/hosttask"
+	"github.com/replicatedhq/ekco/pkg/kubeconfig"
+	"k8s.io/client-go/tools/clientcmd"
+	certutil "k8s.io/client-go/util/cert"
+	"k8s.io/client-go/util/keyutil"
+	kubeadmapi "k8s.io/kubernetes/cmd/kubeadm/app/apis/kubeadm"
+	"k8s.io/kubernetes/cmd/kubeadm/app/phases/certs/renewal"
+	"k8s.io/kubernetes/cmd/kubeadm/app/util/pkiutil"
+)
+
+const (
+	// CAPhaseTrust adds the new CAs to the trust bundles
+	CAPhaseTrust = "trust"
+	// CAPhaseReissue signs with the new CAs and reissues all leaf certificates
+	CAPhaseReissue = "reissue"
+	// CAPhaseVerify checks that all leaf certificates are signed by the new CAs without making
+	// changes
+	CAPhaseVerify = "verify"
+	// CAPhaseFinalize removes the old CAs from the trust bundles
+	CAPhaseFinalize = "finalize"
+)
+
+// CertificateAuthority is a kubeadm CA that can be rotated
+type CertificateAuthority struct {
+	// base name of the cert and key in the kubeadm pki directory
+	BaseName string
+	// base name of the new cert and key in the CA rotation secret
+	SecretName string
+	// default common name of the kubeadm CA
+	CommonName string
+}
+
+var CertificateAuthorities = []CertificateAuthority{
+	{BaseName: "ca", SecretName: "ca", CommonName: "kubernetes"},
+	{BaseName: "front-proxy-ca", SecretName: "front-proxy-ca", CommonName: "front-proxy-ca"},
+	{BaseName: "etcd/ca", SecretName: "etcd-ca", CommonName: "etcd-ca"},
+}
+
+// static pods restarted after each phase in the order they are restarted. Etcd is first since the
+// API server depends on it.
+var caRotationStaticPods = []string{"etcd", "kube-apiserver", "kube-controller-manager", "kube-scheduler"}
+
+type RotateCAOptions struct {
+	Phase         string
+	ConfDir       string
+	KubeletPKIDir string
+	// directory with the cert and key of each new CA
+	NewCADir string
+	Hostname string
+	// true on primaries, which have the CA keys and control plane static pods
+	Primary bool
+	// directory to keep the original files in, empty to not keep backups
+	BackupDir string
+}
+
+// RotateCA runs a phase of CA rotation on a node. Each phase is idempotent so that a failed phase
+// can be run again.
+func RotateCA(ctx context.Context, opts RotateCAOptions, result *hosttask.Result) error {
+	newCAs := map[string]*x509.Certificate{}
+	for _, ca := range CertificateAuthorities {
+		cert, err := pkiutil.TryLoadCertFromDisk(opts.NewCADir, ca.SecretName)
+		if err != nil {
+			return errors.Wrapf(err, "load new %s", ca.BaseName)
+		}
+		newCAs[ca.BaseName] = cert
+	}
+
+	switch opts.Phase {
+	case CAPhaseTrust:
+		if err := trustNewCAs(opts, newCAs, result); err != nil {
+			return err
+		}
+	case CAPhaseReissue:
+		if err := reissueWithNewCAs(opts, newCAs, result); err != nil {
+			return err
+		}
+	case CAPhaseVerify:
+		return verifyIssuedByNewCAs(opts, newCAs, result)
+	case CAPhaseFinalize:
+		if err := removeOldCAs(opts, newCAs, result); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown CA rotation phase %q", opts.Phase)
+	}
+
+	if err := restartForCARotation(ctx, opts, result); err != nil {
+		return err
+	}
+	if opts.Phase == CAPhaseFinalize {
+		return removeOldCAKeyBackups(opts, result)
+	}
+	return nil
+}
+
+// trustNewCAs appends the new CA to each CA bundle and kubeconfig. The old CA remains first in the
+// bundle so that it continues to be used for signing.
+func trustNewCAs(opts RotateCAOptions, newCAs map[string]*x509.Certificate, result *hosttask.Result) error {
+	pkiDir := filepath.Join(opts.ConfDir, "pki")
+	backups := hostBackups(opts.BackupDir, result)
+
+	for _, ca := range CertificateAuthorities {
+		bundle, err := readCABundle(pkiDir, ca.BaseName)
+		if err != nil {
+			return err
+		}
+		if bundle == nil {
+			continue
+		}
+		newCA := newCAs[ca.BaseName]
+		if containsCert(bundle, newCA) {
+			result.Stepf("skip", "%s already trusts the new CA on host %s", ca.BaseName, opts.Hostname)
+			continue
+		}
+		if err := writeCABundle(backups, pkiDir, ca.BaseName, []*x509.Certificate{bundle[0], newCA}, result); err != nil {
+			return err
+		}
+		result.Stepf(CAPhaseTrust, "Added new CA to %s bundle on host %s", ca.BaseName, opts.Hostname)
+	}
+
+	return setKubeconfigCAs(backups, opts.ConfDir, pkiDir, result)
+}
+
+// reissueWithNewCAs makes the new CA the signing CA on primaries and renews all kubeadm leaf
+// certificates and the kubelet client certificate
+func reissueWithNewCAs(opts RotateCAOptions, newCAs map[string]*x509.Certificate, result *hosttask.Result) error {
+	pkiDir := filepath.Join(opts.ConfDir, "pki")
+	backups := hostBackups(opts.BackupDir, result)
+
+	if opts.Primary {
+		for _, ca := range CertificateAuthorities {
+			bundle, err := readCABundle(pkiDir, ca.BaseName)
+			if err != nil {
+				return err
+			}
+			if bundle == nil {
+				continue
+			}
+			newCA := newCAs[ca.BaseName]
+			if bundle[0].Equal(newCA) {
+				result.Stepf("skip", "%s is already signing with the new CA on host %s", ca.BaseName, opts.Hostname)
+				continue
+			}
+			_, newKey, err := pkiutil.TryLoadCertAndKeyFromDisk(opts.NewCADir, ca.SecretName)
+			if err != nil {
+				return errors.Wrapf(err, "load new %s key", ca.BaseName)
+			}
+			if err := writeKey(backups, pkiDir, ca.BaseName, newKey); err != nil {
+				return errors.Wrapf(err, "write %s key", ca.BaseName)
+			}
+			result.ChangedFile(filepath.Join(pkiDir, ca.BaseName+".key"))
+			bundle = append([]*x509.Certificate{newCA}, removeCert(bundle, newCA)...)
+			if err := writeCABundle(backups, pkiDir, ca.BaseName, bundle, result); err != nil {
+				return err
+			}
+			result.Stepf(CAPhaseReissue, "Signing with new CA %s on host %s", ca.BaseName, opts.Hostname)
+		}
+
+		rm, err := renewal.NewManager(&kubeadmapi.ClusterConfiguration{CertificatesDir: pkiDir}, opts.ConfDir)
+		if err != nil {
+			return errors.Wrap(err, "new renewal manager")
+		}
+		for _, handler := range rm.Certificates() {
+			if ok, err := rm.CertificateExists(handler.Name); err != nil {
+				return errors.Wrapf(err, "check for existing %s on host %s", handler.Name, opts.Hostname)
+			} else if !ok {
+				continue
+			}
+			files := renewedFiles(opts.ConfDir, pkiDir, handler)
+			if err := backupFiles(backups, files); err != nil {
+				return errors.Wrapf(err, "back up %s on host %s", handler.Name, opts.Hostname)
+			}
+			renewed, err := rm.RenewUsingLocalCA(handler.Name)
+			if err != nil {
+				return errors.Wrapf(err, "renew %s on host %s", handler.Name, opts.Hostname)
+			}
+			if !renewed {
+				return errors.Errorf("%s has external CA %s on host %s", handler.Name, handler.CABaseName, opts.Hostname)
+			}
+			result.Stepf(CAPhaseReissue, "Reissued %s on host %s", handler.Name, opts.Hostname)
+			for _, file := range files {
+				result.ChangedFile(file)
+			}
+		}
+	}
+
+	if err := reissueKubeletClientCert(opts, result); err != nil {
+		return err
+	}
+
+	// kubeadm sets kubeconfigs to trust only the signing CA when it renews them, but API servers
+	// on other primaries may still be serving certificates signed by the old CA
+	return setKubeconfigCAs(backups, opts.ConfDir, pkiDir, result)
+}
+
+// reissueKubeletClientCert signs the kubelet's current client certificate key with the new cluster
+// CA. The kubelet will otherwise continue to use a certificate signed by the old CA until it
+// rotates the certificate itself.
+func reissueKubeletClientCert(opts RotateCAOptions, result *hosttask.Result) error {
+	current := filepath.Join(opts.KubeletPKIDir, "kubelet-client-current.pem")
+	certs, err := certutil.CertsFromFile(current)
+	if err != nil {
+		if os.IsNotExist(errors.Cause(err)) {
+			return nil
+		}
+		return errors.Wrapf(err, "read %s", current)
+	}
+	caCert, caKey, err := pkiutil.TryLoadCertAndKeyFromDisk(opts.NewCADir, "ca")
+	if err != nil {
+		return errors.Wrap(err, "load new ca")
+	}
+	if certs[0].CheckSignatureFrom(caCert) == nil {
+		result.Stepf("skip", "Kubelet client certificate is already signed by the new CA on host %s", opts.Hostname)
+		return nil
+	}
+	key, err := keyutil.PrivateKeyFromFile(current)
+	if err != nil {
+		return errors.Wrapf(err, "read key from %s", current)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return errors.Errorf("key in %s is not a signer", current)
+	}
+
+	serial, err := cryptorand.Int(cryptorand.Reader, new(big.Int).SetInt64(math.MaxInt64))
+	if err != nil {
+		return errors.Wrap(err, "generate serial number")
+	}
+	template := &x509.Certificate{
+		Subject:      certs[0].Subject,
+		SerialNumber: serial,
+		NotBefore:    time.Now().Add(-5 * time.Minute),
+		NotAfter:     certs[0].NotAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(cryptorand.Reader, template, caCert, signer.Public(), caKey)
+	if err != nil {
+		return errors.Wrap(err, "sign kubelet client certificate")
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return errors.Wrap(err, "parse kubelet client certificate")
+	}
+
+	if err := writeKubeletClientCert(opts.KubeletPKIDir, cert, key, result); err != nil {
+		return err
+	}
+	result.Stepf(CAPhaseReissue, "Reissued kubelet client certificate on host %s", opts.Hostname)
+
+	return nil
+}
+
+// verifyIssuedByNewCAs fails if any leaf certificate on the node is not signed by one of the new
+// CAs. It makes no changes.
+func verifyIssuedByNewCAs(opts RotateCAOptions, newCAs map[string]*x509.Certificate, result *hosttask.Result) error {
+	pkiDir := filepath.Join(opts.ConfDir, "pki")
+
+	var paths []string
+	for _, dir := range []string{pkiDir, filepath.Join(pkiDir, "etcd")} {
+		matches, err := filepath.Glob(filepath.Join(dir, "*.crt"))
+		if err != nil {
+			return errors.Wrapf(err, "list certificates in %s", dir)
+		}
+		paths = append(paths, matches...)
+	}
+	paths = append(paths, filepath.Join(opts.KubeletPKIDir, "kubelet-client-current.pem"))
+
+	var notReissued []string
+	for _, path := range paths {
+		certs, err := certutil.CertsFromFile(path)
+		if err != nil {
+			if os.IsNotExist(errors.Cause(err)) {
+				continue
+			}
+			return errors.Wrapf(err, "read %s", path)
+		}
+		if certs[0].IsCA {
+			continue
+		}
+		if !signedByAny(certs[0], newCAs) {
+			notReissued = append(notReissued, path)
+		}
+	}
+
+	kubeconfigs, err := filepath.Glob(filepath.Join(opts.ConfDir, "*.conf"))
+	if err != nil {
+		return errors.Wrapf(err, "list kubeconfigs in %s", opts.ConfDir)
+	}
+	for _, path := range kubeconfigs {
+		config, err := clientcmd.LoadFromFile(path)
+		if err != nil {
+			return errors.Wrapf(err, "load %s", path)
+		}
+		for _, authInfo := range config.AuthInfos {
+			if len(authInfo.ClientCertificateData) == 0 {
+				continue
+			}
+			certs, err := certutil.ParseCertsPEM(authInfo.ClientCertificateData)
+			if err != nil {
+				return errors.Wrapf(err, "parse client certificate in %s", path)
+			}
+			if !signedByAny(certs[0], newCAs) {
+				notReissued = append(notReissued, path)
+			}
+		}
+	}
+
+	if len(notReissued) > 0 {
+		return errors.Errorf("certificates not signed by the new CAs on host %s: %s", opts.Hostname, strings.Join(notReissued, ", "))
+	}
+	result.Stepf(CAPhaseVerify, "All certificates are signed by the new CAs on host %s", opts.Hostname)
+	return nil
+}
+
+// removeOldCAs leaves only the new CA in each CA bundle and kubeconfig
+func removeOldCAs(opts RotateCAOptions, newCAs map[string]*x509.Certificate, result *hosttask.Result) error {
+	pkiDir := filepath.Join(opts.ConfDir, "pki")
+	backups := hostBackups(opts.BackupDir, result)
+
+	for _, ca := range CertificateAuthorities {
+		bundle, err := readCABundle(pkiDir, ca.BaseName)
+		if err != nil {
+			return err
+		}
+		if bundle == nil {
+			continue
+		}
+		newCA := newCAs[ca.BaseName]
+		if len(bundle) == 1 && bundle[0].Equal(newCA) {
+			result.Stepf("skip", "%s trusts only the new CA on host %s", ca.BaseName, opts.Hostname)
+			continue
+		}
+		if !containsCert(bundle, newCA) {
+			return errors.Errorf("%s does not trust the new CA on host %s", ca.BaseName, opts.Hostname)
+		}
+		if err := writeCABundle(backups, pkiDir, ca.BaseName, []*x509.Certificate{newCA}, result); err != nil {
+			return err
+		}
+		result.Stepf(CAPhaseFinalize, "Removed old CA from %s bundle on host %s", ca.BaseName, opts.Hostname)
+	}
+
+	return setKubeconfigCAs(backups, opts.ConfDir, pkiDir, result)
+}
+
+// removeOldCAKeyBackups deletes the old CA keys that the reissue phase kept in the backups once
+// the old CAs are no longer trusted
+func removeOldCAKeyBackups(opts RotateCAOptions, result *hosttask.Result) error {
+	if opts.BackupDir == "" {
+		return nil
+	}
+	pkiDir := filepath.Join(opts.ConfDir, "pki")
+	var keys []string
+	for _, ca := range CertificateAuthorities {
+		keys = append(keys, filepath.Join(pkiDir, ca.BaseName+".key"))
+	}
+	removed, err := hostfile.RemoveFromBackups(opts.BackupDir, keys)
+	if err != nil {
+		return errors.Wrapf(err, "remove old CA key backups on host %s", opts.Hostname)
+	}
+	if removed > 0 {
+		result.Stepf(CAPhaseFinalize, "Removed %d old CA key backups on host %s", removed, opts.Hostname)
+	}
+	return nil
+}
+
+// restartForCARotation restarts the control plane static pods in order and then the kubelet so
+// that they load the updated bundles and certificates
+func restartForCARotation(ctx context.Context, opts RotateCAOptions, result *hosttask.Result) error {
+	if len(result.ChangedFiles) == 0 {
+		return nil
+	}
+	if opts.Primary {
+		for _, name := range caRotationStaticPods {
+			if _, err := os.Stat(filepath.Join(opts.ConfDir, "manifests", name+".yaml")); os.IsNotExist(err) {
+				continue
+			}
+			if err := restartStaticPod(hostBackups(opts.BackupDir, result), name+".yaml", opts.Hostname, result); err != nil {
+				return err
+			}
+		}
+	}
+	result.Stepf("restart", "Restarting kubelet on host %s", opts.Hostname)
+	if err := kubeconfig.RestartKubelet(ctx); err != nil {
+		return errors.Wrap(err, "restart kubelet")
+	}
+	result.RestartedComponent("kubelet")
+	return nil
+}
+
+// readCABundle returns the certificates in the CA file or nil if it does not exist on this node
+func readCABundle(pkiDir, baseName string) ([]*x509.Certificate, error) {
+	path := filepath.Join(pkiDir, baseName+".crt")
+	certs, err := certutil.CertsFromFile(path)
+	if err != nil {
+		if os.IsNotExist(errors.Cause(err)) {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "read %s", path)
+	}
+	return certs, nil
+}
+
+func writeCABundle(backups hostfile.Backups, pkiDir, baseName string, bundle []*x509.Certificate, result *hosttask.Result) error {
+	if err := writeCertBundle(backups, pkiDir, baseName, bundle); err != nil {
+		return errors.Wrapf(err, "write %s bundle", baseName)
+	}
+	result.ChangedFile(filepath.Join(pkiDir, baseName+".crt"))
+	return nil
+}
+
+// setKubeconfigCAs sets the embedded CA data of every kubeconfig to the cluster CA bundle
+func setKubeconfigCAs(backups hostfile.Backups, confDir, pkiDir string, result *hosttask.Result) error {
+	caData, err := os.ReadFile(filepath.Join(pkiDir, "ca.crt"))
+	if err != nil {
+		return errors.Wrap(err, "read ca.crt")
+	}
+	paths, err := filepath.Glob(filepath.Join(confDir, "*.conf"))
+	if err != nil {
+		return errors.Wrapf(err, "list kubeconfigs in %s", confDir)
+	}
+	for _, path := range paths {
+		config, err := clientcmd.LoadFromFile(path)
+		if err != nil {
+			return errors.Wrapf(err, "load %s", path)
+		}
+		changed := false
+		for _, cluster := range config.Clusters {
+			if len(cluster.CertificateAuthorityData) == 0 || string(cluster.CertificateAuthorityData) == string(caData) {
+				continue
+			}
+			cluster.CertificateAuthorityData = caData
+			changed = true
+		}
+		if !changed {
+			continue
+		}
+		data, err := clientcmd.Write(*config)
+		if err != nil {
+			return errors.Wrapf(err, "encode %s", path)
+		}
+		if err := backups.WriteFile(path, data, 0600); err != nil {
+			return errors.Wrapf(err, "write %s", path)
+		}
+		result.ChangedFile(path)
+	}
+	return nil
+}
+
+func containsCert(bundle []*x509.Certificate, cert *x509.Certificate) bool {
+	for _, c := range bundle {
+		if c.Equal(cert) {
+			return true
+		}
+	}
+	return false
+}
+
+func removeCert(bundle []*x509.Certificate, cert *x509.Certificate) []*x509.Certificate {
+	var out []*x509.Certificate
+	for _, c := range bundle {
+		if !c.Equal(cert) {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+func signedByAny(cert *x509.Certificate, cas map[string]*x509.Certificate) bool {
+	for _, ca := range cas {
+		if cert.CheckSignatureFrom(ca) == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// writeKubeletClientCert writes the certificate and key to a timestamped file and points the
+// kubelet-client-current.pem symlink at it, the same as the kubelet certificate manager
+func writeKubeletClientCert(pkiDir string, cert *x509.Certificate, key crypto.PrivateKey, result *hosttask.Result) error {
+	keyPEM, err := keyutil.MarshalPrivateKeyToPEM(key)
+	if err != nil {
+		return errors.Wrap(err, "encode kubelet client key")
+	}
+	data := append(pkiutil.EncodeCertPEM(cert), keyPEM...)
+
+	current := filepath.Join(pkiDir, "kubelet-client-current.pem")
+	filename := filepath.Join(pkiDir, fmt.Sprintf("kubelet-client-%s.pem", time.Now().Format("2006-01-02-15-04-05")))
+	if err := hostfile.WriteFileAtomic(filename, data, 0600); err != nil {
+		return errors.Wrapf(err, "write %s", filename)
+	}
+	tmp := current + ".tmp"
+	_ = os.Remove(tmp)
+	if err := os.Symlink(filename, tmp); err != nil {
+		return errors.Wrapf(err, "link %s", tmp)
+	}
+	if err := os.Rename(tmp, current); err != nil {
+		return errors.Wrapf(err, "mv %s to %s", tmp, current)
+	}
+	result.ChangedFile(filename)
+	return nil
+}
@@ -0,0 +1,159 @@
+package rotate
+
+import (
+	"crypto"
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/replicatedhq/ekco/pkg/hosttask"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	certutil "k8s.io/client-go/util/cert"
+	"k8s.io/client-go/util/keyutil"
+	kubeadmapi "k8s.io/kubernetes/cmd/kubeadm/app/apis/kubeadm"
+	"k8s.io/kubernetes/cmd/kubeadm/app/util/pkiutil"
+)
+
+func TestCARotationBundles(t *testing.T) {
+	req := require.New(t)
+
+	confDir := t.TempDir()
+	pkiDir := filepath.Join(confDir, "pki")
+	newCADir := t.TempDir()
+	req.NoError(os.MkdirAll(filepath.Join(pkiDir, "etcd"), 0755))
+
+	oldCAs := map[string]*x509.Certificate{}
+	newCAs := map[string]*x509.Certificate{}
+	for _, ca := range CertificateAuthorities {
+		oldCAs[ca.BaseName] = writeTestCA(t, pkiDir, ca.BaseName, ca.CommonName)
+		newCAs[ca.BaseName] = writeTestCA(t, newCADir, ca.SecretName, ca.CommonName)
+	}
+
+	caData, err := os.ReadFile(filepath.Join(pkiDir, "ca.crt"))
+	req.NoError(err)
+	leaf, leafKey, err := pkiutil.NewCertAndKey(oldCAs["ca"], mustLoadKey(t, pkiDir, "ca"), &pkiutil.CertConfig{
+		Config: certutil.Config{
+			CommonName: "kubernetes-admin",
+			Usages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		},
+		EncryptionAlgorithm: kubeadmapi.EncryptionAlgorithmRSA2048,
+	})
+	req.NoError(err)
+	keyData, err := keyutil.MarshalPrivateKeyToPEM(leafKey)
+	req.NoError(err)
+	config := clientcmdapi.NewConfig()
+	config.Clusters["kubernetes"] = &clientcmdapi.Cluster{Server: "https://10.0.0.1:6443", CertificateAuthorityData: caData}
+	config.AuthInfos["admin"] = &clientcmdapi.AuthInfo{ClientCertificateData: pkiutil.EncodeCertPEM(leaf), ClientKeyData: keyData}
+	config.Contexts["admin"] = &clientcmdapi.Context{Cluster: "kubernetes", AuthInfo: "admin"}
+	config.CurrentContext = "admin"
+	req.NoError(clientcmd.WriteToFile(*config, filepath.Join(confDir, "admin.conf")))
+
+	opts := RotateCAOptions{
+		ConfDir:       confDir,
+		KubeletPKIDir: t.TempDir(),
+		NewCADir:      newCADir,
+		Hostname:      "node1",
+		Primary:       true,
+	}
+
+	// trust
+	result := hosttask.NewResult("rotate-ca", "node1")
+	req.NoError(trustNewCAs(opts, newCAs, result))
+	for _, ca := range CertificateAuthorities {
+		bundle, err := readCABundle(pkiDir, ca.BaseName)
+		req.NoError(err)
+		req.Len(bundle, 2)
+		req.True(bundle[0].Equal(oldCAs[ca.BaseName]), ca.BaseName)
+		req.True(bundle[1].Equal(newCAs[ca.BaseName]), ca.BaseName)
+	}
+	requireKubeconfigCAs(t, filepath.Join(confDir, "admin.conf"), 2)
+
+	// trust is idempotent
+	result = hosttask.NewResult("rotate-ca", "node1")
+	req.NoError(trustNewCAs(opts, newCAs, result))
+	req.Empty(result.ChangedFiles)
+
+	// verify fails while the admin cert is signed by the old CA
+	err = verifyIssuedByNewCAs(opts, newCAs, hosttask.NewResult("rotate-ca", "node1"))
+	req.Error(err)
+	req.Contains(err.Error(), "admin.conf")
+
+	// finalize
+	result = hosttask.NewResult("rotate-ca", "node1")
+	req.NoError(removeOldCAs(opts, newCAs, result))
+	for _, ca := range CertificateAuthorities {
+		bundle, err := readCABundle(pkiDir, ca.BaseName)
+		req.NoError(err)
+		req.Len(bundle, 1)
+		req.True(bundle[0].Equal(newCAs[ca.BaseName]), ca.BaseName)
+	}
+	requireKubeconfigCAs(t, filepath.Join(confDir, "admin.conf"), 1)
+}
+
+func TestRemoveOldCAsWithoutTrust(t *testing.T) {
+	req := require.New(t)
+
+	confDir := t.TempDir()
+	pkiDir := filepath.Join(confDir, "pki")
+	newCADir := t.TempDir()
+
+	writeTestCA(t, pkiDir, "ca", "kubernetes")
+	newCAs := map[string]*x509.Certificate{
+		"ca": writeTestCA(t, newCADir, "ca", "kubernetes"),
+	}
+
+	opts := RotateCAOptions{ConfDir: confDir, NewCADir: newCADir, Hostname: "node1"}
+	err := removeOldCAs(opts, newCAs, hosttask.NewResult("rotate-ca", "node1"))
+	req.Error(err)
+	req.Contains(err.Error(), "does not trust the new CA")
+}
+
+func writeTestCA(t *testing.T, dir, baseName, commonName string) *x509.Certificate {
+	cert, key, err := pkiutil.NewCertificateAuthority(&pkiutil.CertConfig{
+		Config:              certutil.Config{CommonName: commonName},
+		EncryptionAlgorithm: kubeadmapi.EncryptionAlgorithmRSA2048,
+	})
+	require.NoError(t, err)
+	require.NoError(t, pkiutil.WriteCertAndKey(dir, baseName, cert, key))
+	return cert
+}
+
+func mustLoadKey(t *testing.T, dir, baseName string) crypto.Signer {
+	_, key, err := pkiutil.TryLoadCertAndKeyFromDisk(dir, baseName)
+	require.NoError(t, err)
+	return key
+}
+
+func requireKubeconfigCAs(t *testing.T, path string, count int) {
+	config, err := clientcmd.LoadFromFile(path)
+	require.NoError(t, err)
+	certs, err := certutil.ParseCertsPEM(config.Clusters["kubernetes"].CertificateAuthorityData)
+	require.NoError(t, err)
+	require.Len(t, certs, count)
+}
+
+func TestRemoveOldCAKeyBackups(t *testing.T) {
+	req := require.New(t)
+
+	confDir := t.TempDir()
+	pkiDir := filepath.Join(confDir, "pki")
+	newCADir := t.TempDir()
+	writeTestCA(t, pkiDir, "ca", "kubernetes")
+	newCA := writeTestCA(t, newCADir, "ca", "kubernetes")
+
+	opts := RotateCAOptions{ConfDir: confDir, NewCADir: newCADir, Hostname: "node1", BackupDir: t.TempDir()}
+	result := hosttask.NewResult("rotate-ca", "node1")
+	backups := hostBackups(opts.BackupDir, result)
+	req.NoError(writeKey(backups, pkiDir, "ca", mustLoadKey(t, newCADir, "ca")))
+	req.NoError(writeCABundle(backups, pkiDir, "ca", []*x509.Certificate{newCA}, result))
+
+	req.NoError(removeOldCAKeyBackups(opts, result))
+	_, err := os.Stat(filepath.Join(opts.BackupDir, backups.Name(), "files", pkiDir, "ca.key"))
+	req.True(os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(opts.BackupDir, backups.Name(), "files", pkiDir, "ca.crt"))
+	req.NoError(err)
+	req.Equal(CAPhaseFinalize, result.Steps[len(result.Steps)-1].Name)
+}
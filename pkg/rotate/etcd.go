@@ -0,0 +1,124 @@
+package rotate
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/replicatedhq/ekco/pkg/hostfile"
+	"github.com/replicatedhq/ekco/pkg/hosttask"
+	certutil "k8s.io/client-go/util/cert"
+	kubeadmconstants "k8s.io/kubernetes/cmd/kubeadm/app/constants"
+)
+
+// time allowed to connect to etcd and complete the TLS handshake
+const etcdDialTimeout = 10 * time.Second
+
+// VerifyEtcdCerts connects to the etcd client and peer ports at address and compares the
+// certificates etcd presents to server.crt and peer.crt on disk. Etcd reloads its certificates
+// from disk, but if it is still presenting an old certificate the etcd static pod is restarted.
+func VerifyEtcdCerts(backups hostfile.Backups, pkiDir, address, hostname string, result *hosttask.Result) error {
+	if _, err := os.Stat(filepath.Join(pkiDir, kubeadmconstants.EtcdServerCertAndKeyBaseName+".crt")); os.IsNotExist(err) {
+		result.Stepf("skip", "No local etcd certificates on host %s, skipping etcd verification", hostname)
+		return nil
+	}
+
+	checks := []struct {
+		port int
+		// certificate etcd serves on the port
+		serving string
+		// certificate etcd accepts from clients on the port
+		client string
+	}{
+		{kubeadmconstants.EtcdListenClientPort, kubeadmconstants.EtcdServerCertAndKeyBaseName, kubeadmconstants.EtcdHealthcheckClientCertAndKeyBaseName},
+		{kubeadmconstants.EtcdListenPeerPort, kubeadmconstants.EtcdPeerCertAndKeyBaseName, kubeadmconstants.EtcdPeerCertAndKeyBaseName},
+	}
+
+	restart := false
+	for _, check := range checks {
+		clientCert, err := tls.LoadX509KeyPair(
+			filepath.Join(pkiDir, check.client+".crt"),
+			filepath.Join(pkiDir, check.client+".key"),
+		)
+		if err != nil {
+			return errors.Wrapf(err, "load %s on host %s", check.client, hostname)
+		}
+		certFile := filepath.Join(pkiDir, check.serving+".crt")
+		endpoint := net.JoinHostPort(address, strconv.Itoa(check.port))
+
+		stale, err := etcdCertStale(endpoint, certFile, clientCert)
+		if err != nil {
+			return errors.Wrapf(err, "verify etcd certificate on host %s", hostname)
+		}
+		if stale {
+			result.Stepf("verify", "Etcd at %s is not serving %s on host %s", endpoint, certFile, hostname)
+			restart = true
+		} else {
+			result.Stepf("verify", "Etcd at %s is serving %s on host %s", endpoint, certFile, hostname)
+		}
+	}
+
+	if restart {
+		return restartStaticPod(backups, "etcd.yaml", hostname, result)
+	}
+	return nil
+}
+
+// etcdCertStale returns true if the certificate presented at endpoint expires at a different time
+// than the certificate in certFile
+func etcdCertStale(endpoint, certFile string, clientCert tls.Certificate) (bool, error) {
+	certs, err := certutil.CertsFromFile(certFile)
+	if err != nil {
+		return false, errors.Wrapf(err, "read %s", certFile)
+	}
+
+	presented, err := presentedCertificate(endpoint, clientCert)
+	if err != nil {
+		return false, err
+	}
+
+	return !presented.NotAfter.Equal(certs[0].NotAfter), nil
+}
+
+// presentedCertificate returns the leaf certificate presented by the TLS server at endpoint
+func presentedCertificate(endpoint string, clientCert tls.Certificate) (*x509.Certificate, error) {
+	var presented *x509.Certificate
+	config := &tls.Config{
+		Certificates: []tls.Certificate{clientCert},
+		// the presented certificate is compared to the file rather than trusted, and an expired
+		// certificate must still be read so that etcd is restarted
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return errors.New("no certificate presented")
+			}
+			cert, err := x509.ParseCertificate(rawCerts[0])
+			if err != nil {
+				return errors.Wrap(err, "parse presented certificate")
+			}
+			presented = cert
+			return nil
+		},
+	}
+
+	dialer := &tls.Dialer{
+		NetDialer: &net.Dialer{Timeout: etcdDialTimeout},
+		Config:    config,
+	}
+	conn, err := dialer.Dial("tcp", endpoint)
+	if err != nil {
+		// the handshake may fail after the certificate has been read if etcd rejects the client
+		if presented != nil {
+			return presented, nil
+		}
+		return nil, errors.Wrapf(err, "connect to %s", endpoint)
+	}
+	_ = conn.Close()
+
+	return presented, nil
+}
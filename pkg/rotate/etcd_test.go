@@ -0,0 +1,87 @@
+package rotate
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/replicatedhq/ekco/pkg/hostfile"
+	"github.com/replicatedhq/ekco/pkg/hosttask"
+	"github.com/stretchr/testify/require"
+	certutil "k8s.io/client-go/util/cert"
+	kubeadmapi "k8s.io/kubernetes/cmd/kubeadm/app/apis/kubeadm"
+	"k8s.io/kubernetes/cmd/kubeadm/app/util/pkiutil"
+)
+
+func Test_etcdCertStale(t *testing.T) {
+	req := require.New(t)
+	pkiDir := t.TempDir()
+	ca := writeTestCA(t, pkiDir, "etcd/ca", "etcd-ca")
+	caKey := mustLoadKey(t, pkiDir, "etcd/ca")
+
+	newCert := func(baseName string, usage x509.ExtKeyUsage, notAfter time.Time) {
+		cert, key, err := pkiutil.NewCertAndKey(ca, caKey, &pkiutil.CertConfig{
+			Config: certutil.Config{
+				CommonName: "node1",
+				AltNames:   certutil.AltNames{IPs: []net.IP{net.ParseIP("127.0.0.1")}},
+				Usages:     []x509.ExtKeyUsage{usage},
+			},
+			NotAfter:            notAfter,
+			EncryptionAlgorithm: kubeadmapi.EncryptionAlgorithmECDSAP256,
+		})
+		req.NoError(err)
+		req.NoError(pkiutil.WriteCertAndKey(pkiDir, baseName, cert, key))
+	}
+	newCert("etcd/server", x509.ExtKeyUsageServerAuth, time.Now().Add(30*24*time.Hour).Truncate(time.Second))
+	newCert("etcd/healthcheck-client", x509.ExtKeyUsageClientAuth, time.Now().Add(365*24*time.Hour))
+
+	serving, err := tls.LoadX509KeyPair(filepath.Join(pkiDir, "etcd/server.crt"), filepath.Join(pkiDir, "etcd/server.key"))
+	req.NoError(err)
+	client, err := tls.LoadX509KeyPair(filepath.Join(pkiDir, "etcd/healthcheck-client.crt"), filepath.Join(pkiDir, "etcd/healthcheck-client.key"))
+	req.NoError(err)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{serving},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	})
+	req.NoError(err)
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.(*tls.Conn).Handshake()
+			_ = conn.Close()
+		}
+	}()
+	endpoint := listener.Addr().String()
+
+	stale, err := etcdCertStale(endpoint, filepath.Join(pkiDir, "etcd/server.crt"), client)
+	req.NoError(err)
+	req.False(stale)
+
+	// the server.crt on disk has been rotated but the listener still serves the old cert
+	newCert("etcd/server", x509.ExtKeyUsageServerAuth, time.Now().Add(365*24*time.Hour).Truncate(time.Second))
+	stale, err = etcdCertStale(endpoint, filepath.Join(pkiDir, "etcd/server.crt"), client)
+	req.NoError(err)
+	req.True(stale)
+
+	listener.Close()
+	_, err = etcdCertStale(endpoint, filepath.Join(pkiDir, "etcd/server.crt"), client)
+	req.Error(err)
+}
+
+func TestVerifyEtcdCerts_externalEtcd(t *testing.T) {
+	result := hosttask.NewResult("rotate-certs", "node1")
+	require.NoError(t, VerifyEtcdCerts(hostfile.Backups{}, t.TempDir(), "127.0.0.1", "node1", result))
+	require.Equal(t, "skip", result.Steps[0].Name)
+	require.Empty(t, result.RestartedComponents)
+}
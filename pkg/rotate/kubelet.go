@@ -0,0 +1,71 @@
+package rotate
+
+import (
+	"context"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/replicatedhq/ekco/pkg/certinventory"
+	"github.com/replicatedhq/ekco/pkg/hosttask"
+	"github.com/replicatedhq/ekco/pkg/kubeconfig"
+	"k8s.io/kubernetes/cmd/kubeadm/app/util/pkiutil"
+)
+
+type RotateKubeletCertsOptions struct {
+	ConfigPath string
+	PKIDir     string
+	// directory with tls.crt and tls.key of a new client certificate signed by the cluster CA
+	ClientCertDir string
+	// remove the self-signed serving certificate so that the kubelet generates a new one
+	Serving  bool
+	Hostname string
+	// directory to keep the original files in, empty to not keep backups
+	BackupDir string
+}
+
+// RotateKubeletCerts installs a new kubelet client certificate and regenerates the self-signed
+// serving certificate for kubelets that do not rotate their own certificates, then restarts the
+// kubelet
+func RotateKubeletCerts(ctx context.Context, opts RotateKubeletCertsOptions, result *hosttask.Result) error {
+	config, err := certinventory.ReadKubeletConfig(opts.ConfigPath)
+	if err != nil {
+		return err
+	}
+
+	if opts.ClientCertDir != "" {
+		cert, key, err := pkiutil.TryLoadCertAndKeyFromDisk(opts.ClientCertDir, "tls")
+		if err != nil {
+			return errors.Wrap(err, "load new kubelet client certificate")
+		}
+		if err := writeKubeletClientCert(opts.PKIDir, cert, key, result); err != nil {
+			return err
+		}
+		result.Stepf("rotate", "Installed kubelet client certificate expiring %s on host %s", cert.NotAfter, opts.Hostname)
+	}
+
+	if opts.Serving {
+		if config.ServerTLSBootstrap || config.TLSCertFile != "" {
+			result.Stepf("skip", "Kubelet serving certificate is not self-signed on host %s", opts.Hostname)
+		} else {
+			backups := hostBackups(opts.BackupDir, result)
+			for _, filename := range []string{"kubelet.crt", "kubelet.key"} {
+				path := filepath.Join(opts.PKIDir, filename)
+				if _, err := backups.Remove(path); err != nil {
+					return errors.Wrapf(err, "remove %s", path)
+				}
+				result.ChangedFile(path)
+			}
+			result.Stepf("rotate", "Removed self-signed kubelet serving certificate on host %s", opts.Hostname)
+		}
+	}
+
+	if len(result.ChangedFiles) == 0 {
+		return nil
+	}
+	result.Stepf("restart", "Restarting kubelet on host %s", opts.Hostname)
+	if err := kubeconfig.RestartKubelet(ctx); err != nil {
+		return errors.Wrap(err, "restart kubelet")
+	}
+	result.RestartedComponent("kubelet")
+	return nil
+}
@@ -0,0 +1,190 @@
+package rotate
+
+import (
+	"net"
+	"path/filepath"
+	"slices"
+
+	"github.com/pkg/errors"
+	"github.com/replicatedhq/ekco/pkg/hosttask"
+	"github.com/replicatedhq/ekco/pkg/util"
+	certutil "k8s.io/client-go/util/cert"
+	kubeadmconstants "k8s.io/kubernetes/cmd/kubeadm/app/constants"
+	certsphase "k8s.io/kubernetes/cmd/kubeadm/app/phases/certs"
+	"k8s.io/kubernetes/cmd/kubeadm/app/phases/certs/renewal"
+	"k8s.io/kubernetes/cmd/kubeadm/app/util/pkiutil"
+)
+
+// serving certificates that can be regenerated with different subject alternative names
+const (
+	RegenCertAPIServer  = "apiserver"
+	RegenCertEtcdServer = "etcd-server"
+	RegenCertEtcdPeer   = "etcd-peer"
+)
+
+type regenCert struct {
+	baseName   string
+	caBaseName string
+	// static pod manifest of the component that serves the certificate
+	manifest string
+}
+
+var regenCerts = map[string]regenCert{
+	RegenCertAPIServer:  {kubeadmconstants.APIServerCertAndKeyBaseName, kubeadmconstants.CACertAndKeyBaseName, "kube-apiserver.yaml"},
+	RegenCertEtcdServer: {kubeadmconstants.EtcdServerCertAndKeyBaseName, kubeadmconstants.EtcdCACertAndKeyBaseName, "etcd.yaml"},
+	RegenCertEtcdPeer:   {kubeadmconstants.EtcdPeerCertAndKeyBaseName, kubeadmconstants.EtcdCACertAndKeyBaseName, "etcd.yaml"},
+}
+
+type RegenCertsOptions struct {
+	PKIDir string
+	// any of apiserver, etcd-server and etcd-peer
+	Certs        []string
+	AddAltNames  []string
+	DropAltNames []string
+	// restart the static pods serving the certificates that changed
+	Restart  bool
+	Hostname string
+	// directory to keep the original files in, empty to not keep backups
+	BackupDir string
+}
+
+// RegenCerts regenerates serving certificates with subject alternative names added and dropped.
+// Certificates whose names would not change are left as they are. The new certificates keep the
+// expiration of the old ones.
+func RegenCerts(opts RegenCertsOptions, result *hosttask.Result) error {
+	var restart []string
+	for _, name := range opts.Certs {
+		rc, ok := regenCerts[name]
+		if !ok {
+			return errors.Errorf("unknown certificate %q", name)
+		}
+
+		changed, err := regenCertFile(opts, rc, result)
+		if err != nil {
+			return errors.Wrapf(err, "regenerate %s on host %s", name, opts.Hostname)
+		}
+		if changed && !slices.Contains(restart, rc.manifest) {
+			restart = append(restart, rc.manifest)
+		}
+	}
+
+	if !opts.Restart {
+		return nil
+	}
+	for _, manifest := range restart {
+		if err := restartStaticPod(hostBackups(opts.BackupDir, result), manifest, opts.Hostname, result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func regenCertFile(opts RegenCertsOptions, rc regenCert, result *hosttask.Result) (bool, error) {
+	cert, err := pkiutil.TryLoadCertFromDisk(opts.PKIDir, rc.baseName)
+	if err != nil {
+		return false, errors.Wrapf(err, "load %s", rc.baseName)
+	}
+
+	altNames := RegenAltNames(cert.IPAddresses, cert.DNSNames, opts.AddAltNames, opts.DropAltNames)
+	if sameAltNames(altNames, certutil.AltNames{IPs: cert.IPAddresses, DNSNames: cert.DNSNames}) {
+		result.Stepf("skip", "%s already has the requested names on host %s", rc.baseName, opts.Hostname)
+		return false, nil
+	}
+
+	// check if encryption algorithm is supported
+	encryptionAlgorithm, err := util.GetEncryptionAlgorithmType(cert)
+	if err != nil {
+		return false, errors.Wrapf(err, "get encryption algorithm type from %s", rc.baseName)
+	}
+
+	cfg := &pkiutil.CertConfig{
+		Config: certutil.Config{
+			CommonName:   cert.Subject.CommonName,
+			Organization: cert.Subject.Organization,
+			AltNames:     altNames,
+			Usages:       cert.ExtKeyUsage,
+		},
+		NotAfter:            cert.NotAfter,
+		EncryptionAlgorithm: encryptionAlgorithm,
+	}
+
+	caCert, caKey, err := certsphase.LoadCertificateAuthority(opts.PKIDir, rc.caBaseName)
+	if err != nil {
+		return false, errors.Wrapf(err, "load %s", rc.caBaseName)
+	}
+	newCert, newKey, err := renewal.NewFileRenewer(caCert, caKey).Renew(cfg)
+	if err != nil {
+		return false, errors.Wrap(err, "sign certificate")
+	}
+	if err := writeCertAndKey(hostBackups(opts.BackupDir, result), opts.PKIDir, rc.baseName, newCert, newKey); err != nil {
+		return false, errors.Wrapf(err, "write %s", rc.baseName)
+	}
+
+	result.Stepf("regen", "Regenerated %s with IPs %s and DNS names %s on host %s", rc.baseName, altNames.IPs, altNames.DNSNames, opts.Hostname)
+	result.ChangedFile(filepath.Join(opts.PKIDir, rc.baseName+".crt"))
+	result.ChangedFile(filepath.Join(opts.PKIDir, rc.baseName+".key"))
+
+	return true, nil
+}
+
+// RegenAltNames returns the IPs and DNS names with the names in drop removed and the names in add
+// appended. Each name in add and drop is parsed as an IP address or else taken as a DNS name.
+func RegenAltNames(ips []net.IP, dnsNames []string, add, drop []string) certutil.AltNames {
+	dropIPs := map[string]bool{}
+	dropDNSNames := map[string]bool{}
+	for _, name := range drop {
+		if ip := net.ParseIP(name); ip != nil {
+			dropIPs[ip.String()] = true
+		} else if name != "" {
+			dropDNSNames[name] = true
+		}
+	}
+
+	altNames := certutil.AltNames{}
+	seenIPs := map[string]bool{}
+	seenDNSNames := map[string]bool{}
+	addIP := func(ip net.IP) {
+		if dropIPs[ip.String()] || seenIPs[ip.String()] {
+			return
+		}
+		seenIPs[ip.String()] = true
+		altNames.IPs = append(altNames.IPs, ip)
+	}
+	addDNSName := func(name string) {
+		if name == "" || dropDNSNames[name] || seenDNSNames[name] {
+			return
+		}
+		seenDNSNames[name] = true
+		altNames.DNSNames = append(altNames.DNSNames, name)
+	}
+
+	for _, ip := range ips {
+		addIP(ip)
+	}
+	for _, name := range dnsNames {
+		addDNSName(name)
+	}
+	for _, name := range add {
+		if ip := net.ParseIP(name); ip != nil {
+			addIP(ip)
+		} else {
+			addDNSName(name)
+		}
+	}
+
+	return altNames
+}
+
+// sameAltNames returns true if a and b have the same names in any order
+func sameAltNames(a, b certutil.AltNames) bool {
+	return slices.Equal(sortedNames(a), sortedNames(b))
+}
+
+func sortedNames(altNames certutil.AltNames) []string {
+	names := slices.Clone(altNames.DNSNames)
+	for _, ip := range altNames.IPs {
+		names = append(names, ip.String())
+	}
+	slices.Sort(names)
+	return names
+}
@@ -0,0 +1,136 @@
+package rotate
+
+import (
+	"crypto/x509"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/replicatedhq/ekco/pkg/hosttask"
+	"github.com/stretchr/testify/require"
+	certutil "k8s.io/client-go/util/cert"
+	kubeadmapi "k8s.io/kubernetes/cmd/kubeadm/app/apis/kubeadm"
+	"k8s.io/kubernetes/cmd/kubeadm/app/util/pkiutil"
+)
+
+func TestRegenAltNames(t *testing.T) {
+	ips := []net.IP{net.ParseIP("10.96.0.1"), net.ParseIP("10.0.0.1")}
+	dnsNames := []string{"kubernetes", "kubernetes.default", "node1"}
+
+	tests := []struct {
+		name string
+		add  []string
+		drop []string
+		want certutil.AltNames
+	}{
+		{
+			name: "unchanged",
+			want: certutil.AltNames{IPs: ips, DNSNames: dnsNames},
+		},
+		{
+			name: "add IP and DNS name",
+			add:  []string{"10.0.0.100", "lb.example.com"},
+			want: certutil.AltNames{
+				IPs:      []net.IP{net.ParseIP("10.96.0.1"), net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.100")},
+				DNSNames: []string{"kubernetes", "kubernetes.default", "node1", "lb.example.com"},
+			},
+		},
+		{
+			name: "add existing names",
+			add:  []string{"10.0.0.1", "node1", "node1"},
+			want: certutil.AltNames{IPs: ips, DNSNames: dnsNames},
+		},
+		{
+			name: "replace load balancer",
+			add:  []string{"lb2.example.com"},
+			drop: []string{"10.0.0.1", "node1"},
+			want: certutil.AltNames{
+				IPs:      []net.IP{net.ParseIP("10.96.0.1")},
+				DNSNames: []string{"kubernetes", "kubernetes.default", "lb2.example.com"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := RegenAltNames(ips, dnsNames, tt.add, tt.drop)
+			require.Equal(t, tt.want.DNSNames, got.DNSNames)
+			require.Equal(t, len(tt.want.IPs), len(got.IPs))
+			for i := range got.IPs {
+				require.True(t, tt.want.IPs[i].Equal(got.IPs[i]), "IP %d: want %s, got %s", i, tt.want.IPs[i], got.IPs[i])
+			}
+		})
+	}
+}
+
+func TestRegenCerts(t *testing.T) {
+	req := require.New(t)
+	pkiDir := t.TempDir()
+	ca := writeTestCA(t, pkiDir, "ca", "kubernetes")
+	etcdCA := writeTestCA(t, pkiDir, "etcd/ca", "etcd-ca")
+
+	notAfter := time.Now().Add(100 * 24 * time.Hour).Truncate(time.Second)
+	writeServingCert := func(caCert *x509.Certificate, caBaseName, baseName string) {
+		cert, key, err := pkiutil.NewCertAndKey(caCert, mustLoadKey(t, pkiDir, caBaseName), &pkiutil.CertConfig{
+			Config: certutil.Config{
+				CommonName: "kube-apiserver",
+				AltNames: certutil.AltNames{
+					IPs:      []net.IP{net.ParseIP("10.0.0.1")},
+					DNSNames: []string{"node1"},
+				},
+				Usages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+			},
+			NotAfter:            notAfter,
+			EncryptionAlgorithm: kubeadmapi.EncryptionAlgorithmRSA2048,
+		})
+		req.NoError(err)
+		req.NoError(pkiutil.WriteCertAndKey(pkiDir, baseName, cert, key))
+	}
+	writeServingCert(ca, "ca", "apiserver")
+	writeServingCert(etcdCA, "etcd/ca", "etcd/server")
+	original, err := os.ReadFile(filepath.Join(pkiDir, "apiserver.crt"))
+	req.NoError(err)
+
+	opts := RegenCertsOptions{
+		PKIDir:      pkiDir,
+		Certs:       []string{RegenCertAPIServer, RegenCertEtcdServer},
+		AddAltNames: []string{"10.0.0.100", "lb.example.com"},
+		Hostname:    "node1",
+		BackupDir:   t.TempDir(),
+	}
+	result := hosttask.NewResult("regen-cert", "node1")
+	req.NoError(RegenCerts(opts, result))
+	req.Len(result.ChangedFiles, 4)
+	req.Empty(result.RestartedComponents)
+
+	// the original certificates are kept in the backup
+	backups := hostBackups(opts.BackupDir, result)
+	backup, err := os.ReadFile(filepath.Join(opts.BackupDir, backups.Name(), "files", pkiDir, "apiserver.crt"))
+	req.NoError(err)
+	req.Equal(original, backup)
+
+	for _, c := range []struct {
+		baseName string
+		ca       *x509.Certificate
+	}{
+		{"apiserver", ca},
+		{"etcd/server", etcdCA},
+	} {
+		cert, err := pkiutil.TryLoadCertFromDisk(pkiDir, c.baseName)
+		req.NoError(err)
+		req.NoError(cert.CheckSignatureFrom(c.ca))
+		req.ElementsMatch([]string{"node1", "lb.example.com"}, cert.DNSNames)
+		req.ElementsMatch([]string{"10.0.0.1", "10.0.0.100"}, []string{cert.IPAddresses[0].String(), cert.IPAddresses[1].String()})
+		req.True(notAfter.Equal(cert.NotAfter))
+	}
+
+	// the certificates already have the names
+	result = hosttask.NewResult("regen-cert", "node1")
+	req.NoError(RegenCerts(opts, result))
+	req.Empty(result.ChangedFiles)
+	req.Equal("skip", result.Steps[0].Name)
+
+	opts.Certs = []string{"front-proxy-client"}
+	req.EqualError(RegenCerts(opts, result), `unknown certificate "front-proxy-client"`)
+}
@@ -1,28 +1,51 @@
 package rotate
 
 import (
-	"fmt"
-	"os"
+	"crypto"
+	"crypto/x509"
 	"path/filepath"
-	"time"
+	"strings"
 
 	"github.com/pkg/errors"
+	"github.com/replicatedhq/ekco/pkg/certpolicy"
+	"github.com/replicatedhq/ekco/pkg/hostfile"
+	"github.com/replicatedhq/ekco/pkg/hosttask"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/duration"
+	"k8s.io/client-go/util/keyutil"
 	kubeadmapi "k8s.io/kubernetes/cmd/kubeadm/app/apis/kubeadm"
 	kubeadmconstants "k8s.io/kubernetes/cmd/kubeadm/app/constants"
 	"k8s.io/kubernetes/cmd/kubeadm/app/phases/certs/renewal"
+	"k8s.io/kubernetes/cmd/kubeadm/app/util/pkiutil"
 )
 
-// RotateCerts is run on primary nodes in short-lived pods scheduled by the ekco operator, which
-// aggregates and parses the output of this function to determine when certificates were rotated
-// and components restarted so that those actions can be logged at the info level in the operator's
-// logs where they are more likely to be seen.
-func RotateCerts(ttl time.Duration, hostname string) error {
+// RotateCerts is run on primary nodes in short-lived pods scheduled by the ekco operator. The
+// certificates rotated and components restarted are recorded in the result, which the operator
+// reads from the pod's termination message. If etcdAddress is set the certificates served by the
+// local etcd member are verified after rotation. Certificates are renewed within the policy's
+// renew before duration of expiration, with the policy's validity and key algorithm. The key
+// algorithm defaults to that of the cluster CA. The originals of the renewed files are kept in
+// backupDir, empty to not keep backups.
+func RotateCerts(policy certpolicy.Policy, hostname, etcdAddress, backupDir string, result *hosttask.Result) error {
 	confDir := "/etc/kubernetes"
 	pkiDir := filepath.Join(confDir, "pki")
+	backups := hostBackups(backupDir, result)
+
+	caCert, err := pkiutil.TryLoadCertFromDisk(pkiDir, kubeadmconstants.CACertAndKeyBaseName)
+	if err != nil {
+		return errors.Wrapf(err, "load CA certificate on host %s", hostname)
+	}
+	algorithm, err := policy.Algorithm(caCert)
+	if err != nil {
+		return errors.Wrapf(err, "get key algorithm on host %s", hostname)
+	}
 
 	cc := &kubeadmapi.ClusterConfiguration{
-		CertificatesDir: pkiDir,
+		CertificatesDir:     pkiDir,
+		EncryptionAlgorithm: algorithm,
+	}
+	if policy.Validity != 0 {
+		cc.CertificateValidityPeriod = &metav1.Duration{Duration: policy.Validity}
 	}
 	rm, err := renewal.NewManager(cc, confDir)
 	if err != nil {
@@ -37,7 +60,7 @@ func RotateCerts(ttl time.Duration, hostname string) error {
 		if ok, err := rm.CertificateExists(handler.Name); err != nil {
 			return errors.Wrapf(err, "check for existing %s on host %s", handler.Name, hostname)
 		} else if !ok {
-			fmt.Printf("Missing certificate %s on host %s\n", handler.Name, hostname)
+			result.Stepf("missing", "Missing certificate %s on host %s", handler.Name, hostname)
 			continue
 		}
 
@@ -45,20 +68,24 @@ func RotateCerts(ttl time.Duration, hostname string) error {
 		if err != nil {
 			return errors.Wrapf(err, "get certificate %s expiration on host %s", handler.Name, hostname)
 		}
-		if ei.ResidualTime() > ttl {
-			fmt.Printf("%s has %s until expiration on host %s, skipping renewal\n", handler.Name, duration.ShortHumanDuration(ei.ResidualTime()), hostname)
+		if ei.ResidualTime() > policy.RenewBefore {
+			result.Stepf("skip", "%s has %s until expiration on host %s, skipping renewal", handler.Name, duration.ShortHumanDuration(ei.ResidualTime()), hostname)
 			continue
 		}
 
+		files := renewedFiles(confDir, pkiDir, handler)
+		if err := backupFiles(backups, files); err != nil {
+			return errors.Wrapf(err, "back up %s on host %s", handler.Name, hostname)
+		}
 		renewed, err := rm.RenewUsingLocalCA(handler.Name)
 		if err != nil {
 			return errors.Wrapf(err, "renew %s on host %s", handler.Name, hostname)
 		}
 		if !renewed {
-			return errors.Wrapf(err, "%s has external CA %s on host %s", handler.Name, handler.CABaseName, hostname)
+			return errors.Errorf("%s has external CA %s on host %s", handler.Name, handler.CABaseName, hostname)
 		}
 
-		// Etcd reloads client and server certs from disk (no restart needed)
+		// Etcd reloads client and server certs from disk (verified below)
 		// API server reloads only server certs from disk
 		switch handler.Name {
 		case kubeadmconstants.ControllerManagerKubeConfigFileName:
@@ -73,24 +100,32 @@ func RotateCerts(ttl time.Duration, hostname string) error {
 			restartAPIServer = true
 		}
 
-		// warning - this output is parsed by the ekco operator
-		fmt.Printf("Rotated %s on host %s\n", handler.Name, hostname)
+		result.Stepf("rotate", "Rotated %s on host %s", handler.Name, hostname)
+		for _, file := range files {
+			result.ChangedFile(file)
+		}
+	}
+
+	if etcdAddress != "" {
+		if err := VerifyEtcdCerts(backups, pkiDir, etcdAddress, hostname, result); err != nil {
+			return err
+		}
 	}
 
 	if restartControllerManager {
-		if err := restartStaticPod("kube-controller-manager.yaml", hostname); err != nil {
+		if err := restartStaticPod(backups, "kube-controller-manager.yaml", hostname, result); err != nil {
 			return err
 		}
 	}
 
 	if restartScheduler {
-		if err := restartStaticPod("kube-scheduler.yaml", hostname); err != nil {
+		if err := restartStaticPod(backups, "kube-scheduler.yaml", hostname, result); err != nil {
 			return err
 		}
 	}
 
 	if restartAPIServer {
-		if err := restartStaticPod("kube-apiserver.yaml", hostname); err != nil {
+		if err := restartStaticPod(backups, "kube-apiserver.yaml", hostname, result); err != nil {
 			return err
 		}
 	}
@@ -98,20 +133,73 @@ func RotateCerts(ttl time.Duration, hostname string) error {
 	return nil
 }
 
-func restartStaticPod(filename string, hostname string) error {
-	p := filepath.Join("/etc/kubernetes/manifests", filename)
-	tmp := filepath.Join("/etc/kubernetes", filename)
+// hostBackups keeps the originals of the host files the task changes in backupDir, empty to not
+// keep backups
+func hostBackups(backupDir string, result *hosttask.Result) hostfile.Backups {
+	return hostfile.Backups{Dir: backupDir, HostRoot: "/", Task: result.Task}
+}
 
-	// warning - this output is parsed by the ekco operator
-	fmt.Printf("Restarting static pod %s on host %s\n", p, hostname)
+// renewedFiles returns the kubeconfig or the cert and key that kubeadm writes when it renews the
+// certificate of the handler
+func renewedFiles(confDir, pkiDir string, handler *renewal.CertificateRenewHandler) []string {
+	if strings.HasSuffix(handler.FileName, ".conf") {
+		return []string{filepath.Join(confDir, handler.FileName)}
+	}
+	return []string{
+		filepath.Join(pkiDir, handler.FileName+".crt"),
+		filepath.Join(pkiDir, handler.FileName+".key"),
+	}
+}
 
-	if err := os.Rename(p, tmp); err != nil {
-		return errors.Wrapf(err, "mv %s to %s", p, tmp)
+// backupFiles keeps the originals of files that are written by kubeadm rather than through the
+// backups
+func backupFiles(backups hostfile.Backups, files []string) error {
+	for _, file := range files {
+		if err := backups.Backup(file); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	if err := os.Rename(tmp, p); err != nil {
-		return errors.Wrapf(err, "mv %s to %s", tmp, p)
+// writeCertAndKey is pkiutil.WriteCertAndKey with the originals kept in the backups
+func writeCertAndKey(backups hostfile.Backups, pkiDir, baseName string, cert *x509.Certificate, key crypto.Signer) error {
+	if err := writeKey(backups, pkiDir, baseName, key); err != nil {
+		return err
 	}
+	return writeCertBundle(backups, pkiDir, baseName, []*x509.Certificate{cert})
+}
+
+// writeKey is pkiutil.WriteKey with the original kept in the backups
+func writeKey(backups hostfile.Backups, pkiDir, baseName string, key crypto.Signer) error {
+	data, err := keyutil.MarshalPrivateKeyToPEM(key)
+	if err != nil {
+		return errors.Wrapf(err, "encode %s key", baseName)
+	}
+	return backups.WriteFile(filepath.Join(pkiDir, baseName+".key"), data, 0600)
+}
+
+// writeCertBundle is pkiutil.WriteCertBundle with the original kept in the backups
+func writeCertBundle(backups hostfile.Backups, pkiDir, baseName string, certs []*x509.Certificate) error {
+	var data []byte
+	for _, cert := range certs {
+		data = append(data, pkiutil.EncodeCertPEM(cert)...)
+	}
+	return backups.WriteFile(filepath.Join(pkiDir, baseName+".crt"), data, 0644)
+}
+
+// restartStaticPod restarts the static pod, keeping the manifest in the task's backups until it is
+// moved back
+func restartStaticPod(backups hostfile.Backups, filename string, hostname string, result *hosttask.Result) error {
+	p := filepath.Join("/etc/kubernetes/manifests", filename)
+
+	result.Stepf("restart", "Restarting static pod %s on host %s", p, hostname)
+
+	if err := backups.RestartStaticPod(p); err != nil {
+		return err
+	}
+
+	result.RestartedComponent(strings.TrimSuffix(filename, ".yaml"))
 
 	return nil
 }
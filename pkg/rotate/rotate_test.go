@@ -0,0 +1,67 @@
+package rotate
+
+import (
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/replicatedhq/ekco/pkg/hosttask"
+	"github.com/stretchr/testify/require"
+	certutil "k8s.io/client-go/util/cert"
+	kubeadmapi "k8s.io/kubernetes/cmd/kubeadm/app/apis/kubeadm"
+	kubeadmconstants "k8s.io/kubernetes/cmd/kubeadm/app/constants"
+	"k8s.io/kubernetes/cmd/kubeadm/app/phases/certs/renewal"
+	"k8s.io/kubernetes/cmd/kubeadm/app/util/pkiutil"
+)
+
+func TestBackupRenewedFiles(t *testing.T) {
+	req := require.New(t)
+	confDir := t.TempDir()
+	pkiDir := filepath.Join(confDir, "pki")
+	ca := writeTestCA(t, pkiDir, kubeadmconstants.CACertAndKeyBaseName, "kubernetes")
+
+	cert, key, err := pkiutil.NewCertAndKey(ca, mustLoadKey(t, pkiDir, kubeadmconstants.CACertAndKeyBaseName), &pkiutil.CertConfig{
+		Config: certutil.Config{
+			CommonName: "kube-apiserver-kubelet-client",
+			Usages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		},
+		NotAfter:            time.Now().Add(24 * time.Hour),
+		EncryptionAlgorithm: kubeadmapi.EncryptionAlgorithmRSA2048,
+	})
+	req.NoError(err)
+	req.NoError(pkiutil.WriteCertAndKey(pkiDir, kubeadmconstants.APIServerKubeletClientCertAndKeyBaseName, cert, key))
+	original, err := os.ReadFile(filepath.Join(pkiDir, kubeadmconstants.APIServerKubeletClientCertAndKeyBaseName+".key"))
+	req.NoError(err)
+
+	rm, err := renewal.NewManager(&kubeadmapi.ClusterConfiguration{CertificatesDir: pkiDir}, confDir)
+	req.NoError(err)
+	var handler *renewal.CertificateRenewHandler
+	for _, h := range rm.Certificates() {
+		if h.Name == "apiserver-kubelet-client" {
+			handler = h
+		}
+	}
+	req.NotNil(handler)
+
+	result := hosttask.NewResult("rotate-certs", "node1")
+	backups := hostBackups(t.TempDir(), result)
+	files := renewedFiles(confDir, pkiDir, handler)
+	req.Equal([]string{
+		filepath.Join(pkiDir, "apiserver-kubelet-client.crt"),
+		filepath.Join(pkiDir, "apiserver-kubelet-client.key"),
+	}, files)
+	req.NoError(backupFiles(backups, files))
+	renewed, err := rm.RenewUsingLocalCA(handler.Name)
+	req.NoError(err)
+	req.True(renewed)
+
+	// the key replaced by kubeadm is kept in the backup
+	current, err := os.ReadFile(files[1])
+	req.NoError(err)
+	req.NotEqual(original, current)
+	backup, err := os.ReadFile(filepath.Join(backups.Dir, backups.Name(), "files", files[1]))
+	req.NoError(err)
+	req.Equal(original, backup)
+}
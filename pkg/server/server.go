@@ -6,8 +6,10 @@ import (
 	"log"
 	"net/http"
 
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/replicatedhq/ekco/pkg/cluster"
 	"github.com/replicatedhq/ekco/pkg/ekcoops"
+	"github.com/replicatedhq/ekco/pkg/issuer"
 	"github.com/replicatedhq/ekco/pkg/migrate"
 )
 
@@ -18,6 +20,8 @@ func Serve(ctx context.Context, config ekcoops.Config, client *cluster.Controlle
 		w.WriteHeader(http.StatusOK)
 	})
 
+	mux.Handle("/metrics", promhttp.Handler())
+
 	mux.HandleFunc("/storagemigration/cluster-ready", func(w http.ResponseWriter, r *http.Request) {
 		status, err := migrate.IsClusterReady(r.Context(), config, client.Config)
 		if err != nil {
@@ -111,6 +115,58 @@ func Serve(ctx context.Context, config ekcoops.Config, client *cluster.Controlle
 		}
 	})
 
+	mux.HandleFunc("/certs", func(w http.ResponseWriter, r *http.Request) {
+		certs, err := client.CertificateInventory(r.Context())
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			if _, err := w.Write([]byte(err.Error())); err != nil {
+				log.Printf("write certificate inventory error: %v", err)
+			}
+			return
+		}
+		data, err := json.Marshal(certs)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			if _, err := w.Write([]byte(err.Error())); err != nil {
+				log.Printf("write json marshaling error: %v", err)
+			}
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if _, err = w.Write(data); err != nil {
+			log.Printf("write certificate inventory: %v", err)
+		}
+	})
+
+	mux.HandleFunc("/internallb/status", func(w http.ResponseWriter, r *http.Request) {
+		statuses, err := client.InternalLBStatus(r.Context())
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			if _, err := w.Write([]byte(err.Error())); err != nil {
+				log.Printf("write internal load balancer status error: %v", err)
+			}
+			return
+		}
+		data, err := json.Marshal(statuses)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			if _, err := w.Write([]byte(err.Error())); err != nil {
+				log.Printf("write json marshaling error: %v", err)
+			}
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if _, err = w.Write(data); err != nil {
+			log.Printf("write internal load balancer status: %v", err)
+		}
+	})
+
+	// ACME HTTP-01 challenges for certificates from the acme issuer, routed from port 80 by
+	// deploy/acme-http01.yaml
+	mux.Handle(issuer.HTTP01ChallengePath, issuer.DefaultHTTP01Solver)
+
 	server := &http.Server{Addr: ":8080", Handler: mux}
 	go func() {
 		<-ctx.Done()
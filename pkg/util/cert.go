@@ -12,7 +12,7 @@ import (
 
 // GetEncryptionAlgorithmType returns the encryption algorithm type for a given certificate
 // https://kubernetes.io/docs/reference/config-api/kubeadm-config.v1beta4/#kubeadm-k8s-io-v1beta4-ClusterConfiguration
-// Can be one of "RSA-2048" (default), "RSA-3072", "RSA-4096" or "ECDSA-P256"
+// Can be one of "RSA-2048" (default), "RSA-3072", "RSA-4096", "ECDSA-P256" or "ECDSA-P384"
 func GetEncryptionAlgorithmType(cert *x509.Certificate) (kubeadmapi.EncryptionAlgorithmType, error) {
 	switch pubKey := cert.PublicKey.(type) {
 	case *rsa.PublicKey:
@@ -28,8 +28,11 @@ func GetEncryptionAlgorithmType(cert *x509.Certificate) (kubeadmapi.EncryptionAl
 			return "", fmt.Errorf("unsupported RSA key size: %d bits", keySize)
 		}
 	case *ecdsa.PublicKey:
-		if pubKey.Curve == elliptic.P256() {
+		switch pubKey.Curve {
+		case elliptic.P256():
 			return kubeadmapi.EncryptionAlgorithmECDSAP256, nil
+		case elliptic.P384():
+			return kubeadmapi.EncryptionAlgorithmECDSAP384, nil
 		}
 		return "", fmt.Errorf("unsupported ECDSA curve: %s", pubKey.Curve.Params().Name)
 	default:
@@ -1,6 +1,8 @@
 package util
 
 import (
+	"net"
+
 	v1 "k8s.io/api/core/v1"
 )
 
@@ -57,11 +59,53 @@ func NodeReadyCounts(nodes []v1.Node) (int, int) {
 	return masters, workers
 }
 
+// IP families of the preferred_ip_family setting
+const (
+	IPFamilyIPv4 = "ipv4"
+	IPFamilyIPv6 = "ipv6"
+)
+
+// NodeInternalIP returns the first InternalIP of the node
 func NodeInternalIP(node v1.Node) string {
+	return NodePreferredInternalIP(node, "")
+}
+
+// NodePreferredInternalIP returns the first InternalIP of the node in the family, or the first
+// InternalIP if the node has none in the family or family is empty. Dual-stack nodes report an
+// InternalIP in each family.
+func NodePreferredInternalIP(node v1.Node, family string) string {
+	ips := NodeInternalIPs(node)
+	for _, ip := range ips {
+		if IPFamily(ip) == family {
+			return ip
+		}
+	}
+	if len(ips) > 0 {
+		return ips[0]
+	}
+	return ""
+}
+
+// NodeInternalIPs returns all InternalIPs of the node in the order reported by the kubelet
+func NodeInternalIPs(node v1.Node) []string {
+	var ips []string
 	for _, addr := range node.Status.Addresses {
 		if addr.Type == v1.NodeInternalIP {
-			return addr.Address
+			ips = append(ips, addr.Address)
 		}
 	}
-	return ""
+	return ips
+}
+
+// IPFamily returns ipv4 or ipv6 for an IP address and an empty string for anything else
+func IPFamily(ip string) string {
+	parsed := net.ParseIP(ip)
+	switch {
+	case parsed == nil:
+		return ""
+	case parsed.To4() != nil:
+		return IPFamilyIPv4
+	default:
+		return IPFamilyIPv6
+	}
 }